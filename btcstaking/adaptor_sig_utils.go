@@ -0,0 +1,70 @@
+package btcstaking
+
+import (
+	"fmt"
+
+	asig "github.com/babylonlabs-io/babylon/crypto/schnorr-adaptor-signature"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// CompleteAdaptorSig completes the adaptor signature with the given secret i.e
+// the decryption key of the adaptor signature. The completed signature can be
+// used in place of a regular schnorr signature by the witness builders.
+// The secret is not modified.
+func CompleteAdaptorSig(adaptor *asig.AdaptorSignature, secret *btcec.ModNScalar) (*schnorr.Signature, error) {
+	if adaptor == nil {
+		return nil, fmt.Errorf("adaptor signature must not be nil")
+	}
+
+	if secret == nil {
+		return nil, fmt.Errorf("secret must not be nil")
+	}
+
+	// decryption key may negate the scalar, so work on a copy
+	secretCopy := *secret
+
+	decKey, err := asig.NewDecyptionKeyFromModNScalar(&secretCopy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret: %w", err)
+	}
+
+	return adaptor.Decrypt(decKey), nil
+}
+
+// CompleteDelegatorAdaptorSig completes the delegator adaptor signature over
+// the given sighash with the secret, and verifies that the completed signature
+// is a valid delegator signature. The adaptor signature itself is verified
+// against the encryption key derived from the secret first, so that a
+// signature encrypted to a different key is rejected with a clear error.
+func CompleteDelegatorAdaptorSig(
+	adaptor *asig.AdaptorSignature,
+	secret *btcec.ModNScalar,
+	stakerPk *btcec.PublicKey,
+	sigHash []byte,
+) (*schnorr.Signature, error) {
+	if stakerPk == nil {
+		return nil, fmt.Errorf("staker public key must not be nil")
+	}
+
+	sig, err := CompleteAdaptorSig(adaptor, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	secretCopy := *secret
+	decKey, err := asig.NewDecyptionKeyFromModNScalar(&secretCopy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret: %w", err)
+	}
+
+	if err := adaptor.EncVerify(stakerPk, decKey.GetEncKey(), sigHash); err != nil {
+		return nil, fmt.Errorf("%w: invalid adaptor signature: %w", ErrInvalidDelegatorSignature, err)
+	}
+
+	if err := VerifyDelegatorSig(sig, stakerPk, sigHash); err != nil {
+		return nil, err
+	}
+
+	return sig, nil
+}
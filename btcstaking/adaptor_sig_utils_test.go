@@ -0,0 +1,94 @@
+package btcstaking_test
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	asig "github.com/babylonlabs-io/babylon/crypto/schnorr-adaptor-signature"
+	btctest "github.com/babylonlabs-io/babylon/testutil/bitcoin"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompleteDelegatorAdaptorSig(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	si, err := stakingInfo.UnbondingPathSpendInfo()
+	require.NoError(t, err)
+
+	tx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.9))
+	sigHash, err := btcstaking.TaprootSigHash(
+		tx, 0, []*wire.TxOut{stakingInfo.StakingOutput}, si, txscript.SigHashDefault,
+	)
+	require.NoError(t, err)
+
+	encKey, decKey, err := asig.GenKeyPair()
+	require.NoError(t, err)
+
+	adaptorSig, err := asig.EncSign(scenario.StakerKey, encKey, sigHash)
+	require.NoError(t, err)
+
+	secret := decKey.ModNScalar
+	delegatorSig, err := btcstaking.CompleteDelegatorAdaptorSig(
+		adaptorSig, &secret, scenario.StakerKey.PubKey(), sigHash,
+	)
+	require.NoError(t, err)
+	// completing must not modify the secret
+	require.True(t, secret.Equals(&decKey.ModNScalar))
+
+	sig, err := btcstaking.CompleteAdaptorSig(adaptorSig, &secret)
+	require.NoError(t, err)
+	require.True(t, sig.IsEqual(delegatorSig))
+
+	// completed signature can be used in the witness as regular signature
+	sigs := signLeafWithKeys(
+		t, tx, stakingInfo.StakingOutput, si,
+		scenario.CovenantKeys[0], scenario.CovenantKeys[1],
+	)
+	sigs[hex.EncodeToString(schnorr.SerializePubKey(scenario.StakerKey.PubKey()))] = delegatorSig
+	orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+	require.NoError(t, err)
+	witness, err := btcstaking.CreateWitness(si, orderedSigs)
+	require.NoError(t, err)
+	tx.TxIn[0].Witness = witness
+	btctest.AssertSlashingTxExecution(t, stakingInfo.StakingOutput, tx)
+
+	// wrong secret does not complete the signature
+	otherKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	_, err = btcstaking.CompleteDelegatorAdaptorSig(
+		adaptorSig, &otherKey.Key, scenario.StakerKey.PubKey(), sigHash,
+	)
+	require.ErrorIs(t, err, btcstaking.ErrInvalidDelegatorSignature)
+
+	// signature of other key is not a delegator signature
+	_, err = btcstaking.CompleteDelegatorAdaptorSig(
+		adaptorSig, &secret, otherKey.PubKey(), sigHash,
+	)
+	require.ErrorIs(t, err, btcstaking.ErrInvalidDelegatorSignature)
+
+	_, err = btcstaking.CompleteAdaptorSig(nil, &secret)
+	require.Error(t, err)
+	_, err = btcstaking.CompleteAdaptorSig(adaptorSig, new(btcec.ModNScalar))
+	require.Error(t, err)
+}
@@ -0,0 +1,185 @@
+package btcstaking
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// DelegationEventType identifies the kind of delegation lifecycle event
+type DelegationEventType int
+
+const (
+	// NewStakingEvent is emitted for a valid staking transaction
+	NewStakingEvent DelegationEventType = iota
+	// UnbondedEvent is emitted when an output is spent through the unbonding path
+	UnbondedEvent
+	// SlashedEvent is emitted when an output is spent through the slashing path
+	SlashedEvent
+	// WithdrawnEvent is emitted when an output is spent through the time lock path
+	WithdrawnEvent
+)
+
+func (e DelegationEventType) String() string {
+	switch e {
+	case NewStakingEvent:
+		return "new_staking"
+	case UnbondedEvent:
+		return "unbonded"
+	case SlashedEvent:
+		return "slashed"
+	case WithdrawnEvent:
+		return "withdrawn"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(e))
+	}
+}
+
+// DelegationEvent is a single delegation lifecycle event found in a block
+type DelegationEvent struct {
+	// Type is the kind of the event
+	Type DelegationEventType
+	// TxHash is the hash of the transaction emitting the event
+	TxHash chainhash.Hash
+	// Outpoint is the staking output for NewStakingEvent, and the spent output
+	// for other events
+	Outpoint wire.OutPoint
+	// InputIdx is the index of the spending input, -1 for NewStakingEvent
+	InputIdx int
+	// Delegation is the delegation parsed from the staking transaction. It is
+	// set only for NewStakingEvent.
+	Delegation *Delegation
+	// Signers are the keys required by the spent leaf. They are nil for
+	// NewStakingEvent and for leaves matched by templates which do not have
+	// the shape of Babylon leaf scripts.
+	Signers *LeafSigners
+}
+
+// spendEventType maps the spend path of the revealed leaf to the event type
+func spendEventType(path SpendPath) (DelegationEventType, bool) {
+	switch path {
+	case TimeLockPath, RecoveryPath:
+		return WithdrawnEvent, true
+	case UnbondingPath:
+		return UnbondedEvent, true
+	case SlashingPath, UnbondingSlashingPath:
+		return SlashedEvent, true
+	default:
+		return 0, false
+	}
+}
+
+// scanSpend returns the event of the input if it is a script path spend of a
+// leaf matching any of the templates
+func scanSpend(txHash chainhash.Hash, inputIdx int, in *wire.TxIn, templates *ScriptTemplateRegistry) (*DelegationEvent, bool) {
+	if len(in.Witness) == 0 {
+		return nil, false
+	}
+
+	parsed, err := ParseWitness(in.Witness)
+	if err != nil {
+		return nil, false
+	}
+
+	// witnesses of other kinds of spends can also have 2 or more elements, so
+	// only inputs with valid control block are considered script path spends
+	if _, err := parseControlBlock(parsed.ControlBlock); err != nil {
+		return nil, false
+	}
+
+	tmpl, err := templates.MatchScript(parsed.Script)
+	if err != nil {
+		return nil, false
+	}
+
+	eventType, ok := spendEventType(tmpl.Path)
+	if !ok {
+		return nil, false
+	}
+
+	// custom templates may match scripts which are not Babylon leaf scripts, in
+	// which case the signers are left nil
+	signers, _ := RequiredSigners(parsed.Script)
+
+	return &DelegationEvent{
+		Type:     eventType,
+		TxHash:   txHash,
+		Outpoint: in.PreviousOutPoint,
+		InputIdx: inputIdx,
+		Signers:  signers,
+	}, true
+}
+
+// ScanBlock walks every transaction of the block and returns the delegation
+// events it emits, in the order of transactions. Events of the spent inputs of
+// a transaction precede its NewStakingEvent.
+// Staking transactions are identified with ParseDelegation, so the tag and the
+// covenant committee are required in addition to the network params. Spends
+// are identified by the leaf revealed in the witness, matched against the given
+// templates, or DefaultScriptTemplateRegistry if templates is nil. Leaf scripts
+// do not tell which output they are committed in, so spends of staking and
+// unbonding outputs emit the same events. Witnesses are not executed, as the
+// block is expected to be already validated by the node.
+func ScanBlock(
+	block *wire.MsgBlock,
+	expectedTag []byte,
+	covenantKeys []*btcec.PublicKey,
+	covenantQuorum uint32,
+	params *chaincfg.Params,
+	templates *ScriptTemplateRegistry,
+) ([]DelegationEvent, error) {
+	if block == nil {
+		return nil, fmt.Errorf("block must not be nil")
+	}
+
+	if params == nil {
+		return nil, fmt.Errorf("network params must not be nil")
+	}
+
+	if len(expectedTag) != TagLen {
+		return nil, fmt.Errorf("invalid tag length: %d, expected: %d", len(expectedTag), TagLen)
+	}
+
+	if err := ValidateQuorum(len(covenantKeys), int(covenantQuorum)); err != nil {
+		return nil, err
+	}
+
+	if templates == nil {
+		templates = DefaultScriptTemplateRegistry
+	}
+
+	var events []DelegationEvent
+
+	for _, tx := range block.Transactions {
+		txHash := tx.TxHash()
+
+		for i, in := range tx.TxIn {
+			if event, ok := scanSpend(txHash, i, in, templates); ok {
+				events = append(events, *event)
+			}
+		}
+
+		if !IsPossibleV0StakingTx(tx, expectedTag) {
+			continue
+		}
+
+		delegation, err := ParseDelegation(tx, expectedTag, covenantKeys, covenantQuorum, params)
+		if err != nil {
+			// transactions with the tag which are not valid staking txs are ignored
+			continue
+		}
+
+		events = append(events, DelegationEvent{
+			Type:       NewStakingEvent,
+			TxHash:     txHash,
+			Outpoint:   delegation.StakingOutpoint,
+			InputIdx:   -1,
+			Delegation: delegation,
+		})
+	}
+
+	return events, nil
+}
@@ -0,0 +1,145 @@
+package btcstaking_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	"github.com/babylonlabs-io/babylon/testutil/datagen"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanBlock(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+	tag := datagen.GenRandomByteArray(r, btcstaking.TagLen)
+	net := &chaincfg.MainNetParams
+
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+
+	randomSigs := func(n int) [][]byte {
+		sigs := make([][]byte, n)
+		for i := range sigs {
+			sigs[i] = datagen.GenRandomByteArray(r, schnorr.SignatureSize)
+		}
+		return sigs
+	}
+	spendTx := func(prevOut wire.OutPoint, si *btcstaking.SpendInfo, numSigs int) *wire.MsgTx {
+		witness, err := btcstaking.CreateWitness(si, randomSigs(numSigs))
+		require.NoError(t, err)
+		tx := wire.NewMsgTx(2)
+		tx.AddTxIn(wire.NewTxIn(&prevOut, nil, witness))
+		tx.AddTxOut(wire.NewTxOut(10000, datagen.GenRandomByteArray(r, 34)))
+		return tx
+	}
+
+	coinbaseTx := wire.NewMsgTx(2)
+	coinbaseTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: wire.MaxPrevOutIndex}, nil, nil))
+	coinbaseTx.AddTxOut(wire.NewTxOut(50000, datagen.GenRandomByteArray(r, 34)))
+
+	funding := []*wire.TxIn{wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{1}}, nil, nil)}
+	stakingTx, err := btcstaking.BuildStakingTx(tag, funding, params.StakingAmount, params, nil, 0)
+	require.NoError(t, err)
+	stakingOutpoint := wire.OutPoint{Hash: stakingTx.TxHash(), Index: 0}
+
+	// staking tx with the tag, but committing to different covenant committee
+	otherCommittee := params
+	otherCommittee.CovenantKeys = params.CovenantKeys[1:]
+	otherFunding := []*wire.TxIn{wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{2}}, nil, nil)}
+	otherStakingTx, err := btcstaking.BuildStakingTx(tag, otherFunding, params.StakingAmount, otherCommittee, nil, 0)
+	require.NoError(t, err)
+
+	// staker, quorum of covenants and one finality provider
+	unbondingTx := spendTx(stakingOutpoint, infos.Unbonding, 6)
+	unbondingOutpoint := wire.OutPoint{Hash: unbondingTx.TxHash(), Index: 0}
+	slashingTx := spendTx(unbondingOutpoint, infos.UnbondingSlashing, 7)
+	oldStakingOutpoint := wire.OutPoint{Hash: chainhash.Hash{3}, Index: 1}
+	withdrawalTx := spendTx(oldStakingOutpoint, infos.TimeLock, 1)
+
+	// segwit v0 spend with two witness elements
+	p2wpkhSpend := wire.NewMsgTx(2)
+	p2wpkhSpend.AddTxIn(wire.NewTxIn(
+		&wire.OutPoint{Hash: chainhash.Hash{4}},
+		nil,
+		wire.TxWitness{datagen.GenRandomByteArray(r, 72), schnorr.SerializePubKey(params.StakerKey)},
+	))
+	p2wpkhSpend.AddTxOut(wire.NewTxOut(10000, datagen.GenRandomByteArray(r, 34)))
+
+	block := &wire.MsgBlock{
+		Transactions: []*wire.MsgTx{
+			coinbaseTx, stakingTx, otherStakingTx, unbondingTx, p2wpkhSpend, slashingTx, withdrawalTx,
+		},
+	}
+
+	events, err := btcstaking.ScanBlock(block, tag, params.CovenantKeys, params.CovenantQuorum, net, nil)
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+
+	require.Equal(t, btcstaking.NewStakingEvent, events[0].Type)
+	require.Equal(t, stakingTx.TxHash(), events[0].TxHash)
+	require.Equal(t, stakingOutpoint, events[0].Outpoint)
+	require.Equal(t, -1, events[0].InputIdx)
+	require.NotNil(t, events[0].Delegation)
+	require.Equal(t, params.StakingTime, events[0].Delegation.StakingTime)
+	require.Equal(t, params.StakingAmount, events[0].Delegation.StakingAmount)
+	require.Nil(t, events[0].Signers)
+
+	expectedSpends := []struct {
+		eventType btcstaking.DelegationEventType
+		tx        *wire.MsgTx
+		outpoint  wire.OutPoint
+		numFps    int
+		numCovs   int
+	}{
+		{btcstaking.UnbondedEvent, unbondingTx, stakingOutpoint, 0, 5},
+		{btcstaking.SlashedEvent, slashingTx, unbondingOutpoint, 1, 5},
+		{btcstaking.WithdrawnEvent, withdrawalTx, oldStakingOutpoint, 0, 0},
+	}
+	for i, expected := range expectedSpends {
+		event := events[i+1]
+		require.Equal(t, expected.eventType, event.Type, "event %d", i+1)
+		require.Equal(t, expected.tx.TxHash(), event.TxHash)
+		require.Equal(t, expected.outpoint, event.Outpoint)
+		require.Equal(t, 0, event.InputIdx)
+		require.Nil(t, event.Delegation)
+		require.NotNil(t, event.Signers)
+		require.Equal(t, schnorr.SerializePubKey(params.StakerKey), schnorr.SerializePubKey(event.Signers.StakerKey))
+		require.Len(t, event.Signers.FinalityProviderKeys, expected.numFps)
+		require.Len(t, event.Signers.CovenantKeys, expected.numCovs)
+	}
+
+	// registry without the slashing template does not report slashing
+	registry := btcstaking.NewScriptTemplateRegistry()
+	for _, tmpl := range btcstaking.DefaultScriptTemplates() {
+		if tmpl.Path != btcstaking.SlashingPath {
+			require.NoError(t, registry.Register(tmpl))
+		}
+	}
+	events, err = btcstaking.ScanBlock(block, tag, params.CovenantKeys, params.CovenantQuorum, net, registry)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	for _, event := range events {
+		require.NotEqual(t, btcstaking.SlashedEvent, event.Type)
+	}
+
+	events, err = btcstaking.ScanBlock(&wire.MsgBlock{}, tag, params.CovenantKeys, params.CovenantQuorum, net, nil)
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	_, err = btcstaking.ScanBlock(nil, tag, params.CovenantKeys, params.CovenantQuorum, net, nil)
+	require.Error(t, err)
+	_, err = btcstaking.ScanBlock(block, tag[1:], params.CovenantKeys, params.CovenantQuorum, net, nil)
+	require.Error(t, err)
+	_, err = btcstaking.ScanBlock(block, tag, params.CovenantKeys, 0, net, nil)
+	require.Error(t, err)
+	_, err = btcstaking.ScanBlock(block, tag, params.CovenantKeys, params.CovenantQuorum, nil, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package btcstaking
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// StakingBundle contains the staking transaction of a delegation together with
+// the transactions pre-signed before the delegation becomes active
+type StakingBundle struct {
+	// StakingTx is the transaction locking the stake in the staking output
+	StakingTx *wire.MsgTx
+	// StakingOutputIdx is the index of the staking output in StakingTx
+	StakingOutputIdx uint32
+	// UnbondingTx spends the staking output through the unbonding path
+	UnbondingTx *wire.MsgTx
+	// SlashingTx spends the staking output through the slashing path
+	SlashingTx *wire.MsgTx
+	// UnbondingSlashingTx spends the unbonding output through the slashing path
+	UnbondingSlashingTx *wire.MsgTx
+}
+
+// VerifyDelegationBundle checks that the transactions of the bundle form a valid
+// delegation described by params, i.e:
+// - staking output of the staking tx commits to the delegation scripts and
+// holds the staking amount
+// - unbonding tx passes VerifyUnbondingTx for the staking output and the given
+// unbonding fee, and its output commits to the delegation unbonding scripts
+// - slashing tx spends the staking output and passes VerifySlashingTx
+// - unbonding slashing tx spends the unbonding output and passes VerifySlashingTx
+// It is the acceptance check done by Babylon nodes and covenant members before
+// the delegation becomes active. The first failure is returned, prefixed with
+// the transaction it was found in.
+func VerifyDelegationBundle(
+	bundle *StakingBundle,
+	params StakingParams,
+	policy SlashingPolicy,
+	unbondingFee btcutil.Amount,
+) error {
+	if bundle == nil {
+		return fmt.Errorf("staking bundle must not be nil")
+	}
+
+	if bundle.StakingTx == nil || bundle.UnbondingTx == nil ||
+		bundle.SlashingTx == nil || bundle.UnbondingSlashingTx == nil {
+		return fmt.Errorf("all transactions of the staking bundle must be set")
+	}
+
+	infos, err := BuildAllSpendInfos(params)
+	if err != nil {
+		return err
+	}
+
+	// staking tx
+	if int(bundle.StakingOutputIdx) >= len(bundle.StakingTx.TxOut) {
+		return fmt.Errorf(
+			"staking tx: invalid staking output index %d, tx has %d outputs",
+			bundle.StakingOutputIdx, len(bundle.StakingTx.TxOut),
+		)
+	}
+
+	stakingOutput := bundle.StakingTx.TxOut[bundle.StakingOutputIdx]
+
+	stakingPkScript, err := StakingOutputScript(params)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(stakingOutput.PkScript, stakingPkScript) {
+		return fmt.Errorf("staking tx: staking output does not commit to the delegation scripts")
+	}
+
+	if btcutil.Amount(stakingOutput.Value) != params.StakingAmount {
+		return fmt.Errorf(
+			"staking tx: staking output value %d does not match staking amount %d",
+			stakingOutput.Value, params.StakingAmount,
+		)
+	}
+
+	stakingOutpoint := wire.OutPoint{Hash: bundle.StakingTx.TxHash(), Index: bundle.StakingOutputIdx}
+
+	// unbonding tx
+	if err := CheckPreSignedUnbondingTxSanity(bundle.UnbondingTx); err != nil {
+		return fmt.Errorf("unbonding tx: %w", err)
+	}
+
+	if err := VerifyUnbondingTx(bundle.UnbondingTx, stakingOutpoint, params.StakingAmount-unbondingFee); err != nil {
+		return fmt.Errorf("unbonding tx: %w", err)
+	}
+
+	unbondingOutput := bundle.UnbondingTx.TxOut[0]
+	if err := VerifyScriptInclusion(infos.UnbondingTimeLock, unbondingOutput.PkScript); err != nil {
+		return fmt.Errorf("unbonding tx: unbonding output does not commit to the delegation scripts: %w", err)
+	}
+
+	// slashing tx
+	if err := VerifySlashingTx(bundle.SlashingTx, stakingOutput.Value, params.StakerKey, policy); err != nil {
+		return fmt.Errorf("slashing tx: %w", err)
+	}
+
+	if bundle.SlashingTx.TxIn[0].PreviousOutPoint != stakingOutpoint {
+		return fmt.Errorf(
+			"slashing tx: does not spend the staking output: expected %s, got %s",
+			stakingOutpoint, bundle.SlashingTx.TxIn[0].PreviousOutPoint,
+		)
+	}
+
+	// unbonding slashing tx
+	if err := VerifySlashingTx(
+		bundle.UnbondingSlashingTx, unbondingOutput.Value, params.StakerKey, policy,
+	); err != nil {
+		return fmt.Errorf("unbonding slashing tx: %w", err)
+	}
+
+	if _, _, err := unbondingSlashingSpend(bundle.UnbondingSlashingTx, bundle.UnbondingTx, params); err != nil {
+		return fmt.Errorf("unbonding slashing tx: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package btcstaking_test
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	sdkmath "cosmossdk.io/math"
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyDelegationBundle(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+	unbondingFee := btcutil.Amount(1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+	stakingPkScript, err := btcstaking.StakingOutputScript(params)
+	require.NoError(t, err)
+
+	slashingAddress, err := genRandomBTCAddress(r)
+	require.NoError(t, err)
+	slashingPkScript, err := txscript.PayToAddrScript(slashingAddress)
+	require.NoError(t, err)
+
+	policy := btcstaking.SlashingPolicy{
+		SlashingPkScript:       slashingPkScript,
+		SlashingRate:           sdkmath.LegacyNewDecWithPrec(1, 1),
+		SlashingTxMinFee:       1000,
+		SlashingChangeLockTime: 100,
+		Net:                    &chaincfg.MainNetParams,
+	}
+
+	stakingTx := wire.NewMsgTx(2)
+	stakingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+	stakingTx.AddTxOut(taprootOutputWithValue(t, r, 10000))
+	stakingTx.AddTxOut(wire.NewTxOut(int64(params.StakingAmount), stakingPkScript))
+	stakingOutpoint := wire.OutPoint{Hash: stakingTx.TxHash(), Index: 1}
+
+	unbondingTx, err := btcstaking.BuildUnbondingTx(
+		stakingOutpoint, params.StakingAmount, unbondingFee, infos.UnbondingTimeLock,
+	)
+	require.NoError(t, err)
+
+	buildSlashingTx := func(fundingTx *wire.MsgTx, outputIdx uint32) *wire.MsgTx {
+		tx, err := btcstaking.BuildSlashingTxFromStakingTxStrict(
+			fundingTx,
+			outputIdx,
+			policy.SlashingPkScript,
+			params.StakerKey,
+			policy.SlashingChangeLockTime,
+			policy.SlashingTxMinFee,
+			policy.SlashingRate,
+			policy.Net,
+		)
+		require.NoError(t, err)
+		return tx
+	}
+
+	validBundle := func() *btcstaking.StakingBundle {
+		return &btcstaking.StakingBundle{
+			StakingTx:           stakingTx,
+			StakingOutputIdx:    1,
+			UnbondingTx:         unbondingTx,
+			SlashingTx:          buildSlashingTx(stakingTx, 1),
+			UnbondingSlashingTx: buildSlashingTx(unbondingTx, 0),
+		}
+	}
+
+	require.NoError(t, btcstaking.VerifyDelegationBundle(validBundle(), params, policy, unbondingFee))
+
+	// errors are prefixed with the transaction which failed the verification
+	requireErrorPrefix := func(t *testing.T, err error, txName string) {
+		require.Error(t, err)
+		require.True(t, strings.HasPrefix(err.Error(), txName+":"), err.Error())
+	}
+
+	t.Run("wrong staking output index", func(t *testing.T) {
+		bundle := validBundle()
+		bundle.StakingOutputIdx = 0
+		err := btcstaking.VerifyDelegationBundle(bundle, params, policy, unbondingFee)
+		requireErrorPrefix(t, err, "staking tx")
+	})
+
+	t.Run("staking amount does not match params", func(t *testing.T) {
+		otherParams := params
+		otherParams.StakingAmount++
+		err := btcstaking.VerifyDelegationBundle(validBundle(), otherParams, policy, unbondingFee)
+		requireErrorPrefix(t, err, "staking tx")
+	})
+
+	t.Run("unexpected unbonding fee", func(t *testing.T) {
+		err := btcstaking.VerifyDelegationBundle(validBundle(), params, policy, 10*unbondingFee)
+		require.ErrorIs(t, err, btcstaking.ErrUnbondingWrongValue)
+		requireErrorPrefix(t, err, "unbonding tx")
+	})
+
+	t.Run("unbonding output of other delegation", func(t *testing.T) {
+		bundle := validBundle()
+		bundle.UnbondingTx = bundle.UnbondingTx.Copy()
+		bundle.UnbondingTx.TxOut[0].PkScript = stakingPkScript
+		err := btcstaking.VerifyDelegationBundle(bundle, params, policy, unbondingFee)
+		requireErrorPrefix(t, err, "unbonding tx")
+	})
+
+	t.Run("slashing tx spending the unbonding output", func(t *testing.T) {
+		bundle := validBundle()
+		bundle.SlashingTx = bundle.UnbondingSlashingTx
+		err := btcstaking.VerifyDelegationBundle(bundle, params, policy, unbondingFee)
+		requireErrorPrefix(t, err, "slashing tx")
+	})
+
+	t.Run("unbonding slashing tx spending the staking output", func(t *testing.T) {
+		bundle := validBundle()
+		bundle.UnbondingSlashingTx = bundle.SlashingTx
+		err := btcstaking.VerifyDelegationBundle(bundle, params, policy, unbondingFee)
+		requireErrorPrefix(t, err, "unbonding slashing tx")
+	})
+
+	t.Run("slashing tx paying to other slashing address", func(t *testing.T) {
+		otherPolicy := policy
+		otherPolicy.SlashingPkScript = stakingPkScript
+		err := btcstaking.VerifyDelegationBundle(validBundle(), params, otherPolicy, unbondingFee)
+		requireErrorPrefix(t, err, "slashing tx")
+	})
+
+	t.Run("missing transaction", func(t *testing.T) {
+		bundle := validBundle()
+		bundle.UnbondingSlashingTx = nil
+		require.Error(t, btcstaking.VerifyDelegationBundle(bundle, params, policy, unbondingFee))
+	})
+}
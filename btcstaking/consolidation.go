@@ -0,0 +1,98 @@
+package btcstaking
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// MaturedDelegation is an output whose time lock expired, spendable by the
+// staker alone through the time lock path described by the spend info. It can
+// be the staking output, the unbonding output or the slashing change output.
+type MaturedDelegation struct {
+	// Outpoint is the outpoint of the matured output
+	Outpoint wire.OutPoint
+	// Value is the value of the matured output
+	Value btcutil.Amount
+	// TimeLockSpendInfo describes the time lock path of the matured output
+	TimeLockSpendInfo *SpendInfo
+}
+
+// BuildConsolidationTx builds the transaction sweeping all the given matured
+// outputs to a single output paying to destScript. Sequence of every input is
+// set to the relative time lock of its time lock script. The fee is computed
+// from the fee rate and the weight of the transaction with the witness of every
+// input, each carrying the staker signature only.
+// It returns the transaction together with the spend infos required to build
+// the witness of every input, in input order.
+func BuildConsolidationTx(
+	inputs []MaturedDelegation,
+	destScript []byte,
+	feeRate SatPerKWeight,
+) (*wire.MsgTx, []*SpendInfo, error) {
+	if len(inputs) == 0 {
+		return nil, nil, fmt.Errorf("at least one matured delegation must be provided")
+	}
+
+	if len(destScript) == 0 {
+		return nil, nil, fmt.Errorf("destination script must not be empty")
+	}
+
+	// relative time locks are enforced only from version 2
+	tx := wire.NewMsgTx(MaxTxVersion)
+	spendInfos := make([]*SpendInfo, len(inputs))
+	witnesses := make([]wire.TxWitness, len(inputs))
+
+	var totalValue btcutil.Amount
+	for i, in := range inputs {
+		if in.TimeLockSpendInfo == nil {
+			return nil, nil, fmt.Errorf("time lock spend info of input %d must not be nil", i)
+		}
+
+		sequence, err := ComputeInputSequence(in.TimeLockSpendInfo)
+		if err != nil {
+			return nil, nil, fmt.Errorf("input %d is not spent through time lock path: %w", i, err)
+		}
+
+		witness, err := dummyWitness(in.TimeLockSpendInfo, 1)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		outpoint := in.Outpoint
+		txIn := wire.NewTxIn(&outpoint, nil, nil)
+		txIn.Sequence = sequence
+		tx.AddTxIn(txIn)
+
+		spendInfos[i] = in.TimeLockSpendInfo
+		witnesses[i] = witness
+		totalValue += in.Value
+	}
+
+	tx.AddTxOut(wire.NewTxOut(0, destScript))
+
+	// weight is computed with the witnesses of all inputs, which are then
+	// removed, so the caller can sign the transaction
+	for i, in := range tx.TxIn {
+		in.Witness = witnesses[i]
+	}
+	weight := blockchain.GetTransactionWeight(btcutil.NewTx(tx))
+	for _, in := range tx.TxIn {
+		in.Witness = nil
+	}
+
+	fee := feeRate.FeeForWeight(weight)
+	if fee >= totalValue {
+		return nil, nil, fmt.Errorf("fee %d must be less than total input value %d", fee, totalValue)
+	}
+
+	tx.TxOut[0].Value = int64(totalValue - fee)
+	if mempool.IsDust(tx.TxOut[0], mempool.DefaultMinRelayTxFee) {
+		return nil, nil, ErrDustOutputFound
+	}
+
+	return tx, spendInfos, nil
+}
@@ -0,0 +1,102 @@
+package btcstaking_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildConsolidationTx(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+	feeRate := btcstaking.SatPerKWeight(2500)
+
+	var (
+		inputs   []btcstaking.MaturedDelegation
+		prevOuts []*wire.TxOut
+	)
+
+	for i, stakingTime := range []uint16{100, 1000, 64000} {
+		stakingInfo, err := btcstaking.BuildStakingInfo(
+			scenario.StakerKey.PubKey(),
+			scenario.FinalityProviderPublicKeys(),
+			scenario.CovenantPublicKeys(),
+			scenario.RequiredCovenantSigs,
+			stakingTime,
+			scenario.StakingAmount,
+			&chaincfg.MainNetParams,
+		)
+		require.NoError(t, err)
+
+		si, err := stakingInfo.TimeLockPathSpendInfo()
+		require.NoError(t, err)
+
+		inputs = append(inputs, btcstaking.MaturedDelegation{
+			Outpoint:          wire.OutPoint{Hash: chainhash.Hash{byte(i)}, Index: uint32(i)},
+			Value:             scenario.StakingAmount,
+			TimeLockSpendInfo: si,
+		})
+		prevOuts = append(prevOuts, stakingInfo.StakingOutput)
+	}
+
+	destScript := taprootOutputWithValue(t, r, 0).PkScript
+
+	tx, spendInfos, err := btcstaking.BuildConsolidationTx(inputs, destScript, feeRate)
+	require.NoError(t, err)
+	require.Len(t, tx.TxIn, 3)
+	require.Len(t, tx.TxOut, 1)
+	require.Len(t, spendInfos, 3)
+	require.Equal(t, uint32(100), tx.TxIn[0].Sequence)
+	require.Equal(t, uint32(1000), tx.TxIn[1].Sequence)
+	require.Equal(t, uint32(64000), tx.TxIn[2].Sequence)
+
+	// sign and execute every input
+	cache, err := btcstaking.NewSigHashCache(tx, prevOuts)
+	require.NoError(t, err)
+	for i, si := range spendInfos {
+		sigHash, err := btcstaking.SigHashForInput(cache, i, si, txscript.SigHashDefault)
+		require.NoError(t, err)
+		sig, err := schnorr.Sign(scenario.StakerKey, sigHash)
+		require.NoError(t, err)
+		tx.TxIn[i].Witness, err = si.CreateTimeLockPathWitness(sig)
+		require.NoError(t, err)
+	}
+
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for i, in := range tx.TxIn {
+		fetcher.AddPrevOut(in.PreviousOutPoint, prevOuts[i])
+	}
+	sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+	for i := range tx.TxIn {
+		engine, err := txscript.NewEngine(
+			prevOuts[i].PkScript, tx, i, txscript.StandardVerifyFlags, nil,
+			sigHashes, prevOuts[i].Value, fetcher,
+		)
+		require.NoError(t, err)
+		require.NoError(t, engine.Execute())
+	}
+
+	// fee covers the weight of all the witnesses
+	fee := 3*scenario.StakingAmount - btcutil.Amount(tx.TxOut[0].Value)
+	require.Equal(t, feeRate.FeeForWeight(blockchain.GetTransactionWeight(btcutil.NewTx(tx))), fee)
+
+	// only time lock leaves can be consolidated
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+	inputs[1].TimeLockSpendInfo = infos.Unbonding
+	_, _, err = btcstaking.BuildConsolidationTx(inputs, destScript, feeRate)
+	require.Error(t, err)
+
+	_, _, err = btcstaking.BuildConsolidationTx(nil, destScript, feeRate)
+	require.Error(t, err)
+}
@@ -0,0 +1,155 @@
+package btcstaking
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// RotationLeaf is the leaf of the output protected by one of the covenant
+// committees during committee rotation
+type RotationLeaf struct {
+	// SpendInfo describes the leaf committing to the committee keys
+	SpendInfo *SpendInfo
+	// Committee is the covenant committee committed in the leaf
+	Committee *CovenantCommittee
+	// Quorum is the number of committee signatures required by the leaf
+	Quorum uint32
+}
+
+// CommitteeRotation describes an output spendable during the committee
+// rotation window by both the old and the new covenant committee, each of them
+// through its own leaf.
+type CommitteeRotation struct {
+	// Primary is the leaf tried first
+	Primary RotationLeaf
+	// Backup is the leaf used if primary committee did not reach quorum
+	Backup RotationLeaf
+}
+
+// BuildWitness builds the witness spending the input with given index through
+// the leaf of the first committee which reached quorum, trying the primary
+// committee first. Every signature of the combined set is checked against the
+// sighash of each leaf, so members of both committees must sign each leaf
+// separately. Signatures of other keys of the leaf, e.g. the delegator, must be
+// also part of the set.
+// prevOuts must contain the outputs spent by every input of the transaction, in
+// input order. It returns the witness together with the spend info of the
+// leaf used, and an error if neither committee reached quorum.
+func (c *CommitteeRotation) BuildWitness(
+	tx *wire.MsgTx,
+	inputIdx int,
+	prevOuts []*wire.TxOut,
+	sigs []BundledSignature,
+) (wire.TxWitness, *SpendInfo, error) {
+	var quorumErrs []error
+
+	for _, leaf := range []RotationLeaf{c.Primary, c.Backup} {
+		if leaf.SpendInfo == nil || leaf.Committee == nil {
+			return nil, nil, fmt.Errorf("rotation leaf must have spend info and committee")
+		}
+
+		if err := ValidateQuorum(leaf.Committee.Size(), int(leaf.Quorum)); err != nil {
+			return nil, nil, fmt.Errorf("invalid rotation leaf: %w", err)
+		}
+
+		sigHash, err := TaprootSigHash(tx, inputIdx, prevOuts, leaf.SpendInfo, txscript.SigHashDefault)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		validSigs := make(map[string]*schnorr.Signature)
+		for _, s := range sigs {
+			if s.SignerPk == nil || s.Signature == nil {
+				continue
+			}
+			if s.Signature.Verify(sigHash, s.SignerPk) {
+				validSigs[keyToString(s.SignerPk)] = s.Signature
+			}
+		}
+
+		covenantKeys := make(map[string]struct{}, leaf.Committee.Size())
+		for _, k := range leaf.Committee.Keys() {
+			covenantKeys[keyToString(k)] = struct{}{}
+		}
+
+		covenantSigs := 0
+		for signer := range validSigs {
+			if _, ok := covenantKeys[signer]; ok {
+				covenantSigs++
+			}
+		}
+
+		if covenantSigs < int(leaf.Quorum) {
+			quorumErrs = append(quorumErrs, fmt.Errorf(
+				"committee has %d valid signatures, quorum is %d", covenantSigs, leaf.Quorum,
+			))
+			continue
+		}
+
+		orderedSigs, err := ReorderSigsForLeaf(
+			selectQuorumSigs(validSigs, covenantKeys, int(leaf.Quorum)),
+			leaf.SpendInfo.GetPkScriptPath(),
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		witness, err := CreateWitness(leaf.SpendInfo, orderedSigs)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return witness, leaf.SpendInfo, nil
+	}
+
+	return nil, nil, fmt.Errorf(
+		"neither committee reached quorum: primary: %w, backup: %w", quorumErrs[0], quorumErrs[1],
+	)
+}
+
+// RederiveForNewCommittee returns the params of the delegation described by
+// oldParams with the covenant committee and quorum replaced by the ones set by
+// governance, from which the spend infos of future transactions are built.
+// Outputs created before the rotation keep committing to the old committee, so
+// they can be spent through the unbonding and slashing paths only with old
+// committee signatures. The returned flag tells whether the members retained in
+// the new committee alone still reach the old quorum, i.e whether existing
+// outputs stay spendable through these paths without the departed members.
+// The new committee must be non-empty, without duplicates, and must produce
+// spendable scripts with the new quorum.
+func RederiveForNewCommittee(
+	oldParams StakingParams,
+	newCommittee []*btcec.PublicKey,
+	newQuorum int,
+) (StakingParams, bool, error) {
+	committee, err := NewCovenantCommittee(newCommittee)
+	if err != nil {
+		return StakingParams{}, false, err
+	}
+
+	if err := ValidateCommitteeSize(committee.Size(), newQuorum); err != nil {
+		return StakingParams{}, false, err
+	}
+
+	newParams := oldParams
+	newParams.CovenantKeys = committee.Keys()
+	newParams.CovenantQuorum = uint32(newQuorum)
+
+	// the new committee must not reuse staker or finality provider keys
+	if _, err := BuildAllSpendInfos(newParams); err != nil {
+		return StakingParams{}, false, fmt.Errorf("invalid params with new committee: %w", err)
+	}
+
+	retained := 0
+	for _, key := range oldParams.CovenantKeys {
+		if committee.IndexOf(key) >= 0 {
+			retained++
+		}
+	}
+
+	return newParams, retained >= int(oldParams.CovenantQuorum), nil
+}
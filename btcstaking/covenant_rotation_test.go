@@ -0,0 +1,204 @@
+package btcstaking_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	btctest "github.com/babylonlabs-io/babylon/testutil/bitcoin"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitteeRotationBuildWitness(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	// new committee shares two members with the old one
+	newCovenantKeys := []*btcec.PrivateKey{scenario.CovenantKeys[0], scenario.CovenantKeys[1]}
+	for i := 0; i < 3; i++ {
+		key, err := btcec.NewPrivateKey()
+		require.NoError(t, err)
+		newCovenantKeys = append(newCovenantKeys, key)
+	}
+
+	oldParams := scenario.StakingParams(100)
+	newParams := oldParams
+	newParams.CovenantKeys = nil
+	for _, k := range newCovenantKeys {
+		newParams.CovenantKeys = append(newParams.CovenantKeys, k.PubKey())
+	}
+
+	oldInfos, err := btcstaking.BuildAllSpendInfos(oldParams)
+	require.NoError(t, err)
+	newInfos, err := btcstaking.BuildAllSpendInfos(newParams)
+	require.NoError(t, err)
+
+	// output committing to unbonding leaves of both committees
+	oldLeaf := oldInfos.Unbonding.RevealedLeaf
+	newLeaf := newInfos.Unbonding.RevealedLeaf
+	tree := txscript.AssembleTaprootScriptTree(oldLeaf, newLeaf)
+	internalKey := oldInfos.Unbonding.ControlBlock.InternalKey
+
+	leafSpendInfo := func(leaf txscript.TapLeaf) *btcstaking.SpendInfo {
+		proof := tree.LeafMerkleProofs[tree.LeafProofIndex[leaf.TapHash()]]
+		return &btcstaking.SpendInfo{
+			ControlBlock: proof.ToControlBlock(internalKey),
+			RevealedLeaf: leaf,
+		}
+	}
+	oldSi, newSi := leafSpendInfo(oldLeaf), leafSpendInfo(newLeaf)
+
+	rootHash := tree.RootNode.TapHash()
+	outputKey := txscript.ComputeTaprootOutputKey(internalKey, rootHash[:])
+	pkScript, err := txscript.PayToTaprootScript(outputKey)
+	require.NoError(t, err)
+	fundingOutput := wire.NewTxOut(int64(scenario.StakingAmount), pkScript)
+
+	oldCommittee, err := btcstaking.NewCovenantCommittee(oldParams.CovenantKeys)
+	require.NoError(t, err)
+	newCommittee, err := btcstaking.NewCovenantCommittee(newParams.CovenantKeys)
+	require.NoError(t, err)
+
+	rotation := btcstaking.CommitteeRotation{
+		Primary: btcstaking.RotationLeaf{SpendInfo: newSi, Committee: newCommittee, Quorum: 3},
+		Backup:  btcstaking.RotationLeaf{SpendInfo: oldSi, Committee: oldCommittee, Quorum: 3},
+	}
+
+	sign := func(tx *wire.MsgTx, si *btcstaking.SpendInfo, keys ...*btcec.PrivateKey) []btcstaking.BundledSignature {
+		sigHash, err := btcstaking.TaprootSigHash(tx, 0, []*wire.TxOut{fundingOutput}, si, txscript.SigHashDefault)
+		require.NoError(t, err)
+
+		var sigs []btcstaking.BundledSignature
+		for _, key := range keys {
+			sig, err := schnorr.Sign(key, sigHash)
+			require.NoError(t, err)
+			sigs = append(sigs, btcstaking.BundledSignature{SignerPk: key.PubKey(), Signature: sig})
+		}
+		return sigs
+	}
+
+	tests := []struct {
+		name       string
+		oldSigners []*btcec.PrivateKey
+		newSigners []*btcec.PrivateKey
+		expectedSi *btcstaking.SpendInfo
+	}{
+		{
+			name:       "new committee reached quorum",
+			oldSigners: []*btcec.PrivateKey{scenario.CovenantKeys[0], scenario.CovenantKeys[1]},
+			newSigners: newCovenantKeys,
+			expectedSi: newSi,
+		},
+		{
+			name:       "only old committee reached quorum",
+			oldSigners: []*btcec.PrivateKey{scenario.CovenantKeys[0], scenario.CovenantKeys[2], scenario.CovenantKeys[4]},
+			newSigners: []*btcec.PrivateKey{newCovenantKeys[0], newCovenantKeys[3]},
+			expectedSi: oldSi,
+		},
+		{
+			name:       "neither committee reached quorum",
+			oldSigners: []*btcec.PrivateKey{scenario.CovenantKeys[3], scenario.CovenantKeys[4]},
+			newSigners: []*btcec.PrivateKey{newCovenantKeys[2], newCovenantKeys[4]},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.9))
+
+			// staker signs both leaves
+			sigs := sign(tx, oldSi, append(tt.oldSigners, scenario.StakerKey)...)
+			sigs = append(sigs, sign(tx, newSi, append(tt.newSigners, scenario.StakerKey)...)...)
+			r.Shuffle(len(sigs), func(i, j int) { sigs[i], sigs[j] = sigs[j], sigs[i] })
+
+			witness, si, err := rotation.BuildWitness(tx, 0, []*wire.TxOut{fundingOutput}, sigs)
+			if tt.expectedSi == nil {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedSi, si)
+
+			tx.TxIn[0].Witness = witness
+			btctest.AssertSlashingTxExecution(t, fundingOutput, tx)
+		})
+	}
+}
+
+func TestRederiveForNewCommittee(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+	oldParams := scenario.StakingParams(100)
+	oldKeys := append([]*btcec.PublicKey{}, oldParams.CovenantKeys...)
+
+	oldPkScript, err := btcstaking.StakingOutputScript(oldParams)
+	require.NoError(t, err)
+
+	newKeys := func(n int) []*btcec.PublicKey {
+		keys := make([]*btcec.PublicKey, n)
+		for i := range keys {
+			key, err := btcec.NewPrivateKey()
+			require.NoError(t, err)
+			keys[i] = key.PubKey()
+		}
+		return keys
+	}
+
+	// same committee in other order derives the same outputs
+	shuffled := append([]*btcec.PublicKey{}, oldKeys...)
+	r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	sameParams, spendable, err := btcstaking.RederiveForNewCommittee(oldParams, shuffled, 3)
+	require.NoError(t, err)
+	require.True(t, spendable)
+	samePkScript, err := btcstaking.StakingOutputScript(sameParams)
+	require.NoError(t, err)
+	require.Equal(t, oldPkScript, samePkScript)
+
+	// two members replaced, the three retained ones still reach the old quorum
+	rotated := append(append([]*btcec.PublicKey{}, oldKeys[:3]...), newKeys(2)...)
+	newParams, spendable, err := btcstaking.RederiveForNewCommittee(oldParams, rotated, 4)
+	require.NoError(t, err)
+	require.True(t, spendable)
+	require.Equal(t, uint32(4), newParams.CovenantQuorum)
+	for _, diff := range btcstaking.DiffStakingParams(oldParams, newParams) {
+		require.Regexp(t, "^covenant", diff)
+	}
+	newPkScript, err := btcstaking.StakingOutputScript(newParams)
+	require.NoError(t, err)
+	require.NotEqual(t, oldPkScript, newPkScript)
+
+	// old params are not modified
+	require.Equal(t, oldKeys, oldParams.CovenantKeys)
+	require.Equal(t, uint32(3), oldParams.CovenantQuorum)
+
+	// three members replaced, old outputs need departed members
+	_, spendable, err = btcstaking.RederiveForNewCommittee(
+		oldParams, append(append([]*btcec.PublicKey{}, oldKeys[:2]...), newKeys(3)...), 3,
+	)
+	require.NoError(t, err)
+	require.False(t, spendable)
+
+	invalid := []struct {
+		name      string
+		committee []*btcec.PublicKey
+		quorum    int
+	}{
+		{"empty committee", nil, 1},
+		{"zero quorum", rotated, 0},
+		{"quorum exceeding committee", rotated, 6},
+		{"duplicate key", append(newKeys(2), oldKeys[0], oldKeys[0]), 2},
+		{"staker key in committee", append(newKeys(2), oldParams.StakerKey), 2},
+	}
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := btcstaking.RederiveForNewCommittee(oldParams, tc.committee, tc.quorum)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package btcstaking
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// CollectorInput describes an input of the transaction which must be signed
+// by a covenant quorum
+type CollectorInput struct {
+	// InputIdx is the index of the input in the transaction
+	InputIdx int
+	// SpendInfo describes the script path spent by the input
+	SpendInfo *SpendInfo
+	// CovenantKeys are the public keys of the covenant committee of the spent output
+	CovenantKeys []*btcec.PublicKey
+	// CovenantQuorum is the number of covenant signatures required by the spent output
+	CovenantQuorum uint32
+}
+
+type inputSigState struct {
+	si           *SpendInfo
+	sigHash      []byte
+	leafKeys     map[string]struct{}
+	covenantKeys map[string]struct{}
+	quorum       int
+	sigs         map[string]*schnorr.Signature
+}
+
+func (s *inputSigState) covenantSigCount() int {
+	count := 0
+	for k := range s.sigs {
+		if _, ok := s.covenantKeys[k]; ok {
+			count++
+		}
+	}
+	return count
+}
+
+// MultiInputSigCollector collects signatures for a transaction spending
+// multiple Babylon outputs, e.g. batch unbonding transaction, where every
+// input requires its own covenant quorum. Quorum progress is tracked
+// independently for every input, and witnesses are built only once every
+// input has reached its quorum.
+// MultiInputSigCollector is not safe for concurrent use.
+type MultiInputSigCollector struct {
+	tx     *wire.MsgTx
+	inputs map[int]*inputSigState
+}
+
+// NewMultiInputSigCollector creates collector for the given transaction.
+// prevOuts must contain the outputs spent by every input of the transaction, in
+// input order, as taproot sighash commits to all of them.
+func NewMultiInputSigCollector(
+	tx *wire.MsgTx,
+	prevOuts []*wire.TxOut,
+	inputs []CollectorInput,
+) (*MultiInputSigCollector, error) {
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("at least one input must be provided")
+	}
+
+	states := make(map[int]*inputSigState, len(inputs))
+
+	for _, in := range inputs {
+		if _, ok := states[in.InputIdx]; ok {
+			return nil, fmt.Errorf("duplicate input %d", in.InputIdx)
+		}
+
+		if err := ValidateQuorum(len(in.CovenantKeys), int(in.CovenantQuorum)); err != nil {
+			return nil, fmt.Errorf("invalid quorum of input %d: %w", in.InputIdx, err)
+		}
+
+		sigHash, err := TaprootSigHash(tx, in.InputIdx, prevOuts, in.SpendInfo, txscript.SigHashDefault)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute sig hash of input %d: %w", in.InputIdx, err)
+		}
+
+		scriptKeys, err := ExtractScriptPubKeys(in.SpendInfo.GetPkScriptPath())
+		if err != nil {
+			return nil, err
+		}
+
+		leafKeys := make(map[string]struct{}, len(scriptKeys))
+		for _, k := range scriptKeys {
+			leafKeys[keyToString(k)] = struct{}{}
+		}
+
+		covenantKeys := make(map[string]struct{}, len(in.CovenantKeys))
+		for _, k := range in.CovenantKeys {
+			key := keyToString(k)
+			if _, ok := leafKeys[key]; !ok {
+				return nil, fmt.Errorf("covenant key %s is not committed in the leaf of input %d", key, in.InputIdx)
+			}
+			covenantKeys[key] = struct{}{}
+		}
+
+		states[in.InputIdx] = &inputSigState{
+			si:           in.SpendInfo,
+			sigHash:      sigHash,
+			leafKeys:     leafKeys,
+			covenantKeys: covenantKeys,
+			quorum:       int(in.CovenantQuorum),
+			sigs:         make(map[string]*schnorr.Signature),
+		}
+	}
+
+	return &MultiInputSigCollector{
+		tx:     tx.Copy(),
+		inputs: states,
+	}, nil
+}
+
+// selectQuorumSigs returns all the signatures of keys which are not covenant
+// members, and signatures of only quorum covenant members, in order of their
+// keys. Covenant multisig requires exactly quorum signatures, so signatures
+// above quorum would make the witness invalid.
+func selectQuorumSigs(
+	sigs map[string]*schnorr.Signature,
+	covenantKeys map[string]struct{},
+	quorum int,
+) map[string]*schnorr.Signature {
+	var covenantSigners []string
+	selected := make(map[string]*schnorr.Signature, len(sigs))
+
+	for signer, sig := range sigs {
+		if _, ok := covenantKeys[signer]; ok {
+			covenantSigners = append(covenantSigners, signer)
+			continue
+		}
+		selected[signer] = sig
+	}
+
+	sort.Strings(covenantSigners)
+	if len(covenantSigners) > quorum {
+		covenantSigners = covenantSigners[:quorum]
+	}
+
+	for _, signer := range covenantSigners {
+		selected[signer] = sigs[signer]
+	}
+
+	return selected
+}
+
+func (c *MultiInputSigCollector) inputState(inputIdx int) (*inputSigState, error) {
+	state, ok := c.inputs[inputIdx]
+	if !ok {
+		return nil, fmt.Errorf("input %d is not tracked by the collector", inputIdx)
+	}
+	return state, nil
+}
+
+// AddSignature adds the signature of the given signer over the input with
+// given index. Signer must be one of the keys committed in the leaf spent by the
+// input, e.g. covenant member or the staker. Signature is verified against the
+// input sighash before being accepted. Adding signature of the same signer
+// twice replaces the previous one.
+func (c *MultiInputSigCollector) AddSignature(
+	inputIdx int,
+	signerPk *btcec.PublicKey,
+	sig *schnorr.Signature,
+) error {
+	state, err := c.inputState(inputIdx)
+	if err != nil {
+		return err
+	}
+
+	if signerPk == nil || sig == nil {
+		return fmt.Errorf("signer and signature must not be nil")
+	}
+
+	signer := keyToString(signerPk)
+	if _, ok := state.leafKeys[signer]; !ok {
+		return fmt.Errorf("key %s is not committed in the leaf of input %d", signer, inputIdx)
+	}
+
+	if !sig.Verify(state.sigHash, signerPk) {
+		return fmt.Errorf("signature of %s over input %d is not valid", signer, inputIdx)
+	}
+
+	state.sigs[signer] = sig
+
+	return nil
+}
+
+// InputProgress returns the number of covenant signatures collected for the
+// input with given index and the quorum required by it
+func (c *MultiInputSigCollector) InputProgress(inputIdx int) (collected int, quorum int, err error) {
+	state, err := c.inputState(inputIdx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return state.covenantSigCount(), state.quorum, nil
+}
+
+// IsComplete returns true if every tracked input has reached its covenant quorum
+func (c *MultiInputSigCollector) IsComplete() bool {
+	for _, state := range c.inputs {
+		if state.covenantSigCount() < state.quorum {
+			return false
+		}
+	}
+	return true
+}
+
+// BuildSignedTx returns copy of the transaction with witnesses of all tracked
+// inputs. Covenant multisig requires exactly quorum signatures, so if more
+// covenant members signed an input, only quorum of them, in order of their
+// keys, are included in the witness. Other signatures required by the leaf,
+// e.g. the delegator signature, must be added before calling BuildSignedTx.
+func (c *MultiInputSigCollector) BuildSignedTx() (*wire.MsgTx, error) {
+	for idx, state := range c.inputs {
+		if collected := state.covenantSigCount(); collected < state.quorum {
+			return nil, fmt.Errorf(
+				"input %d has %d covenant signatures, quorum is %d", idx, collected, state.quorum,
+			)
+		}
+	}
+
+	signedTx := c.tx.Copy()
+
+	for idx, state := range c.inputs {
+		sigs := selectQuorumSigs(state.sigs, state.covenantKeys, state.quorum)
+
+		orderedSigs, err := ReorderSigsForLeaf(sigs, state.si.GetPkScriptPath())
+		if err != nil {
+			return nil, err
+		}
+
+		witness, err := CreateWitness(state.si, orderedSigs)
+		if err != nil {
+			return nil, err
+		}
+
+		signedTx.TxIn[idx].Witness = witness
+	}
+
+	return signedTx, nil
+}
@@ -0,0 +1,140 @@
+package btcstaking_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	btctest "github.com/babylonlabs-io/babylon/testutil/bitcoin"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiInputSigCollector(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	// two staking outputs of different stakers protected by the same covenant
+	otherStakerKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	stakerKeys := []*btcec.PrivateKey{scenario.StakerKey, otherStakerKey}
+
+	tx := wire.NewMsgTx(2)
+	var (
+		prevOuts []*wire.TxOut
+		inputs   []btcstaking.CollectorInput
+	)
+
+	for i, stakerKey := range stakerKeys {
+		stakingInfo, err := btcstaking.BuildStakingInfo(
+			stakerKey.PubKey(),
+			scenario.FinalityProviderPublicKeys(),
+			scenario.CovenantPublicKeys(),
+			scenario.RequiredCovenantSigs,
+			scenario.StakingTime,
+			scenario.StakingAmount,
+			&chaincfg.MainNetParams,
+		)
+		require.NoError(t, err)
+
+		si, err := stakingInfo.UnbondingPathSpendInfo()
+		require.NoError(t, err)
+
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{byte(i + 1)}, Index: 0}, nil, nil))
+		prevOuts = append(prevOuts, stakingInfo.StakingOutput)
+		inputs = append(inputs, btcstaking.CollectorInput{
+			InputIdx:       i,
+			SpendInfo:      si,
+			CovenantKeys:   scenario.CovenantPublicKeys(),
+			CovenantQuorum: scenario.RequiredCovenantSigs,
+		})
+	}
+	tx.AddTxOut(taprootOutputWithValue(t, r, 2*scenario.StakingAmount-1000))
+
+	collector, err := btcstaking.NewMultiInputSigCollector(tx, prevOuts, inputs)
+	require.NoError(t, err)
+
+	sign := func(inputIdx int, key *btcec.PrivateKey) {
+		sigHash, err := btcstaking.TaprootSigHash(
+			tx, inputIdx, prevOuts, inputs[inputIdx].SpendInfo, txscript.SigHashDefault,
+		)
+		require.NoError(t, err)
+		sig, err := schnorr.Sign(key, sigHash)
+		require.NoError(t, err)
+		require.NoError(t, collector.AddSignature(inputIdx, key.PubKey(), sig))
+	}
+
+	requireProgress := func(inputIdx, expected int) {
+		collected, quorum, err := collector.InputProgress(inputIdx)
+		require.NoError(t, err)
+		require.Equal(t, expected, collected)
+		require.Equal(t, int(scenario.RequiredCovenantSigs), quorum)
+	}
+
+	// delegator signatures are known upfront
+	sign(0, stakerKeys[0])
+	sign(1, stakerKeys[1])
+	requireProgress(0, 0)
+	requireProgress(1, 0)
+
+	// covenant signatures arrive in staggered order
+	sign(0, scenario.CovenantKeys[0])
+	sign(1, scenario.CovenantKeys[4])
+	sign(0, scenario.CovenantKeys[1])
+	sign(0, scenario.CovenantKeys[2])
+	requireProgress(0, 3)
+	requireProgress(1, 1)
+	require.False(t, collector.IsComplete())
+
+	_, err = collector.BuildSignedTx()
+	require.Error(t, err)
+
+	// signature over the other input is rejected
+	sigHash, err := btcstaking.TaprootSigHash(tx, 0, prevOuts, inputs[0].SpendInfo, txscript.SigHashDefault)
+	require.NoError(t, err)
+	wrongSig, err := schnorr.Sign(scenario.CovenantKeys[3], sigHash)
+	require.NoError(t, err)
+	require.Error(t, collector.AddSignature(1, scenario.CovenantKeys[3].PubKey(), wrongSig))
+	requireProgress(1, 1)
+
+	// signature of key outside of the leaf is rejected
+	require.Error(t, collector.AddSignature(0, otherStakerKey.PubKey(), wrongSig))
+
+	// signatures above quorum are accepted, but not included in the witness
+	sign(0, scenario.CovenantKeys[3])
+	sign(1, scenario.CovenantKeys[3])
+	sign(1, scenario.CovenantKeys[1])
+	requireProgress(0, 4)
+	requireProgress(1, 3)
+	require.True(t, collector.IsComplete())
+
+	signedTx, err := collector.BuildSignedTx()
+	require.NoError(t, err)
+
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for i, in := range signedTx.TxIn {
+		fetcher.AddPrevOut(in.PreviousOutPoint, prevOuts[i])
+	}
+
+	for i := range signedTx.TxIn {
+		btctest.AssertEngineExecution(t, i, true, func() (*txscript.Engine, error) {
+			return txscript.NewEngine(
+				prevOuts[i].PkScript,
+				signedTx,
+				i,
+				txscript.StandardVerifyFlags,
+				nil,
+				txscript.NewTxSigHashes(signedTx, fetcher),
+				prevOuts[i].Value,
+				fetcher,
+			)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package btcstaking
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// LoadCovenantSigs reads covenant signatures written by SaveCovenantSigs, e.g
+// signatures exchanged as files during air-gapped covenant signing. The file is
+// a JSON object mapping hex encoded BIP340 public keys to hex encoded BIP340
+// signatures. Every key and signature must be valid. Returned map is keyed by
+// lowercase hex encoded keys, so it can be passed directly to the ordering
+// helpers and witness builders, e.g ReorderSigsForLeaf.
+func LoadCovenantSigs(r io.Reader) (map[string]*schnorr.Signature, error) {
+	if r == nil {
+		return nil, fmt.Errorf("reader must not be nil")
+	}
+
+	var encoded map[string]string
+	if err := json.NewDecoder(r).Decode(&encoded); err != nil {
+		return nil, fmt.Errorf("failed to decode covenant signatures: %w", err)
+	}
+
+	sigs := make(map[string]*schnorr.Signature, len(encoded))
+	for pkHex, sigHex := range encoded {
+		pkBytes, err := hex.DecodeString(pkHex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid covenant key %s: %w", pkHex, err)
+		}
+
+		pk, err := schnorr.ParsePubKey(pkBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid covenant key %s: %w", pkHex, err)
+		}
+
+		sigBytes, err := hex.DecodeString(sigHex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature of covenant key %s: %w", pkHex, err)
+		}
+
+		sig, err := schnorr.ParseSignature(sigBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature of covenant key %s: %w", pkHex, err)
+		}
+
+		// the same key may be encoded in different case
+		key := hex.EncodeToString(schnorr.SerializePubKey(pk))
+		if _, ok := sigs[key]; ok {
+			return nil, fmt.Errorf("duplicate covenant key %s", pkHex)
+		}
+
+		sigs[key] = sig
+	}
+
+	return sigs, nil
+}
+
+// SaveCovenantSigs writes covenant signatures keyed by hex encoded BIP340
+// public keys in the format read by LoadCovenantSigs. Entries are sorted by key,
+// so the same signatures always produce the same file.
+func SaveCovenantSigs(w io.Writer, sigs map[string]*schnorr.Signature) error {
+	if w == nil {
+		return fmt.Errorf("writer must not be nil")
+	}
+
+	encoded := make(map[string]string, len(sigs))
+	for pkHex, sig := range sigs {
+		if sig == nil {
+			return fmt.Errorf("signature of covenant key %s must not be nil", pkHex)
+		}
+
+		pkBytes, err := hex.DecodeString(pkHex)
+		if err != nil {
+			return fmt.Errorf("invalid covenant key %s: %w", pkHex, err)
+		}
+
+		if _, err := schnorr.ParsePubKey(pkBytes); err != nil {
+			return fmt.Errorf("invalid covenant key %s: %w", pkHex, err)
+		}
+
+		encoded[pkHex] = hex.EncodeToString(sig.Serialize())
+	}
+
+	// encoding/json writes map entries sorted by key
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(encoded)
+}
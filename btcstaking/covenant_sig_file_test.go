@@ -0,0 +1,84 @@
+package btcstaking_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCovenantSigsFileRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+
+	sigHash := bytes.Repeat([]byte{0x01}, 32)
+
+	sigs := make(map[string]*schnorr.Signature)
+	for _, covKey := range scenario.CovenantKeys[:params.CovenantQuorum] {
+		sig, err := schnorr.Sign(covKey, sigHash)
+		require.NoError(t, err)
+		sigs[hex.EncodeToString(schnorr.SerializePubKey(covKey.PubKey()))] = sig
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, btcstaking.SaveCovenantSigs(&buf, sigs))
+
+	// output is deterministic
+	var buf2 bytes.Buffer
+	require.NoError(t, btcstaking.SaveCovenantSigs(&buf2, sigs))
+	require.Equal(t, buf.String(), buf2.String())
+
+	loaded, err := btcstaking.LoadCovenantSigs(&buf)
+	require.NoError(t, err)
+	require.Len(t, loaded, len(sigs))
+	for k, sig := range sigs {
+		require.True(t, sig.IsEqual(loaded[k]))
+	}
+
+	// loaded signatures feed the ordering helpers
+	ordered, err := btcstaking.ReorderSigsForLeaf(loaded, infos.Unbonding.RevealedLeaf.Script)
+	require.NoError(t, err)
+	filled := 0
+	for _, sig := range ordered {
+		if len(sig) > 0 {
+			filled++
+		}
+	}
+	require.Equal(t, int(params.CovenantQuorum), filled)
+
+	var validPk, validSig string
+	for k, sig := range sigs {
+		validPk, validSig = k, hex.EncodeToString(sig.Serialize())
+		break
+	}
+
+	invalidFiles := map[string]string{
+		"malformed json":     `{"` + validPk + `": `,
+		"invalid key hex":    `{"zz": "` + validSig + `"}`,
+		"key not on curve":   `{"` + strings.Repeat("ff", 32) + `": "` + validSig + `"}`,
+		"compressed key":     `{"02` + validPk + `": "` + validSig + `"}`,
+		"invalid sig hex":    `{"` + validPk + `": "zz"}`,
+		"short signature":    `{"` + validPk + `": "` + validSig[:126] + `"}`,
+		"duplicate key case": `{"` + validPk + `": "` + validSig + `", "` + strings.ToUpper(validPk) + `": "` + validSig + `"}`,
+	}
+	for name, file := range invalidFiles {
+		t.Run(name, func(t *testing.T) {
+			_, err := btcstaking.LoadCovenantSigs(strings.NewReader(file))
+			require.Error(t, err)
+		})
+	}
+
+	require.Error(t, btcstaking.SaveCovenantSigs(&buf, map[string]*schnorr.Signature{"zz": sigs[validPk]}))
+	require.Error(t, btcstaking.SaveCovenantSigs(&buf, map[string]*schnorr.Signature{validPk: nil}))
+}
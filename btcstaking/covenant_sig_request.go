@@ -0,0 +1,219 @@
+package btcstaking
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// InputSigRequest describes what a covenant member must sign for a single input
+type InputSigRequest struct {
+	// InputIdx is the index of the input in the transaction
+	InputIdx int `json:"input_idx"`
+	// SigHash is the tapscript signature hash the member must sign
+	SigHash []byte `json:"sig_hash"`
+	// LeafScript is the script of the leaf being spent
+	LeafScript []byte `json:"leaf_script"`
+	// LeafVersion is the version of the leaf being spent
+	LeafVersion txscript.TapscriptLeafVersion `json:"leaf_version"`
+}
+
+// SigRequest is the request sent to a remote covenant signer
+type SigRequest struct {
+	// UnsignedTx is the serialized transaction which is being signed. It allows
+	// the member to inspect the transaction before signing.
+	UnsignedTx []byte `json:"unsigned_tx"`
+	// Inputs contains the signing request for every input which requires
+	// covenant signature
+	Inputs []InputSigRequest `json:"inputs"`
+}
+
+// InputSignature is the signature of the covenant member over a single input
+type InputSignature struct {
+	// InputIdx is the index of the signed input in the transaction
+	InputIdx int `json:"input_idx"`
+	// Signature is the BIP340 schnorr signature over the input sig hash
+	Signature []byte `json:"signature"`
+}
+
+// SigResponse is the response of a remote covenant signer
+type SigResponse struct {
+	// SignerPk is the BIP340 public key of the covenant member
+	SignerPk []byte `json:"signer_pk"`
+	// Signatures contains signatures for every input of the request in the same order
+	Signatures []InputSignature `json:"signatures"`
+}
+
+// BuildCovenantSigRequest builds the request for covenant members to sign the
+// inputs with given indexes. spendInfos[i] must describe the script path spent
+// by input inputIdxs[i]. prevOuts must contain outputs spent by all the inputs of
+// the transaction, in input order.
+func BuildCovenantSigRequest(
+	tx *wire.MsgTx,
+	inputIdxs []int,
+	spendInfos []*SpendInfo,
+	prevOuts []*wire.TxOut,
+) (*SigRequest, error) {
+	if tx == nil {
+		return nil, fmt.Errorf("tx must not be nil")
+	}
+
+	if len(inputIdxs) == 0 {
+		return nil, fmt.Errorf("at least one input must be provided")
+	}
+
+	if len(inputIdxs) != len(spendInfos) {
+		return nil, fmt.Errorf("number of input indexes %d does not match number of spend infos %d", len(inputIdxs), len(spendInfos))
+	}
+
+	var txBuf bytes.Buffer
+	if err := tx.Serialize(&txBuf); err != nil {
+		return nil, fmt.Errorf("failed to serialize tx: %w", err)
+	}
+
+	inputs := make([]InputSigRequest, len(inputIdxs))
+
+	for i, idx := range inputIdxs {
+		sigHash, err := TaprootSigHash(tx, idx, prevOuts, spendInfos[i], txscript.SigHashDefault)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute sig hash of input %d: %w", idx, err)
+		}
+
+		inputs[i] = InputSigRequest{
+			InputIdx:    idx,
+			SigHash:     sigHash,
+			LeafScript:  spendInfos[i].RevealedLeaf.Script,
+			LeafVersion: spendInfos[i].RevealedLeaf.LeafVersion,
+		}
+	}
+
+	return &SigRequest{
+		UnsignedTx: txBuf.Bytes(),
+		Inputs:     inputs,
+	}, nil
+}
+
+// BuildUnbondingSlashingSigRequest builds the request for covenant members to
+// pre-sign the unbonding slashing tx of the delegation described by params. The
+// request is built for the unbonding output, with the same checks as
+// UnbondingSlashingSigHash, so members can not be asked to sign the slashing
+// tx of the staking output in its place.
+func BuildUnbondingSlashingSigRequest(
+	slashingTx *wire.MsgTx,
+	unbondingTx *wire.MsgTx,
+	params StakingParams,
+) (*SigRequest, error) {
+	si, unbondingOut, err := unbondingSlashingSpend(slashingTx, unbondingTx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return BuildCovenantSigRequest(slashingTx, []int{0}, []*SpendInfo{si}, []*wire.TxOut{unbondingOut})
+}
+
+// Marshal serializes the request to json
+func (r *SigRequest) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// NewSigRequestFromBytes deserializes the request from json
+func NewSigRequestFromBytes(b []byte) (*SigRequest, error) {
+	var r SigRequest
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal sig request: %w", err)
+	}
+	return &r, nil
+}
+
+// Tx deserializes the transaction being signed
+func (r *SigRequest) Tx() (*wire.MsgTx, error) {
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(r.UnsignedTx)); err != nil {
+		return nil, fmt.Errorf("failed to deserialize tx: %w", err)
+	}
+	return &tx, nil
+}
+
+// Sign signs every input of the request with the given private key
+func (r *SigRequest) Sign(privKey *btcec.PrivateKey) (*SigResponse, error) {
+	if privKey == nil {
+		return nil, fmt.Errorf("private key must not be nil")
+	}
+
+	signatures := make([]InputSignature, len(r.Inputs))
+
+	for i, in := range r.Inputs {
+		sig, err := schnorr.Sign(privKey, in.SigHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign input %d: %w", in.InputIdx, err)
+		}
+
+		signatures[i] = InputSignature{
+			InputIdx:  in.InputIdx,
+			Signature: sig.Serialize(),
+		}
+	}
+
+	return &SigResponse{
+		SignerPk:   schnorr.SerializePubKey(privKey.PubKey()),
+		Signatures: signatures,
+	}, nil
+}
+
+// Marshal serializes the response to json
+func (r *SigResponse) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// NewSigResponseFromBytes deserializes the response from json
+func NewSigResponseFromBytes(b []byte) (*SigResponse, error) {
+	var r SigResponse
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal sig response: %w", err)
+	}
+	return &r, nil
+}
+
+// Verify checks that the response contains a valid signature of the signer for
+// every input of the given request and returns the parsed signatures in the
+// order of the request inputs.
+func (r *SigResponse) Verify(req *SigRequest) ([]*schnorr.Signature, error) {
+	if req == nil {
+		return nil, fmt.Errorf("request must not be nil")
+	}
+
+	signerPk, err := schnorr.ParsePubKey(r.SignerPk)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signer public key: %w", err)
+	}
+
+	if len(r.Signatures) != len(req.Inputs) {
+		return nil, fmt.Errorf("expected %d signatures, got %d", len(req.Inputs), len(r.Signatures))
+	}
+
+	sigs := make([]*schnorr.Signature, len(req.Inputs))
+
+	for i, in := range req.Inputs {
+		if r.Signatures[i].InputIdx != in.InputIdx {
+			return nil, fmt.Errorf("signature %d is for input %d, expected input %d", i, r.Signatures[i].InputIdx, in.InputIdx)
+		}
+
+		sig, err := schnorr.ParseSignature(r.Signatures[i].Signature)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature for input %d: %w", in.InputIdx, err)
+		}
+
+		if !sig.Verify(in.SigHash, signerPk) {
+			return nil, fmt.Errorf("signature for input %d is not valid", in.InputIdx)
+		}
+
+		sigs[i] = sig
+	}
+
+	return sigs, nil
+}
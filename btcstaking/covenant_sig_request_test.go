@@ -0,0 +1,203 @@
+package btcstaking_test
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	btctest "github.com/babylonlabs-io/babylon/testutil/bitcoin"
+	"github.com/babylonlabs-io/babylon/testutil/datagen"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCovenantSigRequestRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	si, err := stakingInfo.UnbondingPathSpendInfo()
+	require.NoError(t, err)
+
+	unbondingTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+
+	req, err := btcstaking.BuildCovenantSigRequest(
+		unbondingTx,
+		[]int{0},
+		[]*btcstaking.SpendInfo{si},
+		[]*wire.TxOut{stakingInfo.StakingOutput},
+	)
+	require.NoError(t, err)
+
+	reqBytes, err := req.Marshal()
+	require.NoError(t, err)
+	decodedReq, err := btcstaking.NewSigRequestFromBytes(reqBytes)
+	require.NoError(t, err)
+	require.Equal(t, req, decodedReq)
+
+	decodedTx, err := decodedReq.Tx()
+	require.NoError(t, err)
+	require.Equal(t, unbondingTx.TxHash(), decodedTx.TxHash())
+
+	for _, covKey := range scenario.CovenantKeys {
+		resp, err := decodedReq.Sign(covKey)
+		require.NoError(t, err)
+
+		respBytes, err := resp.Marshal()
+		require.NoError(t, err)
+		decodedResp, err := btcstaking.NewSigResponseFromBytes(respBytes)
+		require.NoError(t, err)
+		require.Equal(t, resp, decodedResp)
+
+		sigs, err := decodedResp.Verify(req)
+		require.NoError(t, err)
+		require.Len(t, sigs, 1)
+
+		// signature must be valid over the unbonding tx
+		err = btcstaking.VerifyTransactionSigWithOutput(
+			unbondingTx,
+			stakingInfo.StakingOutput,
+			si.GetPkScriptPath(),
+			covKey.PubKey(),
+			sigs[0].Serialize(),
+		)
+		require.NoError(t, err)
+	}
+
+	// signature of a different key must not verify
+	resp, err := req.Sign(scenario.StakerKey)
+	require.NoError(t, err)
+	resp.SignerPk = schnorr.SerializePubKey(scenario.CovenantKeys[0].PubKey())
+	_, err = resp.Verify(req)
+	require.Error(t, err)
+}
+
+func TestBuildCovenantSigRequestInvalidInputs(t *testing.T) {
+	tx := createSpendStakeTx(btcutil.Amount(1000))
+
+	_, err := btcstaking.BuildCovenantSigRequest(tx, []int{0}, nil, []*wire.TxOut{{}})
+	require.Error(t, err)
+
+	_, err = btcstaking.BuildCovenantSigRequest(tx, []int{1}, []*btcstaking.SpendInfo{{}}, []*wire.TxOut{{}})
+	require.Error(t, err)
+
+	_, err = btcstaking.BuildCovenantSigRequest(tx, []int{0}, []*btcstaking.SpendInfo{{}}, nil)
+	require.Error(t, err)
+}
+
+func TestBuildUnbondingSlashingSigRequest(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+	stakingPkScript, err := btcstaking.StakingOutputScript(params)
+	require.NoError(t, err)
+	unbondingInfo, err := btcstaking.BuildUnbondingInfo(
+		params.StakerKey,
+		params.FinalityProviderKeys,
+		params.CovenantKeys,
+		params.CovenantQuorum,
+		params.UnbondingTime,
+		params.StakingAmount-1000,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	stakingTx := wire.NewMsgTx(2)
+	stakingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	stakingTx.AddTxOut(wire.NewTxOut(int64(params.StakingAmount), stakingPkScript))
+
+	unbondingTx := wire.NewMsgTx(2)
+	stakingHash := stakingTx.TxHash()
+	unbondingTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&stakingHash, 0), nil, nil))
+	unbondingTx.AddTxOut(unbondingInfo.UnbondingOutput)
+	unbondingOut := unbondingInfo.UnbondingOutput
+
+	newSlashingTx := func(fundingTx *wire.MsgTx) *wire.MsgTx {
+		tx := wire.NewMsgTx(2)
+		fundingHash := fundingTx.TxHash()
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&fundingHash, 0), nil, nil))
+		tx.AddTxOut(wire.NewTxOut(fundingTx.TxOut[0].Value-2000, datagen.GenRandomByteArray(r, 34)))
+		return tx
+	}
+	slashingTx := newSlashingTx(unbondingTx)
+
+	sigHash, err := btcstaking.UnbondingSlashingSigHash(slashingTx, unbondingTx, params)
+	require.NoError(t, err)
+
+	req, err := btcstaking.BuildUnbondingSlashingSigRequest(slashingTx, unbondingTx, params)
+	require.NoError(t, err)
+	require.Len(t, req.Inputs, 1)
+	require.Equal(t, sigHash, req.Inputs[0].SigHash)
+	require.Equal(t, infos.UnbondingSlashing.RevealedLeaf.Script, req.Inputs[0].LeafScript)
+
+	// sig hash of the same slashing tx spending the staking output differs
+	stakingSigHash, err := btcstaking.TaprootSigHash(
+		slashingTx, 0, []*wire.TxOut{stakingTx.TxOut[0]}, infos.Slashing, txscript.SigHashDefault,
+	)
+	require.NoError(t, err)
+	require.NotEqual(t, stakingSigHash, sigHash)
+
+	committee, err := btcstaking.NewCovenantCommittee(params.CovenantKeys)
+	require.NoError(t, err)
+	covSigs := make(map[string]*schnorr.Signature)
+	for _, covKey := range scenario.CovenantKeys[:params.CovenantQuorum] {
+		resp, err := req.Sign(covKey)
+		require.NoError(t, err)
+		sigs, err := resp.Verify(req)
+		require.NoError(t, err)
+		covSigs[hex.EncodeToString(schnorr.SerializePubKey(covKey.PubKey()))] = sigs[0]
+	}
+
+	fpSig, err := schnorr.Sign(scenario.FinalityProviderKeys[0], sigHash)
+	require.NoError(t, err)
+	stakerSig, err := schnorr.Sign(scenario.StakerKey, sigHash)
+	require.NoError(t, err)
+
+	witness, err := btcstaking.CreateWitnessForPath(
+		infos, btcstaking.UnbondingSlashingPath, committee.OrderSignatures(covSigs),
+		[]*schnorr.Signature{fpSig}, stakerSig,
+	)
+	require.NoError(t, err)
+	slashingTx.TxIn[0].Witness = witness
+	btctest.AssertSlashingTxExecution(t, unbondingOut, slashingTx)
+
+	// slashing tx of the staking output is rejected
+	_, err = btcstaking.UnbondingSlashingSigHash(newSlashingTx(stakingTx), stakingTx, params)
+	require.Error(t, err)
+	_, err = btcstaking.BuildUnbondingSlashingSigRequest(newSlashingTx(stakingTx), stakingTx, params)
+	require.Error(t, err)
+
+	// slashing tx not spending the unbonding tx
+	_, err = btcstaking.UnbondingSlashingSigHash(newSlashingTx(stakingTx), unbondingTx, params)
+	require.Error(t, err)
+
+	// unbonding output of different delegation
+	otherParams := params
+	otherParams.UnbondingTime = 101
+	_, err = btcstaking.UnbondingSlashingSigHash(slashingTx, unbondingTx, otherParams)
+	require.Error(t, err)
+
+	_, err = btcstaking.UnbondingSlashingSigHash(nil, unbondingTx, params)
+	require.Error(t, err)
+	_, err = btcstaking.UnbondingSlashingSigHash(slashingTx, nil, params)
+	require.Error(t, err)
+}
@@ -0,0 +1,495 @@
+package btcstaking
+
+import (
+	"bytes"
+	"fmt"
+	"iter"
+	"math/bits"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// QuorumCombinations lazily yields every subset of committee members with at
+// least quorum members, as presence masks indexed in the same way as the
+// committee. Masks are generated on demand, so the iteration can be stopped
+// early even for large committees. Every yielded mask is a fresh slice.
+func QuorumCombinations(committeeSize, quorum int) iter.Seq[[]bool] {
+	return func(yield func([]bool) bool) {
+		if committeeSize <= 0 || quorum < 1 || quorum > committeeSize {
+			return
+		}
+
+		mask := make([]bool, committeeSize)
+
+		// generate masks by deciding membership of each position, pruning
+		// branches which can't reach the quorum anymore
+		var generate func(pos, selected int) bool
+		generate = func(pos, selected int) bool {
+			if selected+committeeSize-pos < quorum {
+				return true
+			}
+
+			if pos == committeeSize {
+				result := make([]bool, committeeSize)
+				copy(result, mask)
+				return yield(result)
+			}
+
+			mask[pos] = false
+			if !generate(pos+1, selected) {
+				return false
+			}
+
+			mask[pos] = true
+			defer func() { mask[pos] = false }()
+			return generate(pos+1, selected+1)
+		}
+
+		generate(0, 0)
+	}
+}
+
+// EnumerateQuorumCombinations returns every subset of committee members with at
+// least quorum members as presence masks indexed in the same way as the
+// committee. The number of combinations grows exponentially with the committee
+// size, so for large committees QuorumCombinations should be used instead.
+func EnumerateQuorumCombinations(committee []*btcec.PublicKey, quorum int) [][]bool {
+	var combinations [][]bool
+	for mask := range QuorumCombinations(len(committee), quorum) {
+		combinations = append(combinations, mask)
+	}
+	return combinations
+}
+
+const (
+	// MaxTapscriptSize is the maximum size of a leaf script accepted by this
+	// package. Tapscript itself does not limit the script size, but the legacy
+	// 10,000 bytes limit is still enforced by many signing and parsing tools, so
+	// larger leaves could not be reliably spent.
+	MaxTapscriptSize = txscript.MaxScriptSize
+
+	// covenantSignerScriptCost is the script cost of every covenant member
+	// <32 bytes pk push> OP_CHECKSIG(ADD)
+	covenantSignerScriptCost = 1 + schnorr.PubKeyBytesLen + 1
+)
+
+// covenantLeafScriptSize returns the size of the unbonding path leaf script,
+// which consists of the staker signature check followed by the covenant multisig.
+func covenantLeafScriptSize(committeeSize, quorum int) (int, error) {
+	// <staker pk> OP_CHECKSIGVERIFY
+	size := covenantSignerScriptCost
+
+	if committeeSize == 1 {
+		// single key covenant uses <pk> OP_CHECKSIG script
+		return size + covenantSignerScriptCost, nil
+	}
+
+	thresholdPush, err := txscript.NewScriptBuilder().AddInt64(int64(quorum)).Script()
+	if err != nil {
+		return 0, err
+	}
+
+	// keys with signature checks, threshold push and OP_NUMEQUAL
+	return size + committeeSize*covenantSignerScriptCost + len(thresholdPush) + 1, nil
+}
+
+// ValidateQuorum checks that the quorum can be reached by the committee of the
+// given size and actually requires signatures. Quorum of zero would make the
+// multisig spendable without any signature.
+func ValidateQuorum(committeeSize, quorum int) error {
+	if quorum < 1 {
+		return fmt.Errorf("%w: got %d", ErrQuorumTooLow, quorum)
+	}
+
+	if quorum > committeeSize {
+		return fmt.Errorf("%w: quorum %d, committee size %d", ErrQuorumExceedsCommittee, quorum, committeeSize)
+	}
+
+	return nil
+}
+
+// ValidateCommitteeSize checks whether covenant committee of the given size and
+// quorum produces leaf scripts which can be spent:
+// - the unbonding leaf script must not exceed MaxTapscriptSize
+// - the witness must not exceed the maximum number of stack elements
+// The slashing leaf additionally contains finality provider keys, so callers
+// must leave room for them when the committee is close to the limits.
+func ValidateCommitteeSize(committeeSize, quorum int) error {
+	if committeeSize < 1 {
+		return fmt.Errorf("covenant committee must have at least one member")
+	}
+
+	if err := ValidateQuorum(committeeSize, quorum); err != nil {
+		return err
+	}
+
+	scriptSize, err := covenantLeafScriptSize(committeeSize, quorum)
+	if err != nil {
+		return err
+	}
+
+	if scriptSize > MaxTapscriptSize {
+		return fmt.Errorf(
+			"covenant committee of size %d produces leaf script of %d bytes, exceeding max size of %d bytes",
+			committeeSize, scriptSize, MaxTapscriptSize,
+		)
+	}
+
+	// witness contains one signature slot per covenant member and delegator slot
+	if committeeSize+1 > txscript.MaxStackSize {
+		return fmt.Errorf(
+			"covenant committee of size %d requires %d witness elements, exceeding max stack size of %d",
+			committeeSize, committeeSize+1, txscript.MaxStackSize,
+		)
+	}
+
+	return nil
+}
+
+// CovenantCommittee is the set of covenant member keys in canonical order, i.e
+// sorted lexicographically by x-only serialization, which is the order in which
+// keys are committed in the covenant multisig script. Establishing the order
+// once allows ordering-sensitive code to rely on the index of every member.
+// The covenant multisig script, MuSig2 aggregation and signature position
+// checks all take the committee. StakingParams and the staking info builders
+// keep accepting plain key slices and convert them to the committee when
+// building the covenant script.
+type CovenantCommittee struct {
+	keys []*btcec.PublicKey
+}
+
+// NewCovenantCommittee creates the committee from the given keys in any order.
+// It returns an error if the keys are empty or any key is repeated.
+func NewCovenantCommittee(keys []*btcec.PublicKey) (*CovenantCommittee, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("covenant committee must have at least one member")
+	}
+
+	for i, k := range keys {
+		if k == nil {
+			return nil, fmt.Errorf("covenant key %d is nil", i)
+		}
+	}
+
+	sortedKeys := SortKeys(keys)
+
+	for i := 1; i < len(sortedKeys); i++ {
+		if keyToString(sortedKeys[i-1]) == keyToString(sortedKeys[i]) {
+			return nil, fmt.Errorf("duplicate covenant key %s", keyToString(sortedKeys[i]))
+		}
+	}
+
+	return &CovenantCommittee{keys: sortedKeys}, nil
+}
+
+// Keys returns copy of the committee keys in canonical order
+func (c *CovenantCommittee) Keys() []*btcec.PublicKey {
+	keys := make([]*btcec.PublicKey, len(c.keys))
+	copy(keys, c.keys)
+	return keys
+}
+
+// Size returns the number of committee members
+func (c *CovenantCommittee) Size() int {
+	return len(c.keys)
+}
+
+// IndexOf returns the index of the given key in canonical order, or -1 if the
+// key is not a committee member
+func (c *CovenantCommittee) IndexOf(pk *btcec.PublicKey) int {
+	if pk == nil {
+		return -1
+	}
+
+	key := schnorr.SerializePubKey(pk)
+	for i, k := range c.keys {
+		if bytes.Equal(schnorr.SerializePubKey(k), key) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// multisigScript builds the covenant multisig script requiring quorum of
+// committee signatures. Keys are already in canonical order, so they are
+// committed in the script as they are. Single member committee produces
+// single key sig script.
+func (c *CovenantCommittee) multisigScript(quorum uint32, withVerify bool) ([]byte, error) {
+	if err := ValidateQuorum(len(c.keys), int(quorum)); err != nil {
+		return nil, fmt.Errorf("invalid number of required signers: %w", err)
+	}
+
+	if len(c.keys) == 1 {
+		return buildSingleKeySigScript(c.keys[0], withVerify)
+	}
+
+	return assembleMultiSigScript(c.keys, quorum, withVerify)
+}
+
+// OrderSignatures orders covenant signatures keyed by hex encoded BIP340 public
+// key of the signer in the order expected by CreateUnbondingPathWitness and
+// CreateSlashingPathWitness, i.e reverse canonical order. Members without
+// signature get nil entry.
+func (c *CovenantCommittee) OrderSignatures(sigs map[string]*schnorr.Signature) []*schnorr.Signature {
+	ordered := make([]*schnorr.Signature, len(c.keys))
+	for i, k := range c.keys {
+		ordered[len(c.keys)-1-i] = sigs[keyToString(k)]
+	}
+	return ordered
+}
+
+// VerifyCommitteeMembership checks that every key of the covenant committee
+// committed in the script, e.g the covenant keys returned by RequiredSigners
+// for the leaf being signed, belongs to the trusted committee set by governance.
+// Signers must call it before signing anything, so a coordinator can't make
+// them sign a spend of an output controlled by rogue covenant keys. Keys are
+// compared by their x-only serialization, regardless of order. The error lists
+// all unexpected keys.
+func VerifyCommitteeMembership(scriptCommittee, trustedCommittee []*btcec.PublicKey) error {
+	if len(scriptCommittee) == 0 {
+		return fmt.Errorf("script covenant committee must have at least one member")
+	}
+
+	trusted := make(map[string]struct{}, len(trustedCommittee))
+	for i, key := range trustedCommittee {
+		if key == nil {
+			return fmt.Errorf("trusted covenant key %d is nil", i)
+		}
+		trusted[keyToString(key)] = struct{}{}
+	}
+
+	var unexpected []string
+	for i, key := range scriptCommittee {
+		if key == nil {
+			return fmt.Errorf("script covenant key %d is nil", i)
+		}
+
+		if _, ok := trusted[keyToString(key)]; !ok {
+			unexpected = append(unexpected, keyToString(key))
+		}
+	}
+
+	if len(unexpected) > 0 {
+		return fmt.Errorf("%w: %s", ErrUntrustedCovenantKey, strings.Join(unexpected, ", "))
+	}
+
+	return nil
+}
+
+// CompactCovenantSigs splits the placeholder aligned covenant signatures, as
+// expected by CreateUnbondingPathWitness and CreateSlashingPathWitness, into
+// a presence bitmap and a dense slice of the provided signatures. Bit i of the
+// bitmap (bit i%8 of byte i/8) is set if signature at index i is not nil.
+func CompactCovenantSigs(sigs []*schnorr.Signature) ([]byte, []*schnorr.Signature) {
+	bitmap := make([]byte, (len(sigs)+7)/8)
+	var compact []*schnorr.Signature
+
+	for i, sig := range sigs {
+		if sig == nil {
+			continue
+		}
+
+		bitmap[i/8] |= 1 << (i % 8)
+		compact = append(compact, sig)
+	}
+
+	return bitmap, compact
+}
+
+// ExpandCovenantSigs reconstructs the placeholder aligned covenant signatures
+// from the presence bitmap and the dense slice of signatures built by
+// CompactCovenantSigs. The bitmap must have exactly the number of bytes required
+// for the committee, no bits set beyond the committee size, and as many bits set
+// as there are compact signatures.
+func ExpandCovenantSigs(bitmap []byte, compact []*schnorr.Signature, committeeSize int) ([]*schnorr.Signature, error) {
+	if committeeSize < 1 {
+		return nil, fmt.Errorf("covenant committee must have at least one member")
+	}
+
+	if len(bitmap) != (committeeSize+7)/8 {
+		return nil, fmt.Errorf(
+			"bitmap has %d bytes, committee of size %d requires %d", len(bitmap), committeeSize, (committeeSize+7)/8,
+		)
+	}
+
+	// bits of the last byte beyond committee size must not be set
+	if unused := committeeSize % 8; unused != 0 && bitmap[len(bitmap)-1]>>unused != 0 {
+		return nil, fmt.Errorf("bitmap has bits set beyond committee size %d", committeeSize)
+	}
+
+	popCount := 0
+	for _, b := range bitmap {
+		popCount += bits.OnesCount8(b)
+	}
+
+	if popCount != len(compact) {
+		return nil, fmt.Errorf("bitmap has %d bits set, got %d signatures", popCount, len(compact))
+	}
+
+	sigs := make([]*schnorr.Signature, committeeSize)
+	next := 0
+
+	for i := range sigs {
+		if bitmap[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+
+		if compact[next] == nil {
+			return nil, fmt.Errorf("compact signature %d must not be nil", next)
+		}
+
+		sigs[i] = compact[next]
+		next++
+	}
+
+	return sigs, nil
+}
+
+// xOnlyCommitteeKeys returns the committee keys with even y coordinate, as
+// committed in the covenant scripts, in canonical order. Converting keys to
+// their x-only form does not change their order.
+func xOnlyCommitteeKeys(committee *CovenantCommittee) ([]*btcec.PublicKey, error) {
+	if committee == nil || len(committee.keys) == 0 {
+		return nil, fmt.Errorf("covenant committee must have at least one member")
+	}
+
+	xOnlyKeys := make([]*btcec.PublicKey, len(committee.keys))
+	for i, key := range committee.keys {
+		xOnly, err := schnorr.ParsePubKey(schnorr.SerializePubKey(key))
+		if err != nil {
+			return nil, err
+		}
+		xOnlyKeys[i] = xOnly
+	}
+
+	return xOnlyKeys, nil
+}
+
+// VerifyAggregationOrder checks that aggKey is the MuSig2 aggregate key of the
+// committee keys taken in the canonical order, in which they are committed in
+// the covenant script. Scripts commit to x-only keys, so keys are aggregated
+// with even y coordinate. A mismatch means the aggregation used different keys
+// or different ordering, and the aggregated signature will not be valid.
+func VerifyAggregationOrder(aggKey *btcec.PublicKey, committee *CovenantCommittee) error {
+	if aggKey == nil {
+		return fmt.Errorf("aggregate key must not be nil")
+	}
+
+	xOnlyKeys, err := xOnlyCommitteeKeys(committee)
+	if err != nil {
+		return err
+	}
+
+	expected, _, _, err := musig2.AggregateKeys(xOnlyKeys, false)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate committee keys: %w", err)
+	}
+
+	if bytes.Equal(schnorr.SerializePubKey(expected.FinalKey), schnorr.SerializePubKey(aggKey)) {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"%w: expected %s, got %s",
+		ErrAggregationOrderMismatch, keyToString(expected.FinalKey), keyToString(aggKey),
+	)
+}
+
+// VerifyCovenantSigPosition checks that the signature submitted by a covenant
+// member for the committee slot claimedIndex belongs to that slot, i.e that
+// claimedPk is the key at claimedIndex in the canonical order of the committee
+// and that sig is valid signature of sigHash under claimedPk. Placing a valid
+// signature in the slot of a different member would make the whole witness
+// invalid.
+func VerifyCovenantSigPosition(
+	sig *schnorr.Signature,
+	claimedPk *btcec.PublicKey,
+	committee *CovenantCommittee,
+	claimedIndex int,
+	sigHash []byte,
+) error {
+	if sig == nil {
+		return fmt.Errorf("signature must not be nil")
+	}
+
+	if claimedPk == nil {
+		return fmt.Errorf("claimed public key must not be nil")
+	}
+
+	if committee == nil {
+		return fmt.Errorf("covenant committee must not be nil")
+	}
+
+	if claimedIndex < 0 || claimedIndex >= committee.Size() {
+		return fmt.Errorf(
+			"%w: index %d out of range, committee has %d members",
+			ErrCovenantSigPosition, claimedIndex, committee.Size(),
+		)
+	}
+
+	if committee.IndexOf(claimedPk) != claimedIndex {
+		return fmt.Errorf(
+			"%w: key %s is not at index %d",
+			ErrCovenantSigPosition, keyToString(claimedPk), claimedIndex,
+		)
+	}
+
+	if !sig.Verify(sigHash, claimedPk) {
+		return fmt.Errorf("%w: signature does not verify under key %s", ErrInvalidCovenantSignature, keyToString(claimedPk))
+	}
+
+	return nil
+}
+
+// CovenantMuSigNonceRound generates the MuSig2 nonces of the covenant member
+// holding memberKey for the first round of the signing session identified by
+// sessionID. Nonces are generated from fresh randomness from crypto/rand, with
+// the session id, the member key and the aggregate key of the committee,
+// aggregated in canonical order as checked by VerifyAggregationOrder, only
+// mixed in as additional inputs, so every call returns different nonces.
+// Public nonces of all members are exchanged and combined with
+// musig2.AggregateNonces before the signing round.
+// The caller must persist the secret nonces until the signing round and use
+// them for at most one signature, then erase them. Nonces can not be
+// regenerated, e.g after a restart the member must start a new session, as
+// signing twice with the same secret nonces, even the same message under
+// different aggregate nonces, reveals the member key.
+func CovenantMuSigNonceRound(
+	committee *CovenantCommittee,
+	sessionID []byte,
+	memberKey *btcec.PrivateKey,
+) (*musig2.Nonces, error) {
+	if memberKey == nil {
+		return nil, fmt.Errorf("member key must not be nil")
+	}
+
+	if len(sessionID) == 0 {
+		return nil, fmt.Errorf("session id must not be empty")
+	}
+
+	xOnlyKeys, err := xOnlyCommitteeKeys(committee)
+	if err != nil {
+		return nil, err
+	}
+
+	if committee.IndexOf(memberKey.PubKey()) < 0 {
+		return nil, fmt.Errorf("%w: member key is not in the covenant committee", ErrWrongSigningKey)
+	}
+
+	aggKey, _, _, err := musig2.AggregateKeys(xOnlyKeys, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate committee keys: %w", err)
+	}
+
+	return musig2.GenNonces(
+		musig2.WithPublicKey(memberKey.PubKey()),
+		musig2.WithNonceAuxInput(sessionID),
+		musig2.WithNonceSecretKeyAux(memberKey),
+		musig2.WithNonceCombinedKeyAux(aggKey.FinalKey),
+	)
+}
@@ -0,0 +1,489 @@
+package btcstaking_test
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	btctest "github.com/babylonlabs-io/babylon/testutil/bitcoin"
+	"github.com/babylonlabs-io/babylon/testutil/datagen"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnumerateQuorumCombinations(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	// C(5,3) + C(5,4) + C(5,5)
+	combinations := btcstaking.EnumerateQuorumCombinations(scenario.CovenantPublicKeys(), 3)
+	require.Len(t, combinations, 16)
+
+	seen := make(map[string]struct{})
+	for _, mask := range combinations {
+		count := 0
+		key := ""
+		for _, present := range mask {
+			if present {
+				count++
+				key += "1"
+			} else {
+				key += "0"
+			}
+		}
+		require.GreaterOrEqual(t, count, 3)
+		seen[key] = struct{}{}
+	}
+	require.Len(t, seen, 16)
+
+	require.Empty(t, btcstaking.EnumerateQuorumCombinations(scenario.CovenantPublicKeys(), 0))
+	require.Empty(t, btcstaking.EnumerateQuorumCombinations(scenario.CovenantPublicKeys(), 6))
+
+	// iteration can be stopped early
+	count := 0
+	for range btcstaking.QuorumCombinations(60, 30) {
+		count++
+		if count == 10 {
+			break
+		}
+	}
+	require.Equal(t, 10, count)
+}
+
+func TestQuorumCombinationsSpendability(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 4, 2, btcutil.Amount(2*10e8), 1000)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	si, err := stakingInfo.UnbondingPathSpendInfo()
+	require.NoError(t, err)
+
+	for _, mask := range btcstaking.EnumerateQuorumCombinations(scenario.CovenantPublicKeys(), 2) {
+		spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+
+		signers := []*btcec.PrivateKey{scenario.StakerKey}
+		for i, present := range mask {
+			if present {
+				signers = append(signers, scenario.CovenantKeys[i])
+			}
+		}
+		// staker signature and covenant signatures
+		exactQuorum := len(signers) == 1+int(scenario.RequiredCovenantSigs)
+
+		sigs := signLeafWithKeys(t, spendTx, stakingInfo.StakingOutput, si, signers...)
+		orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+		require.NoError(t, err)
+
+		witness, err := btcstaking.CreateWitness(si, orderedSigs)
+		require.NoError(t, err)
+		spendTx.TxIn[0].Witness = witness
+
+		// covenant multisig ends with OP_NUMEQUAL, so more than quorum
+		// signatures make the script fail
+		prevOutputFetcher := stakingInfo.GetOutputFetcher()
+		newEngine := func() (*txscript.Engine, error) {
+			return txscript.NewEngine(
+				stakingInfo.GetPkScript(),
+				spendTx, 0, txscript.StandardVerifyFlags, nil,
+				txscript.NewTxSigHashes(spendTx, prevOutputFetcher), stakingInfo.StakingOutput.Value,
+				prevOutputFetcher,
+			)
+		}
+		btctest.AssertEngineExecution(t, 0, exactQuorum, newEngine)
+	}
+}
+
+func TestValidateCommitteeSize(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+
+	require.NoError(t, btcstaking.ValidateCommitteeSize(1, 1))
+	require.NoError(t, btcstaking.ValidateCommitteeSize(9, 6))
+	require.Error(t, btcstaking.ValidateCommitteeSize(0, 0))
+	require.Error(t, btcstaking.ValidateCommitteeSize(5, 6))
+
+	// largest committee whose unbonding leaf fits in the size limit
+	scenario := GenerateTestScenario(r, t, 1, 293, 200, btcutil.Amount(2*10e8), 1000)
+	require.NoError(t, btcstaking.ValidateCommitteeSize(293, 200))
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+	require.Len(t, infos.Unbonding.GetPkScriptPath(), btcstaking.MaxTapscriptSize)
+
+	err = btcstaking.ValidateCommitteeSize(294, 200)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "10034 bytes")
+}
+
+func TestValidateQuorum(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+
+	require.NoError(t, btcstaking.ValidateQuorum(3, 1))
+	require.NoError(t, btcstaking.ValidateQuorum(3, 3))
+	require.ErrorIs(t, btcstaking.ValidateQuorum(3, 0), btcstaking.ErrQuorumTooLow)
+	require.ErrorIs(t, btcstaking.ValidateQuorum(3, 4), btcstaking.ErrQuorumExceedsCommittee)
+	require.ErrorIs(t, btcstaking.ValidateCommitteeSize(3, 0), btcstaking.ErrQuorumTooLow)
+
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	testCases := []struct {
+		name          string
+		committeeSize int
+		quorum        uint32
+		expectedErr   error
+	}{
+		{"zero quorum", 3, 0, btcstaking.ErrQuorumTooLow},
+		{"zero quorum single member", 1, 0, btcstaking.ErrQuorumTooLow},
+		{"quorum above committee size", 3, 4, btcstaking.ErrQuorumExceedsCommittee},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := scenario.StakingParams(100)
+			params.CovenantKeys = params.CovenantKeys[:tc.committeeSize]
+			params.CovenantQuorum = tc.quorum
+
+			_, err := btcstaking.BuildAllSpendInfos(params)
+			require.ErrorIs(t, err, tc.expectedErr)
+
+			_, err = btcstaking.BuildStakingInfo(
+				params.StakerKey,
+				params.FinalityProviderKeys,
+				params.CovenantKeys,
+				params.CovenantQuorum,
+				params.StakingTime,
+				params.StakingAmount,
+				&chaincfg.MainNetParams,
+			)
+			require.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestCovenantCommittee(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	covenantKeys := scenario.CovenantPublicKeys()
+	r.Shuffle(len(covenantKeys), func(i, j int) {
+		covenantKeys[i], covenantKeys[j] = covenantKeys[j], covenantKeys[i]
+	})
+
+	committee, err := btcstaking.NewCovenantCommittee(covenantKeys)
+	require.NoError(t, err)
+	require.Equal(t, 5, committee.Size())
+	require.Equal(t, btcstaking.SortKeys(covenantKeys), committee.Keys())
+
+	for i, k := range committee.Keys() {
+		require.Equal(t, i, committee.IndexOf(k))
+	}
+	require.Equal(t, -1, committee.IndexOf(scenario.StakerKey.PubKey()))
+
+	_, err = btcstaking.NewCovenantCommittee(append(covenantKeys, covenantKeys[2]))
+	require.Error(t, err)
+	_, err = btcstaking.NewCovenantCommittee(nil)
+	require.Error(t, err)
+
+	// ordered signatures can be used directly to build the unbonding witness
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		covenantKeys,
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+	si, err := stakingInfo.UnbondingPathSpendInfo()
+	require.NoError(t, err)
+
+	spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+	covenantSigs := signLeafWithKeys(
+		t, spendTx, stakingInfo.StakingOutput, si,
+		scenario.CovenantKeys[4], scenario.CovenantKeys[0], scenario.CovenantKeys[2],
+	)
+	stakerSigs := signLeafWithKeys(t, spendTx, stakingInfo.StakingOutput, si, scenario.StakerKey)
+	stakerSig := stakerSigs[hex.EncodeToString(schnorr.SerializePubKey(scenario.StakerKey.PubKey()))]
+
+	witness, err := si.CreateUnbondingPathWitness(committee.OrderSignatures(covenantSigs), stakerSig)
+	require.NoError(t, err)
+	spendTx.TxIn[0].Witness = witness
+	btctest.AssertSlashingTxExecution(t, stakingInfo.StakingOutput, spendTx)
+}
+
+func TestExpandCovenantSigs(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 9, 6, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+	stakingOutput := &wire.TxOut{Value: int64(scenario.StakingAmount)}
+	covenantSigs := GenerateSignatures(t, scenario.CovenantKeys, spendTx, stakingOutput, infos.Unbonding.RevealedLeaf)
+	for _, idx := range r.Perm(9)[:3] {
+		covenantSigs[idx] = nil
+	}
+
+	bitmap, compact := btcstaking.CompactCovenantSigs(covenantSigs)
+	require.Len(t, bitmap, 2)
+	require.Len(t, compact, 6)
+
+	expanded, err := btcstaking.ExpandCovenantSigs(bitmap, compact, 9)
+	require.NoError(t, err)
+	require.Equal(t, covenantSigs, expanded)
+
+	// witness built from expanded signatures is the same as from the original ones
+	stakerSig := compact[0]
+	expected, err := infos.Unbonding.CreateUnbondingPathWitness(covenantSigs, stakerSig)
+	require.NoError(t, err)
+	witness, err := infos.Unbonding.CreateUnbondingPathWitness(expanded, stakerSig)
+	require.NoError(t, err)
+	require.Equal(t, expected, witness)
+
+	// popcount must match number of compact signatures
+	_, err = btcstaking.ExpandCovenantSigs(bitmap, compact[1:], 9)
+	require.Error(t, err)
+	_, err = btcstaking.ExpandCovenantSigs(bitmap, append(compact, compact[0]), 9)
+	require.Error(t, err)
+
+	// bitmap size must match committee size
+	_, err = btcstaking.ExpandCovenantSigs(bitmap, compact, 8)
+	require.Error(t, err)
+	_, err = btcstaking.ExpandCovenantSigs(append(bitmap, 0), compact, 9)
+	require.Error(t, err)
+
+	// bits beyond committee size must not be set
+	overflow := []byte{bitmap[0], bitmap[1] | 0x80}
+	_, err = btcstaking.ExpandCovenantSigs(overflow, append(compact, compact[0]), 9)
+	require.Error(t, err)
+
+	// compact signatures must not be nil
+	withNil := append([]*schnorr.Signature{nil}, compact[1:]...)
+	_, err = btcstaking.ExpandCovenantSigs(bitmap, withNil, 9)
+	require.Error(t, err)
+}
+
+func TestVerifyAggregationOrder(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	// committee keys in the order committed in the script
+	signers, err := btcstaking.RequiredSigners(infos.Unbonding.GetPkScriptPath())
+	require.NoError(t, err)
+	scriptKeys := signers.CovenantKeys
+
+	aggKey, _, _, err := musig2.AggregateKeys(scriptKeys, false)
+	require.NoError(t, err)
+
+	committee, err := btcstaking.NewCovenantCommittee(scenario.CovenantPublicKeys())
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.VerifyAggregationOrder(aggKey.FinalKey, committee))
+
+	// order of keys used to create the committee does not matter
+	shuffled := scenario.CovenantPublicKeys()
+	r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	shuffledCommittee, err := btcstaking.NewCovenantCommittee(shuffled)
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.VerifyAggregationOrder(aggKey.FinalKey, shuffledCommittee))
+
+	// aggregation in other order than script order
+	reversed := make([]*btcec.PublicKey, len(scriptKeys))
+	for i, key := range scriptKeys {
+		reversed[len(scriptKeys)-1-i] = key
+	}
+	wrongOrderKey, _, _, err := musig2.AggregateKeys(reversed, false)
+	require.NoError(t, err)
+	err = btcstaking.VerifyAggregationOrder(wrongOrderKey.FinalKey, committee)
+	require.ErrorIs(t, err, btcstaking.ErrAggregationOrderMismatch)
+
+	// aggregation of other committee
+	smallerCommittee, err := btcstaking.NewCovenantCommittee(committee.Keys()[1:])
+	require.NoError(t, err)
+	err = btcstaking.VerifyAggregationOrder(aggKey.FinalKey, smallerCommittee)
+	require.ErrorIs(t, err, btcstaking.ErrAggregationOrderMismatch)
+
+	require.Error(t, btcstaking.VerifyAggregationOrder(nil, committee))
+	require.Error(t, btcstaking.VerifyAggregationOrder(aggKey.FinalKey, nil))
+}
+
+func TestVerifyCovenantSigPosition(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	committee, err := btcstaking.NewCovenantCommittee(scenario.CovenantPublicKeys())
+	require.NoError(t, err)
+	keys := committee.Keys()
+
+	privKeys := make(map[string]*btcec.PrivateKey)
+	for _, k := range scenario.CovenantKeys {
+		privKeys[hex.EncodeToString(schnorr.SerializePubKey(k.PubKey()))] = k
+	}
+	privKeyAt := func(i int) *btcec.PrivateKey {
+		return privKeys[hex.EncodeToString(schnorr.SerializePubKey(keys[i]))]
+	}
+
+	sigHash := datagen.GenRandomByteArray(r, 32)
+	sig, err := schnorr.Sign(privKeyAt(2), sigHash)
+	require.NoError(t, err)
+
+	require.NoError(t, btcstaking.VerifyCovenantSigPosition(sig, keys[2], committee, 2, sigHash))
+
+	// valid signature claiming slot of another member
+	err = btcstaking.VerifyCovenantSigPosition(sig, keys[2], committee, 3, sigHash)
+	require.ErrorIs(t, err, btcstaking.ErrCovenantSigPosition)
+	err = btcstaking.VerifyCovenantSigPosition(sig, keys[2], committee, len(keys), sigHash)
+	require.ErrorIs(t, err, btcstaking.ErrCovenantSigPosition)
+	err = btcstaking.VerifyCovenantSigPosition(sig, keys[2], committee, -1, sigHash)
+	require.ErrorIs(t, err, btcstaking.ErrCovenantSigPosition)
+
+	// signature of one member claimed under the key of another member
+	err = btcstaking.VerifyCovenantSigPosition(sig, keys[3], committee, 3, sigHash)
+	require.ErrorIs(t, err, btcstaking.ErrInvalidCovenantSignature)
+
+	// key outside of the committee
+	outsider, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	outsiderSig, err := schnorr.Sign(outsider, sigHash)
+	require.NoError(t, err)
+	err = btcstaking.VerifyCovenantSigPosition(outsiderSig, outsider.PubKey(), committee, 2, sigHash)
+	require.ErrorIs(t, err, btcstaking.ErrCovenantSigPosition)
+
+	// signature over different message
+	err = btcstaking.VerifyCovenantSigPosition(sig, keys[2], committee, 2, datagen.GenRandomByteArray(r, 32))
+	require.ErrorIs(t, err, btcstaking.ErrInvalidCovenantSignature)
+
+	require.Error(t, btcstaking.VerifyCovenantSigPosition(sig, keys[2], nil, 2, sigHash))
+}
+
+func TestCovenantMuSigNonceRound(t *testing.T) {
+	// keys with even y coordinate, as committed in the scripts, so the members
+	// can sign with them directly
+	newMemberKey := func() *btcec.PrivateKey {
+		for {
+			key, err := btcec.NewPrivateKey()
+			require.NoError(t, err)
+			if key.PubKey().SerializeCompressed()[0] == 0x02 {
+				return key
+			}
+		}
+	}
+
+	memberA, memberB := newMemberKey(), newMemberKey()
+	committee, err := btcstaking.NewCovenantCommittee([]*btcec.PublicKey{memberA.PubKey(), memberB.PubKey()})
+	require.NoError(t, err)
+	sessionID := []byte("unbonding-session-1")
+
+	noncesA, err := btcstaking.CovenantMuSigNonceRound(committee, sessionID, memberA)
+	require.NoError(t, err)
+	noncesB, err := btcstaking.CovenantMuSigNonceRound(committee, sessionID, memberB)
+	require.NoError(t, err)
+	require.NotEqual(t, noncesA.PubNonce, noncesB.PubNonce)
+
+	// nonces are never reused, even for the same session
+	again, err := btcstaking.CovenantMuSigNonceRound(committee, sessionID, memberA)
+	require.NoError(t, err)
+	require.NotEqual(t, noncesA.PubNonce, again.PubNonce)
+	require.NotEqual(t, noncesA.SecNonce, again.SecNonce)
+
+	// and differ across sessions
+	otherSession, err := btcstaking.CovenantMuSigNonceRound(committee, []byte("unbonding-session-2"), memberA)
+	require.NoError(t, err)
+	require.NotEqual(t, noncesA.PubNonce, otherSession.PubNonce)
+
+	// the nonces can be used to produce signature under the aggregate key
+	combinedNonce, err := musig2.AggregateNonces([][musig2.PubNonceSize]byte{noncesA.PubNonce, noncesB.PubNonce})
+	require.NoError(t, err)
+
+	scriptOrder := committee.Keys()
+	aggKey, _, _, err := musig2.AggregateKeys(scriptOrder, false)
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.VerifyAggregationOrder(aggKey.FinalKey, committee))
+
+	var msg [32]byte
+	copy(msg[:], datagen.GenRandomByteArray(rand.New(rand.NewSource(time.Now().Unix())), 32))
+
+	partialA, err := musig2.Sign(noncesA.SecNonce, memberA, combinedNonce, scriptOrder, msg)
+	require.NoError(t, err)
+	partialB, err := musig2.Sign(noncesB.SecNonce, memberB, combinedNonce, scriptOrder, msg)
+	require.NoError(t, err)
+
+	sig := musig2.CombineSigs(partialA.R, []*musig2.PartialSignature{partialA, partialB})
+	require.True(t, sig.Verify(msg[:], aggKey.FinalKey))
+
+	outsider := newMemberKey()
+	_, err = btcstaking.CovenantMuSigNonceRound(committee, sessionID, outsider)
+	require.ErrorIs(t, err, btcstaking.ErrWrongSigningKey)
+
+	_, err = btcstaking.CovenantMuSigNonceRound(committee, nil, memberA)
+	require.Error(t, err)
+
+	_, err = btcstaking.CovenantMuSigNonceRound(nil, sessionID, memberA)
+	require.Error(t, err)
+}
+
+func TestVerifyCommitteeMembership(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+	signers, err := btcstaking.RequiredSigners(infos.Unbonding.GetPkScriptPath())
+	require.NoError(t, err)
+
+	trusted := scenario.CovenantPublicKeys()
+	r.Shuffle(len(trusted), func(i, j int) { trusted[i], trusted[j] = trusted[j], trusted[i] })
+	require.NoError(t, btcstaking.VerifyCommitteeMembership(signers.CovenantKeys, trusted))
+
+	// trusted set can be larger than the script committee
+	extra, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.VerifyCommitteeMembership(
+		signers.CovenantKeys, append(trusted, extra.PubKey()),
+	))
+
+	// coordinator swaps two members for rogue keys
+	rogue1, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	rogue2, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	rogueParams := scenario.StakingParams(100)
+	rogueParams.CovenantKeys = append(
+		[]*btcec.PublicKey{rogue1.PubKey(), rogue2.PubKey()}, rogueParams.CovenantKeys[2:]...,
+	)
+	rogueInfos, err := btcstaking.BuildAllSpendInfos(rogueParams)
+	require.NoError(t, err)
+	rogueSigners, err := btcstaking.RequiredSigners(rogueInfos.Slashing.GetPkScriptPath())
+	require.NoError(t, err)
+
+	err = btcstaking.VerifyCommitteeMembership(rogueSigners.CovenantKeys, trusted)
+	require.ErrorIs(t, err, btcstaking.ErrUntrustedCovenantKey)
+	require.Contains(t, err.Error(), hex.EncodeToString(schnorr.SerializePubKey(rogue1.PubKey())))
+	require.Contains(t, err.Error(), hex.EncodeToString(schnorr.SerializePubKey(rogue2.PubKey())))
+	for _, key := range trusted {
+		require.NotContains(t, err.Error(), hex.EncodeToString(schnorr.SerializePubKey(key)))
+	}
+
+	require.Error(t, btcstaking.VerifyCommitteeMembership(nil, trusted))
+}
@@ -0,0 +1,123 @@
+package btcstaking
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// BuildCpfpChild builds the child transaction bumping the fee of the stuck
+// parent transaction, e.g an unbonding tx, through child pays for parent. The
+// child spends the output with index parentOutputIdx of the parent through the
+// leaf described by the spend info, and pays the rest of the output value to
+// childDestScript. The child fee is chosen so the fee of the parent and child
+// package reaches combinedFeeRate, and the child alone pays at least the same
+// rate.
+// The parent fee is computed from parentPrevOuts, the outputs spent by every
+// parent input in input order. The parent must be signed, as its weight is
+// computed with its witnesses. The child weight is estimated with a signature
+// in every key slot of the revealed script, so the package rate is never lower
+// than combinedFeeRate.
+// Leaves with a relative time lock are rejected, as a child spending through
+// them can not be included in a block together with its parent. In particular,
+// the time lock leaf of the unbonding output can not be used for CPFP, the
+// output must commit to an additional leaf or an anchor output must be used.
+// It returns the unsigned child together with the spend info required to build
+// its witness.
+func BuildCpfpChild(
+	parentTx *wire.MsgTx,
+	parentPrevOuts []*wire.TxOut,
+	parentOutputIdx int,
+	childDestScript []byte,
+	combinedFeeRate SatPerKWeight,
+	si *SpendInfo,
+) (*wire.MsgTx, *SpendInfo, error) {
+	if parentTx == nil {
+		return nil, nil, fmt.Errorf("parent tx must not be nil")
+	}
+
+	if parentOutputIdx < 0 || parentOutputIdx >= len(parentTx.TxOut) {
+		return nil, nil, fmt.Errorf(
+			"parent output index %d out of range, parent tx has %d outputs", parentOutputIdx, len(parentTx.TxOut),
+		)
+	}
+
+	if len(childDestScript) == 0 {
+		return nil, nil, fmt.Errorf("child destination script must not be empty")
+	}
+
+	if combinedFeeRate <= 0 {
+		return nil, nil, fmt.Errorf("combined fee rate must be positive")
+	}
+
+	if si == nil {
+		return nil, nil, fmt.Errorf("spend info must not be nil")
+	}
+
+	for i, in := range parentTx.TxIn {
+		if len(in.Witness) == 0 {
+			return nil, nil, fmt.Errorf("parent input %d has no witness, parent tx must be signed", i)
+		}
+	}
+
+	parentOutput := parentTx.TxOut[parentOutputIdx]
+	if err := VerifyScriptInclusion(si, parentOutput.PkScript); err != nil {
+		return nil, nil, fmt.Errorf("spend info does not describe parent output %d: %w", parentOutputIdx, err)
+	}
+
+	if timelock, err := ExtractTimelock(si.GetPkScriptPath()); err == nil {
+		return nil, nil, fmt.Errorf("%w: %d blocks", ErrTimelockedCpfpLeaf, timelock)
+	}
+
+	parentFee, err := ComputeFee(parentTx, parentPrevOuts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid parent tx: %w", err)
+	}
+
+	keys, err := ExtractScriptPubKeys(si.GetPkScriptPath())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	witness, err := dummyWitness(si, len(keys))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parentHash := parentTx.TxHash()
+	child := wire.NewMsgTx(MaxTxVersion)
+	child.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&parentHash, uint32(parentOutputIdx)), nil, nil))
+	child.AddTxOut(wire.NewTxOut(0, childDestScript))
+
+	// weight is computed with the witness, which is then removed, so the
+	// caller can sign the child
+	child.TxIn[0].Witness = witness
+	childWeight := blockchain.GetTransactionWeight(btcutil.NewTx(child))
+	child.TxIn[0].Witness = nil
+
+	parentWeight := blockchain.GetTransactionWeight(btcutil.NewTx(parentTx))
+
+	childFee := combinedFeeRate.FeeForWeight(parentWeight+childWeight) - parentFee
+	// parent may already pay more than the target rate, the child must still
+	// pay for itself
+	if minChildFee := combinedFeeRate.FeeForWeight(childWeight); childFee < minChildFee {
+		childFee = minChildFee
+	}
+
+	outputValue := btcutil.Amount(parentOutput.Value)
+	if childFee >= outputValue {
+		return nil, nil, fmt.Errorf(
+			"child fee %d must be less than parent output value %d", childFee, outputValue,
+		)
+	}
+
+	child.TxOut[0].Value = int64(outputValue - childFee)
+	if mempool.IsDust(child.TxOut[0], mempool.DefaultMinRelayTxFee) {
+		return nil, nil, ErrDustOutputFound
+	}
+
+	return child, si, nil
+}
@@ -0,0 +1,149 @@
+package btcstaking_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	"github.com/babylonlabs-io/babylon/testutil/datagen"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+// cpfpParent returns a signed parent tx paying given fee, whose first output
+// commits to the staker anchor leaf and to the staker time lock leaf, together
+// with the outputs it spends and the spend infos of both leaves
+func cpfpParent(
+	t *testing.T,
+	r *rand.Rand,
+	stakerKey *btcec.PrivateKey,
+	outputValue, fee btcutil.Amount,
+) (*wire.MsgTx, []*wire.TxOut, *btcstaking.SpendInfo, *btcstaking.SpendInfo) {
+	internalKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	anchorScript, err := txscript.NewScriptBuilder().
+		AddData(schnorr.SerializePubKey(stakerKey.PubKey())).
+		AddOp(txscript.OP_CHECKSIG).
+		Script()
+	require.NoError(t, err)
+	timeLockScript, err := txscript.NewScriptBuilder().
+		AddData(schnorr.SerializePubKey(stakerKey.PubKey())).
+		AddOp(txscript.OP_CHECKSIGVERIFY).
+		AddInt64(100).
+		AddOp(txscript.OP_CHECKSEQUENCEVERIFY).
+		Script()
+	require.NoError(t, err)
+
+	tree := btcstaking.NewTaprootTreeFromScripts([][]byte{anchorScript, timeLockScript})
+	rootHash := tree.RootNode.TapHash()
+	outputKey := txscript.ComputeTaprootOutputKey(internalKey.PubKey(), rootHash[:])
+	pkScript, err := txscript.PayToTaprootScript(outputKey)
+	require.NoError(t, err)
+
+	anchorSi, err := btcstaking.SpendInfoFromRevealedScript(anchorScript, internalKey.PubKey(), tree)
+	require.NoError(t, err)
+	timeLockSi, err := btcstaking.SpendInfoFromRevealedScript(timeLockScript, internalKey.PubKey(), tree)
+	require.NoError(t, err)
+
+	fundingOutput := taprootOutputWithValue(t, r, outputValue+fee)
+	parent := wire.NewMsgTx(2)
+	parent.AddTxIn(wire.NewTxIn(
+		&wire.OutPoint{Hash: chainhash.Hash(datagen.GenRandomByteArray(r, 32))}, nil,
+		// key path spend witness
+		wire.TxWitness{datagen.GenRandomByteArray(r, 64)},
+	))
+	parent.AddTxOut(wire.NewTxOut(int64(outputValue), pkScript))
+
+	return parent, []*wire.TxOut{fundingOutput}, anchorSi, timeLockSi
+}
+
+func TestBuildCpfpChild(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	stakerKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	destScript := taprootOutputWithValue(t, r, 0).PkScript
+	feeRate := btcstaking.SatPerKWeight(10000)
+
+	parent, parentPrevOuts, anchorSi, timeLockSi := cpfpParent(t, r, stakerKey, 100000, 100)
+	parentFee := btcutil.Amount(100)
+
+	child, si, err := btcstaking.BuildCpfpChild(parent, parentPrevOuts, 0, destScript, feeRate, anchorSi)
+	require.NoError(t, err)
+	require.Equal(t, anchorSi, si)
+	require.Len(t, child.TxIn, 1)
+	require.Len(t, child.TxOut, 1)
+	require.Equal(t, parent.TxHash(), child.TxIn[0].PreviousOutPoint.Hash)
+	require.Equal(t, uint32(0), child.TxIn[0].PreviousOutPoint.Index)
+	require.Equal(t, destScript, child.TxOut[0].PkScript)
+	require.Empty(t, child.TxIn[0].Witness)
+
+	// sign and execute the child
+	sig, err := btcstaking.SignTxWithOneScriptSpendInputFromTapLeaf(child, parent.TxOut[0], stakerKey, si.RevealedLeaf)
+	require.NoError(t, err)
+	witness, err := btcstaking.CreateWitness(si, [][]byte{sig.Serialize()})
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.ValidateWitness(parent.TxOut[0], child, 0, witness))
+	child.TxIn[0].Witness = witness
+
+	// package of signed parent and child pays at least the target rate
+	childFee := btcutil.Amount(parent.TxOut[0].Value - child.TxOut[0].Value)
+	parentWeight := blockchain.GetTransactionWeight(btcutil.NewTx(parent))
+	childWeight := blockchain.GetTransactionWeight(btcutil.NewTx(child))
+	require.Equal(t, feeRate.FeeForWeight(parentWeight+childWeight)-parentFee, childFee)
+	require.GreaterOrEqual(t, parentFee+childFee, feeRate.FeeForWeight(parentWeight+childWeight))
+
+	t.Run("parent paying more than target rate", func(t *testing.T) {
+		parent, parentPrevOuts, anchorSi, _ := cpfpParent(t, r, stakerKey, 100000, 50000)
+		child, _, err := btcstaking.BuildCpfpChild(parent, parentPrevOuts, 0, destScript, feeRate, anchorSi)
+		require.NoError(t, err)
+		child.TxIn[0].Witness = witness
+		childWeight := blockchain.GetTransactionWeight(btcutil.NewTx(child))
+		require.Equal(t, feeRate.FeeForWeight(childWeight), btcutil.Amount(parent.TxOut[0].Value-child.TxOut[0].Value))
+	})
+
+	t.Run("time locked leaf", func(t *testing.T) {
+		_, _, err := btcstaking.BuildCpfpChild(parent, parentPrevOuts, 0, destScript, feeRate, timeLockSi)
+		require.ErrorIs(t, err, btcstaking.ErrTimelockedCpfpLeaf)
+	})
+
+	t.Run("spend info of other output", func(t *testing.T) {
+		_, _, otherSi, _ := cpfpParent(t, r, stakerKey, 100000, 100)
+		_, _, err := btcstaking.BuildCpfpChild(parent, parentPrevOuts, 0, destScript, feeRate, otherSi)
+		require.Error(t, err)
+	})
+
+	t.Run("unsigned parent", func(t *testing.T) {
+		unsigned := parent.Copy()
+		unsigned.TxIn[0].Witness = nil
+		_, _, err := btcstaking.BuildCpfpChild(unsigned, parentPrevOuts, 0, destScript, feeRate, anchorSi)
+		require.Error(t, err)
+	})
+
+	t.Run("fee rate exceeding output value", func(t *testing.T) {
+		_, _, err := btcstaking.BuildCpfpChild(parent, parentPrevOuts, 0, destScript, 1000000, anchorSi)
+		require.Error(t, err)
+	})
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		_, _, err := btcstaking.BuildCpfpChild(nil, parentPrevOuts, 0, destScript, feeRate, anchorSi)
+		require.Error(t, err)
+		_, _, err = btcstaking.BuildCpfpChild(parent, parentPrevOuts, 1, destScript, feeRate, anchorSi)
+		require.Error(t, err)
+		_, _, err = btcstaking.BuildCpfpChild(parent, nil, 0, destScript, feeRate, anchorSi)
+		require.Error(t, err)
+		_, _, err = btcstaking.BuildCpfpChild(parent, parentPrevOuts, 0, nil, feeRate, anchorSi)
+		require.Error(t, err)
+		_, _, err = btcstaking.BuildCpfpChild(parent, parentPrevOuts, 0, destScript, 0, anchorSi)
+		require.Error(t, err)
+		_, _, err = btcstaking.BuildCpfpChild(parent, parentPrevOuts, 0, destScript, feeRate, nil)
+		require.Error(t, err)
+	})
+}
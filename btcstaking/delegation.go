@@ -0,0 +1,655 @@
+package btcstaking
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// StakingParams contains all the parameters which determine the scripts committed
+// to in the staking and unbonding outputs of a single delegation.
+type StakingParams struct {
+	// StakerKey is the public key of the btc holder
+	StakerKey *btcec.PublicKey
+	// FinalityProviderKeys are the public keys of the finality providers the
+	// delegation is restaked to
+	FinalityProviderKeys []*btcec.PublicKey
+	// CovenantKeys are the public keys of the covenant committee members
+	CovenantKeys []*btcec.PublicKey
+	// CovenantQuorum is the number of covenant signatures required to spend
+	// through the unbonding and slashing paths
+	CovenantQuorum uint32
+	// StakingTime is the relative time lock of the staking output
+	StakingTime uint16
+	// UnbondingTime is the relative time lock of the unbonding output
+	UnbondingTime uint16
+	// StakingAmount is the value of the staking output
+	StakingAmount btcutil.Amount
+}
+
+// DelegationSpendInfos contains the spend infos of every script path of the
+// staking and unbonding outputs of a single delegation.
+type DelegationSpendInfos struct {
+	// TimeLock spends the staking output after the staking time lock
+	TimeLock *SpendInfo
+	// Unbonding spends the staking output with covenant cooperation
+	Unbonding *SpendInfo
+	// Slashing spends the staking output with finality provider and covenant cooperation
+	Slashing *SpendInfo
+	// UnbondingTimeLock spends the unbonding output after the unbonding time lock
+	UnbondingTimeLock *SpendInfo
+	// UnbondingSlashing spends the unbonding output with finality provider and
+	// covenant cooperation
+	UnbondingSlashing *SpendInfo
+	// Recovery spends the staking output after the recovery time lock. It is
+	// set only for delegations built with BuildSpendInfosWithRecovery.
+	Recovery *SpendInfo
+}
+
+// newDelegationScriptHolder builds the taproot tree committing to the given
+// babylon script paths. If withUnbondingPath is false, the tree only contains
+// the time lock and slashing paths as in the unbonding output. Extra scripts are
+// committed after the babylon script paths.
+func newDelegationScriptHolder(
+	paths *babylonScriptPaths,
+	withUnbondingPath bool,
+	extraScripts ...[]byte,
+) (*taprootScriptHolder, error) {
+	unspendableKeyPathKey := unspendableKeyPathInternalPubKey()
+
+	scripts := [][]byte{paths.timeLockPathScript}
+	if withUnbondingPath {
+		scripts = append(scripts, paths.unbondingPathScript)
+	}
+	scripts = append(scripts, paths.slashingPathScript)
+	scripts = append(scripts, extraScripts...)
+
+	return newTaprootScriptHolder(&unspendableKeyPathKey, scripts)
+}
+
+func spendInfoForScript(sh *taprootScriptHolder, script []byte) (*SpendInfo, error) {
+	return sh.scriptSpendInfoByName(txscript.NewBaseTapLeaf(script).TapHash())
+}
+
+// BuildAllSpendInfos builds the spend infos of all the script paths of the
+// staking and unbonding outputs from a single set of parameters. Deriving all of
+// them at once guarantees that every spend info is consistent with the others.
+// It is up to the caller to verify whether provided parameters obey parameters
+// expected by Babylon chain.
+func BuildAllSpendInfos(params StakingParams) (*DelegationSpendInfos, error) {
+	return buildDelegationSpendInfos(params, nil)
+}
+
+// BuildSpendInfosWithRecovery builds the spend infos in the same way as
+// BuildAllSpendInfos for a staking output which additionally commits to the
+// recovery leaf, spendable by the staker alone after recoveryTime blocks. It is
+// meant as the last resort if the other paths become unusable, so recoveryTime
+// must be longer than the staking time. The unbonding output does not commit to
+// the recovery leaf, as its own time lock is shorter.
+func BuildSpendInfosWithRecovery(params StakingParams, recoveryTime uint16) (*DelegationSpendInfos, error) {
+	if recoveryTime <= params.StakingTime {
+		return nil, fmt.Errorf(
+			"%s: recovery time %d must be longer than staking time %d",
+			errBuildingStakingInfo, recoveryTime, params.StakingTime,
+		)
+	}
+
+	if params.StakerKey == nil {
+		return nil, fmt.Errorf("%s: staker key must not be nil", errBuildingStakingInfo)
+	}
+
+	recoveryScript, err := buildTimeLockScript(params.StakerKey, recoveryTime)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errBuildingStakingInfo, err)
+	}
+
+	return buildDelegationSpendInfos(params, recoveryScript)
+}
+
+// BuildSpendInfosParallel builds the spend infos of many delegations with
+// BuildAllSpendInfos, using the given number of workers. It returns the spend
+// infos and the error of every params in the order of paramsList, with nil
+// errors for successfully built spend infos. If workers is lower than 1, a
+// single worker is used.
+// Building spend infos only reads the params and keys are sorted in copies, so
+// params of different delegations can share key slices e.g the covenant
+// committee.
+func BuildSpendInfosParallel(paramsList []StakingParams, workers int) ([]*DelegationSpendInfos, []error) {
+	infos := make([]*DelegationSpendInfos, len(paramsList))
+	errs := make([]error, len(paramsList))
+
+	parallelFor(len(paramsList), workers, func(i int) {
+		infos[i], errs[i] = BuildAllSpendInfos(paramsList[i])
+	})
+
+	return infos, errs
+}
+
+// validateStakingParams checks the params which are not validated while
+// building the scripts
+func validateStakingParams(params StakingParams) error {
+	if params.StakingTime == 0 {
+		return fmt.Errorf("%s: staking time must be greater than 0", errBuildingStakingInfo)
+	}
+
+	if params.StakingAmount <= 0 {
+		return fmt.Errorf("%s: staking amount must be greater than 0", errBuildingStakingInfo)
+	}
+
+	return nil
+}
+
+// buildDelegationSpendInfos builds spend infos of all the script paths of the
+// delegation outputs. If recoveryScript is not nil, it is committed in the
+// staking output and its spend info is set as the recovery path.
+func buildDelegationSpendInfos(params StakingParams, recoveryScript []byte) (*DelegationSpendInfos, error) {
+	if err := validateStakingParams(params); err != nil {
+		return nil, err
+	}
+
+	stakingPaths, err := newBabylonScriptPaths(
+		params.StakerKey,
+		params.FinalityProviderKeys,
+		params.CovenantKeys,
+		params.CovenantQuorum,
+		params.StakingTime,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errBuildingStakingInfo, err)
+	}
+
+	unbondingPaths, err := newBabylonScriptPaths(
+		params.StakerKey,
+		params.FinalityProviderKeys,
+		params.CovenantKeys,
+		params.CovenantQuorum,
+		params.UnbondingTime,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errBuildingUnbondingInfo, err)
+	}
+
+	var extraScripts [][]byte
+	if recoveryScript != nil {
+		extraScripts = append(extraScripts, recoveryScript)
+	}
+
+	stakingHolder, err := newDelegationScriptHolder(stakingPaths, true, extraScripts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errBuildingStakingInfo, err)
+	}
+
+	unbondingHolder, err := newDelegationScriptHolder(unbondingPaths, false)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errBuildingUnbondingInfo, err)
+	}
+
+	infos := &DelegationSpendInfos{}
+
+	toBuild := []struct {
+		target **SpendInfo
+		holder *taprootScriptHolder
+		script []byte
+	}{
+		{&infos.TimeLock, stakingHolder, stakingPaths.timeLockPathScript},
+		{&infos.Unbonding, stakingHolder, stakingPaths.unbondingPathScript},
+		{&infos.Slashing, stakingHolder, stakingPaths.slashingPathScript},
+		{&infos.UnbondingTimeLock, unbondingHolder, unbondingPaths.timeLockPathScript},
+		{&infos.UnbondingSlashing, unbondingHolder, unbondingPaths.slashingPathScript},
+	}
+
+	for _, b := range toBuild {
+		si, err := spendInfoForScript(b.holder, b.script)
+		if err != nil {
+			return nil, err
+		}
+		*b.target = si
+	}
+
+	if recoveryScript != nil {
+		si, err := spendInfoForScript(stakingHolder, recoveryScript)
+		if err != nil {
+			return nil, err
+		}
+		infos.Recovery = si
+	}
+
+	return infos, nil
+}
+
+// StakingOutputScript returns the P2TR pk script of the staking output with
+// the time lock, unbonding and slashing leaves. It is derived from the spend
+// infos built by BuildAllSpendInfos, so the output is spendable with the spend
+// infos built from the same params.
+func StakingOutputScript(params StakingParams) ([]byte, error) {
+	infos, err := BuildAllSpendInfos(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return infos.TimeLock.taprootPkScript()
+}
+
+// SpendInfoForPath returns the spend info of the given spend path. Time lock,
+// unbonding and slashing paths spend the staking output, while
+// UnbondingSlashingPath spends the unbonding output through its slashing leaf.
+// RecoveryPath is available only if the staking output commits to the recovery
+// leaf.
+func (d *DelegationSpendInfos) SpendInfoForPath(path SpendPath) (*SpendInfo, error) {
+	var si *SpendInfo
+
+	switch path {
+	case TimeLockPath:
+		si = d.TimeLock
+	case UnbondingPath:
+		si = d.Unbonding
+	case SlashingPath:
+		si = d.Slashing
+	case UnbondingSlashingPath:
+		si = d.UnbondingSlashing
+	case RecoveryPath:
+		si = d.Recovery
+	default:
+		return nil, fmt.Errorf("unsupported spend path %s", path)
+	}
+
+	if si == nil {
+		return nil, fmt.Errorf("spend info of %s path must not be nil", path)
+	}
+
+	return si, nil
+}
+
+// CreateWitnessForPath creates the witness spending the given path of the
+// delegation outputs. Signatures which are not required by the path are ignored:
+// time lock and recovery paths require only delegatorSig, unbonding path
+// requires covenantSigs and delegatorSig, while both slashing paths require all
+// of them.
+// UnbondingSlashingPath spends the unbonding output, so the signatures must be
+// made over the tx spending the unbonding output.
+func CreateWitnessForPath(
+	infos *DelegationSpendInfos,
+	path SpendPath,
+	covenantSigs []*schnorr.Signature,
+	fpSigs []*schnorr.Signature,
+	delegatorSig *schnorr.Signature,
+) (wire.TxWitness, error) {
+	if infos == nil {
+		return nil, fmt.Errorf("delegation spend infos must not be nil")
+	}
+
+	si, err := infos.SpendInfoForPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch path {
+	case TimeLockPath:
+		return si.CreateTimeLockPathWitness(delegatorSig)
+	case RecoveryPath:
+		return si.CreateRecoveryPathWitness(delegatorSig)
+	case UnbondingPath:
+		return si.CreateUnbondingPathWitness(covenantSigs, delegatorSig)
+	default:
+		return si.CreateSlashingPathWitness(covenantSigs, fpSigs, delegatorSig)
+	}
+}
+
+// paramKeyString returns the hex encoded BIP340 key, or <nil> for nil key
+func paramKeyString(key *btcec.PublicKey) string {
+	if key == nil {
+		return "<nil>"
+	}
+
+	return keyToString(key)
+}
+
+// diffKeys lists differences between two key sets. Keys are sorted in the leaf
+// scripts, so keys which differ only in order do not change the output, which is
+// reported separately from keys which are missing in one of the sets.
+func diffKeys(name string, a, b []*btcec.PublicKey) []string {
+	var diffs []string
+
+	inA := make(map[string]struct{}, len(a))
+	for _, key := range a {
+		inA[paramKeyString(key)] = struct{}{}
+	}
+
+	inB := make(map[string]struct{}, len(b))
+	for _, key := range b {
+		inB[paramKeyString(key)] = struct{}{}
+	}
+
+	if len(a) != len(b) {
+		diffs = append(diffs, fmt.Sprintf("%s: count %d != %d", name, len(a), len(b)))
+	}
+
+	for _, key := range a {
+		if _, ok := inB[paramKeyString(key)]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: %s only in first params", name, paramKeyString(key)))
+		}
+	}
+
+	for _, key := range b {
+		if _, ok := inA[paramKeyString(key)]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: %s only in second params", name, paramKeyString(key)))
+		}
+	}
+
+	if len(diffs) == 0 {
+		for i := range a {
+			if paramKeyString(a[i]) != paramKeyString(b[i]) {
+				diffs = append(diffs, fmt.Sprintf(
+					"%s: same keys in different order, first difference at index %d (scripts are the same)", name, i,
+				))
+				break
+			}
+		}
+	}
+
+	return diffs
+}
+
+// DiffStakingParams lists every field which differs between the two staking
+// params, one human readable line per difference, e.g to find out why a locally
+// built output does not match the output on chain. It returns nil if the params
+// are the same.
+func DiffStakingParams(a, b StakingParams) []string {
+	var diffs []string
+
+	if paramKeyString(a.StakerKey) != paramKeyString(b.StakerKey) {
+		diffs = append(diffs, fmt.Sprintf(
+			"staker key: %s != %s", paramKeyString(a.StakerKey), paramKeyString(b.StakerKey),
+		))
+	}
+
+	diffs = append(diffs, diffKeys("finality provider keys", a.FinalityProviderKeys, b.FinalityProviderKeys)...)
+	diffs = append(diffs, diffKeys("covenant keys", a.CovenantKeys, b.CovenantKeys)...)
+
+	if a.CovenantQuorum != b.CovenantQuorum {
+		diffs = append(diffs, fmt.Sprintf("covenant quorum: %d != %d", a.CovenantQuorum, b.CovenantQuorum))
+	}
+
+	if a.StakingTime != b.StakingTime {
+		diffs = append(diffs, fmt.Sprintf("staking time: %d != %d", a.StakingTime, b.StakingTime))
+	}
+
+	if a.UnbondingTime != b.UnbondingTime {
+		diffs = append(diffs, fmt.Sprintf("unbonding time: %d != %d", a.UnbondingTime, b.UnbondingTime))
+	}
+
+	if a.StakingAmount != b.StakingAmount {
+		diffs = append(diffs, fmt.Sprintf("staking amount: %v != %v", a.StakingAmount, b.StakingAmount))
+	}
+
+	return diffs
+}
+
+// sameTaprootOutput checks that both spend infos prove inclusion in the same
+// taproot output i.e they have the same internal key and merkle root
+func sameTaprootOutput(a, b *SpendInfo) error {
+	if a.ControlBlock.InternalKey == nil || b.ControlBlock.InternalKey == nil {
+		return fmt.Errorf("control block internal key is nil")
+	}
+
+	if keyToString(a.ControlBlock.InternalKey) != keyToString(b.ControlBlock.InternalKey) {
+		return fmt.Errorf("internal keys differ")
+	}
+
+	if !bytes.Equal(a.ControlBlock.RootHash(a.RevealedLeaf.Script), b.ControlBlock.RootHash(b.RevealedLeaf.Script)) {
+		return fmt.Errorf("merkle roots differ")
+	}
+
+	return nil
+}
+
+func sameKeys(a, b []*btcec.PublicKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if keyToString(a[i]) != keyToString(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// VerifyDelegationConsistency checks that the spend infos describe the leaves of
+// the staking and unbonding outputs of a single delegation, e.g to catch a leaf
+// built with stale parameters:
+// - every leaf has the shape of its spend path
+// - leaves of the staking output belong to the same taproot output, and so do
+// leaves of the unbonding output, which is a different output
+// - all leaves require the same staker, finality provider and covenant keys
+// - unbonding time lock is lower than staking time lock
+func VerifyDelegationConsistency(infos *DelegationSpendInfos) error {
+	if infos == nil {
+		return fmt.Errorf("delegation spend infos must not be nil")
+	}
+
+	leaves := []struct {
+		name string
+		si   *SpendInfo
+		path SpendPath
+	}{
+		{"staking time lock", infos.TimeLock, TimeLockPath},
+		{"staking unbonding", infos.Unbonding, UnbondingPath},
+		{"staking slashing", infos.Slashing, SlashingPath},
+		{"unbonding time lock", infos.UnbondingTimeLock, TimeLockPath},
+		{"unbonding slashing", infos.UnbondingSlashing, SlashingPath},
+	}
+
+	signers := make([]*LeafSigners, len(leaves))
+
+	for i, leaf := range leaves {
+		if leaf.si == nil {
+			return fmt.Errorf("%s spend info must not be nil", leaf.name)
+		}
+
+		path, err := SpendPathFromScript(leaf.si.GetPkScriptPath())
+		if err != nil || path != leaf.path {
+			return fmt.Errorf("%w: %s leaf is not a %s script", ErrDelegationLeafMismatch, leaf.name, leaf.path)
+		}
+
+		signers[i], err = RequiredSigners(leaf.si.GetPkScriptPath())
+		if err != nil {
+			return fmt.Errorf("%s leaf: %w", leaf.name, err)
+		}
+	}
+
+	for _, si := range []*SpendInfo{infos.Unbonding, infos.Slashing} {
+		if err := sameTaprootOutput(infos.TimeLock, si); err != nil {
+			return fmt.Errorf("%w: staking output leaves: %w", ErrDelegationOutputMismatch, err)
+		}
+	}
+
+	if err := sameTaprootOutput(infos.UnbondingTimeLock, infos.UnbondingSlashing); err != nil {
+		return fmt.Errorf("%w: unbonding output leaves: %w", ErrDelegationOutputMismatch, err)
+	}
+
+	if sameTaprootOutput(infos.TimeLock, infos.UnbondingTimeLock) == nil {
+		return fmt.Errorf("%w: staking and unbonding leaves belong to the same output", ErrDelegationOutputMismatch)
+	}
+
+	for i, s := range signers {
+		if keyToString(s.StakerKey) != keyToString(signers[0].StakerKey) {
+			return fmt.Errorf("%w: %s leaf has different staker key", ErrDelegationKeysMismatch, leaves[i].name)
+		}
+	}
+
+	unbonding, slashing, unbondingSlashing := signers[1], signers[2], signers[4]
+
+	if !sameKeys(slashing.FinalityProviderKeys, unbondingSlashing.FinalityProviderKeys) {
+		return fmt.Errorf("%w: slashing leaves have different finality provider keys", ErrDelegationKeysMismatch)
+	}
+
+	for _, s := range []*LeafSigners{slashing, unbondingSlashing} {
+		if !sameKeys(unbonding.CovenantKeys, s.CovenantKeys) || unbonding.CovenantQuorum != s.CovenantQuorum {
+			return fmt.Errorf("%w: leaves have different covenant keys or quorum", ErrDelegationKeysMismatch)
+		}
+	}
+
+	stakingTime, err := ExtractTimelock(infos.TimeLock.GetPkScriptPath())
+	if err != nil {
+		return err
+	}
+
+	unbondingTime, err := ExtractTimelock(infos.UnbondingTimeLock.GetPkScriptPath())
+	if err != nil {
+		return err
+	}
+
+	if unbondingTime >= stakingTime {
+		return fmt.Errorf(
+			"%w: unbonding time lock %d must be lower than staking time lock %d",
+			ErrDelegationTimelockMismatch, unbondingTime, stakingTime,
+		)
+	}
+
+	return nil
+}
+
+// ScanAddresses returns the taproot addresses of the staking and unbonding
+// outputs of every delegation described by paramsList, so a wallet can import
+// watch addresses of all its delegations at once e.g when recovering them from
+// known parameters. Addresses are returned in the order of paramsList, staking
+// output address first, and every address is returned only once.
+func ScanAddresses(paramsList []StakingParams, netParams *chaincfg.Params) ([]btcutil.Address, error) {
+	if netParams == nil {
+		return nil, fmt.Errorf("network params must not be nil")
+	}
+
+	seen := make(map[string]struct{})
+	var addresses []btcutil.Address
+
+	for i, params := range paramsList {
+		infos, err := BuildAllSpendInfos(params)
+		if err != nil {
+			return nil, fmt.Errorf("params %d: %w", i, err)
+		}
+
+		for _, si := range []*SpendInfo{infos.TimeLock, infos.UnbondingTimeLock} {
+			addr, err := TaprootAddress(si, netParams)
+			if err != nil {
+				return nil, fmt.Errorf("params %d: %w", i, err)
+			}
+
+			encoded := addr.EncodeAddress()
+			if _, ok := seen[encoded]; ok {
+				continue
+			}
+			seen[encoded] = struct{}{}
+			addresses = append(addresses, addr)
+		}
+	}
+
+	return addresses, nil
+}
+
+// staleLeafReason describes why the leaf script of a witness template differs
+// from the leaf expected from the current params
+func staleLeafReason(templateScript, expectedScript []byte) string {
+	got, err := RequiredSigners(templateScript)
+	if err != nil {
+		return "template script is not a Babylon leaf script"
+	}
+
+	expected, err := RequiredSigners(expectedScript)
+	if err != nil {
+		return "template script does not match the current leaf script"
+	}
+
+	switch {
+	case !sameKeys(got.CovenantKeys, expected.CovenantKeys):
+		return "covenant committee rotated"
+	case got.CovenantQuorum != expected.CovenantQuorum:
+		return fmt.Sprintf("covenant quorum changed from %d to %d", got.CovenantQuorum, expected.CovenantQuorum)
+	case keyToString(got.StakerKey) != keyToString(expected.StakerKey):
+		return "staker key changed"
+	case !sameKeys(got.FinalityProviderKeys, expected.FinalityProviderKeys):
+		return "finality provider keys changed"
+	default:
+		return "template script does not match the current leaf script"
+	}
+}
+
+// IsTemplateStillValid checks whether the pre-signed witness template still
+// spends a leaf of the delegation built from the current params, e.g after the
+// covenant committee rotated and the staker must request new covenant
+// signatures. Both the revealed script and the control block of the template
+// must match a leaf of the current staking or unbonding output.
+// If the template is stale, it returns false and an error wrapping
+// ErrStaleTemplate with the reason. Other errors mean that the template or the
+// params are malformed.
+func IsTemplateStillValid(template wire.TxWitness, currentParams StakingParams) (bool, error) {
+	parsed, err := ParseWitness(template)
+	if err != nil {
+		return false, err
+	}
+
+	infos, err := BuildAllSpendInfos(currentParams)
+	if err != nil {
+		return false, err
+	}
+
+	path, err := SpendPathFromScript(parsed.Script)
+	if err != nil {
+		return false, fmt.Errorf("%w: template script is not a Babylon leaf script", ErrStaleTemplate)
+	}
+
+	var candidates []*SpendInfo
+	switch path {
+	case TimeLockPath:
+		candidates = []*SpendInfo{infos.TimeLock, infos.UnbondingTimeLock}
+	case UnbondingPath:
+		candidates = []*SpendInfo{infos.Unbonding}
+	case SlashingPath:
+		candidates = []*SpendInfo{infos.Slashing, infos.UnbondingSlashing}
+	default:
+		return false, fmt.Errorf("unsupported spend path %s", path)
+	}
+
+	scriptMatches := false
+	for _, si := range candidates {
+		if !bytes.Equal(si.GetPkScriptPath(), parsed.Script) {
+			continue
+		}
+		scriptMatches = true
+
+		controlBlock, err := si.ControlBlock.ToBytes()
+		if err != nil {
+			return false, err
+		}
+
+		if bytes.Equal(controlBlock, parsed.ControlBlock) {
+			return true, nil
+		}
+	}
+
+	if scriptMatches {
+		return false, fmt.Errorf(
+			"%w: control block does not prove inclusion in the current %s output tree", ErrStaleTemplate, path,
+		)
+	}
+
+	if path == TimeLockPath {
+		timelock, err := ExtractTimelock(parsed.Script)
+		if err == nil && timelock != currentParams.StakingTime && timelock != currentParams.UnbondingTime {
+			return false, fmt.Errorf(
+				"%w: time lock %d does not match staking time %d or unbonding time %d",
+				ErrStaleTemplate, timelock, currentParams.StakingTime, currentParams.UnbondingTime,
+			)
+		}
+	}
+
+	return false, fmt.Errorf("%w: %s", ErrStaleTemplate, staleLeafReason(parsed.Script, candidates[0].GetPkScriptPath()))
+}
@@ -0,0 +1,636 @@
+package btcstaking_test
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	btctest "github.com/babylonlabs-io/babylon/testutil/bitcoin"
+	"github.com/babylonlabs-io/babylon/testutil/datagen"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func (t *TestScenario) StakingParams(unbondingTime uint16) btcstaking.StakingParams {
+	return btcstaking.StakingParams{
+		StakerKey:            t.StakerKey.PubKey(),
+		FinalityProviderKeys: t.FinalityProviderPublicKeys(),
+		CovenantKeys:         t.CovenantPublicKeys(),
+		CovenantQuorum:       t.RequiredCovenantSigs,
+		StakingTime:          t.StakingTime,
+		UnbondingTime:        unbondingTime,
+		StakingAmount:        t.StakingAmount,
+	}
+}
+
+func TestBuildAllSpendInfosMatchesIndividualInfos(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 2, 5, 3, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		params.StakerKey,
+		params.FinalityProviderKeys,
+		params.CovenantKeys,
+		params.CovenantQuorum,
+		params.StakingTime,
+		params.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	unbondingInfo, err := btcstaking.BuildUnbondingInfo(
+		params.StakerKey,
+		params.FinalityProviderKeys,
+		params.CovenantKeys,
+		params.CovenantQuorum,
+		params.UnbondingTime,
+		params.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	timeLock, err := stakingInfo.TimeLockPathSpendInfo()
+	require.NoError(t, err)
+	unbonding, err := stakingInfo.UnbondingPathSpendInfo()
+	require.NoError(t, err)
+	slashing, err := stakingInfo.SlashingPathSpendInfo()
+	require.NoError(t, err)
+	unbondingTimeLock, err := unbondingInfo.TimeLockPathSpendInfo()
+	require.NoError(t, err)
+	unbondingSlashing, err := unbondingInfo.SlashingPathSpendInfo()
+	require.NoError(t, err)
+
+	require.Equal(t, timeLock, infos.TimeLock)
+	require.Equal(t, unbonding, infos.Unbonding)
+	require.Equal(t, slashing, infos.Slashing)
+	require.Equal(t, unbondingTimeLock, infos.UnbondingTimeLock)
+	require.Equal(t, unbondingSlashing, infos.UnbondingSlashing)
+}
+
+func TestBuildAllSpendInfosInvalidParams(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	params := scenario.StakingParams(100)
+	params.StakerKey = nil
+	_, err := btcstaking.BuildAllSpendInfos(params)
+	require.Error(t, err)
+
+	params = scenario.StakingParams(100)
+	params.CovenantQuorum = 4
+	_, err = btcstaking.BuildAllSpendInfos(params)
+	require.Error(t, err)
+}
+
+func TestVerifyDelegationConsistency(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 2, 3, 2, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+
+	build := func(p btcstaking.StakingParams) *btcstaking.DelegationSpendInfos {
+		infos, err := btcstaking.BuildAllSpendInfos(p)
+		require.NoError(t, err)
+		return infos
+	}
+
+	infos := build(params)
+	require.NoError(t, btcstaking.VerifyDelegationConsistency(infos))
+
+	staleParams := params
+	staleParams.StakingTime = params.StakingTime + 1
+	stale := build(staleParams)
+
+	otherFpParams := params
+	otherFpParams.FinalityProviderKeys = params.FinalityProviderKeys[:1]
+	otherFp := build(otherFpParams)
+
+	longUnbondingParams := params
+	longUnbondingParams.UnbondingTime = params.StakingTime
+	longUnbonding := build(longUnbondingParams)
+
+	testCases := []struct {
+		name        string
+		modify      func(infos *btcstaking.DelegationSpendInfos)
+		expectedErr error
+	}{
+		{
+			"leaves swapped",
+			func(infos *btcstaking.DelegationSpendInfos) {
+				infos.TimeLock, infos.Unbonding = infos.Unbonding, infos.TimeLock
+			},
+			btcstaking.ErrDelegationLeafMismatch,
+		},
+		{
+			"staking leaf built with stale params",
+			func(infos *btcstaking.DelegationSpendInfos) { infos.Slashing = stale.Slashing },
+			btcstaking.ErrDelegationOutputMismatch,
+		},
+		{
+			"unbonding leaf of staking output",
+			func(infos *btcstaking.DelegationSpendInfos) { infos.UnbondingSlashing = infos.Slashing },
+			btcstaking.ErrDelegationOutputMismatch,
+		},
+		{
+			"unbonding output with different finality providers",
+			func(infos *btcstaking.DelegationSpendInfos) {
+				infos.UnbondingTimeLock = otherFp.UnbondingTimeLock
+				infos.UnbondingSlashing = otherFp.UnbondingSlashing
+			},
+			btcstaking.ErrDelegationKeysMismatch,
+		},
+		{
+			"unbonding time lock not lower than staking time lock",
+			func(infos *btcstaking.DelegationSpendInfos) {
+				infos.UnbondingTimeLock = longUnbonding.UnbondingTimeLock
+				infos.UnbondingSlashing = longUnbonding.UnbondingSlashing
+			},
+			btcstaking.ErrDelegationTimelockMismatch,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			modified := *infos
+			tc.modify(&modified)
+			require.ErrorIs(t, btcstaking.VerifyDelegationConsistency(&modified), tc.expectedErr)
+		})
+	}
+}
+
+func TestCreateWitnessForPathUnbondingSlashing(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+
+	unbondingInfo, err := btcstaking.BuildUnbondingInfo(
+		params.StakerKey,
+		params.FinalityProviderKeys,
+		params.CovenantKeys,
+		params.CovenantQuorum,
+		params.UnbondingTime,
+		params.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	si, err := infos.SpendInfoForPath(btcstaking.UnbondingSlashingPath)
+	require.NoError(t, err)
+	require.Equal(t, infos.UnbondingSlashing, si)
+
+	spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+
+	stakerSig, err := btcstaking.SignTxWithOneScriptSpendInputFromTapLeaf(
+		spendTx, unbondingInfo.UnbondingOutput, scenario.StakerKey, si.RevealedLeaf,
+	)
+	require.NoError(t, err)
+	covenantSigs := GenerateSignatures(
+		t, scenario.CovenantKeys, spendTx, unbondingInfo.UnbondingOutput, si.RevealedLeaf,
+	)
+	covenantSigs[1] = nil
+	covenantSigs[4] = nil
+	fpSigs := GenerateSignatures(
+		t, scenario.FinalityProviderKeys, spendTx, unbondingInfo.UnbondingOutput, si.RevealedLeaf,
+	)
+
+	witness, err := btcstaking.CreateWitnessForPath(
+		infos, btcstaking.UnbondingSlashingPath, covenantSigs, fpSigs, stakerSig,
+	)
+	require.NoError(t, err)
+	spendTx.TxIn[0].Witness = witness
+	btctest.AssertSlashingTxExecution(t, unbondingInfo.UnbondingOutput, spendTx)
+
+	// slashing path of the staking output reveals the same script, but proves
+	// its inclusion in the staking output, so it cannot spend the unbonding output
+	witness, err = btcstaking.CreateWitnessForPath(
+		infos, btcstaking.SlashingPath, covenantSigs, fpSigs, stakerSig,
+	)
+	require.NoError(t, err)
+	spendTx.TxIn[0].Witness = witness
+	prevOutputFetcher := txscript.NewCannedPrevOutputFetcher(
+		unbondingInfo.UnbondingOutput.PkScript, unbondingInfo.UnbondingOutput.Value,
+	)
+	newEngine := func() (*txscript.Engine, error) {
+		return txscript.NewEngine(
+			unbondingInfo.UnbondingOutput.PkScript,
+			spendTx, 0, txscript.StandardVerifyFlags, nil,
+			txscript.NewTxSigHashes(spendTx, prevOutputFetcher), unbondingInfo.UnbondingOutput.Value,
+			prevOutputFetcher,
+		)
+	}
+	btctest.AssertEngineExecution(t, 0, false, newEngine)
+
+	// unknown path
+	_, err = btcstaking.CreateWitnessForPath(
+		infos, btcstaking.SpendPath(100), covenantSigs, fpSigs, stakerSig,
+	)
+	require.Error(t, err)
+}
+
+func TestStakingOutputScript(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 2, 5, 3, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+
+	pkScript, err := btcstaking.StakingOutputScript(params)
+	require.NoError(t, err)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		params.StakerKey,
+		params.FinalityProviderKeys,
+		params.CovenantKeys,
+		params.CovenantQuorum,
+		params.StakingTime,
+		params.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+	require.Equal(t, stakingInfo.StakingOutput.PkScript, pkScript)
+
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+
+	stakingOutput := wire.NewTxOut(int64(params.StakingAmount), pkScript)
+
+	for _, path := range []btcstaking.SpendPath{
+		btcstaking.TimeLockPath, btcstaking.UnbondingPath, btcstaking.SlashingPath,
+	} {
+		si, err := infos.SpendInfoForPath(path)
+		require.NoError(t, err)
+
+		spendTx := createSpendStakeTx(params.StakingAmount.MulF64(0.5))
+		if path == btcstaking.TimeLockPath {
+			spendTx.TxIn[0].Sequence = uint32(params.StakingTime)
+		}
+
+		stakerSig, err := btcstaking.SignTxWithOneScriptSpendInputFromTapLeaf(
+			spendTx, stakingOutput, scenario.StakerKey, si.RevealedLeaf,
+		)
+		require.NoError(t, err)
+		covenantSigs := GenerateSignatures(t, scenario.CovenantKeys, spendTx, stakingOutput, si.RevealedLeaf)
+		covenantSigs[0] = nil
+		covenantSigs[3] = nil
+		fpSigs := GenerateSignatures(t, scenario.FinalityProviderKeys, spendTx, stakingOutput, si.RevealedLeaf)
+		fpSigs[1] = nil
+
+		spendTx.TxIn[0].Witness, err = btcstaking.CreateWitnessForPath(infos, path, covenantSigs, fpSigs, stakerSig)
+		require.NoError(t, err)
+		btctest.AssertSlashingTxExecution(t, stakingOutput, spendTx)
+	}
+
+	invalid := []func(p *btcstaking.StakingParams){
+		func(p *btcstaking.StakingParams) { p.StakingTime = 0 },
+		func(p *btcstaking.StakingParams) { p.StakingAmount = 0 },
+		func(p *btcstaking.StakingParams) { p.StakerKey = nil },
+		func(p *btcstaking.StakingParams) { p.CovenantQuorum = 6 },
+		func(p *btcstaking.StakingParams) { p.FinalityProviderKeys = nil },
+	}
+	for _, modify := range invalid {
+		p := params
+		modify(&p)
+		_, err := btcstaking.StakingOutputScript(p)
+		require.Error(t, err)
+
+		// spend infos are validated in the same way as the output script
+		_, err = btcstaking.BuildAllSpendInfos(p)
+		require.Error(t, err)
+	}
+}
+
+func TestDiffStakingParams(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 2, 3, 2, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+
+	require.Empty(t, btcstaking.DiffStakingParams(params, params))
+
+	other := scenario.StakingParams(100)
+	other.StakerKey = scenario.CovenantKeys[0].PubKey()
+	other.CovenantQuorum = 3
+	other.StakingTime = 2000
+	other.StakingAmount = params.StakingAmount + 1
+
+	diffs := btcstaking.DiffStakingParams(params, other)
+	require.Len(t, diffs, 4)
+	require.Contains(t, diffs[0], "staker key")
+	require.Contains(t, diffs[1], "covenant quorum: 2 != 3")
+	require.Contains(t, diffs[2], "staking time: 1000 != 2000")
+	require.Contains(t, diffs[3], "staking amount")
+
+	// keys in different order
+	other = scenario.StakingParams(100)
+	other.FinalityProviderKeys = []*btcec.PublicKey{params.FinalityProviderKeys[1], params.FinalityProviderKeys[0]}
+	diffs = btcstaking.DiffStakingParams(params, other)
+	require.Len(t, diffs, 1)
+	require.Contains(t, diffs[0], "finality provider keys: same keys in different order")
+
+	// replaced and missing covenant keys
+	other = scenario.StakingParams(100)
+	other.CovenantKeys = []*btcec.PublicKey{params.CovenantKeys[0], scenario.StakerKey.PubKey()}
+	diffs = btcstaking.DiffStakingParams(params, other)
+	require.Equal(t, []string{
+		"covenant keys: count 3 != 2",
+		fmt.Sprintf("covenant keys: %x only in first params", schnorr.SerializePubKey(params.CovenantKeys[1])),
+		fmt.Sprintf("covenant keys: %x only in first params", schnorr.SerializePubKey(params.CovenantKeys[2])),
+		fmt.Sprintf("covenant keys: %x only in second params", schnorr.SerializePubKey(scenario.StakerKey.PubKey())),
+	}, diffs)
+
+	// nil keys are reported without panic
+	other = scenario.StakingParams(100)
+	other.StakerKey = nil
+	diffs = btcstaking.DiffStakingParams(params, other)
+	require.Len(t, diffs, 1)
+	require.Contains(t, diffs[0], "<nil>")
+}
+
+func TestScanAddresses(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	first := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000).StakingParams(100)
+	second := GenerateTestScenario(r, t, 2, 5, 3, btcutil.Amount(10e8), 2000).StakingParams(200)
+
+	// amount is not committed in the scripts, so the addresses are the same
+	firstOtherAmount := first
+	firstOtherAmount.StakingAmount = first.StakingAmount / 2
+
+	addresses, err := btcstaking.ScanAddresses(
+		[]btcstaking.StakingParams{first, second, first, firstOtherAmount}, &chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+	require.Len(t, addresses, 4)
+
+	for i, params := range []btcstaking.StakingParams{first, second} {
+		stakingPkScript, err := btcstaking.StakingOutputScript(params)
+		require.NoError(t, err)
+		pkScript, err := txscript.PayToAddrScript(addresses[2*i])
+		require.NoError(t, err)
+		require.Equal(t, stakingPkScript, pkScript)
+		require.True(t, addresses[2*i].IsForNet(&chaincfg.MainNetParams))
+
+		infos, err := btcstaking.BuildAllSpendInfos(params)
+		require.NoError(t, err)
+		unbondingAddr, err := btcstaking.TaprootAddress(infos.UnbondingTimeLock, &chaincfg.MainNetParams)
+		require.NoError(t, err)
+		require.Equal(t, unbondingAddr.EncodeAddress(), addresses[2*i+1].EncodeAddress())
+	}
+
+	addresses, err = btcstaking.ScanAddresses(nil, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	require.Empty(t, addresses)
+
+	invalid := second
+	invalid.CovenantQuorum = 6
+	_, err = btcstaking.ScanAddresses([]btcstaking.StakingParams{first, invalid}, &chaincfg.MainNetParams)
+	require.Error(t, err)
+
+	_, err = btcstaking.ScanAddresses([]btcstaking.StakingParams{first}, nil)
+	require.Error(t, err)
+}
+
+func TestIsTemplateStillValid(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+
+	template := func(si *btcstaking.SpendInfo, numSigs int) wire.TxWitness {
+		sigs := make([][]byte, numSigs)
+		for i := 0; i < numSigs-1; i++ {
+			sigs[i] = datagen.GenRandomByteArray(r, 64)
+		}
+		// delegator slot is left empty
+		witness, err := btcstaking.CreateWitness(si, sigs)
+		require.NoError(t, err)
+		return witness
+	}
+	unbondingTemplate := template(infos.Unbonding, 6)
+	slashingTemplate := template(infos.Slashing, 7)
+	unbondingSlashingTemplate := template(infos.UnbondingSlashing, 7)
+	timeLockTemplate := template(infos.TimeLock, 1)
+
+	for _, tmpl := range []wire.TxWitness{unbondingTemplate, slashingTemplate, unbondingSlashingTemplate, timeLockTemplate} {
+		valid, err := btcstaking.IsTemplateStillValid(tmpl, params)
+		require.NoError(t, err)
+		require.True(t, valid)
+	}
+
+	newKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	rotated := params
+	rotated.CovenantKeys = append([]*btcec.PublicKey{newKey.PubKey()}, params.CovenantKeys[1:]...)
+	quorumChanged := params
+	quorumChanged.CovenantQuorum = 4
+	fpChanged := params
+	fpChanged.FinalityProviderKeys = []*btcec.PublicKey{newKey.PubKey()}
+	unbondingTimeChanged := params
+	unbondingTimeChanged.UnbondingTime = 150
+	stakingTimeChanged := params
+	stakingTimeChanged.StakingTime = 2000
+
+	testCases := []struct {
+		name     string
+		template wire.TxWitness
+		params   btcstaking.StakingParams
+		reason   string
+	}{
+		{"unbonding template after committee rotation", unbondingTemplate, rotated, "covenant committee rotated"},
+		{"slashing template after committee rotation", slashingTemplate, rotated, "covenant committee rotated"},
+		{"unbonding template after quorum change", unbondingTemplate, quorumChanged, "covenant quorum changed from 3 to 4"},
+		{"slashing template after finality provider change", slashingTemplate, fpChanged, "finality provider keys changed"},
+		{"unbonding slashing template after unbonding time change", unbondingSlashingTemplate, unbondingTimeChanged, "control block"},
+		{"time lock template after staking time change", timeLockTemplate, stakingTimeChanged, "time lock 1000"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			valid, err := btcstaking.IsTemplateStillValid(tc.template, tc.params)
+			require.False(t, valid)
+			require.ErrorIs(t, err, btcstaking.ErrStaleTemplate)
+			require.Contains(t, err.Error(), tc.reason)
+		})
+	}
+
+	// malformed template
+	valid, err := btcstaking.IsTemplateStillValid(unbondingTemplate[len(unbondingTemplate)-1:], params)
+	require.False(t, valid)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, btcstaking.ErrStaleTemplate)
+}
+
+func TestCreateWitnessForPathRecovery(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+	recoveryTime := uint16(5000)
+
+	infos, err := btcstaking.BuildSpendInfosWithRecovery(params, recoveryTime)
+	require.NoError(t, err)
+	require.NotNil(t, infos.Recovery)
+
+	// recovery leaf changes the staking output, but not the unbonding output
+	stakingPkScript, err := btcstaking.StakingOutputScript(params)
+	require.NoError(t, err)
+	defaultInfos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+	require.Nil(t, defaultInfos.Recovery)
+	require.Equal(t, defaultInfos.UnbondingTimeLock, infos.UnbondingTimeLock)
+
+	outputKey, err := btcstaking.TaprootOutputKey(infos.Recovery)
+	require.NoError(t, err)
+	recoveryPkScript, err := txscript.PayToTaprootScript(outputKey)
+	require.NoError(t, err)
+	require.NotEqual(t, stakingPkScript, recoveryPkScript)
+	for _, si := range []*btcstaking.SpendInfo{infos.TimeLock, infos.Unbonding, infos.Slashing} {
+		require.NoError(t, btcstaking.VerifyScriptInclusion(si, recoveryPkScript))
+	}
+	stakingOut := wire.NewTxOut(int64(params.StakingAmount), recoveryPkScript)
+
+	si, err := infos.SpendInfoForPath(btcstaking.RecoveryPath)
+	require.NoError(t, err)
+	require.Equal(t, infos.Recovery, si)
+
+	sequence, err := btcstaking.ComputeInputSequence(si)
+	require.NoError(t, err)
+	spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+	spendTx.TxIn[0].Sequence = sequence
+	require.NoError(t, btcstaking.ValidateRecoverySequence(spendTx, 0, si))
+
+	stakerSig, err := btcstaking.SignTxWithOneScriptSpendInputFromTapLeaf(
+		spendTx, stakingOut, scenario.StakerKey, si.RevealedLeaf,
+	)
+	require.NoError(t, err)
+
+	witness, err := btcstaking.CreateWitnessForPath(infos, btcstaking.RecoveryPath, nil, nil, stakerSig)
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.ValidateWitness(stakingOut, spendTx, 0, witness))
+
+	// staking time lock is not enough to spend the recovery leaf
+	stakingTimeTx := spendTx.Copy()
+	stakingTimeTx.TxIn[0].Sequence = btcstaking.SequenceForTimelock(params.StakingTime)
+	require.Error(t, btcstaking.ValidateRecoverySequence(stakingTimeTx, 0, si))
+	stakerSig, err = btcstaking.SignTxWithOneScriptSpendInputFromTapLeaf(
+		stakingTimeTx, stakingOut, scenario.StakerKey, si.RevealedLeaf,
+	)
+	require.NoError(t, err)
+	witness, err = si.CreateRecoveryPathWitness(stakerSig)
+	require.NoError(t, err)
+	require.Error(t, btcstaking.ValidateWitness(stakingOut, stakingTimeTx, 0, witness))
+
+	disabledTx := spendTx.Copy()
+	disabledTx.TxIn[0].Sequence = wire.MaxTxInSequenceNum
+	require.Error(t, btcstaking.ValidateRecoverySequence(disabledTx, 0, si))
+	require.Error(t, btcstaking.ValidateRecoverySequence(spendTx, 1, si))
+
+	// recovery leaf must not be shorter than the staking time lock
+	_, err = btcstaking.BuildSpendInfosWithRecovery(params, params.StakingTime)
+	require.Error(t, err)
+
+	// only the time lock shaped leaf can be spent through the recovery path
+	_, err = infos.Unbonding.CreateRecoveryPathWitness(stakerSig)
+	require.Error(t, err)
+	_, err = infos.Recovery.CreateRecoveryPathWitness(nil)
+	require.Error(t, err)
+
+	// delegation without recovery leaf
+	_, err = btcstaking.CreateWitnessForPath(defaultInfos, btcstaking.RecoveryPath, nil, nil, stakerSig)
+	require.Error(t, err)
+}
+
+// delegationParamsList returns params of n delegations of different stakers
+// sharing the finality provider and the covenant committee, with the staker key
+// of every params listed in invalid removed
+func delegationParamsList(tb testing.TB, n int, invalid ...int) []btcstaking.StakingParams {
+	fpKey, err := btcec.NewPrivateKey()
+	require.NoError(tb, err)
+
+	covenantKeys := make([]*btcec.PublicKey, 5)
+	for i := range covenantKeys {
+		covenantKey, err := btcec.NewPrivateKey()
+		require.NoError(tb, err)
+		covenantKeys[i] = covenantKey.PubKey()
+	}
+
+	paramsList := make([]btcstaking.StakingParams, n)
+	for i := range paramsList {
+		stakerKey, err := btcec.NewPrivateKey()
+		require.NoError(tb, err)
+
+		paramsList[i] = btcstaking.StakingParams{
+			StakerKey:            stakerKey.PubKey(),
+			FinalityProviderKeys: []*btcec.PublicKey{fpKey.PubKey()},
+			CovenantKeys:         covenantKeys,
+			CovenantQuorum:       3,
+			StakingTime:          1000,
+			UnbondingTime:        100,
+			StakingAmount:        btcutil.Amount(10e8),
+		}
+	}
+
+	for _, i := range invalid {
+		paramsList[i].StakerKey = nil
+	}
+
+	return paramsList
+}
+
+func TestBuildSpendInfosParallel(t *testing.T) {
+	invalid := map[int]bool{3: true, 17: true}
+	paramsList := delegationParamsList(t, 50, 3, 17)
+
+	for _, workers := range []int{-1, 1, 4, 100} {
+		infos, errs := btcstaking.BuildSpendInfosParallel(paramsList, workers)
+		require.Len(t, infos, len(paramsList))
+		require.Len(t, errs, len(paramsList))
+
+		for i, params := range paramsList {
+			if invalid[i] {
+				require.Error(t, errs[i], "params %d", i)
+				require.Nil(t, infos[i], "params %d", i)
+				continue
+			}
+
+			require.NoError(t, errs[i], "params %d", i)
+			expected, err := btcstaking.BuildAllSpendInfos(params)
+			require.NoError(t, err)
+			require.Equal(t, expected, infos[i], "params %d", i)
+		}
+	}
+
+	infos, errs := btcstaking.BuildSpendInfosParallel(nil, 4)
+	require.Empty(t, infos)
+	require.Empty(t, errs)
+}
+
+func BenchmarkBuildSpendInfos10000Sequential(b *testing.B) {
+	paramsList := delegationParamsList(b, 10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		btcstaking.BuildSpendInfosParallel(paramsList, 1)
+	}
+}
+
+func BenchmarkBuildSpendInfos10000Parallel(b *testing.B) {
+	paramsList := delegationParamsList(b, 10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		btcstaking.BuildSpendInfosParallel(paramsList, runtime.NumCPU())
+	}
+}
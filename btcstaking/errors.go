@@ -3,8 +3,36 @@ package btcstaking
 import "errors"
 
 var (
-	ErrInvalidSlashingRate        = errors.New("invalid slashing rate")
-	ErrDustOutputFound            = errors.New("transaction contains a dust output")
-	ErrInsufficientSlashingAmount = errors.New("insufficient slashing amount")
-	ErrInsufficientChangeAmount   = errors.New("insufficient change amount")
+	ErrInvalidSlashingRate          = errors.New("invalid slashing rate")
+	ErrDustOutputFound              = errors.New("transaction contains a dust output")
+	ErrInsufficientSlashingAmount   = errors.New("insufficient slashing amount")
+	ErrInsufficientChangeAmount     = errors.New("insufficient change amount")
+	ErrInvalidDelegatorSignature    = errors.New("invalid delegator signature")
+	ErrUnbondingWrongInputCount     = errors.New("unbonding tx must have exactly one input")
+	ErrUnbondingWrongOutpoint       = errors.New("unbonding tx does not spend the staking output")
+	ErrUnbondingWrongValue          = errors.New("unbonding tx output has unexpected value")
+	ErrControlBlockParityMismatch   = errors.New("control block output key parity mismatch")
+	ErrScriptNotCommitted           = errors.New("script not committed by this control block")
+	ErrSigopsBudgetExceeded         = errors.New("witness sigops budget exceeded")
+	ErrFinalityProviderSigsMismatch = errors.New("number of finality provider signatures does not match the script")
+	ErrResignRequired               = errors.New("pre-signed signatures are bound to the previous output value")
+	ErrQuorumTooLow                 = errors.New("quorum must be at least 1")
+	ErrQuorumExceedsCommittee       = errors.New("quorum exceeds committee size")
+	ErrWrongSigningKey              = errors.New("signing key does not match the script key")
+	ErrDelegationLeafMismatch       = errors.New("delegation leaf has unexpected script")
+	ErrDelegationOutputMismatch     = errors.New("delegation leaves do not belong to the expected outputs")
+	ErrDelegationKeysMismatch       = errors.New("delegation leaves require different keys")
+	ErrDelegationTimelockMismatch   = errors.New("delegation time locks are inconsistent")
+	ErrUnsafeFpSigHash              = errors.New("finality provider signature sighash type allows modifying the slashing tx")
+	ErrAggregationOrderMismatch     = errors.New("aggregate key does not match committee in script order")
+	ErrUnsupportedMetadataVersion   = errors.New("unsupported staking metadata version")
+	ErrUnexpectedLeaf               = errors.New("witness does not spend the expected leaf")
+	ErrCovenantSigPosition          = errors.New("covenant signature claims wrong committee position")
+	ErrInvalidCovenantSignature     = errors.New("invalid covenant signature")
+	ErrRelativeTimelockIgnored      = errors.New("tx version does not enforce relative time locks")
+	ErrUntrustedCovenantKey         = errors.New("covenant key is not in the trusted committee")
+	ErrInvalidFpSignature           = errors.New("invalid finality provider signature")
+	ErrInvalidControlBlockLength    = errors.New("invalid control block length")
+	ErrTimelockedCpfpLeaf           = errors.New("leaf time lock prevents spending the output together with its parent")
+	ErrStaleTemplate                = errors.New("witness template is stale")
 )
@@ -0,0 +1,568 @@
+package btcstaking
+
+import (
+	"fmt"
+	"sort"
+
+	sdkmath "cosmossdk.io/math"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// SatPerKWeight represents a fee rate in satoshis per kilo weight unit.
+// It follows the semantics of lnd's chainfee.SatPerKWeight, without depending
+// on the whole lnd module.
+type SatPerKWeight btcutil.Amount
+
+// FeeForWeight calculates the fee resulting from this fee rate and the given weight
+func (s SatPerKWeight) FeeForWeight(wu int64) btcutil.Amount {
+	return btcutil.Amount(s) * btcutil.Amount(wu) / 1000
+}
+
+// FeeForVSize calculates the fee resulting from this fee rate and the given virtual size
+func (s SatPerKWeight) FeeForVSize(vbytes int64) btcutil.Amount {
+	return s.FeeForWeight(vbytes * blockchain.WitnessScaleFactor)
+}
+
+// FeePerKVByte returns the fee rate in satoshis per kilo virtual byte, the unit
+// of btcd mempool relay policy
+func (s SatPerKWeight) FeePerKVByte() btcutil.Amount {
+	return btcutil.Amount(s) * blockchain.WitnessScaleFactor
+}
+
+var (
+	// dummyTaprootPkScript is used in estimations in place of output scripts
+	// which are not known upfront. It has the size of a P2TR pk script.
+	dummyTaprootPkScript = append(
+		[]byte{txscript.OP_1, txscript.OP_DATA_32},
+		make([]byte, 32)...,
+	)
+	dummySignature = make([]byte, schnorr.SignatureSize)
+)
+
+// dummyWitness builds witness with the same size as the witness spending
+// given spend info with filledSigs non-empty signatures
+func dummyWitness(si *SpendInfo, filledSigs int) (wire.TxWitness, error) {
+	if si == nil {
+		return nil, fmt.Errorf("spend info must not be nil")
+	}
+
+	keys, err := ExtractScriptPubKeys(si.GetPkScriptPath())
+	if err != nil {
+		return nil, err
+	}
+
+	if filledSigs < 0 || filledSigs > len(keys) {
+		return nil, fmt.Errorf("number of signatures %d must be between 0 and %d", filledSigs, len(keys))
+	}
+
+	sigs := make([][]byte, len(keys))
+	for i := range sigs {
+		if i < filledSigs {
+			sigs[i] = dummySignature
+		} else {
+			sigs[i] = []byte{}
+		}
+	}
+
+	return CreateWitness(si, sigs)
+}
+
+// EstimateWitnessSize returns the serialized size of the witness spending the
+// given spend info with filledSigs non-empty signatures. Every key in the
+// revealed script gets a signature slot, and slots without signature are
+// empty. As witness data is not scaled, the size is also the witness weight.
+func EstimateWitnessSize(si *SpendInfo, filledSigs int) (int, error) {
+	witness, err := dummyWitness(si, filledSigs)
+	if err != nil {
+		return 0, err
+	}
+
+	return witness.SerializeSize(), nil
+}
+
+// covenantSigSlotCost is the size of the witness slot holding a covenant
+// signature: <length byte> <64 bytes signature>
+const covenantSigSlotCost = 1 + schnorr.SignatureSize
+
+// MarginalWitnessBytes returns by how many bytes the witness spending the given
+// path grows with every additional covenant member, assuming the member signs,
+// i.e the quorum grows together with the committee. The new member adds its
+// signature slot and its key with OP_CHECKSIGADD to the revealed script:
+// - unbonding path: 65 + 34 = 99 bytes
+// - slashing and unbonding slashing paths: 65 + 34 = 99 bytes
+// - time lock and recovery paths do not require covenant signatures: 0 bytes
+// A member which does not sign still costs 35 bytes, as its slot holds the
+// empty placeholder. As witness data is not scaled, the figure is also the
+// weight increase. It does not account for the one-off growth of the script
+// length prefix when the script exceeds 252 bytes, nor for the change from the
+// single key covenant script to the multisig script.
+func MarginalWitnessBytes(path SpendPath) int {
+	switch path {
+	case UnbondingPath, SlashingPath, UnbondingSlashingPath:
+		// every covenant leaf checks the committee once
+		return covenantSigSlotCost + covenantSignerScriptCost
+	default:
+		return 0
+	}
+}
+
+// dummyTaprootOutputs returns given number of outputs with P2TR pk script size
+func dummyTaprootOutputs(num int) []*wire.TxOut {
+	outputs := make([]*wire.TxOut, num)
+	for i := range outputs {
+		outputs[i] = wire.NewTxOut(0, dummyTaprootPkScript)
+	}
+	return outputs
+}
+
+// estimateSpendTxWeight returns the weight of a transaction spending one input
+// described by given spend info and having given outputs
+func estimateSpendTxWeight(si *SpendInfo, filledSigs int, outputs []*wire.TxOut) (int64, error) {
+	witness, err := dummyWitness(si, filledSigs)
+	if err != nil {
+		return 0, err
+	}
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, witness))
+	for _, out := range outputs {
+		tx.AddTxOut(out)
+	}
+
+	return blockchain.GetTransactionWeight(btcutil.NewTx(tx)), nil
+}
+
+// LifecycleFees contains the fees paid in each phase of a delegation lifecycle
+type LifecycleFees struct {
+	// StakingFee is the fee of the staking transaction
+	StakingFee btcutil.Amount
+	// UnbondingFee is the fee of the unbonding transaction spending the staking output
+	UnbondingFee btcutil.Amount
+	// WithdrawalFee is the fee of the withdrawal transaction spending the staking
+	// output after the time lock
+	WithdrawalFee btcutil.Amount
+	// SlashingFee is the fee of the slashing transaction spending the staking output
+	SlashingFee btcutil.Amount
+}
+
+// LifecycleWeight estimates the fee paid in each phase of the delegation
+// lifecycle with given parameters at given fee rate. Estimations assume that:
+// - staking tx spends one P2TR key path input and has staking and change outputs
+// - unbonding tx has one output
+// - withdrawal tx has one P2TR output
+// - slashing tx has P2TR slashing output and change output
+// Witness sizes are computed from the actual scripts built from the params with
+// the minimal number of signatures required by each path.
+func LifecycleWeight(params StakingParams, feeRate SatPerKWeight) (LifecycleFees, error) {
+	infos, err := BuildAllSpendInfos(params)
+	if err != nil {
+		return LifecycleFees{}, err
+	}
+
+	quorum := int(params.CovenantQuorum)
+
+	stakingTx := wire.NewMsgTx(wire.TxVersion)
+	stakingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, wire.TxWitness{dummySignature}))
+	for _, out := range dummyTaprootOutputs(2) {
+		stakingTx.AddTxOut(out)
+	}
+	stakingWeight := blockchain.GetTransactionWeight(btcutil.NewTx(stakingTx))
+
+	// unbonding path requires staker and covenant quorum signatures
+	unbondingWeight, err := estimateSpendTxWeight(infos.Unbonding, 1+quorum, dummyTaprootOutputs(1))
+	if err != nil {
+		return LifecycleFees{}, err
+	}
+
+	// time lock path requires only staker signature
+	withdrawalWeight, err := estimateSpendTxWeight(infos.TimeLock, 1, dummyTaprootOutputs(1))
+	if err != nil {
+		return LifecycleFees{}, err
+	}
+
+	// slashing path requires staker, one finality provider and covenant quorum
+	// signatures
+	slashingWeight, err := estimateSpendTxWeight(infos.Slashing, 2+quorum, dummyTaprootOutputs(2))
+	if err != nil {
+		return LifecycleFees{}, err
+	}
+
+	return LifecycleFees{
+		StakingFee:    feeRate.FeeForWeight(stakingWeight),
+		UnbondingFee:  feeRate.FeeForWeight(unbondingWeight),
+		WithdrawalFee: feeRate.FeeForWeight(withdrawalWeight),
+		SlashingFee:   feeRate.FeeForWeight(slashingWeight),
+	}, nil
+}
+
+// NetRecoverable returns, for every path spending the staking output or the
+// unbonding output, the value of the output paying the staker created by the
+// spend at the given fee rate, i.e:
+// - time lock path: staking amount minus the withdrawal fee
+// - unbonding path: staking amount minus the unbonding fee, still locked in the
+// unbonding output for the unbonding time
+// - slashing path: staking amount minus the slashed part and the slashing fee,
+// locked in the slashing change output
+// - unbonding slashing path: unbonding path value minus the slashed part of it
+// and the slashing fee
+// Fees are estimated as in LifecycleWeight, and the slashed part is computed as
+// in BuildSlashingTxFromStakingTxStrict. Values which would not cover the fee
+// are reported as zero.
+func NetRecoverable(
+	params StakingParams,
+	feeRate SatPerKWeight,
+	slashingRate sdkmath.LegacyDec,
+) (map[SpendPath]btcutil.Amount, error) {
+	if slashingRate.IsNil() || !isSlashingTxRateValid(slashingRate) {
+		return nil, ErrInvalidSlashingRate
+	}
+
+	slashingRateFloat64, err := slashingRate.Float64()
+	if err != nil {
+		return nil, fmt.Errorf("error converting slashing rate to float64: %w", err)
+	}
+
+	fees, err := LifecycleWeight(params, feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := BuildAllSpendInfos(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// unbonding slashing tx has the same shape as the slashing tx, but the
+	// control block of the unbonding slashing leaf can have different size
+	unbondingSlashingWeight, err := estimateSpendTxWeight(
+		infos.UnbondingSlashing, 2+int(params.CovenantQuorum), dummyTaprootOutputs(2),
+	)
+	if err != nil {
+		return nil, err
+	}
+	unbondingSlashingFee := feeRate.FeeForWeight(unbondingSlashingWeight)
+
+	nonNegative := func(amount btcutil.Amount) btcutil.Amount {
+		if amount < 0 {
+			return 0
+		}
+		return amount
+	}
+
+	stakingAmount := params.StakingAmount
+	unbondingAmount := nonNegative(stakingAmount - fees.UnbondingFee)
+
+	return map[SpendPath]btcutil.Amount{
+		TimeLockPath:  nonNegative(stakingAmount - fees.WithdrawalFee),
+		UnbondingPath: unbondingAmount,
+		SlashingPath: nonNegative(
+			stakingAmount - stakingAmount.MulF64(slashingRateFloat64) - fees.SlashingFee,
+		),
+		UnbondingSlashingPath: nonNegative(
+			unbondingAmount - unbondingAmount.MulF64(slashingRateFloat64) - unbondingSlashingFee,
+		),
+	}, nil
+}
+
+// MinViableStakingValue returns the lowest staking value for which every spend
+// of the delegation with the given params produces only outputs which are not
+// dust at dustRelayFee, with fees estimated at feeRate as in LifecycleWeight:
+// - withdrawal output of the time lock path spend
+// - unbonding output, and the withdrawal output of its time lock path spend
+// - slashing and change outputs of the slashing txs spending the staking and
+// unbonding outputs, or only slashing outputs if the whole stake is burned
+// All outputs are assumed to be P2TR outputs, which have the highest dust
+// threshold among segwit outputs. The staking amount of params is ignored, as
+// it does not influence the sizes of the spends. Wallets should warn users
+// staking less, as some of the paths would not be spendable in a standard tx.
+func MinViableStakingValue(
+	params StakingParams,
+	feeRate SatPerKWeight,
+	dustRelayFee SatPerKWeight,
+	slashingRate sdkmath.LegacyDec,
+) (btcutil.Amount, error) {
+	if slashingRate.IsNil() || !isSlashingTxRateValid(slashingRate) {
+		return 0, ErrInvalidSlashingRate
+	}
+
+	slashingRateFloat64, err := slashingRate.Float64()
+	if err != nil {
+		return 0, fmt.Errorf("error converting slashing rate to float64: %w", err)
+	}
+	burnAll := IsBurnAllSlashingRate(slashingRate)
+
+	fees, err := LifecycleWeight(params, feeRate)
+	if err != nil {
+		return 0, err
+	}
+
+	infos, err := BuildAllSpendInfos(params)
+	if err != nil {
+		return 0, err
+	}
+
+	// time lock path of the unbonding output requires only staker signature
+	unbondingWithdrawalWeight, err := estimateSpendTxWeight(infos.UnbondingTimeLock, 1, dummyTaprootOutputs(1))
+	if err != nil {
+		return 0, err
+	}
+	unbondingWithdrawalFee := feeRate.FeeForWeight(unbondingWithdrawalWeight)
+
+	unbondingSlashingWeight, err := estimateSpendTxWeight(
+		infos.UnbondingSlashing, 2+int(params.CovenantQuorum), dummyTaprootOutputs(2),
+	)
+	if err != nil {
+		return 0, err
+	}
+	unbondingSlashingFee := feeRate.FeeForWeight(unbondingSlashingWeight)
+
+	minRelayTxFee := dustRelayFee.FeePerKVByte()
+	notDust := func(amount btcutil.Amount) bool {
+		return amount > 0 && !mempool.IsDust(wire.NewTxOut(int64(amount), dummyTaprootPkScript), minRelayTxFee)
+	}
+
+	slashable := func(value, fee btcutil.Amount) bool {
+		if burnAll {
+			return notDust(value - fee)
+		}
+		slashed := value.MulF64(slashingRateFloat64)
+		return notDust(slashed) && notDust(value-slashed-fee)
+	}
+
+	viable := func(stakingValue btcutil.Amount) bool {
+		unbondingValue := stakingValue - fees.UnbondingFee
+
+		return notDust(stakingValue-fees.WithdrawalFee) &&
+			notDust(unbondingValue) &&
+			notDust(unbondingValue-unbondingWithdrawalFee) &&
+			slashable(stakingValue, fees.SlashingFee) &&
+			slashable(unbondingValue, unbondingSlashingFee)
+	}
+
+	// all the outputs grow with the staking value, so the lowest viable value
+	// can be found with binary search
+	minValue := btcutil.Amount(sort.Search(int(btcutil.MaxSatoshi)+1, func(i int) bool {
+		return viable(btcutil.Amount(i))
+	}))
+
+	if minValue > btcutil.MaxSatoshi {
+		return 0, fmt.Errorf("no staking value keeps all paths spendable at fee rate %d", feeRate)
+	}
+
+	return minValue, nil
+}
+
+// WitnessOverheadSats returns the part of the fee of the worst case spend of
+// the staking output, i.e the slashing path spend, which pays for its witness.
+// The witness contains staker, one finality provider and covenant quorum
+// signatures, so it grows with the size of the covenant committee and the
+// number of finality providers. It allows to reason about how the committee
+// size affects the effective staking yield.
+func WitnessOverheadSats(params StakingParams, feeRate SatPerKWeight) (btcutil.Amount, error) {
+	infos, err := BuildAllSpendInfos(params)
+	if err != nil {
+		return 0, err
+	}
+
+	witnessWeight, err := EstimateWitnessSize(infos.Slashing, 2+int(params.CovenantQuorum))
+	if err != nil {
+		return 0, err
+	}
+
+	return feeRate.FeeForWeight(int64(witnessWeight)), nil
+}
+
+// MinStandardFeeRate returns the minimal fee rate at which the fee of the given
+// transaction reaches the default minimum relay fee of its virtual size. The
+// given witness is placed in every input of the transaction which does not have
+// a witness yet, so the computation uses the exact witness-inclusive weight.
+// Babylon spends have large witnesses, so fee rates which are relayable for
+// simple transfers may not be enough for them.
+func MinStandardFeeRate(tx *wire.MsgTx, witness wire.TxWitness) (SatPerKWeight, error) {
+	if tx == nil {
+		return 0, fmt.Errorf("tx must not be nil")
+	}
+
+	if len(tx.TxIn) == 0 {
+		return 0, fmt.Errorf("tx must have at least one input")
+	}
+
+	txWithWitness := tx.Copy()
+	for _, in := range txWithWitness.TxIn {
+		if len(in.Witness) == 0 {
+			in.Witness = witness
+		}
+	}
+
+	btcTx := btcutil.NewTx(txWithWitness)
+	weight := blockchain.GetTransactionWeight(btcTx)
+	vsize := mempool.GetTxVirtualSize(btcTx)
+
+	// same computation as in btcd mempool relay policy
+	minFee := vsize * int64(mempool.DefaultMinRelayTxFee) / 1000
+	if minFee == 0 {
+		minFee = int64(mempool.DefaultMinRelayTxFee)
+	}
+
+	// round up, so the fee computed from the rate is never below the minimum
+	return SatPerKWeight((minFee*1000 + weight - 1) / weight), nil
+}
+
+// TxVSize returns the virtual size of the transaction once witnesses[i] is
+// attached to input i, computed as (base size * 3 + total size) / 4 rounded up.
+// Empty witnesses leave the corresponding inputs without witness, so mixed
+// witness and non-witness inputs are supported. Inputs without a corresponding
+// element in witnesses keep their current witness. The transaction itself is
+// not modified.
+// It returns 0 if the transaction is nil or there are more witnesses than
+// inputs, as the size of such transaction is not defined.
+func TxVSize(tx *wire.MsgTx, witnesses []wire.TxWitness) int {
+	if tx == nil || len(witnesses) > len(tx.TxIn) {
+		return 0
+	}
+
+	txWithWitness := tx.Copy()
+	for i, in := range txWithWitness.TxIn {
+		if i < len(witnesses) {
+			in.Witness = witnesses[i]
+		}
+	}
+
+	baseSize := txWithWitness.SerializeSizeStripped()
+	totalSize := txWithWitness.SerializeSize()
+	weight := baseSize*(blockchain.WitnessScaleFactor-1) + totalSize
+
+	return (weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor
+}
+
+// ComputeFee returns the fee paid by the transaction, i.e the value of the
+// spent outputs minus the value of its outputs. prevOuts must contain the
+// outputs spent by every input of the transaction, in input order.
+func ComputeFee(tx *wire.MsgTx, prevOuts []*wire.TxOut) (btcutil.Amount, error) {
+	if tx == nil {
+		return 0, fmt.Errorf("tx must not be nil")
+	}
+
+	if len(prevOuts) != len(tx.TxIn) {
+		return 0, fmt.Errorf("number of prev outputs %d does not match number of inputs %d", len(prevOuts), len(tx.TxIn))
+	}
+
+	var inputsValue, outputsValue btcutil.Amount
+	for i, out := range prevOuts {
+		if out == nil {
+			return 0, fmt.Errorf("prev output of input %d must not be nil", i)
+		}
+		inputsValue += btcutil.Amount(out.Value)
+	}
+
+	for _, out := range tx.TxOut {
+		outputsValue += btcutil.Amount(out.Value)
+	}
+
+	if outputsValue > inputsValue {
+		return 0, fmt.Errorf("outputs value %d exceeds inputs value %d", outputsValue, inputsValue)
+	}
+
+	return inputsValue - outputsValue, nil
+}
+
+// ComputeFeeRate returns the fee rate in sat/vB paid by the transaction once
+// witnesses are attached to its inputs, as described in TxVSize. It allows
+// covenant members and finality providers to check that the fee of unbonding
+// or slashing tx is reasonable before signing it, in which case witnesses of
+// the expected size can be passed in place of the final ones.
+func ComputeFeeRate(tx *wire.MsgTx, prevOuts []*wire.TxOut, witnesses []wire.TxWitness) (float64, error) {
+	fee, err := ComputeFee(tx, prevOuts)
+	if err != nil {
+		return 0, err
+	}
+
+	vsize := TxVSize(tx, witnesses)
+	if vsize == 0 {
+		return 0, fmt.Errorf("number of witnesses %d exceeds number of inputs %d", len(witnesses), len(tx.TxIn))
+	}
+
+	return float64(fee) / float64(vsize), nil
+}
+
+// SameOutputs checks whether the replacement txB pays the same recipients the
+// same amounts as the original txA, so a fee bump does not redirect funds.
+// Outputs are matched by pk script regardless of their order. The value of at
+// most one output, i.e the change output paying the fee bump, may be lower in
+// the replacement, while values of all the other outputs must be the same.
+// Adding, removing or increasing outputs is not allowed.
+func SameOutputs(txA, txB *wire.MsgTx) bool {
+	if txA == nil || txB == nil {
+		return false
+	}
+
+	if len(txA.TxOut) != len(txB.TxOut) {
+		return false
+	}
+
+	valuesByScript := func(tx *wire.MsgTx) map[string][]int64 {
+		values := make(map[string][]int64)
+		for _, out := range tx.TxOut {
+			key := string(out.PkScript)
+			values[key] = append(values[key], out.Value)
+		}
+		for _, v := range values {
+			sort.Slice(v, func(i, j int) bool { return v[i] < v[j] })
+		}
+		return values
+	}
+
+	valuesA := valuesByScript(txA)
+	valuesB := valuesByScript(txB)
+
+	if len(valuesA) != len(valuesB) {
+		return false
+	}
+
+	decreased := 0
+	for script, a := range valuesA {
+		b, ok := valuesB[script]
+		if !ok || len(a) != len(b) {
+			return false
+		}
+
+		for i := range a {
+			switch {
+			case b[i] > a[i]:
+				return false
+			case b[i] < a[i]:
+				decreased++
+			}
+		}
+	}
+
+	return decreased <= 1
+}
+
+// CheckNoDust checks that none of the outputs of the transaction is dust at the
+// given dust relay fee rate, as a transaction with dust output is non-standard
+// and would not be relayed. The dust threshold depends on the output script
+// type, e.g it is higher for P2TR than for P2WPKH outputs. It should be used
+// before broadcasting transactions with outputs of values not fixed by Babylon,
+// e.g slashing change outputs or small withdrawals. The returned error contains
+// the index and the value of the first dust output.
+func CheckNoDust(tx *wire.MsgTx, dustRelayFee SatPerKWeight) error {
+	if tx == nil {
+		return fmt.Errorf("tx must not be nil")
+	}
+
+	minRelayTxFee := dustRelayFee.FeePerKVByte()
+
+	for i, out := range tx.TxOut {
+		if mempool.IsDust(out, minRelayTxFee) {
+			return fmt.Errorf("%w: output %d has value %d", ErrDustOutputFound, i, out.Value)
+		}
+	}
+
+	return nil
+}
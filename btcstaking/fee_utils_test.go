@@ -0,0 +1,560 @@
+package btcstaking_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	sdkmath "cosmossdk.io/math"
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	"github.com/babylonlabs-io/babylon/testutil/datagen"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateWitnessSizeMatchesSignedWitness(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	si, err := stakingInfo.UnbondingPathSpendInfo()
+	require.NoError(t, err)
+
+	spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+	sigs := signLeafWithKeys(
+		t, spendTx, stakingInfo.StakingOutput, si,
+		scenario.StakerKey, scenario.CovenantKeys[0], scenario.CovenantKeys[1], scenario.CovenantKeys[2],
+	)
+	orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+	require.NoError(t, err)
+	witness, err := btcstaking.CreateWitness(si, orderedSigs)
+	require.NoError(t, err)
+
+	size, err := btcstaking.EstimateWitnessSize(si, 4)
+	require.NoError(t, err)
+	require.Equal(t, witness.SerializeSize(), size)
+
+	_, err = btcstaking.EstimateWitnessSize(si, 7)
+	require.Error(t, err)
+}
+
+func TestLifecycleWeight(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	feeRate := btcstaking.SatPerKWeight(2500)
+
+	small := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+	smallFees, err := btcstaking.LifecycleWeight(small.StakingParams(100), feeRate)
+	require.NoError(t, err)
+
+	require.Positive(t, smallFees.StakingFee)
+	require.Less(t, smallFees.WithdrawalFee, smallFees.UnbondingFee)
+	require.Less(t, smallFees.UnbondingFee, smallFees.SlashingFee)
+
+	large := GenerateTestScenario(r, t, 1, 9, 6, btcutil.Amount(2*10e8), 1000)
+	largeFees, err := btcstaking.LifecycleWeight(large.StakingParams(100), feeRate)
+	require.NoError(t, err)
+
+	// committee size does not influence staking and withdrawal transactions
+	require.Equal(t, smallFees.StakingFee, largeFees.StakingFee)
+	require.Equal(t, smallFees.WithdrawalFee, largeFees.WithdrawalFee)
+	require.Less(t, smallFees.UnbondingFee, largeFees.UnbondingFee)
+	require.Less(t, smallFees.SlashingFee, largeFees.SlashingFee)
+}
+
+func TestWitnessOverheadSats(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	feeRate := btcstaking.SatPerKWeight(2500)
+
+	small := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+	smallOverhead, err := btcstaking.WitnessOverheadSats(small.StakingParams(100), feeRate)
+	require.NoError(t, err)
+
+	// witness overhead is part of the slashing fee
+	smallFees, err := btcstaking.LifecycleWeight(small.StakingParams(100), feeRate)
+	require.NoError(t, err)
+	require.Positive(t, smallOverhead)
+	require.Less(t, smallOverhead, smallFees.SlashingFee)
+
+	infos, err := btcstaking.BuildAllSpendInfos(small.StakingParams(100))
+	require.NoError(t, err)
+	witnessSize, err := btcstaking.EstimateWitnessSize(infos.Slashing, 4)
+	require.NoError(t, err)
+	require.Equal(t, feeRate.FeeForWeight(int64(witnessSize)), smallOverhead)
+
+	// larger committee makes the witness more expensive
+	large := GenerateTestScenario(r, t, 1, 9, 6, btcutil.Amount(2*10e8), 1000)
+	largeOverhead, err := btcstaking.WitnessOverheadSats(large.StakingParams(100), feeRate)
+	require.NoError(t, err)
+	require.Less(t, smallOverhead, largeOverhead)
+
+	doubleOverhead, err := btcstaking.WitnessOverheadSats(large.StakingParams(100), 2*feeRate)
+	require.NoError(t, err)
+	// fee is rounded once per computation
+	require.InDelta(t, int64(2*largeOverhead), int64(doubleOverhead), 1)
+
+	invalid := small.StakingParams(100)
+	invalid.StakerKey = nil
+	_, err = btcstaking.WitnessOverheadSats(invalid, feeRate)
+	require.Error(t, err)
+}
+
+func TestMinStandardFeeRate(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 9, 6, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+	btcTxNoWitness := btcutil.NewTx(spendTx)
+
+	for _, si := range []*btcstaking.SpendInfo{infos.TimeLock, infos.Unbonding, infos.Slashing} {
+		keys, err := btcstaking.ExtractScriptPubKeys(si.GetPkScriptPath())
+		require.NoError(t, err)
+
+		witness := make(wire.TxWitness, 0, len(keys)+2)
+		for range keys {
+			witness = append(witness, datagen.GenRandomByteArray(r, 64))
+		}
+		witness = append(witness, si.GetPkScriptPath())
+		controlBlock, err := si.ControlBlock.ToBytes()
+		require.NoError(t, err)
+		witness = append(witness, controlBlock)
+
+		feeRate, err := btcstaking.MinStandardFeeRate(spendTx, witness)
+		require.NoError(t, err)
+
+		// input tx is not modified
+		require.Empty(t, spendTx.TxIn[0].Witness)
+
+		withWitness := spendTx.Copy()
+		withWitness.TxIn[0].Witness = witness
+		btcTx := btcutil.NewTx(withWitness)
+		weight := blockchain.GetTransactionWeight(btcTx)
+		minFee := btcutil.Amount(mempool.GetTxVirtualSize(btcTx)) * mempool.DefaultMinRelayTxFee / 1000
+
+		// fee at the returned rate reaches the relay floor, while lower rate does not
+		require.GreaterOrEqual(t, feeRate.FeeForWeight(weight), minFee)
+		require.Less(t, (feeRate - 1).FeeForWeight(weight), minFee)
+
+		// witness weight must be taken into account
+		require.Greater(t, weight, blockchain.GetTransactionWeight(btcTxNoWitness))
+	}
+
+	_, err = btcstaking.MinStandardFeeRate(nil, nil)
+	require.Error(t, err)
+}
+
+func TestTxVSize(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	sigs := make([][]byte, 7)
+	for i := range sigs {
+		sigs[i] = datagen.GenRandomByteArray(r, 64)
+	}
+	slashingWitness, err := btcstaking.CreateWitness(infos.Slashing, sigs)
+	require.NoError(t, err)
+	keySpendWitness := wire.TxWitness{datagen.GenRandomByteArray(r, 64)}
+
+	tx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+	// legacy input with signature script and no witness
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, datagen.GenRandomByteArray(r, 107), nil))
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 2}, nil, nil))
+
+	testCases := []struct {
+		name      string
+		witnesses []wire.TxWitness
+	}{
+		{"no witnesses", nil},
+		{"mixed witness and non-witness inputs", []wire.TxWitness{slashingWitness, nil, keySpendWitness}},
+		{"single witness input", []wire.TxWitness{nil, nil, keySpendWitness}},
+		{"witnesses for a part of inputs", []wire.TxWitness{slashingWitness}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			withWitness := tx.Copy()
+			for i, w := range tc.witnesses {
+				withWitness.TxIn[i].Witness = w
+			}
+
+			expected := mempool.GetTxVirtualSize(btcutil.NewTx(withWitness))
+			require.Equal(t, int(expected), btcstaking.TxVSize(tx, tc.witnesses))
+		})
+	}
+
+	// input tx is not modified
+	for _, in := range tx.TxIn {
+		require.Empty(t, in.Witness)
+	}
+
+	// more witnesses than inputs
+	require.Zero(t, btcstaking.TxVSize(tx, []wire.TxWitness{nil, nil, keySpendWitness, keySpendWitness}))
+	require.Zero(t, btcstaking.TxVSize(nil, nil))
+}
+
+func TestComputeFee(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	sigs := make([][]byte, 4)
+	for i := range sigs {
+		sigs[i] = datagen.GenRandomByteArray(r, 64)
+	}
+	unbondingWitness, err := btcstaking.CreateWitness(infos.Unbonding, sigs)
+	require.NoError(t, err)
+
+	stakingOut := wire.NewTxOut(int64(scenario.StakingAmount), datagen.GenRandomByteArray(r, 34))
+	unbondingTx := createSpendStakeTx(scenario.StakingAmount - 2000)
+
+	fee, err := btcstaking.ComputeFee(unbondingTx, []*wire.TxOut{stakingOut})
+	require.NoError(t, err)
+	require.Equal(t, btcutil.Amount(2000), fee)
+
+	feeRate, err := btcstaking.ComputeFeeRate(unbondingTx, []*wire.TxOut{stakingOut}, []wire.TxWitness{unbondingWitness})
+	require.NoError(t, err)
+	vsize := btcstaking.TxVSize(unbondingTx, []wire.TxWitness{unbondingWitness})
+	require.InDelta(t, 2000/float64(vsize), feeRate, 1e-9)
+
+	// witness increases the virtual size and lowers the fee rate
+	feeRateNoWitness, err := btcstaking.ComputeFeeRate(unbondingTx, []*wire.TxOut{stakingOut}, nil)
+	require.NoError(t, err)
+	require.Greater(t, feeRateNoWitness, feeRate)
+
+	// fee input added to the tx
+	feeOut := wire.NewTxOut(500, datagen.GenRandomByteArray(r, 34))
+	tx := unbondingTx.Copy()
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+	fee, err = btcstaking.ComputeFee(tx, []*wire.TxOut{stakingOut, feeOut})
+	require.NoError(t, err)
+	require.Equal(t, btcutil.Amount(2500), fee)
+
+	// outputs exceeding inputs
+	tx = createSpendStakeTx(scenario.StakingAmount + 1)
+	_, err = btcstaking.ComputeFee(tx, []*wire.TxOut{stakingOut})
+	require.Error(t, err)
+
+	// prev outputs not matching inputs
+	_, err = btcstaking.ComputeFee(unbondingTx, []*wire.TxOut{stakingOut, feeOut})
+	require.Error(t, err)
+	_, err = btcstaking.ComputeFee(unbondingTx, nil)
+	require.Error(t, err)
+	_, err = btcstaking.ComputeFee(unbondingTx, []*wire.TxOut{nil})
+	require.Error(t, err)
+	_, err = btcstaking.ComputeFeeRate(nil, nil, nil)
+	require.Error(t, err)
+	_, err = btcstaking.ComputeFeeRate(
+		unbondingTx, []*wire.TxOut{stakingOut}, []wire.TxWitness{unbondingWitness, unbondingWitness},
+	)
+	require.Error(t, err)
+}
+
+func TestSameOutputs(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	recipient := datagen.GenRandomByteArray(r, 34)
+	change := datagen.GenRandomByteArray(r, 34)
+	other := datagen.GenRandomByteArray(r, 34)
+
+	original := wire.NewMsgTx(2)
+	original.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	original.AddTxOut(wire.NewTxOut(50000, recipient))
+	original.AddTxOut(wire.NewTxOut(30000, change))
+
+	replacement := func(outs ...*wire.TxOut) *wire.MsgTx {
+		tx := original.Copy()
+		// replacement spends additional input to bump the fee
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+		tx.TxOut = outs
+		return tx
+	}
+
+	testCases := []struct {
+		name        string
+		replacement *wire.MsgTx
+		expected    bool
+	}{
+		{"same outputs", replacement(wire.NewTxOut(50000, recipient), wire.NewTxOut(30000, change)), true},
+		{"reordered outputs", replacement(wire.NewTxOut(30000, change), wire.NewTxOut(50000, recipient)), true},
+		{"change decreased", replacement(wire.NewTxOut(50000, recipient), wire.NewTxOut(29000, change)), true},
+		{"recipient and change decreased", replacement(wire.NewTxOut(49000, recipient), wire.NewTxOut(29000, change)), false},
+		{"recipient increased", replacement(wire.NewTxOut(51000, recipient), wire.NewTxOut(29000, change)), false},
+		{"change redirected", replacement(wire.NewTxOut(50000, recipient), wire.NewTxOut(30000, other)), false},
+		{"output added", replacement(
+			wire.NewTxOut(50000, recipient), wire.NewTxOut(20000, change), wire.NewTxOut(9000, other),
+		), false},
+		{"change removed", replacement(wire.NewTxOut(50000, recipient)), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, btcstaking.SameOutputs(original, tc.replacement))
+		})
+	}
+
+	// outputs paying the same script are matched by value
+	multi := original.Copy()
+	multi.AddTxOut(wire.NewTxOut(10000, recipient))
+	multiReplacement := multi.Copy()
+	multiReplacement.TxOut[1].Value = 25000
+	require.True(t, btcstaking.SameOutputs(multi, multiReplacement))
+	multiReplacement.TxOut[2].Value = 60000
+	multiReplacement.TxOut[0].Value = 10000
+	require.False(t, btcstaking.SameOutputs(multi, multiReplacement))
+
+	require.False(t, btcstaking.SameOutputs(nil, original))
+	require.False(t, btcstaking.SameOutputs(original, nil))
+}
+
+func TestCheckNoDust(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+
+	p2wpkhAddr, err := btcutil.NewAddressWitnessPubKeyHash(datagen.GenRandomByteArray(r, 20), &chaincfg.MainNetParams)
+	require.NoError(t, err)
+
+	// 250 sat/kw is the default relay fee of 1000 sat/kvB
+	dustRelayFee := btcstaking.SatPerKWeight(250)
+
+	tests := []struct {
+		name     string
+		outputs  []*wire.TxOut
+		dustIdx  int
+		expected bool
+	}{
+		{
+			name:    "p2tr output at threshold",
+			outputs: []*wire.TxOut{taprootOutputWithValue(t, r, 330)},
+		},
+		{
+			name:     "p2tr output below threshold",
+			outputs:  []*wire.TxOut{taprootOutputWithValue(t, r, 329)},
+			expected: true,
+		},
+		{
+			name:    "p2wpkh output at threshold",
+			outputs: []*wire.TxOut{outputFromAddressAndValue(t, p2wpkhAddr, 294)},
+		},
+		{
+			name:     "p2wpkh output below threshold",
+			outputs:  []*wire.TxOut{outputFromAddressAndValue(t, p2wpkhAddr, 293)},
+			expected: true,
+		},
+		{
+			// p2wpkh threshold is lower, so the same value is dust only for p2tr
+			name: "second output is dust",
+			outputs: []*wire.TxOut{
+				outputFromAddressAndValue(t, p2wpkhAddr, 300),
+				taprootOutputWithValue(t, r, 300),
+			},
+			dustIdx:  1,
+			expected: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tx := wire.NewMsgTx(2)
+			tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+			for _, out := range tc.outputs {
+				tx.AddTxOut(out)
+			}
+
+			err := btcstaking.CheckNoDust(tx, dustRelayFee)
+			if !tc.expected {
+				require.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, btcstaking.ErrDustOutputFound)
+			require.Contains(t, err.Error(), fmt.Sprintf("output %d has value %d", tc.dustIdx, tc.outputs[tc.dustIdx].Value))
+		})
+	}
+
+	// higher dust relay fee raises the threshold
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	tx.AddTxOut(taprootOutputWithValue(t, r, 330))
+	require.ErrorIs(t, btcstaking.CheckNoDust(tx, dustRelayFee*3), btcstaking.ErrDustOutputFound)
+}
+
+func TestMarginalWitnessBytes(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+
+	infosWithCommittee := func(numCov, quorum uint32) *btcstaking.DelegationSpendInfos {
+		scenario := GenerateTestScenario(r, t, 1, numCov, quorum, btcutil.Amount(2*10e8), 1000)
+		infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+		require.NoError(t, err)
+		return infos
+	}
+
+	// committees small enough for the scripts to keep 1 byte length prefix
+	smaller := infosWithCommittee(3, 2)
+	larger := infosWithCommittee(4, 3)
+
+	tests := []struct {
+		path   btcstaking.SpendPath
+		si     func(infos *btcstaking.DelegationSpendInfos) *btcstaking.SpendInfo
+		nonCov int
+	}{
+		{btcstaking.TimeLockPath, func(i *btcstaking.DelegationSpendInfos) *btcstaking.SpendInfo { return i.TimeLock }, 1},
+		{btcstaking.UnbondingPath, func(i *btcstaking.DelegationSpendInfos) *btcstaking.SpendInfo { return i.Unbonding }, 1},
+		{btcstaking.SlashingPath, func(i *btcstaking.DelegationSpendInfos) *btcstaking.SpendInfo { return i.Slashing }, 2},
+		{btcstaking.UnbondingSlashingPath, func(i *btcstaking.DelegationSpendInfos) *btcstaking.SpendInfo { return i.UnbondingSlashing }, 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path.String(), func(t *testing.T) {
+			filled := tc.nonCov
+			if tc.path != btcstaking.TimeLockPath {
+				filled += 2
+			}
+			smallerSize, err := btcstaking.EstimateWitnessSize(tc.si(smaller), filled)
+			require.NoError(t, err)
+
+			if tc.path != btcstaking.TimeLockPath {
+				filled++
+			}
+			largerSize, err := btcstaking.EstimateWitnessSize(tc.si(larger), filled)
+			require.NoError(t, err)
+
+			require.Equal(t, btcstaking.MarginalWitnessBytes(tc.path), largerSize-smallerSize)
+		})
+	}
+
+	require.Equal(t, 99, btcstaking.MarginalWitnessBytes(btcstaking.UnbondingPath))
+	require.Zero(t, btcstaking.MarginalWitnessBytes(btcstaking.RecoveryPath))
+}
+
+func TestNetRecoverable(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	// 10 sat/vB
+	feeRate := btcstaking.SatPerKWeight(2500)
+	slashingRate := sdkmath.LegacyNewDecWithPrec(1, 1)
+
+	scenario := GenerateTestScenario(r, t, 1, 9, 6, btcutil.Amount(1e8), 1000)
+	params := scenario.StakingParams(100)
+
+	net, err := btcstaking.NetRecoverable(params, feeRate, slashingRate)
+	require.NoError(t, err)
+	require.Len(t, net, 4)
+
+	fees, err := btcstaking.LifecycleWeight(params, feeRate)
+	require.NoError(t, err)
+
+	require.Equal(t, params.StakingAmount-fees.WithdrawalFee, net[btcstaking.TimeLockPath])
+	require.Equal(t, params.StakingAmount-fees.UnbondingFee, net[btcstaking.UnbondingPath])
+	require.Equal(t, btcutil.Amount(9e7)-fees.SlashingFee, net[btcstaking.SlashingPath])
+
+	// withdrawal witness is the smallest one
+	require.Greater(t, net[btcstaking.TimeLockPath], net[btcstaking.UnbondingPath])
+	// slashing after unbonding loses the unbonding fee and 10% of the rest
+	require.Less(t, net[btcstaking.UnbondingSlashingPath], net[btcstaking.SlashingPath])
+	require.InDelta(t,
+		float64(net[btcstaking.UnbondingPath])*0.9-float64(fees.SlashingFee),
+		float64(net[btcstaking.UnbondingSlashingPath]),
+		float64(fees.SlashingFee)/10,
+	)
+
+	// burning the whole stake leaves nothing after slashing
+	burnAll, err := btcstaking.NetRecoverable(params, feeRate, sdkmath.LegacyOneDec())
+	require.NoError(t, err)
+	require.Zero(t, burnAll[btcstaking.SlashingPath])
+	require.Zero(t, burnAll[btcstaking.UnbondingSlashingPath])
+	require.Equal(t, net[btcstaking.TimeLockPath], burnAll[btcstaking.TimeLockPath])
+
+	// stake not covering the fees
+	dust := params
+	dust.StakingAmount = 1000
+	dustNet, err := btcstaking.NetRecoverable(dust, btcstaking.SatPerKWeight(25000), slashingRate)
+	require.NoError(t, err)
+	for path, amount := range dustNet {
+		require.Zero(t, amount, path.String())
+	}
+
+	_, err = btcstaking.NetRecoverable(params, feeRate, sdkmath.LegacyNewDec(2))
+	require.ErrorIs(t, err, btcstaking.ErrInvalidSlashingRate)
+}
+
+func TestMinViableStakingValue(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	slashingRate := sdkmath.LegacyNewDecWithPrec(1, 1)
+	// default relay fee of 1000 sat/kvB, as used by the slashing tx builders
+	dustRelayFee := btcstaking.SatPerKWeight(250)
+	slashingPkScript := taprootOutputWithValue(t, r, 0).PkScript
+
+	committees := []struct{ size, quorum uint32 }{{3, 2}, {9, 6}, {15, 10}}
+	feeRates := []btcstaking.SatPerKWeight{250, 2500, 25000}
+
+	prevByCommittee := make([]btcutil.Amount, len(feeRates))
+	for _, committee := range committees {
+		scenario := GenerateTestScenario(r, t, 1, committee.size, committee.quorum, btcutil.Amount(1e8), 1000)
+		params := scenario.StakingParams(100)
+		stakingPkScript, err := btcstaking.StakingOutputScript(params)
+		require.NoError(t, err)
+
+		prevByFeeRate := btcutil.Amount(0)
+		for i, feeRate := range feeRates {
+			minValue, err := btcstaking.MinViableStakingValue(params, feeRate, dustRelayFee, slashingRate)
+			require.NoError(t, err)
+
+			// higher fee rate and larger committee require higher stake
+			require.Greater(t, minValue, prevByFeeRate)
+			require.GreaterOrEqual(t, minValue, prevByCommittee[i])
+			prevByFeeRate, prevByCommittee[i] = minValue, minValue
+
+			fees, err := btcstaking.LifecycleWeight(params, feeRate)
+			require.NoError(t, err)
+
+			// slashing tx of the minimal stake has no dust output
+			stakingTx := wire.NewMsgTx(2)
+			stakingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+			stakingTx.AddTxOut(wire.NewTxOut(int64(minValue), stakingPkScript))
+			slashingTx, err := btcstaking.BuildSlashingTxFromStakingTxStrict(
+				stakingTx, 0, slashingPkScript, params.StakerKey, 100,
+				int64(fees.SlashingFee), slashingRate, &chaincfg.MainNetParams,
+			)
+			require.NoError(t, err)
+			require.NoError(t, btcstaking.CheckNoDust(slashingTx, dustRelayFee))
+
+			// withdrawal and unbonding outputs are not dust
+			withdrawal := wire.NewMsgTx(2)
+			withdrawal.AddTxOut(wire.NewTxOut(int64(minValue-fees.WithdrawalFee), slashingPkScript))
+			withdrawal.AddTxOut(wire.NewTxOut(int64(minValue-fees.UnbondingFee), slashingPkScript))
+			require.NoError(t, btcstaking.CheckNoDust(withdrawal, dustRelayFee))
+		}
+	}
+
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(1e8), 1000)
+	params := scenario.StakingParams(100)
+
+	// burning the whole stake does not create change output
+	burnAllMin, err := btcstaking.MinViableStakingValue(params, 2500, dustRelayFee, sdkmath.LegacyOneDec())
+	require.NoError(t, err)
+	partialMin, err := btcstaking.MinViableStakingValue(params, 2500, dustRelayFee, slashingRate)
+	require.NoError(t, err)
+	require.Less(t, burnAllMin, partialMin)
+
+	_, err = btcstaking.MinViableStakingValue(params, 2500, dustRelayFee, sdkmath.LegacyZeroDec())
+	require.ErrorIs(t, err, btcstaking.ErrInvalidSlashingRate)
+}
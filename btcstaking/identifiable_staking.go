@@ -11,6 +11,7 @@ import (
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 )
@@ -24,6 +25,19 @@ const (
 	v0OpReturnCreationErrMsg = "cannot create V0 op_return data"
 )
 
+// supportedMetadataVersions are the versions of the staking metadata layout
+// which ParseStakingMetadata can interpret
+var supportedMetadataVersions = map[byte]struct{}{
+	0: {},
+}
+
+// IsSupportedMetadataVersion checks whether the staking metadata with the given
+// version can be parsed
+func IsSupportedMetadataVersion(version byte) bool {
+	_, ok := supportedMetadataVersions[version]
+	return ok
+}
+
 type IdentifiableStakingInfo struct {
 	StakingOutput         *wire.TxOut
 	scriptHolder          *taprootScriptHolder
@@ -478,3 +492,183 @@ func IsPossibleV0StakingTx(tx *wire.MsgTx, expectedTag []byte) bool {
 
 	return possibleStakingTx
 }
+
+// EncodeStakingMetadata encodes the staking metadata of the delegation with the
+// given params as V0 OP_RETURN output. V0 metadata identifies a single finality
+// provider, so params must contain exactly one finality provider key.
+func EncodeStakingMetadata(tag []byte, params StakingParams) (*wire.TxOut, error) {
+	if len(params.FinalityProviderKeys) != 1 {
+		return nil, fmt.Errorf(
+			"%s: metadata requires exactly one finality provider key, got %d",
+			v0OpReturnCreationErrMsg, len(params.FinalityProviderKeys),
+		)
+	}
+
+	data, err := NewV0OpReturnDataFromParsed(
+		tag, params.StakerKey, params.FinalityProviderKeys[0], params.StakingTime,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return data.ToTxOutput()
+}
+
+// ParseStakingMetadata parses the staking metadata from the OP_RETURN output of
+// the staking transaction. The version byte following the tag is checked before
+// interpreting the rest of the data, as the layout depends on the version.
+// Metadata with a version outside of the supported versions is rejected with
+// ErrUnsupportedMetadataVersion.
+func ParseStakingMetadata(out *wire.TxOut) (*V0OpReturnData, error) {
+	if out == nil {
+		return nil, fmt.Errorf("nil tx output")
+	}
+
+	if !txscript.IsNullData(out.PkScript) {
+		return nil, fmt.Errorf("invalid op return script")
+	}
+
+	pushes, err := txscript.PushedData(out.PkScript)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse op return data: %w", err)
+	}
+
+	if len(pushes) != 1 || len(pushes[0]) < TagLen+1 {
+		return nil, fmt.Errorf("op return output does not contain staking metadata")
+	}
+
+	if version := pushes[0][TagLen]; !IsSupportedMetadataVersion(version) {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedMetadataVersion, version)
+	}
+
+	return NewV0OpReturnDataFromTxOutput(out)
+}
+
+// BuildStakingTx builds the unsigned staking transaction of the delegation with
+// the given params, spending the given funding inputs. The transaction has:
+// - the staking output with the value of stakingValue, built by StakingOutputScript
+// - the OP_RETURN output with the staking metadata
+// - the change output, if changeScript is not empty
+// stakingValue must be equal to the staking amount of params. The encoded
+// metadata is checked to round trip through ParseStakingMetadata.
+func BuildStakingTx(
+	tag []byte,
+	funding []*wire.TxIn,
+	stakingValue btcutil.Amount,
+	params StakingParams,
+	changeScript []byte,
+	changeValue btcutil.Amount,
+) (*wire.MsgTx, error) {
+	if len(funding) == 0 {
+		return nil, fmt.Errorf("staking tx must have at least one funding input")
+	}
+
+	if stakingValue != params.StakingAmount {
+		return nil, fmt.Errorf(
+			"staking value %d does not match staking amount %d of params", stakingValue, params.StakingAmount,
+		)
+	}
+
+	stakingScript, err := StakingOutputScript(params)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataOutput, err := EncodeStakingMetadata(tag, params)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := ParseStakingMetadata(metadataOutput)
+	if err != nil {
+		return nil, fmt.Errorf("encoded staking metadata does not round trip: %w", err)
+	}
+
+	if !bytes.Equal(parsed.Marshall(), metadataOutput.PkScript[2:]) {
+		return nil, fmt.Errorf("encoded staking metadata does not round trip")
+	}
+
+	tx := wire.NewMsgTx(2)
+	for _, in := range funding {
+		if in == nil {
+			return nil, fmt.Errorf("funding input must not be nil")
+		}
+		// funding inputs are added without signature script and witness, as the
+		// staking tx is unsigned
+		txIn := wire.NewTxIn(&in.PreviousOutPoint, nil, nil)
+		txIn.Sequence = in.Sequence
+		tx.AddTxIn(txIn)
+	}
+
+	tx.AddTxOut(wire.NewTxOut(int64(stakingValue), stakingScript))
+	tx.AddTxOut(metadataOutput)
+
+	if len(changeScript) == 0 {
+		if changeValue != 0 {
+			return nil, fmt.Errorf("change value %d provided without change script", changeValue)
+		}
+
+		return tx, nil
+	}
+
+	changeOutput := wire.NewTxOut(int64(changeValue), changeScript)
+	if mempool.IsDust(changeOutput, mempool.DefaultMinRelayTxFee) {
+		return nil, fmt.Errorf("change output: %w", ErrDustOutputFound)
+	}
+	tx.AddTxOut(changeOutput)
+
+	return tx, nil
+}
+
+// Delegation contains the data of a delegation identified from its staking
+// transaction
+type Delegation struct {
+	// StakerKey is the public key of the staker
+	StakerKey *btcec.PublicKey
+	// FinalityProviderKeys are the public keys of the finality providers the
+	// delegation is staked to
+	FinalityProviderKeys []*btcec.PublicKey
+	// StakingAmount is the value of the staking output
+	StakingAmount btcutil.Amount
+	// StakingTime is the relative time lock of the staking output
+	StakingTime uint16
+	// StakingOutpoint is the outpoint of the staking output
+	StakingOutpoint wire.OutPoint
+}
+
+// ParseDelegation parses the delegation from its staking transaction. The staking
+// output commits only to the hash of its scripts, so covenant keys and quorum
+// are required to identify it, in the same way as in ParseV0StakingTx.
+// The staker and finality provider keys and the staking time are read from the
+// staking metadata, and the staking output is identified by rebuilding its
+// script from them. It returns an error if the transaction is not a valid
+// Babylon staking transaction.
+func ParseDelegation(
+	stakingTx *wire.MsgTx,
+	expectedTag []byte,
+	covenantKeys []*btcec.PublicKey,
+	covenantQuorum uint32,
+	net *chaincfg.Params,
+) (*Delegation, error) {
+	parsed, err := ParseV0StakingTx(stakingTx, expectedTag, covenantKeys, covenantQuorum, net)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid staking tx: %w", err)
+	}
+
+	metadata, err := ParseStakingMetadata(parsed.OpReturnOutput)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid staking tx: %w", err)
+	}
+
+	if parsed.StakingOutput.Value <= 0 {
+		return nil, fmt.Errorf("not a valid staking tx: staking output value must be larger than 0")
+	}
+
+	return &Delegation{
+		StakerKey:            metadata.StakerPublicKey.PubKey,
+		FinalityProviderKeys: []*btcec.PublicKey{metadata.FinalityProviderPublicKey.PubKey},
+		StakingAmount:        btcutil.Amount(parsed.StakingOutput.Value),
+		StakingTime:          metadata.StakingTime,
+		StakingOutpoint:      wire.OutPoint{Hash: stakingTx.TxHash(), Index: uint32(parsed.StakingOutputIdx)},
+	}, nil
+}
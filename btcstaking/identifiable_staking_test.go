@@ -4,12 +4,15 @@ import (
 	"math"
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/babylonlabs-io/babylon/btcstaking"
 
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/stretchr/testify/require"
 
@@ -102,3 +105,161 @@ func FuzzGenerateAndParseValidV0StakingTransaction(f *testing.F) {
 }
 
 // TODO Negative test cases
+
+func TestBuildStakingTx(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+	tag := datagen.GenRandomByteArray(r, btcstaking.TagLen)
+	net := &chaincfg.MainNetParams
+
+	funding := []*wire.TxIn{
+		wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{1}, Index: 0}, nil, nil),
+		wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{2}, Index: 3}, nil, [][]byte{{0x01}}),
+	}
+	changeAddress, err := genRandomBTCAddress(r)
+	require.NoError(t, err)
+	changeScript, err := txscript.PayToAddrScript(changeAddress)
+	require.NoError(t, err)
+
+	tx, err := btcstaking.BuildStakingTx(tag, funding, params.StakingAmount, params, changeScript, 10000)
+	require.NoError(t, err)
+	require.Len(t, tx.TxIn, 2)
+	require.Len(t, tx.TxOut, 3)
+	for i, in := range tx.TxIn {
+		require.Equal(t, funding[i].PreviousOutPoint, in.PreviousOutPoint)
+		require.Empty(t, in.Witness)
+	}
+
+	stakingScript, err := btcstaking.StakingOutputScript(params)
+	require.NoError(t, err)
+	require.Equal(t, stakingScript, tx.TxOut[0].PkScript)
+	require.Equal(t, int64(params.StakingAmount), tx.TxOut[0].Value)
+	require.Equal(t, changeScript, tx.TxOut[2].PkScript)
+
+	// metadata round trips
+	metadata, err := btcstaking.ParseStakingMetadata(tx.TxOut[1])
+	require.NoError(t, err)
+	require.Equal(t, tag, metadata.Tag)
+	require.Equal(t, params.StakingTime, metadata.StakingTime)
+	require.Equal(t, schnorr.SerializePubKey(params.StakerKey), metadata.StakerPublicKey.Marshall())
+	require.Equal(t, schnorr.SerializePubKey(params.FinalityProviderKeys[0]), metadata.FinalityProviderPublicKey.Marshall())
+
+	// built tx is recognized as staking tx
+	parsed, err := btcstaking.ParseV0StakingTx(tx, tag, params.CovenantKeys, params.CovenantQuorum, net)
+	require.NoError(t, err)
+	require.Equal(t, 0, parsed.StakingOutputIdx)
+	require.Equal(t, 1, parsed.OpReturnOutputIdx)
+
+	// change output is optional
+	tx, err = btcstaking.BuildStakingTx(tag, funding, params.StakingAmount, params, nil, 0)
+	require.NoError(t, err)
+	require.Len(t, tx.TxOut, 2)
+
+	// invalid inputs
+	_, err = btcstaking.BuildStakingTx(tag, nil, params.StakingAmount, params, changeScript, 10000)
+	require.Error(t, err)
+	_, err = btcstaking.BuildStakingTx(tag, funding, params.StakingAmount+1, params, changeScript, 10000)
+	require.Error(t, err)
+	_, err = btcstaking.BuildStakingTx(tag[:3], funding, params.StakingAmount, params, changeScript, 10000)
+	require.Error(t, err)
+	_, err = btcstaking.BuildStakingTx(tag, funding, params.StakingAmount, params, nil, 10000)
+	require.Error(t, err)
+	_, err = btcstaking.BuildStakingTx(tag, funding, params.StakingAmount, params, changeScript, 1)
+	require.ErrorIs(t, err, btcstaking.ErrDustOutputFound)
+
+	// metadata identifies single finality provider
+	restaking := GenerateTestScenario(r, t, 2, 5, 3, btcutil.Amount(2*10e8), 1000).StakingParams(100)
+	_, err = btcstaking.BuildStakingTx(tag, funding, restaking.StakingAmount, restaking, changeScript, 10000)
+	require.Error(t, err)
+}
+
+func TestParseStakingMetadataVersion(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+	tag := datagen.GenRandomByteArray(r, btcstaking.TagLen)
+
+	out, err := btcstaking.EncodeStakingMetadata(tag, scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	require.True(t, btcstaking.IsSupportedMetadataVersion(0))
+	metadata, err := btcstaking.ParseStakingMetadata(out)
+	require.NoError(t, err)
+	require.Equal(t, byte(0), metadata.Version)
+
+	data := metadata.Marshall()
+	bumped := append([]byte{}, data...)
+	bumped[btcstaking.TagLen] = 1
+	require.False(t, btcstaking.IsSupportedMetadataVersion(1))
+
+	// unknown version with the current layout and with a different layout
+	for _, d := range [][]byte{bumped, append(bumped, datagen.GenRandomByteArray(r, 8)...)} {
+		script, err := txscript.NullDataScript(d)
+		require.NoError(t, err)
+
+		_, err = btcstaking.ParseStakingMetadata(wire.NewTxOut(0, script))
+		require.ErrorIs(t, err, btcstaking.ErrUnsupportedMetadataVersion)
+		require.Contains(t, err.Error(), ": 1")
+	}
+
+	// not an op return output
+	_, err = btcstaking.ParseStakingMetadata(wire.NewTxOut(0, []byte{txscript.OP_TRUE}))
+	require.Error(t, err)
+	script, err := txscript.NullDataScript(tag)
+	require.NoError(t, err)
+	_, err = btcstaking.ParseStakingMetadata(wire.NewTxOut(0, script))
+	require.Error(t, err)
+}
+
+func TestParseDelegation(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+	tag := datagen.GenRandomByteArray(r, btcstaking.TagLen)
+	net := &chaincfg.MainNetParams
+
+	funding := []*wire.TxIn{wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{1}}, nil, nil)}
+	stakingTx, err := btcstaking.BuildStakingTx(tag, funding, params.StakingAmount, params, nil, 0)
+	require.NoError(t, err)
+
+	delegation, err := btcstaking.ParseDelegation(stakingTx, tag, params.CovenantKeys, params.CovenantQuorum, net)
+	require.NoError(t, err)
+	require.Equal(t, schnorr.SerializePubKey(params.StakerKey), schnorr.SerializePubKey(delegation.StakerKey))
+	require.Len(t, delegation.FinalityProviderKeys, 1)
+	require.Equal(t,
+		schnorr.SerializePubKey(params.FinalityProviderKeys[0]),
+		schnorr.SerializePubKey(delegation.FinalityProviderKeys[0]),
+	)
+	require.Equal(t, params.StakingAmount, delegation.StakingAmount)
+	require.Equal(t, params.StakingTime, delegation.StakingTime)
+	require.Equal(t, wire.OutPoint{Hash: stakingTx.TxHash(), Index: 0}, delegation.StakingOutpoint)
+
+	// delegation params rebuilt from the parsed data produce the same staking output
+	rebuilt := params
+	rebuilt.StakerKey = delegation.StakerKey
+	rebuilt.FinalityProviderKeys = delegation.FinalityProviderKeys
+	rebuilt.StakingTime = delegation.StakingTime
+	rebuilt.StakingAmount = delegation.StakingAmount
+	stakingScript, err := btcstaking.StakingOutputScript(rebuilt)
+	require.NoError(t, err)
+	require.Equal(t, stakingTx.TxOut[0].PkScript, stakingScript)
+
+	// other covenant committee does not identify the staking output
+	_, err = btcstaking.ParseDelegation(stakingTx, tag, params.CovenantKeys[1:], params.CovenantQuorum, net)
+	require.Error(t, err)
+
+	// other tag
+	_, err = btcstaking.ParseDelegation(
+		stakingTx, datagen.GenRandomByteArray(r, btcstaking.TagLen), params.CovenantKeys, params.CovenantQuorum, net,
+	)
+	require.Error(t, err)
+
+	// missing metadata
+	noMetadataTx := stakingTx.Copy()
+	noMetadataTx.TxOut[1] = wire.NewTxOut(10000, stakingTx.TxOut[0].PkScript)
+	_, err = btcstaking.ParseDelegation(noMetadataTx, tag, params.CovenantKeys, params.CovenantQuorum, net)
+	require.Error(t, err)
+
+	_, err = btcstaking.ParseDelegation(nil, tag, params.CovenantKeys, params.CovenantQuorum, net)
+	require.Error(t, err)
+}
@@ -0,0 +1,107 @@
+package btcstaking
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// OutputType identifies the kind of Babylon output
+type OutputType int
+
+const (
+	// UnknownOutput is an output which does not commit to any known script
+	UnknownOutput OutputType = iota
+	// StakingOutput commits to time lock, unbonding and slashing paths
+	StakingOutput
+	// UnbondingOutput commits to time lock and slashing paths
+	UnbondingOutput
+	// SlashingChangeOutput commits only to the time lock path returning the
+	// non-slashed funds to the staker
+	SlashingChangeOutput
+	// StakingOrUnbondingOutput is either staking or unbonding output, which
+	// can't be told apart by the known spend infos, see ClassifyOutput
+	StakingOrUnbondingOutput
+)
+
+func (o OutputType) String() string {
+	switch o {
+	case UnknownOutput:
+		return "unknown"
+	case StakingOutput:
+		return "staking"
+	case UnbondingOutput:
+		return "unbonding"
+	case SlashingChangeOutput:
+		return "slashing_change"
+	case StakingOrUnbondingOutput:
+		return "staking_or_unbonding"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(o))
+	}
+}
+
+// ClassifyOutput matches the given pk script against the taproot output keys
+// committed to by the known spend infos. If any of them matches, it returns the
+// type of the output and the first matching spend info. The type is derived from
+// the shape of the taproot tree proven by the matching spend infos:
+// - staking output tree has three leaves, including the unbonding path
+// - unbonding output tree has two leaves
+// - slashing change output tree has the time lock leaf only
+// The slashing leaf of the staking output has the same depth as the leaves of
+// the unbonding output, and its sibling commits to the time lock, which is not
+// known from the slashing script. If the slashing leaf is the only matching
+// spend info, the output is reported as StakingOrUnbondingOutput. To classify
+// staking and unbonding outputs precisely, the known spend infos must contain
+// their time lock or unbonding leaves, e.g all spend infos built by
+// BuildAllSpendInfos.
+func ClassifyOutput(pkScript []byte, knownTemplates []*SpendInfo) (OutputType, *SpendInfo, bool) {
+	if !txscript.IsPayToTaproot(pkScript) {
+		return UnknownOutput, nil, false
+	}
+
+	var matches []*SpendInfo
+	for _, si := range knownTemplates {
+		outputKey, err := TaprootOutputKey(si)
+		if err != nil {
+			continue
+		}
+
+		// taproot pk script is OP_1 OP_DATA_32 <x-only output key>
+		if bytes.Equal(schnorr.SerializePubKey(outputKey), pkScript[2:]) {
+			matches = append(matches, si)
+		}
+	}
+
+	if len(matches) == 0 {
+		return UnknownOutput, nil, false
+	}
+
+	outputType := StakingOrUnbondingOutput
+	for _, si := range matches {
+		depth := len(si.ControlBlock.InclusionProof) / chainhash.HashSize
+
+		if depth == 0 {
+			outputType = SlashingChangeOutput
+			break
+		}
+
+		path, err := SpendPathFromScript(si.GetPkScriptPath())
+		if depth > 1 || (err == nil && path == UnbondingPath) {
+			outputType = StakingOutput
+			break
+		}
+
+		// leaves of the unbonding output other than slashing leaf are not
+		// present at this depth in the staking output
+		if err != nil || path != SlashingPath {
+			outputType = UnbondingOutput
+			break
+		}
+	}
+
+	return outputType, matches[0], true
+}
@@ -0,0 +1,97 @@
+package btcstaking_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyOutput(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+
+	changePkScript, changeSi, err := btcstaking.BuildSlashingChangeScript(
+		params.StakerKey, params.FinalityProviderKeys, params.CovenantKeys, 100,
+	)
+	require.NoError(t, err)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		params.StakerKey,
+		params.FinalityProviderKeys,
+		params.CovenantKeys,
+		params.CovenantQuorum,
+		params.StakingTime,
+		params.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	unbondingAddress, err := btcstaking.TaprootAddress(infos.UnbondingSlashing, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	unbondingPkScript, err := txscript.PayToAddrScript(unbondingAddress)
+	require.NoError(t, err)
+
+	stakingAddress, err := btcstaking.TaprootAddress(infos.Slashing, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	require.Equal(t, stakingInfo.GetPkScript()[2:], stakingAddress.ScriptAddress())
+
+	templates := []*btcstaking.SpendInfo{
+		infos.Slashing,
+		infos.Unbonding,
+		infos.TimeLock,
+		infos.UnbondingSlashing,
+		infos.UnbondingTimeLock,
+		changeSi,
+	}
+
+	testCases := []struct {
+		name       string
+		pkScript   []byte
+		outputType btcstaking.OutputType
+		found      bool
+	}{
+		{"staking output", stakingInfo.GetPkScript(), btcstaking.StakingOutput, true},
+		{"unbonding output", unbondingPkScript, btcstaking.UnbondingOutput, true},
+		{"slashing change output", changePkScript, btcstaking.SlashingChangeOutput, true},
+		{"unrelated output", taprootOutputWithValue(t, r, 10000).PkScript, btcstaking.UnknownOutput, false},
+		{"non taproot output", []byte{txscript.OP_TRUE}, btcstaking.UnknownOutput, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			outputType, si, found := btcstaking.ClassifyOutput(tc.pkScript, templates)
+			require.Equal(t, tc.found, found)
+			require.Equal(t, tc.outputType, outputType)
+
+			if found {
+				require.NoError(t, btcstaking.VerifyScriptInclusion(si, tc.pkScript))
+			}
+		})
+	}
+
+	// slashing leaves alone have the same depth in both outputs
+	outputType, si, found := btcstaking.ClassifyOutput(stakingInfo.GetPkScript(), []*btcstaking.SpendInfo{infos.Slashing})
+	require.True(t, found)
+	require.Equal(t, btcstaking.StakingOrUnbondingOutput, outputType)
+	require.Equal(t, infos.Slashing, si)
+
+	outputType, si, found = btcstaking.ClassifyOutput(unbondingPkScript, []*btcstaking.SpendInfo{infos.UnbondingSlashing})
+	require.True(t, found)
+	require.Equal(t, btcstaking.StakingOrUnbondingOutput, outputType)
+	require.Equal(t, infos.UnbondingSlashing, si)
+
+	// time lock leaf of the unbonding output alone is enough
+	outputType, _, found = btcstaking.ClassifyOutput(unbondingPkScript, []*btcstaking.SpendInfo{infos.UnbondingTimeLock})
+	require.True(t, found)
+	require.Equal(t, btcstaking.UnbondingOutput, outputType)
+}
@@ -0,0 +1,39 @@
+package btcstaking
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// FinalizePsbtInput finalizes the PSBT input with the witness built by the
+// witness builders of this package. The witness is serialized into the
+// FinalScriptWitness field, and, as required by BIP174 finalizers, all the
+// other fields apart from the witness utxo are removed.
+// It returns psbt.ErrInputAlreadyFinalized if the input is already finalized.
+func FinalizePsbtInput(input *psbt.PInput, witness wire.TxWitness) error {
+	if input == nil {
+		return fmt.Errorf("psbt input must not be nil")
+	}
+
+	if input.FinalScriptSig != nil || input.FinalScriptWitness != nil {
+		return psbt.ErrInputAlreadyFinalized
+	}
+
+	if len(witness) == 0 {
+		return fmt.Errorf("witness must not be empty")
+	}
+
+	var buf bytes.Buffer
+	if err := psbt.WriteTxWitness(&buf, witness); err != nil {
+		return fmt.Errorf("failed to serialize witness: %w", err)
+	}
+
+	finalized := psbt.NewPsbtInput(nil, input.WitnessUtxo)
+	finalized.FinalScriptWitness = buf.Bytes()
+	*input = *finalized
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package btcstaking_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	btctest "github.com/babylonlabs-io/babylon/testutil/bitcoin"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFinalizePsbtInput(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	si, err := stakingInfo.UnbondingPathSpendInfo()
+	require.NoError(t, err)
+
+	packet, err := psbt.New(
+		[]*wire.OutPoint{{Hash: chainhash.Hash{1}, Index: 0}},
+		[]*wire.TxOut{taprootOutputWithValue(t, r, scenario.StakingAmount.MulF64(0.9))},
+		2,
+		0,
+		[]uint32{wire.MaxTxInSequenceNum},
+	)
+	require.NoError(t, err)
+	packet.Inputs[0].WitnessUtxo = stakingInfo.StakingOutput
+
+	sigs := signLeafWithKeys(
+		t, packet.UnsignedTx, stakingInfo.StakingOutput, si,
+		scenario.StakerKey, scenario.CovenantKeys[0], scenario.CovenantKeys[2],
+	)
+	orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+	require.NoError(t, err)
+	witness, err := btcstaking.CreateWitness(si, orderedSigs)
+	require.NoError(t, err)
+
+	require.NoError(t, btcstaking.FinalizePsbtInput(&packet.Inputs[0], witness))
+	require.True(t, packet.IsComplete())
+
+	// input can't be finalized twice
+	err = btcstaking.FinalizePsbtInput(&packet.Inputs[0], witness)
+	require.ErrorIs(t, err, psbt.ErrInputAlreadyFinalized)
+
+	// finalized psbt survives serialization round trip
+	var buf bytes.Buffer
+	require.NoError(t, packet.Serialize(&buf))
+	parsed, err := psbt.NewFromRawBytes(&buf, false)
+	require.NoError(t, err)
+
+	signedTx, err := psbt.Extract(parsed)
+	require.NoError(t, err)
+	require.Equal(t, witness, signedTx.TxIn[0].Witness)
+	btctest.AssertSlashingTxExecution(t, stakingInfo.StakingOutput, signedTx)
+}
@@ -0,0 +1,275 @@
+package btcstaking
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// ExtractScriptPubKeys returns all BIP340 public keys pushed by the given script
+// in the order in which they appear in the script.
+func ExtractScriptPubKeys(script []byte) ([]*btcec.PublicKey, error) {
+	var keys []*btcec.PublicKey
+
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	for tokenizer.Next() {
+		data := tokenizer.Data()
+
+		if len(data) != schnorr.PubKeyBytesLen {
+			continue
+		}
+
+		key, err := schnorr.ParsePubKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key at script offset %d: %w", tokenizer.ByteIndex(), err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := tokenizer.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse script: %w", err)
+	}
+
+	return keys, nil
+}
+
+// ExtractTimelock returns the relative time lock enforced by the given time
+// lock script i.e the number pushed right before OP_CHECKSEQUENCEVERIFY.
+func ExtractTimelock(script []byte) (uint16, error) {
+	lockTime, err := extractLockBeforeOpcode(script, txscript.OP_CHECKSEQUENCEVERIFY, "OP_CHECKSEQUENCEVERIFY")
+	if err != nil {
+		return 0, err
+	}
+
+	if lockTime <= 0 || lockTime > math.MaxUint16 {
+		return 0, fmt.Errorf("time lock %d is out of range", lockTime)
+	}
+
+	return uint16(lockTime), nil
+}
+
+// ExtractAbsoluteTimelock returns the absolute lock time enforced by the given
+// script i.e the number pushed right before OP_CHECKLOCKTIMEVERIFY. Babylon
+// scripts only use relative time locks, so this is meant for other leaves
+// committed in the same taproot tree.
+func ExtractAbsoluteTimelock(script []byte) (uint32, error) {
+	lockTime, err := extractLockBeforeOpcode(script, txscript.OP_CHECKLOCKTIMEVERIFY, "OP_CHECKLOCKTIMEVERIFY")
+	if err != nil {
+		return 0, err
+	}
+
+	if lockTime <= 0 || lockTime > math.MaxUint32 {
+		return 0, fmt.Errorf("lock time %d is out of range", lockTime)
+	}
+
+	return uint32(lockTime), nil
+}
+
+// extractLockBeforeOpcode returns the number pushed right before the first
+// occurrence of the given lock opcode in the script
+func extractLockBeforeOpcode(script []byte, lockOpcode byte, opcodeName string) (int64, error) {
+	var (
+		prevOpcode byte
+		prevData   []byte
+		hasPrev    bool
+	)
+
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	for tokenizer.Next() {
+		if tokenizer.Opcode() != lockOpcode {
+			prevOpcode = tokenizer.Opcode()
+			prevData = tokenizer.Data()
+			hasPrev = true
+			continue
+		}
+
+		if !hasPrev {
+			return 0, fmt.Errorf("%s is not preceded by lock time", opcodeName)
+		}
+
+		if txscript.IsSmallInt(prevOpcode) {
+			return int64(txscript.AsSmallInt(prevOpcode)), nil
+		}
+
+		// lock times are encoded on at most 5 bytes
+		num, err := txscript.MakeScriptNum(prevData, true, 5)
+		if err != nil {
+			return 0, fmt.Errorf("invalid lock time encoding: %w", err)
+		}
+
+		return int64(num), nil
+	}
+
+	if err := tokenizer.Err(); err != nil {
+		return 0, fmt.Errorf("failed to parse script: %w", err)
+	}
+
+	return 0, fmt.Errorf("script does not contain %s", opcodeName)
+}
+
+// scriptSegment is a single key or multisig signature check of a script
+type scriptSegment struct {
+	keys      []*btcec.PublicKey
+	threshold uint32
+}
+
+// parseScriptSegments splits the script into its signature check segments.
+// Babylon scripts are concatenations of single key and multisig segments, where
+// every segment except the last one ends with OP_CHECKSIGVERIFY or
+// OP_NUMEQUALVERIFY. Threshold of single key segments is 1.
+func parseScriptSegments(script []byte) ([]scriptSegment, error) {
+	var (
+		segments   []scriptSegment
+		current    scriptSegment
+		prevOpcode byte
+		prevData   []byte
+	)
+
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	for tokenizer.Next() {
+		opcode := tokenizer.Opcode()
+		data := tokenizer.Data()
+
+		if len(data) == schnorr.PubKeyBytesLen {
+			key, err := schnorr.ParsePubKey(data)
+			if err != nil {
+				return nil, fmt.Errorf("invalid public key at script offset %d: %w", tokenizer.ByteIndex(), err)
+			}
+			current.keys = append(current.keys, key)
+		}
+
+		switch opcode {
+		case txscript.OP_NUMEQUAL, txscript.OP_NUMEQUALVERIFY:
+			var threshold int64
+			if txscript.IsSmallInt(prevOpcode) {
+				threshold = int64(txscript.AsSmallInt(prevOpcode))
+			} else {
+				num, err := txscript.MakeScriptNum(prevData, true, 4)
+				if err != nil {
+					return nil, fmt.Errorf("invalid multisig threshold encoding: %w", err)
+				}
+				threshold = int64(num)
+			}
+
+			if threshold < 1 || threshold > int64(len(current.keys)) {
+				return nil, fmt.Errorf("invalid multisig threshold %d for %d keys", threshold, len(current.keys))
+			}
+			current.threshold = uint32(threshold)
+		case txscript.OP_CHECKSIG, txscript.OP_CHECKSIGVERIFY:
+			if len(current.keys) == 1 {
+				current.threshold = 1
+			}
+		}
+
+		if opcode == txscript.OP_CHECKSIGVERIFY || opcode == txscript.OP_NUMEQUALVERIFY {
+			segments = append(segments, current)
+			current = scriptSegment{}
+		}
+
+		prevOpcode = opcode
+		prevData = data
+	}
+
+	if err := tokenizer.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse script: %w", err)
+	}
+
+	if len(current.keys) > 0 {
+		segments = append(segments, current)
+	}
+
+	return segments, nil
+}
+
+// countKeysPerSegment returns the number of keys in each signature check segment
+// of the given script, see parseScriptSegments
+func countKeysPerSegment(script []byte) ([]int, error) {
+	segments, err := parseScriptSegments(script)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]int, len(segments))
+	for i, segment := range segments {
+		counts[i] = len(segment.keys)
+	}
+
+	return counts, nil
+}
+
+// LeafSigners contains the keys whose signatures are required to spend a
+// Babylon leaf, in the order in which they appear in the script
+type LeafSigners struct {
+	// StakerKey is the key of the staker, required by every leaf
+	StakerKey *btcec.PublicKey
+	// FinalityProviderKeys are the keys of the finality providers, only one of
+	// which must sign. Only the slashing leaf has finality provider keys.
+	FinalityProviderKeys []*btcec.PublicKey
+	// CovenantKeys are the keys of the covenant committee, sorted as in the
+	// script. Time lock leaf has no covenant keys.
+	CovenantKeys []*btcec.PublicKey
+	// CovenantQuorum is the number of covenant signatures required
+	CovenantQuorum uint32
+}
+
+// WitnessOrder returns all signer keys in the order of witness signature slots,
+// i.e the reverse of the script order. A slot must be left empty if its key
+// did not sign.
+func (s *LeafSigners) WitnessOrder() []*btcec.PublicKey {
+	keys := make([]*btcec.PublicKey, 0, 1+len(s.FinalityProviderKeys)+len(s.CovenantKeys))
+
+	for i := len(s.CovenantKeys) - 1; i >= 0; i-- {
+		keys = append(keys, s.CovenantKeys[i])
+	}
+	for i := len(s.FinalityProviderKeys) - 1; i >= 0; i-- {
+		keys = append(keys, s.FinalityProviderKeys[i])
+	}
+
+	return append(keys, s.StakerKey)
+}
+
+// RequiredSigners parses the keys required to spend the given Babylon leaf
+// script. Time lock leaf requires only the staker, unbonding leaf requires the
+// staker and the covenant quorum, and slashing leaf additionally requires one
+// of the finality providers.
+func RequiredSigners(leafScript []byte) (*LeafSigners, error) {
+	segments, err := parseScriptSegments(leafScript)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(segments) == 0 || len(segments) > 3 {
+		return nil, fmt.Errorf("script has %d signature checks, expected Babylon leaf script", len(segments))
+	}
+
+	if len(segments[0].keys) != 1 {
+		return nil, fmt.Errorf("first signature check of Babylon leaf script must be the staker key")
+	}
+
+	signers := &LeafSigners{
+		StakerKey: segments[0].keys[0],
+	}
+
+	switch len(segments) {
+	case 1:
+		if _, err := ExtractTimelock(leafScript); err != nil {
+			return nil, fmt.Errorf("single signature script is not a time lock script: %w", err)
+		}
+	case 2:
+		signers.CovenantKeys = segments[1].keys
+		signers.CovenantQuorum = segments[1].threshold
+	case 3:
+		if segments[1].threshold != 1 {
+			return nil, fmt.Errorf("finality provider threshold must be 1, got %d", segments[1].threshold)
+		}
+		signers.FinalityProviderKeys = segments[1].keys
+		signers.CovenantKeys = segments[2].keys
+		signers.CovenantQuorum = segments[2].threshold
+	}
+
+	return signers, nil
+}
@@ -0,0 +1,169 @@
+package btcstaking_test
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	btctest "github.com/babylonlabs-io/babylon/testutil/bitcoin"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+// signLeafWithKeys signs the only input of the tx with every given key and
+// returns signatures keyed by hex encoded BIP340 public key
+func signLeafWithKeys(
+	t *testing.T,
+	tx *wire.MsgTx,
+	fundingOutput *wire.TxOut,
+	si *btcstaking.SpendInfo,
+	keys ...*btcec.PrivateKey,
+) map[string]*schnorr.Signature {
+	sigs := make(map[string]*schnorr.Signature)
+	for _, key := range keys {
+		sig, err := btcstaking.SignTxWithOneScriptSpendInputFromTapLeaf(tx, fundingOutput, key, si.RevealedLeaf)
+		require.NoError(t, err)
+		sigs[hex.EncodeToString(schnorr.SerializePubKey(key.PubKey()))] = sig
+	}
+	return sigs
+}
+
+func TestExtractScriptPubKeys(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 2, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	keys, err := btcstaking.ExtractScriptPubKeys(infos.TimeLock.GetPkScriptPath())
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Equal(t, schnorr.SerializePubKey(scenario.StakerKey.PubKey()), schnorr.SerializePubKey(keys[0]))
+
+	keys, err = btcstaking.ExtractScriptPubKeys(infos.Unbonding.GetPkScriptPath())
+	require.NoError(t, err)
+	require.Len(t, keys, 4)
+
+	keys, err = btcstaking.ExtractScriptPubKeys(infos.Slashing.GetPkScriptPath())
+	require.NoError(t, err)
+	require.Len(t, keys, 6)
+}
+
+func TestReorderSigsForLeaf(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 2, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	unbondingSi, err := stakingInfo.UnbondingPathSpendInfo()
+	require.NoError(t, err)
+	slashingSi, err := stakingInfo.SlashingPathSpendInfo()
+	require.NoError(t, err)
+
+	for _, si := range []*btcstaking.SpendInfo{unbondingSi, slashingSi} {
+		spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+
+		// signatures of staker, one finality provider and quorum of covenant
+		// members provided in random order
+		signers := []*btcec.PrivateKey{
+			scenario.CovenantKeys[4],
+			scenario.FinalityProviderKeys[1],
+			scenario.CovenantKeys[0],
+			scenario.StakerKey,
+			scenario.CovenantKeys[2],
+		}
+		sigs := signLeafWithKeys(t, spendTx, stakingInfo.StakingOutput, si, signers...)
+
+		orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+		require.NoError(t, err)
+
+		witness, err := btcstaking.CreateWitness(si, orderedSigs)
+		require.NoError(t, err)
+		spendTx.TxIn[0].Witness = witness
+
+		btctest.AssertSlashingTxExecution(t, stakingInfo.StakingOutput, spendTx)
+	}
+
+	_, err = btcstaking.ReorderSigsForLeaf(map[string]*schnorr.Signature{}, unbondingSi.GetPkScriptPath())
+	require.Error(t, err)
+}
+
+func TestRequiredSigners(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+
+	xOnly := func(keys ...*btcec.PublicKey) []string {
+		res := make([]string, len(keys))
+		for i, k := range keys {
+			res[i] = hex.EncodeToString(schnorr.SerializePubKey(k))
+		}
+		return res
+	}
+
+	for _, numCovenants := range []uint32{1, 5} {
+		quorum := (numCovenants + 1) / 2
+		scenario := GenerateTestScenario(r, t, 2, numCovenants, quorum, btcutil.Amount(2*10e8), 1000)
+		params := scenario.StakingParams(100)
+
+		infos, err := btcstaking.BuildAllSpendInfos(params)
+		require.NoError(t, err)
+
+		sortedCovenantKeys := btcstaking.SortKeys(params.CovenantKeys)
+		sortedFpKeys := btcstaking.SortKeys(params.FinalityProviderKeys)
+
+		testCases := []struct {
+			name   string
+			si     *btcstaking.SpendInfo
+			fps    []*btcec.PublicKey
+			cov    []*btcec.PublicKey
+			quorum uint32
+		}{
+			{"time lock", infos.TimeLock, nil, nil, 0},
+			{"unbonding", infos.Unbonding, nil, sortedCovenantKeys, quorum},
+			{"slashing", infos.Slashing, sortedFpKeys, sortedCovenantKeys, quorum},
+			{"unbonding slashing", infos.UnbondingSlashing, sortedFpKeys, sortedCovenantKeys, quorum},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				signers, err := btcstaking.RequiredSigners(tc.si.GetPkScriptPath())
+				require.NoError(t, err)
+
+				require.Equal(t, xOnly(params.StakerKey), xOnly(signers.StakerKey))
+				require.Equal(t, xOnly(tc.fps...), xOnly(signers.FinalityProviderKeys...))
+				require.Equal(t, xOnly(tc.cov...), xOnly(signers.CovenantKeys...))
+				require.Equal(t, tc.quorum, signers.CovenantQuorum)
+
+				// witness order matches the order used by ReorderSigsForLeaf
+				witnessKeys := signers.WitnessOrder()
+				for i, key := range witnessKeys {
+					sigs := map[string]*schnorr.Signature{
+						xOnly(key)[0]: schnorr.NewSignature(new(btcec.FieldVal).SetInt(1), new(btcec.ModNScalar).SetInt(1)),
+					}
+					ordered, err := btcstaking.ReorderSigsForLeaf(sigs, tc.si.GetPkScriptPath())
+					require.NoError(t, err)
+					require.Len(t, ordered, len(witnessKeys))
+					require.NotEmpty(t, ordered[i])
+				}
+			})
+		}
+	}
+
+	// script which is not a Babylon leaf
+	_, err := btcstaking.RequiredSigners([]byte{0x51})
+	require.Error(t, err)
+}
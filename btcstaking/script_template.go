@@ -0,0 +1,113 @@
+package btcstaking
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ScriptTemplate describes the shape of leaf scripts spent through a single
+// spend path. Templates are used only to recognize and interpret scripts,
+// witness builders do not depend on them.
+type ScriptTemplate struct {
+	// Name uniquely identifies the template in the registry
+	Name string
+	// Path is the spend path of scripts matching the template
+	Path SpendPath
+	// Match returns true if the script has the shape of the template
+	Match func(script []byte) bool
+}
+
+// ScriptTemplateRegistry contains the script templates known to parsers. It
+// lets integrators, e.g. forks or networks using different scripts, register
+// their own templates. It is safe for concurrent use.
+type ScriptTemplateRegistry struct {
+	mu        sync.RWMutex
+	templates []ScriptTemplate
+}
+
+// NewScriptTemplateRegistry creates an empty registry
+func NewScriptTemplateRegistry() *ScriptTemplateRegistry {
+	return &ScriptTemplateRegistry{}
+}
+
+// Register adds the template to the registry. Templates are matched in the
+// order of registration.
+func (r *ScriptTemplateRegistry) Register(tmpl ScriptTemplate) error {
+	if tmpl.Name == "" {
+		return fmt.Errorf("script template name must not be empty")
+	}
+
+	if tmpl.Match == nil {
+		return fmt.Errorf("script template %s must have match function", tmpl.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.templates {
+		if t.Name == tmpl.Name {
+			return fmt.Errorf("script template %s is already registered", tmpl.Name)
+		}
+	}
+
+	r.templates = append(r.templates, tmpl)
+
+	return nil
+}
+
+// Templates returns all registered templates in the order of registration
+func (r *ScriptTemplateRegistry) Templates() []ScriptTemplate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	templates := make([]ScriptTemplate, len(r.templates))
+	copy(templates, r.templates)
+
+	return templates
+}
+
+// MatchScript returns the first registered template matching the given script
+func (r *ScriptTemplateRegistry) MatchScript(script []byte) (*ScriptTemplate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, t := range r.templates {
+		if t.Match(script) {
+			tmpl := t
+			return &tmpl, nil
+		}
+	}
+
+	return nil, fmt.Errorf("script does not match any registered script template")
+}
+
+// DefaultScriptTemplates returns the templates of the leaf scripts currently
+// used by Babylon on mainnet
+func DefaultScriptTemplates() []ScriptTemplate {
+	matchPath := func(path SpendPath) func([]byte) bool {
+		return func(script []byte) bool {
+			p, err := babylonScriptPath(script)
+			return err == nil && p == path
+		}
+	}
+
+	return []ScriptTemplate{
+		{Name: "babylon-timelock", Path: TimeLockPath, Match: matchPath(TimeLockPath)},
+		{Name: "babylon-unbonding", Path: UnbondingPath, Match: matchPath(UnbondingPath)},
+		{Name: "babylon-slashing", Path: SlashingPath, Match: matchPath(SlashingPath)},
+	}
+}
+
+// DefaultScriptTemplateRegistry is the registry consulted by SpendPathFromScript
+// and functions built on it. It contains DefaultScriptTemplates.
+var DefaultScriptTemplateRegistry = newDefaultScriptTemplateRegistry()
+
+func newDefaultScriptTemplateRegistry() *ScriptTemplateRegistry {
+	r := NewScriptTemplateRegistry()
+	for _, tmpl := range DefaultScriptTemplates() {
+		if err := r.Register(tmpl); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
@@ -0,0 +1,79 @@
+package btcstaking_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScriptTemplateRegistry(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	// default templates recognize all current leaves
+	for _, tc := range []struct {
+		si   *btcstaking.SpendInfo
+		name string
+	}{
+		{infos.TimeLock, "babylon-timelock"},
+		{infos.Unbonding, "babylon-unbonding"},
+		{infos.Slashing, "babylon-slashing"},
+		{infos.UnbondingTimeLock, "babylon-timelock"},
+		{infos.UnbondingSlashing, "babylon-slashing"},
+	} {
+		tmpl, err := btcstaking.DefaultScriptTemplateRegistry.MatchScript(tc.si.GetPkScriptPath())
+		require.NoError(t, err)
+		require.Equal(t, tc.name, tmpl.Name)
+	}
+
+	// fork using absolute time lock for withdrawals
+	// SCRIPT: <StakerPk> OP_CHECKSIGVERIFY <height> OP_CHECKLOCKTIMEVERIFY
+	absoluteTimeLockScript, err := txscript.NewScriptBuilder().
+		AddData(schnorr.SerializePubKey(scenario.StakerKey.PubKey())).
+		AddOp(txscript.OP_CHECKSIGVERIFY).
+		AddInt64(900000).
+		AddOp(txscript.OP_CHECKLOCKTIMEVERIFY).
+		Script()
+	require.NoError(t, err)
+
+	forkTemplate := btcstaking.ScriptTemplate{
+		Name: "fork-absolute-timelock",
+		Path: btcstaking.TimeLockPath,
+		Match: func(script []byte) bool {
+			_, err := btcstaking.ExtractAbsoluteTimelock(script)
+			return err == nil && bytes.HasPrefix(script, []byte{txscript.OP_DATA_32})
+		},
+	}
+
+	registry := btcstaking.NewScriptTemplateRegistry()
+	_, err = registry.MatchScript(absoluteTimeLockScript)
+	require.Error(t, err)
+
+	require.NoError(t, registry.Register(forkTemplate))
+	tmpl, err := registry.MatchScript(absoluteTimeLockScript)
+	require.NoError(t, err)
+	require.Equal(t, "fork-absolute-timelock", tmpl.Name)
+	require.Equal(t, btcstaking.TimeLockPath, tmpl.Path)
+
+	// templates are not matched across registries
+	_, err = registry.MatchScript(infos.Unbonding.GetPkScriptPath())
+	require.Error(t, err)
+	_, err = btcstaking.DefaultScriptTemplateRegistry.MatchScript(absoluteTimeLockScript)
+	require.Error(t, err)
+
+	// invalid registrations
+	require.Error(t, registry.Register(forkTemplate))
+	require.Error(t, registry.Register(btcstaking.ScriptTemplate{Name: "no-match"}))
+	require.Error(t, registry.Register(btcstaking.ScriptTemplate{Match: forkTemplate.Match}))
+	require.Len(t, registry.Templates(), 1)
+}
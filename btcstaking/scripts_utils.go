@@ -70,7 +70,7 @@ func prepareKeysForMultisigScript(keys []*btcec.PublicKey) ([]*btcec.PublicKey,
 
 // buildMultiSigScript creates multisig script with given keys and signer threshold to
 // successfully execute script
-// it validates whether threshold is not greater than number of keys
+// it validates whether threshold is at least 1 and not greater than number of keys
 // If there is only one key provided it will return single key sig script
 // Note: It is up to the caller to ensure that the keys are unique
 func buildMultiSigScript(
@@ -82,8 +82,8 @@ func buildMultiSigScript(
 		return nil, fmt.Errorf("no keys provided")
 	}
 
-	if int(threshold) > len(keys) {
-		return nil, fmt.Errorf("required number of valid signers is greater than number of provided keys")
+	if err := ValidateQuorum(len(keys), int(threshold)); err != nil {
+		return nil, fmt.Errorf("invalid number of required signers: %w", err)
 	}
 
 	if len(keys) == 1 {
@@ -0,0 +1,367 @@
+package btcstaking
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// newPrevOutFetcher creates prev output fetcher for all inputs of the given
+// transaction. prevOuts[i] must be the output spent by tx.TxIn[i].
+func newPrevOutFetcher(tx *wire.MsgTx, prevOuts []*wire.TxOut) (*txscript.MultiPrevOutFetcher, error) {
+	if tx == nil {
+		return nil, fmt.Errorf("tx must not be nil")
+	}
+
+	if len(prevOuts) != len(tx.TxIn) {
+		return nil, fmt.Errorf("number of prev outputs %d does not match number of inputs %d", len(prevOuts), len(tx.TxIn))
+	}
+
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+
+	for i, in := range tx.TxIn {
+		if prevOuts[i] == nil {
+			return nil, fmt.Errorf("prev output of input %d must not be nil", i)
+		}
+		fetcher.AddPrevOut(in.PreviousOutPoint, prevOuts[i])
+	}
+
+	return fetcher, nil
+}
+
+// SigHashCache caches the taproot signature hash midstates of a transaction
+// i.e hashes of all prevouts, amounts, pk scripts, sequences and outputs. They
+// are the same for every input, so computing them once makes signing
+// transactions with many inputs linear instead of quadratic.
+// The cache must be recreated after the transaction is modified.
+type SigHashCache struct {
+	tx        *wire.MsgTx
+	fetcher   *txscript.MultiPrevOutFetcher
+	sigHashes *txscript.TxSigHashes
+}
+
+// NewSigHashCache precomputes the signature hash midstates of the given
+// transaction. prevOuts must contain the outputs spent by every input of the
+// transaction, in input order.
+func NewSigHashCache(tx *wire.MsgTx, prevOuts []*wire.TxOut) (*SigHashCache, error) {
+	fetcher, err := newPrevOutFetcher(tx, prevOuts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigHashCache{
+		tx:        tx,
+		fetcher:   fetcher,
+		sigHashes: txscript.NewTxSigHashes(tx, fetcher),
+	}, nil
+}
+
+// SigHashForInput computes the tapscript signature hash which must be signed to
+// spend the input with index inputIdx through the script path described by the
+// given spend info, reusing the midstates of the cache.
+func SigHashForInput(
+	cache *SigHashCache,
+	inputIdx int,
+	si *SpendInfo,
+	sigHashType txscript.SigHashType,
+) ([]byte, error) {
+	if cache == nil {
+		return nil, fmt.Errorf("sighash cache must not be nil")
+	}
+
+	if si == nil {
+		return nil, fmt.Errorf("spend info must not be nil")
+	}
+
+	if inputIdx < 0 || inputIdx >= len(cache.tx.TxIn) {
+		return nil, fmt.Errorf("invalid input index %d, tx has %d inputs", inputIdx, len(cache.tx.TxIn))
+	}
+
+	return txscript.CalcTapscriptSignaturehash(
+		cache.sigHashes,
+		sigHashType,
+		cache.tx,
+		inputIdx,
+		cache.fetcher,
+		si.RevealedLeaf,
+	)
+}
+
+// TaprootSigHash computes the tapscript signature hash which must be signed to
+// spend the input with index inputIdx through the script path described by the
+// given spend info.
+// prevOuts must contain the outputs spent by every input of the transaction, in
+// input order, as taproot signature hash commits to all of them.
+// To sign multiple inputs of the same transaction, SigHashCache should be used
+// instead.
+func TaprootSigHash(
+	tx *wire.MsgTx,
+	inputIdx int,
+	prevOuts []*wire.TxOut,
+	si *SpendInfo,
+	sigHashType txscript.SigHashType,
+) ([]byte, error) {
+	if si == nil {
+		return nil, fmt.Errorf("spend info must not be nil")
+	}
+
+	cache, err := NewSigHashCache(tx, prevOuts)
+	if err != nil {
+		return nil, err
+	}
+
+	return SigHashForInput(cache, inputIdx, si, sigHashType)
+}
+
+// unbondingSlashingSpend returns the spend info and the output spent by the
+// unbonding slashing tx of the delegation described by params. It checks that
+// the slashing tx spends the only output of the unbonding tx and that the
+// unbonding output commits to the delegation's unbonding tree, so the staking
+// output can not be mistaken for the unbonding output.
+func unbondingSlashingSpend(
+	slashingTx *wire.MsgTx,
+	unbondingTx *wire.MsgTx,
+	params StakingParams,
+) (*SpendInfo, *wire.TxOut, error) {
+	if slashingTx == nil {
+		return nil, nil, fmt.Errorf("slashing tx must not be nil")
+	}
+
+	if unbondingTx == nil {
+		return nil, nil, fmt.Errorf("unbonding tx must not be nil")
+	}
+
+	if len(unbondingTx.TxOut) != 1 {
+		return nil, nil, fmt.Errorf("unbonding tx must have exactly one output, got %d", len(unbondingTx.TxOut))
+	}
+
+	if len(slashingTx.TxIn) != 1 {
+		return nil, nil, fmt.Errorf("slashing tx must have exactly one input, got %d", len(slashingTx.TxIn))
+	}
+
+	unbondingOutpoint := wire.OutPoint{Hash: unbondingTx.TxHash(), Index: 0}
+	if slashingTx.TxIn[0].PreviousOutPoint != unbondingOutpoint {
+		return nil, nil, fmt.Errorf(
+			"slashing tx does not spend the unbonding output: expected %s, got %s",
+			unbondingOutpoint, slashingTx.TxIn[0].PreviousOutPoint,
+		)
+	}
+
+	infos, err := BuildAllSpendInfos(params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unbondingOut := unbondingTx.TxOut[0]
+	if err := VerifyScriptInclusion(infos.UnbondingSlashing, unbondingOut.PkScript); err != nil {
+		return nil, nil, fmt.Errorf("unbonding output does not belong to the delegation: %w", err)
+	}
+
+	return infos.UnbondingSlashing, unbondingOut, nil
+}
+
+// UnbondingSlashingSigHash computes the signature hash covenant members sign to
+// pre-sign the unbonding slashing tx, i.e the slashing tx spending the
+// unbonding output through the slashing path. The slashing leaves of the
+// staking and unbonding outputs have the same script, so signatures differ only
+// in the spent output. Signing the slashing tx of the staking output instead
+// would produce signature which is not valid for the unbonding slashing tx.
+func UnbondingSlashingSigHash(
+	slashingTx *wire.MsgTx,
+	unbondingTx *wire.MsgTx,
+	params StakingParams,
+) ([]byte, error) {
+	si, unbondingOut, err := unbondingSlashingSpend(slashingTx, unbondingTx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return TaprootSigHash(slashingTx, 0, []*wire.TxOut{unbondingOut}, si, txscript.SigHashDefault)
+}
+
+// pathSigHashPolicy lists sighash types allowed for signatures of each spend path.
+// - time lock path can be signed with SIGHASH_ALL|ANYONECANPAY, so the staker
+// can add inputs to bump the fee of the withdrawal.
+// - unbonding and slashing paths are pre-signed by covenant members and finality
+// providers, so every signature must commit to all inputs and outputs.
+// It is never modified, so it can be read concurrently.
+var pathSigHashPolicy = map[SpendPath][]txscript.SigHashType{
+	TimeLockPath: {
+		txscript.SigHashDefault,
+		txscript.SigHashAll,
+		txscript.SigHashAll | txscript.SigHashAnyOneCanPay,
+	},
+	UnbondingPath: {
+		txscript.SigHashDefault,
+		txscript.SigHashAll,
+	},
+	SlashingPath: {
+		txscript.SigHashDefault,
+		txscript.SigHashAll,
+	},
+	UnbondingSlashingPath: {
+		txscript.SigHashDefault,
+		txscript.SigHashAll,
+	},
+	RecoveryPath: {
+		txscript.SigHashDefault,
+		txscript.SigHashAll,
+		txscript.SigHashAll | txscript.SigHashAnyOneCanPay,
+	},
+}
+
+// AllowedSigHashTypes returns a copy of the sighash types allowed for
+// signatures of the given spend path, or nil if the path has no policy.
+// Modifying the returned slice does not change the policy.
+func AllowedSigHashTypes(path SpendPath) []txscript.SigHashType {
+	allowed, ok := pathSigHashPolicy[path]
+	if !ok {
+		return nil
+	}
+
+	return append([]txscript.SigHashType(nil), allowed...)
+}
+
+// ValidateSigHashForPath checks whether the sighash type is allowed for the
+// given spend path, as returned by AllowedSigHashTypes
+func ValidateSigHashForPath(path SpendPath, sigHashType txscript.SigHashType) error {
+	allowed, ok := pathSigHashPolicy[path]
+	if !ok {
+		return fmt.Errorf("no sighash policy for %s path", path)
+	}
+
+	for _, t := range allowed {
+		if t == sigHashType {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("sighash type 0x%x is not allowed for %s path", byte(sigHashType), path)
+}
+
+// CheckInputExpectations checks that the input structure of the transaction
+// spending a Babylon output through the given path is the expected one, so a
+// signer can reject malformed transactions before committing a signature:
+// - time lock and recovery paths spend the single staking or unbonding input,
+// unless allowExtraInputs is set, e.g when the staker adds inputs to bump the fee.
+// - unbonding, slashing and unbonding slashing paths are pre-signed over the
+// whole transaction, so they always spend exactly one input and
+// allowExtraInputs is ignored.
+func CheckInputExpectations(tx *wire.MsgTx, path SpendPath, allowExtraInputs bool) error {
+	if tx == nil {
+		return fmt.Errorf("tx must not be nil")
+	}
+
+	if len(tx.TxIn) == 0 {
+		return fmt.Errorf("%s tx must have at least one input", path)
+	}
+
+	switch path {
+	case TimeLockPath, RecoveryPath:
+		if !allowExtraInputs && len(tx.TxIn) != 1 {
+			return fmt.Errorf("%s tx must have exactly one input, got %d", path, len(tx.TxIn))
+		}
+	case UnbondingPath:
+		if len(tx.TxIn) != 1 {
+			return fmt.Errorf("%w: got %d inputs", ErrUnbondingWrongInputCount, len(tx.TxIn))
+		}
+	case SlashingPath, UnbondingSlashingPath:
+		if len(tx.TxIn) != 1 {
+			return fmt.Errorf("%s tx must have exactly one input, got %d", path, len(tx.TxIn))
+		}
+	default:
+		return fmt.Errorf("unsupported spend path %s", path)
+	}
+
+	return nil
+}
+
+// SigningContext contains the data a signer needs to display before signing the
+// spend of a single input through a script path
+type SigningContext struct {
+	// SigHash is the tapscript signature hash being signed
+	SigHash []byte
+	// Path is the spend path of the revealed leaf
+	Path SpendPath
+	// PathName is the human readable name of the spend path
+	PathName string
+	// Amount is the value of the output being spent
+	Amount btcutil.Amount
+	// Destinations are the outputs of the spending transaction
+	Destinations []*wire.TxOut
+	// Fee is the fee paid by the spending transaction
+	Fee btcutil.Amount
+}
+
+// BuildSigningContext computes the signature hash of the input with index
+// inputIdx spent through the script path described by the given spend info, and
+// gathers the context required to render the spend in signer UIs.
+// prevOuts must contain the outputs spent by every input of the transaction, in
+// input order.
+func BuildSigningContext(
+	tx *wire.MsgTx,
+	inputIdx int,
+	prevOuts []*wire.TxOut,
+	si *SpendInfo,
+) (*SigningContext, error) {
+	sigHash, err := TaprootSigHash(tx, inputIdx, prevOuts, si, txscript.SigHashDefault)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := SpendPathFromScript(si.GetPkScriptPath())
+	if err != nil {
+		return nil, err
+	}
+
+	var inputsValue, outputsValue btcutil.Amount
+	for _, out := range prevOuts {
+		inputsValue += btcutil.Amount(out.Value)
+	}
+	for _, out := range tx.TxOut {
+		outputsValue += btcutil.Amount(out.Value)
+	}
+
+	return &SigningContext{
+		SigHash:      sigHash,
+		Path:         path,
+		PathName:     path.String(),
+		Amount:       btcutil.Amount(prevOuts[inputIdx].Value),
+		Destinations: tx.TxOut,
+		Fee:          inputsValue - outputsValue,
+	}, nil
+}
+
+// DetectAmountBinding reports whether signatures over the script path described
+// by the given spend info must be collected again after the value of the spent
+// output changed from oldAmount to newAmount.
+// Taproot signature hashes commit to the amount of the spent output with every
+// sighash type (BIP341), so offline signers can't be tricked into paying more
+// fee than they intended. As a consequence, any change of the staking amount
+// invalidates pre-signed unbonding and slashing signatures. The amount is
+// committed for every leaf, so the result does not depend on the spend info,
+// in particular a nil spend info does not mean that signatures remain valid.
+// CheckAmountBinding also validates the spend info.
+func DetectAmountBinding(si *SpendInfo, oldAmount, newAmount btcutil.Amount) bool {
+	return oldAmount != newAmount
+}
+
+// CheckAmountBinding returns ErrResignRequired if signatures over the script
+// path described by the given spend info are invalidated by the change of the
+// spent output value, see DetectAmountBinding. The spend info must not be nil.
+func CheckAmountBinding(si *SpendInfo, oldAmount, newAmount btcutil.Amount) error {
+	if si == nil {
+		return fmt.Errorf("spend info must not be nil")
+	}
+
+	if DetectAmountBinding(si, oldAmount, newAmount) {
+		return fmt.Errorf(
+			"%w: spent output value changed from %d to %d, signatures over leaf %s must be collected again",
+			ErrResignRequired, oldAmount, newAmount, si.RevealedLeaf.TapHash(),
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,248 @@
+package btcstaking_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	"github.com/babylonlabs-io/babylon/testutil/datagen"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildSigningContext(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+
+	for _, numCovenants := range []uint32{1, 3} {
+		scenario := GenerateTestScenario(r, t, 1, numCovenants, 1, btcutil.Amount(2*10e8), 1000)
+
+		infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+		require.NoError(t, err)
+
+		fundingOutput := taprootOutputWithValue(t, r, scenario.StakingAmount)
+		tx := createSpendStakeTx(scenario.StakingAmount - 1000)
+		prevOuts := []*wire.TxOut{fundingOutput}
+
+		tests := []struct {
+			si   *btcstaking.SpendInfo
+			path btcstaking.SpendPath
+		}{
+			{infos.TimeLock, btcstaking.TimeLockPath},
+			{infos.Unbonding, btcstaking.UnbondingPath},
+			{infos.Slashing, btcstaking.SlashingPath},
+			{infos.UnbondingTimeLock, btcstaking.TimeLockPath},
+			{infos.UnbondingSlashing, btcstaking.SlashingPath},
+		}
+
+		for _, tt := range tests {
+			ctx, err := btcstaking.BuildSigningContext(tx, 0, prevOuts, tt.si)
+			require.NoError(t, err)
+
+			expectedSigHash, err := btcstaking.TaprootSigHash(tx, 0, prevOuts, tt.si, txscript.SigHashDefault)
+			require.NoError(t, err)
+
+			require.Equal(t, expectedSigHash, ctx.SigHash)
+			require.Equal(t, tt.path, ctx.Path)
+			require.Equal(t, tt.path.String(), ctx.PathName)
+			require.Equal(t, scenario.StakingAmount, ctx.Amount)
+			require.Equal(t, tx.TxOut, ctx.Destinations)
+			require.Equal(t, btcutil.Amount(1000), ctx.Fee)
+		}
+	}
+
+	_, err := btcstaking.SpendPathFromScript([]byte{txscript.OP_TRUE})
+	require.Error(t, err)
+}
+
+func TestDetectAmountBinding(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	oldAmount := scenario.StakingAmount
+	newAmount := scenario.StakingAmount - 500
+
+	fundingOutput := taprootOutputWithValue(t, r, oldAmount)
+	tx := createSpendStakeTx(newAmount - 1000)
+
+	sigHash, err := btcstaking.TaprootSigHash(tx, 0, []*wire.TxOut{fundingOutput}, infos.Unbonding, txscript.SigHashDefault)
+	require.NoError(t, err)
+	sig, err := schnorr.Sign(scenario.StakerKey, sigHash)
+	require.NoError(t, err)
+
+	require.False(t, btcstaking.DetectAmountBinding(infos.Unbonding, oldAmount, oldAmount))
+	require.NoError(t, btcstaking.CheckAmountBinding(infos.Unbonding, oldAmount, oldAmount))
+
+	// signature over the old amount does not verify against the new amount
+	fundingOutput.Value = int64(newAmount)
+	newSigHash, err := btcstaking.TaprootSigHash(tx, 0, []*wire.TxOut{fundingOutput}, infos.Unbonding, txscript.SigHashDefault)
+	require.NoError(t, err)
+	require.False(t, sig.Verify(newSigHash, scenario.StakerKey.PubKey()))
+
+	require.True(t, btcstaking.DetectAmountBinding(infos.Unbonding, oldAmount, newAmount))
+	require.ErrorIs(t,
+		btcstaking.CheckAmountBinding(infos.Unbonding, oldAmount, newAmount),
+		btcstaking.ErrResignRequired,
+	)
+
+	// missing spend info does not hide the changed amount
+	require.True(t, btcstaking.DetectAmountBinding(nil, oldAmount, newAmount))
+	err = btcstaking.CheckAmountBinding(nil, oldAmount, newAmount)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, btcstaking.ErrResignRequired)
+	require.Error(t, btcstaking.CheckAmountBinding(nil, oldAmount, oldAmount))
+}
+
+// multiInputSpendTx builds a transaction spending numInputs outputs through the
+// unbonding path, together with the spent outputs and the staker key
+func multiInputSpendTx(tb testing.TB, r *rand.Rand, numInputs int) (*wire.MsgTx, []*wire.TxOut, *btcstaking.SpendInfo, *btcec.PrivateKey) {
+	stakerKey, err := btcec.NewPrivateKey()
+	require.NoError(tb, err)
+	_, fpKeys, err := datagen.GenRandomBTCKeyPairs(r, 1)
+	require.NoError(tb, err)
+	_, covenantKeys, err := datagen.GenRandomBTCKeyPairs(r, 3)
+	require.NoError(tb, err)
+
+	infos, err := btcstaking.BuildAllSpendInfos(btcstaking.StakingParams{
+		StakerKey:            stakerKey.PubKey(),
+		FinalityProviderKeys: fpKeys,
+		CovenantKeys:         covenantKeys,
+		CovenantQuorum:       2,
+		StakingTime:          1000,
+		UnbondingTime:        100,
+		StakingAmount:        btcutil.Amount(2 * 10e8),
+	})
+	require.NoError(tb, err)
+
+	tx := createSpendStakeTx(btcutil.Amount(numInputs) * 10e8)
+	tx.TxIn = nil
+
+	prevOuts := make([]*wire.TxOut, numInputs)
+	for i := range prevOuts {
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: uint32(i)}, nil, nil))
+
+		pkScript := append([]byte{txscript.OP_1, txscript.OP_DATA_32}, datagen.GenRandomByteArray(r, 32)...)
+		prevOuts[i] = wire.NewTxOut(int64(2*10e8), pkScript)
+	}
+
+	return tx, prevOuts, infos.Unbonding, stakerKey
+}
+
+func TestSigHashForInput(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	tx, prevOuts, si, _ := multiInputSpendTx(t, r, 5)
+
+	cache, err := btcstaking.NewSigHashCache(tx, prevOuts)
+	require.NoError(t, err)
+
+	for i := range tx.TxIn {
+		for _, sigHashType := range []txscript.SigHashType{
+			txscript.SigHashDefault,
+			txscript.SigHashAll | txscript.SigHashAnyOneCanPay,
+		} {
+			expected, err := btcstaking.TaprootSigHash(tx, i, prevOuts, si, sigHashType)
+			require.NoError(t, err)
+
+			sigHash, err := btcstaking.SigHashForInput(cache, i, si, sigHashType)
+			require.NoError(t, err)
+			require.Equal(t, expected, sigHash)
+		}
+	}
+
+	_, err = btcstaking.SigHashForInput(cache, len(tx.TxIn), si, txscript.SigHashDefault)
+	require.Error(t, err)
+
+	_, err = btcstaking.NewSigHashCache(tx, prevOuts[1:])
+	require.Error(t, err)
+}
+
+func BenchmarkSign50InputsWithoutCache(b *testing.B) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	tx, prevOuts, si, key := multiInputSpendTx(b, r, 50)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for idx := range tx.TxIn {
+			sigHash, err := btcstaking.TaprootSigHash(tx, idx, prevOuts, si, txscript.SigHashDefault)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := schnorr.Sign(key, sigHash); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkSign50InputsWithCache(b *testing.B) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	tx, prevOuts, si, key := multiInputSpendTx(b, r, 50)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache, err := btcstaking.NewSigHashCache(tx, prevOuts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for idx := range tx.TxIn {
+			sigHash, err := btcstaking.SigHashForInput(cache, idx, si, txscript.SigHashDefault)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := schnorr.Sign(key, sigHash); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestCheckInputExpectations(t *testing.T) {
+	oneInput := createSpendStakeTx(btcutil.Amount(1000))
+	twoInputs := oneInput.Copy()
+	twoInputs.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+	noInputs := wire.NewMsgTx(2)
+
+	testCases := []struct {
+		name             string
+		tx               *wire.MsgTx
+		path             btcstaking.SpendPath
+		allowExtraInputs bool
+		expectErr        bool
+	}{
+		{"time lock single input", oneInput, btcstaking.TimeLockPath, false, false},
+		{"time lock extra input", twoInputs, btcstaking.TimeLockPath, false, true},
+		{"time lock extra input allowed", twoInputs, btcstaking.TimeLockPath, true, false},
+		{"time lock no inputs", noInputs, btcstaking.TimeLockPath, true, true},
+		{"unbonding single input", oneInput, btcstaking.UnbondingPath, false, false},
+		{"unbonding extra input", twoInputs, btcstaking.UnbondingPath, true, true},
+		{"slashing single input", oneInput, btcstaking.SlashingPath, false, false},
+		{"slashing extra input", twoInputs, btcstaking.SlashingPath, true, true},
+		{"unbonding slashing single input", oneInput, btcstaking.UnbondingSlashingPath, false, false},
+		{"unbonding slashing extra input", twoInputs, btcstaking.UnbondingSlashingPath, true, true},
+		{"unknown path", oneInput, btcstaking.SpendPath(100), false, true},
+		{"nil tx", nil, btcstaking.TimeLockPath, false, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := btcstaking.CheckInputExpectations(tc.tx, tc.path, tc.allowExtraInputs)
+			if tc.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	err := btcstaking.CheckInputExpectations(twoInputs, btcstaking.UnbondingPath, false)
+	require.ErrorIs(t, err, btcstaking.ErrUnbondingWrongInputCount)
+}
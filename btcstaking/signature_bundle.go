@@ -0,0 +1,80 @@
+package btcstaking
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// PathSigs contains signatures for a single spend path keyed by hex encoded
+// BIP340 public key of the signer. It can be passed directly to ReorderSigsForLeaf.
+type PathSigs map[string]*schnorr.Signature
+
+// BundledSignature is a signature together with the key which produced it
+type BundledSignature struct {
+	SignerPk  *btcec.PublicKey
+	Signature *schnorr.Signature
+}
+
+// SignatureBundle contains signatures produced for all paths at once, together
+// with the sighashes of every path. Sighash of a path not present in the bundle
+// can be left empty.
+type SignatureBundle struct {
+	TimeLockSigHash  []byte
+	UnbondingSigHash []byte
+	SlashingSigHash  []byte
+	Signatures       []BundledSignature
+}
+
+// SplitSigsByPath routes every signature of the bundle to the path whose
+// sighash it signs. It returns an error if any signature does not verify
+// against the sighash of any path.
+func SplitSigsByPath(bundle SignatureBundle) (timelock, unbonding, slashing PathSigs, err error) {
+	timelock, unbonding, slashing = PathSigs{}, PathSigs{}, PathSigs{}
+
+	paths := []struct {
+		sigHash []byte
+		sigs    PathSigs
+	}{
+		{bundle.TimeLockSigHash, timelock},
+		{bundle.UnbondingSigHash, unbonding},
+		{bundle.SlashingSigHash, slashing},
+	}
+
+	// sighashes must be distinct, otherwise signatures could not be routed
+	for i := range paths {
+		for j := i + 1; j < len(paths); j++ {
+			if len(paths[i].sigHash) > 0 && bytes.Equal(paths[i].sigHash, paths[j].sigHash) {
+				return nil, nil, nil, fmt.Errorf("sighashes of different paths must be distinct")
+			}
+		}
+	}
+
+	for i, s := range bundle.Signatures {
+		if s.SignerPk == nil || s.Signature == nil {
+			return nil, nil, nil, fmt.Errorf("signature %d has nil signer or signature", i)
+		}
+
+		routed := false
+
+		for _, p := range paths {
+			if len(p.sigHash) == 0 || !s.Signature.Verify(p.sigHash, s.SignerPk) {
+				continue
+			}
+
+			p.sigs[keyToString(s.SignerPk)] = s.Signature
+			routed = true
+			break
+		}
+
+		if !routed {
+			return nil, nil, nil, fmt.Errorf(
+				"signature %d of key %s does not sign any path sighash", i, keyToString(s.SignerPk),
+			)
+		}
+	}
+
+	return timelock, unbonding, slashing, nil
+}
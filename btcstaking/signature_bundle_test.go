@@ -0,0 +1,104 @@
+package btcstaking_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	btctest "github.com/babylonlabs-io/babylon/testutil/bitcoin"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitSigsByPathRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 5)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	prevOuts := []*wire.TxOut{stakingInfo.StakingOutput}
+
+	timeLockTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.9))
+	timeLockTx.TxIn[0].Sequence = uint32(scenario.StakingTime)
+	unbondingTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.8))
+	slashingTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.7))
+
+	paths := []struct {
+		tx      *wire.MsgTx
+		si      *btcstaking.SpendInfo
+		signers []*btcec.PrivateKey
+		sigHash []byte
+	}{
+		{timeLockTx, infos.TimeLock, []*btcec.PrivateKey{scenario.StakerKey}, nil},
+		{unbondingTx, infos.Unbonding, []*btcec.PrivateKey{scenario.StakerKey, scenario.CovenantKeys[0], scenario.CovenantKeys[1]}, nil},
+		{slashingTx, infos.Slashing, []*btcec.PrivateKey{scenario.StakerKey, scenario.FinalityProviderKeys[0], scenario.CovenantKeys[1], scenario.CovenantKeys[2]}, nil},
+	}
+
+	bundle := btcstaking.SignatureBundle{}
+	for i := range paths {
+		sigHash, err := btcstaking.TaprootSigHash(paths[i].tx, 0, prevOuts, paths[i].si, txscript.SigHashDefault)
+		require.NoError(t, err)
+		paths[i].sigHash = sigHash
+
+		for _, key := range paths[i].signers {
+			sig, err := schnorr.Sign(key, sigHash)
+			require.NoError(t, err)
+			bundle.Signatures = append(bundle.Signatures, btcstaking.BundledSignature{
+				SignerPk:  key.PubKey(),
+				Signature: sig,
+			})
+		}
+	}
+	bundle.TimeLockSigHash = paths[0].sigHash
+	bundle.UnbondingSigHash = paths[1].sigHash
+	bundle.SlashingSigHash = paths[2].sigHash
+
+	// shuffle bundle, so routing can't depend on signatures order
+	r.Shuffle(len(bundle.Signatures), func(i, j int) {
+		bundle.Signatures[i], bundle.Signatures[j] = bundle.Signatures[j], bundle.Signatures[i]
+	})
+
+	timeLockSigs, unbondingSigs, slashingSigs, err := btcstaking.SplitSigsByPath(bundle)
+	require.NoError(t, err)
+	require.Len(t, timeLockSigs, 1)
+	require.Len(t, unbondingSigs, 3)
+	require.Len(t, slashingSigs, 4)
+
+	for i, pathSigs := range []btcstaking.PathSigs{timeLockSigs, unbondingSigs, slashingSigs} {
+		orderedSigs, err := btcstaking.ReorderSigsForLeaf(pathSigs, paths[i].si.GetPkScriptPath())
+		require.NoError(t, err)
+		witness, err := btcstaking.CreateWitness(paths[i].si, orderedSigs)
+		require.NoError(t, err)
+		paths[i].tx.TxIn[0].Witness = witness
+
+		btctest.AssertSlashingTxExecution(t, stakingInfo.StakingOutput, paths[i].tx)
+	}
+
+	// signature which does not sign any of the sighashes can't be routed
+	foreignSig, err := schnorr.Sign(scenario.StakerKey, make([]byte, 32))
+	require.NoError(t, err)
+	bundle.Signatures = append(bundle.Signatures, btcstaking.BundledSignature{
+		SignerPk:  scenario.StakerKey.PubKey(),
+		Signature: foreignSig,
+	})
+	_, _, _, err = btcstaking.SplitSigsByPath(bundle)
+	require.Error(t, err)
+}
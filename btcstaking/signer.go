@@ -0,0 +1,121 @@
+package btcstaking
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Signer produces BIP340 signatures over tapscript sighashes. It abstracts
+// away where the private key lives, e.g. in memory, in a remote signer or in
+// a hardware wallet.
+type Signer interface {
+	// PubKey returns the public key of the signer
+	PubKey() *btcec.PublicKey
+	// Sign signs the given sighash
+	Sign(sigHash []byte) (*schnorr.Signature, error)
+}
+
+type privateKeySigner struct {
+	privKey *btcec.PrivateKey
+}
+
+var _ Signer = (*privateKeySigner)(nil)
+
+// NewPrivateKeySigner creates Signer holding the given private key in memory
+func NewPrivateKeySigner(privKey *btcec.PrivateKey) (Signer, error) {
+	if privKey == nil {
+		return nil, fmt.Errorf("private key must not be nil")
+	}
+
+	return &privateKeySigner{privKey: privKey}, nil
+}
+
+func (s *privateKeySigner) PubKey() *btcec.PublicKey {
+	return s.privKey.PubKey()
+}
+
+func (s *privateKeySigner) Sign(sigHash []byte) (*schnorr.Signature, error) {
+	return schnorr.Sign(s.privKey, sigHash)
+}
+
+// SignSlashingAsCovenant signs the slashing tx spending the slashing leaf of
+// the given spend info on behalf of the covenant member holding the signer key.
+// Before signing, the slashing tx is checked against the slashing policy with
+// VerifySlashingTx, so a compromised coordinator can't make the member sign a
+// slashing tx which redirects the staker's funds. The slashing tx must have
+// a single input spending the output committing to the slashing leaf, which is
+// the only element of prevOuts.
+func SignSlashingAsCovenant(
+	signer Signer,
+	slashingTx *wire.MsgTx,
+	si *SpendInfo,
+	prevOuts []*wire.TxOut,
+	policy SlashingPolicy,
+) (*schnorr.Signature, error) {
+	if signer == nil {
+		return nil, fmt.Errorf("signer must not be nil")
+	}
+
+	if slashingTx == nil {
+		return nil, fmt.Errorf("slashing tx must not be nil")
+	}
+
+	if si == nil {
+		return nil, fmt.Errorf("spend info must not be nil")
+	}
+
+	path, err := SpendPathFromScript(si.GetPkScriptPath())
+	if err != nil {
+		return nil, err
+	}
+
+	if path != SlashingPath {
+		return nil, fmt.Errorf("%w: expected %s leaf, got %s", ErrUnexpectedLeaf, SlashingPath, path)
+	}
+
+	if err := CheckInputExpectations(slashingTx, SlashingPath, false); err != nil {
+		return nil, err
+	}
+
+	if len(prevOuts) != 1 || prevOuts[0] == nil {
+		return nil, fmt.Errorf("slashing tx must spend exactly one previous output")
+	}
+
+	if err := VerifyScriptInclusion(si, prevOuts[0].PkScript); err != nil {
+		return nil, err
+	}
+
+	signers, err := RequiredSigners(si.RevealedLeaf.Script)
+	if err != nil {
+		return nil, err
+	}
+
+	signerKey := schnorr.SerializePubKey(signer.PubKey())
+	isMember := false
+	for _, covenantKey := range signers.CovenantKeys {
+		if bytes.Equal(schnorr.SerializePubKey(covenantKey), signerKey) {
+			isMember = true
+			break
+		}
+	}
+
+	if !isMember {
+		return nil, fmt.Errorf("%w: signer is not a member of the covenant committee", ErrWrongSigningKey)
+	}
+
+	if err := VerifySlashingTx(slashingTx, prevOuts[0].Value, signers.StakerKey, policy); err != nil {
+		return nil, fmt.Errorf("invalid slashing tx: %w", err)
+	}
+
+	sigHash, err := TaprootSigHash(slashingTx, 0, prevOuts, si, txscript.SigHashDefault)
+	if err != nil {
+		return nil, err
+	}
+
+	return signer.Sign(sigHash)
+}
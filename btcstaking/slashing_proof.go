@@ -0,0 +1,307 @@
+package btcstaking
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// maxSlashingProofElementSize bounds the size of every variable length element
+// of the serialized slashing proof, the same way consensus bounds the size of
+// witness elements
+const maxSlashingProofElementSize = txscript.MaxScriptSize
+
+// SlashingProof proves that a finality provider signed the slashing path of a
+// staking output. It contains only the witness elements required to check the
+// signature, so light clients can verify it without the whole slashing tx.
+type SlashingProof struct {
+	// FinalityProviderKey is the key of the finality provider which signed
+	// the slashing tx
+	FinalityProviderKey *btcec.PublicKey
+	// FinalityProviderSig is the signature of the finality provider as it
+	// appears in the witness, optionally followed by the sighash type
+	FinalityProviderSig []byte
+	// Script is the revealed slashing path script
+	Script []byte
+	// ControlBlock is the serialized control block proving the script is
+	// committed in the staking output
+	ControlBlock []byte
+}
+
+// BuildSlashingProof builds the slashing proof from the witness of the input of
+// the slashing tx which spends the slashing path described by the given spend
+// info. Exactly one finality provider signature slot of the witness must be
+// filled.
+func BuildSlashingProof(slashingTx *wire.MsgTx, si *SpendInfo) (*SlashingProof, error) {
+	if slashingTx == nil {
+		return nil, fmt.Errorf("slashing tx must not be nil")
+	}
+
+	if si == nil {
+		return nil, fmt.Errorf("spend info must not be nil")
+	}
+
+	script := si.GetPkScriptPath()
+
+	var parsed *ParsedWitness
+	for _, in := range slashingTx.TxIn {
+		p, err := ParseWitness(in.Witness)
+		if err != nil {
+			continue
+		}
+
+		if bytes.Equal(p.Script, script) {
+			parsed = p
+			break
+		}
+	}
+
+	if parsed == nil {
+		return nil, fmt.Errorf("slashing tx does not spend the slashing path")
+	}
+
+	fpKey, fpSig, err := signingFinalityProvider(parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SlashingProof{
+		FinalityProviderKey: fpKey,
+		FinalityProviderSig: fpSig,
+		Script:              script,
+		ControlBlock:        parsed.ControlBlock,
+	}, nil
+}
+
+// signingFinalityProvider returns the key and the signature of the finality
+// provider which signed the slashing path spent by the parsed witness. Exactly
+// one finality provider signature slot of the witness must be filled.
+func signingFinalityProvider(parsed *ParsedWitness) (*btcec.PublicKey, []byte, error) {
+	keys, err := ExtractScriptPubKeys(parsed.Script)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(parsed.Signatures) != len(keys) {
+		return nil, nil, fmt.Errorf("witness has %d signatures, script has %d keys", len(parsed.Signatures), len(keys))
+	}
+
+	segments, err := countKeysPerSegment(parsed.Script)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// slashing script has staker, finality providers and covenant segments
+	if len(segments) != 3 {
+		return nil, nil, fmt.Errorf("witness does not spend slashing path")
+	}
+
+	var (
+		fpKey *btcec.PublicKey
+		fpSig []byte
+	)
+
+	// finality provider keys follow the staker key in the script, and signatures
+	// are in the reverse order of keys in the witness
+	for i := segments[0]; i < segments[0]+segments[1]; i++ {
+		sig := parsed.Signatures[len(keys)-1-i]
+		if len(sig) == 0 {
+			continue
+		}
+
+		if fpKey != nil {
+			return nil, nil, fmt.Errorf("witness has more than one finality provider signature")
+		}
+
+		fpKey = keys[i]
+		fpSig = sig
+	}
+
+	if fpKey == nil {
+		return nil, nil, fmt.Errorf("witness does not have finality provider signature")
+	}
+
+	return fpKey, fpSig, nil
+}
+
+// VerifyRestakingSlashingTx checks the slashing tx of a restaked delegation
+// against the witness spending its slashing path:
+// - exactly one finality provider signature slot of the witness is filled, and
+// the key of the signing finality provider is one of delegationFps
+// - the slashing tx passes pre-signed slashing tx sanity checks, with single
+// output allowed for burn-all slashing
+// - all outputs have positive value and none of them is dust
+func VerifyRestakingSlashingTx(
+	slashingTx *wire.MsgTx,
+	delegationFps []*btcec.PublicKey,
+	witness wire.TxWitness,
+) error {
+	if slashingTx == nil {
+		return fmt.Errorf("slashing tx must not be nil")
+	}
+
+	if len(delegationFps) == 0 {
+		return fmt.Errorf("delegation must have at least one finality provider")
+	}
+
+	parsed, err := ParseWitness(witness)
+	if err != nil {
+		return err
+	}
+
+	fpKey, _, err := signingFinalityProvider(parsed)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, key := range delegationFps {
+		if key != nil && keyToString(key) == keyToString(fpKey) {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("signing finality provider %s is not a finality provider of the delegation", keyToString(fpKey))
+	}
+
+	if len(slashingTx.TxOut) == 1 {
+		err = CheckPreSignedTxSanity(slashingTx, 1, 1, 1, MaxTxVersion)
+	} else {
+		err = CheckPreSignedSlashingTxSanity(slashingTx)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid slashing tx: %w", err)
+	}
+
+	for i, out := range slashingTx.TxOut {
+		if out.Value <= 0 {
+			return fmt.Errorf("slashing tx output %d must have positive value", i)
+		}
+
+		if mempool.IsDust(out, mempool.DefaultMinRelayTxFee) {
+			return fmt.Errorf("slashing tx output %d: %w", i, ErrDustOutputFound)
+		}
+	}
+
+	return nil
+}
+
+// Verify checks that the proof script is committed in the given staking output
+// pk script, that the finality provider key is one of the finality provider keys
+// of the script, and that the signature of the finality provider signs the given
+// sighash of the slashing tx.
+func (p *SlashingProof) Verify(stakingPkScript []byte, sigHash []byte) error {
+	if p.FinalityProviderKey == nil {
+		return fmt.Errorf("finality provider key must not be nil")
+	}
+
+	if !txscript.IsPayToTaproot(stakingPkScript) {
+		return fmt.Errorf("staking pk script must be pay to taproot")
+	}
+
+	cb, err := parseControlBlock(p.ControlBlock)
+	if err != nil {
+		return fmt.Errorf("invalid control block: %w", err)
+	}
+
+	if err := txscript.VerifyTaprootLeafCommitment(cb, stakingPkScript[2:], p.Script); err != nil {
+		return fmt.Errorf("%w: %w", ErrScriptNotCommitted, err)
+	}
+
+	keys, err := ExtractScriptPubKeys(p.Script)
+	if err != nil {
+		return err
+	}
+
+	segments, err := countKeysPerSegment(p.Script)
+	if err != nil {
+		return err
+	}
+
+	if len(segments) != 3 {
+		return fmt.Errorf("script is not a slashing path script")
+	}
+
+	found := false
+	for _, key := range keys[segments[0] : segments[0]+segments[1]] {
+		if keyToString(key) == keyToString(p.FinalityProviderKey) {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("key %s is not a finality provider key of the script", keyToString(p.FinalityProviderKey))
+	}
+
+	sig, _, err := splitWitnessSig(p.FinalityProviderSig)
+	if err != nil {
+		return err
+	}
+
+	if !sig.Verify(sigHash, p.FinalityProviderKey) {
+		return fmt.Errorf("invalid finality provider signature")
+	}
+
+	return nil
+}
+
+// Serialize serializes the proof as the BIP340 finality provider key followed by
+// the signature, the script and the control block, each prefixed with its length
+func (p *SlashingProof) Serialize() ([]byte, error) {
+	if p.FinalityProviderKey == nil {
+		return nil, fmt.Errorf("finality provider key must not be nil")
+	}
+
+	var buf bytes.Buffer
+
+	buf.Write(schnorr.SerializePubKey(p.FinalityProviderKey))
+
+	for _, element := range [][]byte{p.FinalityProviderSig, p.Script, p.ControlBlock} {
+		if err := wire.WriteVarBytes(&buf, 0, element); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ParseSlashingProof parses the proof serialized with SlashingProof.Serialize
+func ParseSlashingProof(b []byte) (*SlashingProof, error) {
+	if len(b) < schnorr.PubKeyBytesLen {
+		return nil, fmt.Errorf("serialized slashing proof is too short")
+	}
+
+	key, err := schnorr.ParsePubKey(b[:schnorr.PubKeyBytesLen])
+	if err != nil {
+		return nil, fmt.Errorf("invalid finality provider key: %w", err)
+	}
+
+	r := bytes.NewReader(b[schnorr.PubKeyBytesLen:])
+
+	var elements [3][]byte
+	for i := range elements {
+		elements[i], err = wire.ReadVarBytes(r, 0, maxSlashingProofElementSize, "slashing proof element")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("serialized slashing proof has %d trailing bytes", r.Len())
+	}
+
+	return &SlashingProof{
+		FinalityProviderKey: key,
+		FinalityProviderSig: elements[0],
+		Script:              elements[1],
+		ControlBlock:        elements[2],
+	}, nil
+}
@@ -0,0 +1,189 @@
+package btcstaking_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	btctest "github.com/babylonlabs-io/babylon/testutil/bitcoin"
+	"github.com/babylonlabs-io/babylon/testutil/datagen"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildSlashingProof(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 3, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	si, err := stakingInfo.SlashingPathSpendInfo()
+	require.NoError(t, err)
+
+	slashingTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+	slashingFpKey := scenario.FinalityProviderKeys[1]
+
+	sigs := signLeafWithKeys(
+		t, slashingTx, stakingInfo.StakingOutput, si,
+		scenario.StakerKey,
+		slashingFpKey,
+		scenario.CovenantKeys[0], scenario.CovenantKeys[2],
+	)
+	orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+	require.NoError(t, err)
+	slashingTx.TxIn[0].Witness, err = btcstaking.CreateWitness(si, orderedSigs)
+	require.NoError(t, err)
+
+	proof, err := btcstaking.BuildSlashingProof(slashingTx, si)
+	require.NoError(t, err)
+	require.Equal(t,
+		schnorr.SerializePubKey(slashingFpKey.PubKey()),
+		schnorr.SerializePubKey(proof.FinalityProviderKey),
+	)
+
+	sigHash, err := btcstaking.TaprootSigHash(
+		slashingTx, 0, []*wire.TxOut{stakingInfo.StakingOutput}, si, txscript.SigHashDefault,
+	)
+	require.NoError(t, err)
+	require.NoError(t, proof.Verify(stakingInfo.GetPkScript(), sigHash))
+
+	// serialization round trip
+	serialized, err := proof.Serialize()
+	require.NoError(t, err)
+	parsed, err := btcstaking.ParseSlashingProof(serialized)
+	require.NoError(t, err)
+	require.Equal(t, proof.Script, parsed.Script)
+	require.Equal(t, proof.ControlBlock, parsed.ControlBlock)
+	require.Equal(t, proof.FinalityProviderSig, parsed.FinalityProviderSig)
+	require.NoError(t, parsed.Verify(stakingInfo.GetPkScript(), sigHash))
+
+	_, err = btcstaking.ParseSlashingProof(append(serialized, 0x00))
+	require.Error(t, err)
+	_, err = btcstaking.ParseSlashingProof(serialized[:len(serialized)-1])
+	require.Error(t, err)
+
+	// proof must not verify against other output or other sighash
+	require.ErrorIs(t, proof.Verify(taprootOutputWithValue(t, r, 10000).PkScript, sigHash), btcstaking.ErrScriptNotCommitted)
+	otherSigHash := append([]byte{}, sigHash...)
+	otherSigHash[0] ^= 0x01
+	require.Error(t, proof.Verify(stakingInfo.GetPkScript(), otherSigHash))
+
+	// key which is not a finality provider key of the script
+	parsed.FinalityProviderKey = scenario.StakerKey.PubKey()
+	require.Error(t, parsed.Verify(stakingInfo.GetPkScript(), sigHash))
+
+	// witness must contain exactly one finality provider signature
+	sigs = signLeafWithKeys(
+		t, slashingTx, stakingInfo.StakingOutput, si,
+		scenario.StakerKey,
+		scenario.CovenantKeys[0], scenario.CovenantKeys[2],
+	)
+	orderedSigs, err = btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+	require.NoError(t, err)
+	slashingTx.TxIn[0].Witness, err = btcstaking.CreateWitness(si, orderedSigs)
+	require.NoError(t, err)
+	_, err = btcstaking.BuildSlashingProof(slashingTx, si)
+	require.Error(t, err)
+
+	// slashing tx must spend the slashing path
+	unbondingSi, err := stakingInfo.UnbondingPathSpendInfo()
+	require.NoError(t, err)
+	_, err = btcstaking.BuildSlashingProof(slashingTx, unbondingSi)
+	require.Error(t, err)
+}
+
+func TestVerifyRestakingSlashingTx(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 3, 3, 2, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+
+	stakingOutput, err := btcstaking.StakingOutputScript(params)
+	require.NoError(t, err)
+	stakingTx := wire.NewMsgTx(2)
+	stakingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	stakingTx.AddTxOut(wire.NewTxOut(int64(params.StakingAmount), stakingOutput))
+
+	slashingAddress, err := genRandomBTCAddress(r)
+	require.NoError(t, err)
+	slashingPkScript, err := txscript.PayToAddrScript(slashingAddress)
+	require.NoError(t, err)
+
+	changePkScript, _, err := btcstaking.BuildSlashingChangeScript(
+		params.StakerKey, params.FinalityProviderKeys, params.CovenantKeys, 100,
+	)
+	require.NoError(t, err)
+
+	buildSignedSlashingTx := func(rate float64, fpKeys ...*btcec.PrivateKey) *wire.MsgTx {
+		// slashing tx burning the whole stake has no change output
+		change := changePkScript
+		if rate == 1 {
+			change = nil
+		}
+		slashingTx, si, err := btcstaking.BuildSlashingTxFromStaking(
+			stakingTx, 0, params, rate, slashingPkScript, change, 100, btcstaking.SatPerKWeight(2500),
+		)
+		require.NoError(t, err)
+
+		keys := append([]*btcec.PrivateKey{scenario.StakerKey, scenario.CovenantKeys[0], scenario.CovenantKeys[1]}, fpKeys...)
+		sigs := signLeafWithKeys(t, slashingTx, stakingTx.TxOut[0], si, keys...)
+		orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+		require.NoError(t, err)
+		slashingTx.TxIn[0].Witness, err = btcstaking.CreateWitness(si, orderedSigs)
+		require.NoError(t, err)
+		if len(fpKeys) == 1 {
+			btctest.AssertSlashingTxExecution(t, stakingTx.TxOut[0], slashingTx)
+		}
+
+		return slashingTx
+	}
+
+	delegationFps := scenario.FinalityProviderPublicKeys()
+
+	for _, rate := range []float64{0.1, 1} {
+		slashingTx := buildSignedSlashingTx(rate, scenario.FinalityProviderKeys[2])
+		require.NoError(t, btcstaking.VerifyRestakingSlashingTx(slashingTx, delegationFps, slashingTx.TxIn[0].Witness))
+
+		// signing finality provider must belong to the delegation
+		err = btcstaking.VerifyRestakingSlashingTx(slashingTx, delegationFps[:2], slashingTx.TxIn[0].Witness)
+		require.Error(t, err)
+	}
+
+	// exactly one finality provider signature is required
+	slashingTx := buildSignedSlashingTx(0.1)
+	require.Error(t, btcstaking.VerifyRestakingSlashingTx(slashingTx, delegationFps, slashingTx.TxIn[0].Witness))
+
+	// witness of other path
+	slashingTx = buildSignedSlashingTx(0.1, scenario.FinalityProviderKeys[0])
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+	timeLockWitness, err := btcstaking.CreateWitness(infos.TimeLock, [][]byte{datagen.GenRandomByteArray(r, 64)})
+	require.NoError(t, err)
+	require.Error(t, btcstaking.VerifyRestakingSlashingTx(slashingTx, delegationFps, timeLockWitness))
+
+	// output amounts
+	witness := slashingTx.TxIn[0].Witness
+	dustTx := slashingTx.Copy()
+	dustTx.TxOut[1].Value = 1
+	require.ErrorIs(t, btcstaking.VerifyRestakingSlashingTx(dustTx, delegationFps, witness), btcstaking.ErrDustOutputFound)
+	zeroTx := slashingTx.Copy()
+	zeroTx.TxOut[0].Value = 0
+	require.Error(t, btcstaking.VerifyRestakingSlashingTx(zeroTx, delegationFps, witness))
+	extraOutputTx := slashingTx.Copy()
+	extraOutputTx.AddTxOut(slashingTx.TxOut[0])
+	require.Error(t, btcstaking.VerifyRestakingSlashingTx(extraOutputTx, delegationFps, witness))
+}
@@ -0,0 +1,108 @@
+package btcstaking
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// SpendPath identifies the script path through which a Babylon output is spent
+type SpendPath int
+
+const (
+	// TimeLockPath is the path spendable by the staker after the time lock
+	TimeLockPath SpendPath = iota
+	// UnbondingPath is the path spendable by the staker with covenant cooperation
+	UnbondingPath
+	// SlashingPath is the path spendable by the staker with finality provider
+	// and covenant cooperation
+	SlashingPath
+	// UnbondingSlashingPath is the slashing path of the unbonding output. Its
+	// leaf is committed in the unbonding output, so it is spent with a different
+	// control block than the slashing path of the staking output.
+	UnbondingSlashingPath
+	// RecoveryPath is the disaster recovery path spendable by the staker alone
+	// after a time lock longer than the staking time. Its leaf has the shape of
+	// the time lock leaf, so it is only committed in outputs built with
+	// BuildSpendInfosWithRecovery.
+	RecoveryPath
+)
+
+func (p SpendPath) String() string {
+	switch p {
+	case TimeLockPath:
+		return "timelock"
+	case UnbondingPath:
+		return "unbonding"
+	case SlashingPath:
+		return "slashing"
+	case UnbondingSlashingPath:
+		return "unbonding slashing"
+	case RecoveryPath:
+		return "recovery"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(p))
+	}
+}
+
+// SpendPathFromScript determines the spend path of the given leaf script by
+// matching it against the templates of DefaultScriptTemplateRegistry. With the
+// default templates, time lock and slashing leaves of the unbonding output are
+// recognized as time lock and slashing paths, as leaf scripts alone do not tell
+// which output they are committed in. For the same reason, the recovery leaf is
+// recognized as time lock path.
+func SpendPathFromScript(script []byte) (SpendPath, error) {
+	tmpl, err := DefaultScriptTemplateRegistry.MatchScript(script)
+	if err != nil {
+		return 0, err
+	}
+
+	return tmpl.Path, nil
+}
+
+// babylonScriptPath determines the spend path of the given Babylon leaf script
+// from its structure:
+// - time lock script ends with OP_CHECKSEQUENCEVERIFY
+// - unbonding script requires staker and covenant signatures
+// - slashing script requires staker, finality provider and covenant signatures
+func babylonScriptPath(script []byte) (SpendPath, error) {
+	var (
+		checks     int
+		isTimeLock bool
+		lastOpcode byte
+	)
+
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	for tokenizer.Next() {
+		lastOpcode = tokenizer.Opcode()
+
+		switch lastOpcode {
+		case txscript.OP_CHECKSEQUENCEVERIFY:
+			isTimeLock = true
+		// every signature check ends with one of these opcodes, or with
+		// OP_CHECKSIG when it is the last single key check. OP_CHECKSIG is also
+		// used inside the multisig scripts, so it is only counted below.
+		case txscript.OP_CHECKSIGVERIFY, txscript.OP_NUMEQUALVERIFY, txscript.OP_NUMEQUAL:
+			checks++
+		}
+	}
+
+	if err := tokenizer.Err(); err != nil {
+		return 0, fmt.Errorf("failed to parse script: %w", err)
+	}
+
+	if lastOpcode == txscript.OP_CHECKSIG {
+		checks++
+	}
+
+	switch {
+	case isTimeLock && checks == 1:
+		return TimeLockPath, nil
+	case !isTimeLock && checks == 2:
+		return UnbondingPath, nil
+	case !isTimeLock && checks == 3:
+		return SlashingPath, nil
+	default:
+		return 0, fmt.Errorf("script is not a babylon leaf script")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"strconv"
 
 	sdkmath "cosmossdk.io/math"
 	asig "github.com/babylonlabs-io/babylon/crypto/schnorr-adaptor-signature"
@@ -23,6 +24,10 @@ const (
 	// to support v3 transactions.
 	MaxTxVersion = 2
 
+	// UnbondingValueTolerance is the maximum difference between the expected and
+	// the actual value of the unbonding output accepted by VerifyUnbondingTx
+	UnbondingValueTolerance = btcutil.Amount(1000)
+
 	MaxStandardTxWeight = 400000
 )
 
@@ -35,17 +40,24 @@ const (
 //   - stakingAmount: The amount of staked funds in the staking output.
 //   - fee: The transaction fee to be paid.
 //   - slashingAddress: The Bitcoin address to which the slashed funds will be sent.
-//   - changeAddress: The Bitcoin address to receive the change from the transaction.
+//   - changePkScript: The pk script of the output receiving the change from the transaction.
 //   - slashingRate: The rate at which the staked funds will be slashed, expressed as a decimal.
 //
 // Returns:
 //   - *wire.MsgTx: The constructed slashing transaction without a script signature or witness.
 //   - error: An error if any validation or construction step fails.
+//
+// If the slashing rate is 1, the whole staking amount minus the fee is slashed and
+// the transaction has no change output, so changePkScript is ignored. Only
+// BuildSlashingTxFromStaking builds such transactions, callers building slashing
+// transactions checked by Babylon chain reject the rate of 1 before.
+// Change output is never absorbed into the fee: if the change is dust,
+// ErrDustOutputFound is returned.
 func buildSlashingTxFromOutpoint(
 	stakingOutput wire.OutPoint,
 	stakingAmount, fee int64,
 	slashingPkScript []byte,
-	changeAddress btcutil.Address,
+	changePkScript []byte,
 	slashingRate sdkmath.LegacyDec,
 ) (*wire.MsgTx, error) {
 	// Validate staking amount
@@ -54,7 +66,7 @@ func buildSlashingTxFromOutpoint(
 	}
 
 	// Validate slashing rate
-	if !IsSlashingRateValid(slashingRate) {
+	if !isSlashingTxRateValid(slashingRate) {
 		return nil, ErrInvalidSlashingRate
 	}
 
@@ -62,6 +74,28 @@ func buildSlashingTxFromOutpoint(
 		return nil, fmt.Errorf("slashing pk script must not be empty")
 	}
 
+	// Create a new btc transaction
+	tx := wire.NewMsgTx(wire.TxVersion)
+	// TODO: this builds input with sequence number equal to MaxTxInSequenceNum, which
+	// means this tx is not replaceable.
+	input := wire.NewTxIn(&stakingOutput, nil, nil)
+	tx.AddTxIn(input)
+
+	if IsBurnAllSlashingRate(slashingRate) {
+		slashingAmount := btcutil.Amount(stakingAmount) - btcutil.Amount(fee)
+		if slashingAmount <= 0 {
+			return nil, ErrInsufficientSlashingAmount
+		}
+
+		slashingOut := wire.NewTxOut(int64(slashingAmount), slashingPkScript)
+		if mempool.IsDust(slashingOut, mempool.DefaultMinRelayTxFee) {
+			return nil, ErrDustOutputFound
+		}
+		tx.AddTxOut(slashingOut)
+
+		return tx, nil
+	}
+
 	// Calculate the amount to be slashed
 	slashingRateFloat64, err := slashingRate.Float64()
 	if err != nil {
@@ -77,20 +111,12 @@ func buildSlashingTxFromOutpoint(
 	if changeAmount <= 0 {
 		return nil, ErrInsufficientChangeAmount
 	}
-	// Generate script for change address
-	changeAddrScript, err := txscript.PayToAddrScript(changeAddress)
-	if err != nil {
-		return nil, err
+	if len(changePkScript) == 0 {
+		return nil, fmt.Errorf("change pk script must not be empty")
 	}
 
-	// Create a new btc transaction
-	tx := wire.NewMsgTx(wire.TxVersion)
-	// TODO: this builds input with sequence number equal to MaxTxInSequenceNum, which
-	// means this tx is not replaceable.
-	input := wire.NewTxIn(&stakingOutput, nil, nil)
-	tx.AddTxIn(input)
 	tx.AddTxOut(wire.NewTxOut(int64(slashingAmount), slashingPkScript))
-	tx.AddTxOut(wire.NewTxOut(int64(changeAmount), changeAddrScript))
+	tx.AddTxOut(wire.NewTxOut(int64(changeAmount), changePkScript))
 
 	// Verify that the none of the outputs is a dust output.
 	for _, out := range tx.TxOut {
@@ -145,6 +171,8 @@ func getPossibleStakingOutput(
 // This function validates the same conditions as BuildSlashingTxFromStakingTx and additionally checks whether the
 // staking output at the specified index commits to the provided script and whether the provided script is a valid
 // staking script for the given network. If any of these additional validations fail, an error is returned.
+// As slashing transactions built by it are checked by CheckSlashingTxMatchFundingTx, the slashing rate must be
+// in the range (0,1), i.e it never builds transactions burning the whole stake.
 func BuildSlashingTxFromStakingTxStrict(
 	stakingTx *wire.MsgTx,
 	stakingOutputIdx uint32,
@@ -155,6 +183,11 @@ func BuildSlashingTxFromStakingTxStrict(
 	slashingRate sdkmath.LegacyDec,
 	net *chaincfg.Params,
 ) (*wire.MsgTx, error) {
+	// Validate slashing rate, same as Babylon chain does
+	if !IsSlashingRateValid(slashingRate) {
+		return nil, ErrInvalidSlashingRate
+	}
+
 	// Get the staking output at the specified index from the staking transaction
 	stakingOutput, err := getPossibleStakingOutput(stakingTx, stakingOutputIdx)
 	if err != nil {
@@ -180,10 +213,240 @@ func BuildSlashingTxFromStakingTxStrict(
 	return buildSlashingTxFromOutpoint(
 		*stakingOutpoint,
 		stakingOutput.Value, fee,
-		slashingPkScript, si.TapAddress,
+		slashingPkScript, si.PkScript,
 		slashingRate)
 }
 
+// BuildSlashingChangeScript builds the pk script of the slashing tx change
+// output, which returns the non-slashed funds to the staker after the change
+// time lock, together with the spend info required to spend it. Finality
+// provider and covenant keys are not committed in the change output, they are
+// only used to check that the staker key is distinct from them, as required for
+// the staking output.
+func BuildSlashingChangeScript(
+	stakerPk *btcec.PublicKey,
+	fps []*btcec.PublicKey,
+	covenant []*btcec.PublicKey,
+	changeTimelock uint16,
+) ([]byte, *SpendInfo, error) {
+	if stakerPk == nil {
+		return nil, nil, fmt.Errorf("staker key is nil")
+	}
+
+	if err := checkForDuplicateKeys(stakerPk, fps, covenant); err != nil {
+		return nil, nil, err
+	}
+
+	// network is only used to derive the address, and does not influence
+	// the pk script
+	changeInfo, err := BuildRelativeTimelockTaprootScript(
+		stakerPk,
+		changeTimelock,
+		&chaincfg.MainNetParams,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return changeInfo.PkScript, changeInfo.SpendInfo, nil
+}
+
+// BuildSlashingTxFromStaking builds the slashing transaction spending the staking
+// output with the given index, together with the spend info required to build
+// the slashing path witness. The staking output must commit to the scripts
+// built from the given params, which are also required to derive the spend
+// info, as it can't be recovered from the staking tx alone.
+// Slashed funds are sent to slashingPkScript, while the change is sent to
+// changePkScript, which must be the pk script returning the change to the
+// staker after slashingTime blocks, as built by BuildSlashingChangeScript. The
+// fee is computed from the fee rate and the weight of the slashing transaction
+// spent through the slashing path with the minimal number of signatures.
+// Slashing rate of 1 burns the whole stake, and the transaction has no change
+// output, so changePkScript must be empty.
+func BuildSlashingTxFromStaking(
+	stakingTx *wire.MsgTx,
+	stakingOutputIdx int,
+	params StakingParams,
+	slashingRate float64,
+	slashingPkScript, changePkScript []byte,
+	slashingTime uint16,
+	feeRate SatPerKWeight,
+) (*wire.MsgTx, *SpendInfo, error) {
+	if slashingRate <= 0 || slashingRate > 1 {
+		return nil, nil, ErrInvalidSlashingRate
+	}
+
+	rate, err := sdkmath.LegacyNewDecFromStr(strconv.FormatFloat(slashingRate, 'f', -1, 64))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidSlashingRate, err)
+	}
+
+	if stakingOutputIdx < 0 {
+		return nil, nil, fmt.Errorf("invalid staking output index %d", stakingOutputIdx)
+	}
+
+	stakingOutput, err := getPossibleStakingOutput(stakingTx, uint32(stakingOutputIdx))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	infos, err := BuildAllSpendInfos(params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expectedPkScript, err := infos.Slashing.taprootPkScript()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !bytes.Equal(stakingOutput.PkScript, expectedPkScript) {
+		return nil, nil, fmt.Errorf("staking output does not commit to scripts built from provided params")
+	}
+
+	if IsBurnAllSlashingRate(rate) {
+		if len(changePkScript) != 0 {
+			return nil, nil, fmt.Errorf("change pk script must be empty when the whole stake is slashed")
+		}
+	} else {
+		expectedChangePkScript, _, err := BuildSlashingChangeScript(
+			params.StakerKey,
+			params.FinalityProviderKeys,
+			params.CovenantKeys,
+			slashingTime,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !bytes.Equal(changePkScript, expectedChangePkScript) {
+			return nil, nil, fmt.Errorf(
+				"change pk script must return the change to the staker after %d blocks", slashingTime,
+			)
+		}
+	}
+
+	// slashing tx has slashing output and change output, unless the whole
+	// stake is burned
+	outputs := []*wire.TxOut{wire.NewTxOut(0, slashingPkScript)}
+	if !IsBurnAllSlashingRate(rate) {
+		outputs = append(outputs, wire.NewTxOut(0, changePkScript))
+	}
+
+	weight, err := estimateSpendTxWeight(
+		infos.Slashing,
+		2+int(params.CovenantQuorum),
+		outputs,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stakingTxHash := stakingTx.TxHash()
+
+	slashingTx, err := buildSlashingTxFromOutpoint(
+		*wire.NewOutPoint(&stakingTxHash, uint32(stakingOutputIdx)),
+		stakingOutput.Value,
+		int64(feeRate.FeeForWeight(weight)),
+		slashingPkScript,
+		changePkScript,
+		rate,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return slashingTx, infos.Slashing, nil
+}
+
+// BuildUnbondingTx builds the pre-signed unbonding transaction spending the
+// staking output at the given outpoint to the unbonding output. The unbonding
+// output commits to the taproot tree of the given unbonding spend info, which
+// can describe any path of the unbonding output, and its value is the staking
+// value minus the unbonding fee.
+// CreateUnbondingPathWitness builds the witness spending the staking output.
+func BuildUnbondingTx(
+	stakingOutpoint wire.OutPoint,
+	stakingValue btcutil.Amount,
+	unbondingFee btcutil.Amount,
+	unbondingSpendInfo *SpendInfo,
+) (*wire.MsgTx, error) {
+	if unbondingSpendInfo == nil {
+		return nil, fmt.Errorf("unbonding spend info must not be nil")
+	}
+
+	if unbondingFee < 0 {
+		return nil, fmt.Errorf("unbonding fee must not be negative")
+	}
+
+	if unbondingFee >= stakingValue {
+		return nil, fmt.Errorf(
+			"unbonding fee %d must be less than staking value %d", unbondingFee, stakingValue,
+		)
+	}
+
+	unbondingPkScript, err := unbondingSpendInfo.taprootPkScript()
+	if err != nil {
+		return nil, err
+	}
+
+	unbondingOutput := wire.NewTxOut(int64(stakingValue-unbondingFee), unbondingPkScript)
+	if mempool.IsDust(unbondingOutput, mempool.DefaultMinRelayTxFee) {
+		return nil, ErrDustOutputFound
+	}
+
+	// unbonding tx is always version 2
+	unbondingTx := wire.NewMsgTx(MaxTxVersion)
+	unbondingTx.AddTxIn(wire.NewTxIn(&stakingOutpoint, nil, nil))
+	unbondingTx.AddTxOut(unbondingOutput)
+
+	return unbondingTx, nil
+}
+
+// VerifyUnbondingTx checks that the pre-signed unbonding transaction spends
+// exactly the staking output at the given outpoint and nothing else, and that its
+// only output value differs from the expected value by at most
+// UnbondingValueTolerance. Covenant members must call it before signing, so
+// that the unbonding tx can't be used to drain other inputs into fees.
+func VerifyUnbondingTx(
+	unbondingTx *wire.MsgTx,
+	stakingOutpoint wire.OutPoint,
+	expectedValue btcutil.Amount,
+) error {
+	if unbondingTx == nil {
+		return fmt.Errorf("unbonding tx must not be nil")
+	}
+
+	if len(unbondingTx.TxIn) != 1 {
+		return fmt.Errorf("%w: got %d inputs", ErrUnbondingWrongInputCount, len(unbondingTx.TxIn))
+	}
+
+	if unbondingTx.TxIn[0].PreviousOutPoint != stakingOutpoint {
+		return fmt.Errorf(
+			"%w: expected %s, got %s",
+			ErrUnbondingWrongOutpoint, stakingOutpoint, unbondingTx.TxIn[0].PreviousOutPoint,
+		)
+	}
+
+	if len(unbondingTx.TxOut) != 1 {
+		return fmt.Errorf("unbonding tx must have exactly one output, got %d", len(unbondingTx.TxOut))
+	}
+
+	value := btcutil.Amount(unbondingTx.TxOut[0].Value)
+	diff := value - expectedValue
+	if diff < 0 {
+		diff = -diff
+	}
+
+	if diff > UnbondingValueTolerance {
+		return fmt.Errorf(
+			"%w: expected %d, got %d", ErrUnbondingWrongValue, expectedValue, value,
+		)
+	}
+
+	return nil
+}
+
 // IsTransferTx Transfer transaction is a transaction which:
 // - has exactly one input
 // - has exactly one output
@@ -398,6 +661,46 @@ func validateSlashingTx(
 	return nil
 }
 
+// validateBurnAllSlashingTx performs basic checks on a slashing transaction
+// burning the whole stake:
+// - the slashing transaction passes the pre-signed tx sanity checks with
+// exactly one output.
+// - the output pays to the provided slashing address and is not dust.
+// - the min fee for slashing tx is preserved
+func validateBurnAllSlashingTx(
+	slashingTx *wire.MsgTx,
+	slashingPkScript []byte,
+	slashingTxMinFee, stakingOutputValue int64,
+) error {
+	if err := CheckPreSignedTxSanity(slashingTx, 1, 1, 1, MaxTxVersion); err != nil {
+		return fmt.Errorf("invalid slashing tx: %w", err)
+	}
+
+	slashingOut := slashingTx.TxOut[0]
+
+	if !bytes.Equal(slashingOut.PkScript, slashingPkScript) {
+		return fmt.Errorf("slashing transaction must pay to the provided slashing address")
+	}
+
+	if mempool.IsDust(slashingOut, mempool.DefaultMinRelayTxFee) {
+		return ErrDustOutputFound
+	}
+
+	if slashingOut.Value <= 0 || stakingOutputValue <= 0 {
+		return fmt.Errorf("values of slashing and staking transaction must be larger than 0")
+	}
+
+	if stakingOutputValue <= slashingOut.Value {
+		return fmt.Errorf("slashing transaction must not spend more than staking transaction")
+	}
+
+	if stakingOutputValue-slashingOut.Value < slashingTxMinFee {
+		return fmt.Errorf("slashing transaction fee must be larger than %d", slashingTxMinFee)
+	}
+
+	return nil
+}
+
 // CheckSlashingTxMatchFundingTx validates all relevant data of slashing and funding transaction.
 // - both transactions are valid from pov of BTC rules
 // - funding transaction has output committing to the provided script
@@ -464,6 +767,73 @@ func CheckSlashingTxMatchFundingTx(
 	return nil
 }
 
+// SlashingPolicy contains the Babylon parameters which every slashing tx must
+// obey
+type SlashingPolicy struct {
+	// SlashingPkScript is the pk script receiving the slashed funds
+	SlashingPkScript []byte
+	// SlashingRate is the part of the stake which is slashed
+	SlashingRate sdkmath.LegacyDec
+	// SlashingTxMinFee is the minimal fee of the slashing tx
+	SlashingTxMinFee int64
+	// SlashingChangeLockTime is the time lock of the change output returning
+	// the non-slashed funds to the staker
+	SlashingChangeLockTime uint16
+	// Net is the bitcoin network of the change output address
+	Net *chaincfg.Params
+}
+
+// VerifySlashingTx checks that the slashing tx spending the output with value
+// spentOutputValue, i.e the staking or unbonding output of the delegation of
+// the given staker, obeys the slashing policy. Unlike
+// CheckSlashingTxMatchFundingTx, it does not require the funding transaction,
+// so it can be used by signers which only know the spent output. It also
+// accepts slashing rate of 1, burning the whole stake without change output,
+// which is not accepted by CheckSlashingTxMatchFundingTx used by Babylon chain.
+func VerifySlashingTx(
+	slashingTx *wire.MsgTx,
+	spentOutputValue int64,
+	stakerPk *btcec.PublicKey,
+	policy SlashingPolicy,
+) error {
+	if slashingTx == nil {
+		return fmt.Errorf("slashing tx must not be nil")
+	}
+
+	if stakerPk == nil {
+		return fmt.Errorf("staker public key must not be nil")
+	}
+
+	if policy.Net == nil {
+		return fmt.Errorf("network params must not be nil")
+	}
+
+	if policy.SlashingTxMinFee <= 0 {
+		return fmt.Errorf("slashing transaction min fee must be larger than 0")
+	}
+
+	if policy.SlashingRate.IsNil() || !isSlashingTxRateValid(policy.SlashingRate) {
+		return ErrInvalidSlashingRate
+	}
+
+	if IsBurnAllSlashingRate(policy.SlashingRate) {
+		return validateBurnAllSlashingTx(
+			slashingTx, policy.SlashingPkScript, policy.SlashingTxMinFee, spentOutputValue,
+		)
+	}
+
+	return validateSlashingTx(
+		slashingTx,
+		policy.SlashingPkScript,
+		policy.SlashingRate,
+		policy.SlashingTxMinFee,
+		spentOutputValue,
+		stakerPk,
+		policy.SlashingChangeLockTime,
+		policy.Net,
+	)
+}
+
 func signTxWithOneScriptSpendInputFromTapLeafInternal(
 	txToSign *wire.MsgTx,
 	fundingOutput *wire.TxOut,
@@ -694,6 +1064,80 @@ func VerifyTransactionSigWithOutput(
 	return nil
 }
 
+// VerifyFpSlashingCommitment checks that fpSig is the signature of the finality
+// provider with key fpPk over the canonical slashing message, i.e the
+// SIGHASH_DEFAULT signature hash of the slashing tx spending spentOutput through
+// the slashing leaf described by si. The staking and unbonding slashing leaves
+// have the same script, so the message differs only by the spent output, whose
+// pk script must commit to si. The finality provider must be one of the keys
+// of the slashing leaf. All components verifying finality provider signatures
+// should use it, so they check the same message.
+func VerifyFpSlashingCommitment(
+	fpSig *schnorr.Signature,
+	fpPk *btcec.PublicKey,
+	slashingTx *wire.MsgTx,
+	si *SpendInfo,
+	spentOutput *wire.TxOut,
+) error {
+	if fpSig == nil {
+		return fmt.Errorf("%w: signature is nil", ErrInvalidFpSignature)
+	}
+
+	if fpPk == nil {
+		return fmt.Errorf("finality provider public key must not be nil")
+	}
+
+	if slashingTx == nil {
+		return fmt.Errorf("slashing tx must not be nil")
+	}
+
+	if si == nil {
+		return fmt.Errorf("spend info must not be nil")
+	}
+
+	if spentOutput == nil {
+		return fmt.Errorf("spent output must not be nil")
+	}
+
+	path, err := SpendPathFromScript(si.GetPkScriptPath())
+	if err != nil {
+		return err
+	}
+
+	if path != SlashingPath {
+		return fmt.Errorf("%w: expected %s leaf, got %s", ErrUnexpectedLeaf, SlashingPath, path)
+	}
+
+	signers, err := RequiredSigners(si.GetPkScriptPath())
+	if err != nil {
+		return err
+	}
+
+	isFp := false
+	for _, key := range signers.FinalityProviderKeys {
+		if keyToString(key) == keyToString(fpPk) {
+			isFp = true
+			break
+		}
+	}
+
+	if !isFp {
+		return fmt.Errorf("%w: key %s is not a finality provider of the slashing leaf", ErrWrongSigningKey, keyToString(fpPk))
+	}
+
+	if err := VerifyScriptInclusion(si, spentOutput.PkScript); err != nil {
+		return err
+	}
+
+	if err := VerifyTransactionSigWithOutput(
+		slashingTx, spentOutput, si.GetPkScriptPath(), fpPk, fpSig.Serialize(),
+	); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidFpSignature, err)
+	}
+
+	return nil
+}
+
 // EncVerifyTransactionSigWithOutput verifies that:
 // - provided transaction has exactly one input
 // - provided signature is valid adaptor signature
@@ -10,8 +10,10 @@ import (
 
 	sdkmath "cosmossdk.io/math"
 	"github.com/babylonlabs-io/babylon/btcstaking"
+	btctest "github.com/babylonlabs-io/babylon/testutil/bitcoin"
 	"github.com/babylonlabs-io/babylon/testutil/datagen"
 	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
@@ -599,3 +601,512 @@ func TestCheckPreSignedTxSanity(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildSlashingTxFromStaking(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 2, 5, 3, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+	slashingTime := uint16(100)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		params.StakerKey,
+		params.FinalityProviderKeys,
+		params.CovenantKeys,
+		params.CovenantQuorum,
+		params.StakingTime,
+		params.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	stakingTx := wire.NewMsgTx(2)
+	stakingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	stakingTx.AddTxOut(taprootOutputWithValue(t, r, 10000))
+	stakingTx.AddTxOut(stakingInfo.StakingOutput)
+
+	slashingAddress, err := genRandomBTCAddress(r)
+	require.NoError(t, err)
+	slashingPkScript, err := txscript.PayToAddrScript(slashingAddress)
+	require.NoError(t, err)
+
+	changePkScript, _, err := btcstaking.BuildSlashingChangeScript(
+		params.StakerKey, params.FinalityProviderKeys, params.CovenantKeys, slashingTime,
+	)
+	require.NoError(t, err)
+
+	slashingTx, si, err := btcstaking.BuildSlashingTxFromStaking(
+		stakingTx, 1, params, 0.1, slashingPkScript, changePkScript, slashingTime, btcstaking.SatPerKWeight(2500),
+	)
+	require.NoError(t, err)
+
+	err = btcstaking.CheckSlashingTxMatchFundingTx(
+		slashingTx,
+		stakingTx,
+		1,
+		1,
+		sdkmath.LegacyNewDecWithPrec(1, 1),
+		slashingPkScript,
+		params.StakerKey,
+		slashingTime,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	// slashing tx must be spendable with the returned spend info
+	sigs := signLeafWithKeys(
+		t, slashingTx, stakingInfo.StakingOutput, si,
+		scenario.StakerKey,
+		scenario.FinalityProviderKeys[0],
+		scenario.CovenantKeys[0], scenario.CovenantKeys[1], scenario.CovenantKeys[2],
+	)
+	orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+	require.NoError(t, err)
+	witness, err := btcstaking.CreateWitness(si, orderedSigs)
+	require.NoError(t, err)
+	slashingTx.TxIn[0].Witness = witness
+	btctest.AssertSlashingTxExecution(t, stakingInfo.StakingOutput, slashingTx)
+
+	// invalid slashing rates
+	for _, rate := range []float64{0, -0.5, 1.5} {
+		_, _, err = btcstaking.BuildSlashingTxFromStaking(
+			stakingTx, 1, params, rate, slashingPkScript, changePkScript, slashingTime, btcstaking.SatPerKWeight(2500),
+		)
+		require.ErrorIs(t, err, btcstaking.ErrInvalidSlashingRate)
+	}
+
+	// staking output must commit to the provided params
+	otherParams := params
+	otherParams.StakingTime = params.StakingTime + 1
+	_, _, err = btcstaking.BuildSlashingTxFromStaking(
+		stakingTx, 1, otherParams, 0.1, slashingPkScript, changePkScript, slashingTime, btcstaking.SatPerKWeight(2500),
+	)
+	require.Error(t, err)
+
+	// change must be returned to the staker after the slashing time
+	_, _, err = btcstaking.BuildSlashingTxFromStaking(
+		stakingTx, 1, params, 0.1, slashingPkScript, changePkScript, slashingTime+1, btcstaking.SatPerKWeight(2500),
+	)
+	require.Error(t, err)
+	_, _, err = btcstaking.BuildSlashingTxFromStaking(
+		stakingTx, 1, params, 0.1, slashingPkScript, slashingPkScript, slashingTime, btcstaking.SatPerKWeight(2500),
+	)
+	require.Error(t, err)
+}
+
+func TestBurnAllSlashingTx(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(10_000_000), 1000)
+	params := scenario.StakingParams(100)
+	slashingTime := uint16(100)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		params.StakerKey,
+		params.FinalityProviderKeys,
+		params.CovenantKeys,
+		params.CovenantQuorum,
+		params.StakingTime,
+		params.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	stakingTx := wire.NewMsgTx(2)
+	stakingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	stakingTx.AddTxOut(stakingInfo.StakingOutput)
+
+	slashingAddress, err := genRandomBTCAddress(r)
+	require.NoError(t, err)
+	slashingPkScript, err := txscript.PayToAddrScript(slashingAddress)
+	require.NoError(t, err)
+
+	checkSlashingTx := func(tx *wire.MsgTx, rate sdkmath.LegacyDec) error {
+		return btcstaking.VerifySlashingTx(
+			tx, stakingInfo.StakingOutput.Value, params.StakerKey, btcstaking.SlashingPolicy{
+				SlashingPkScript:       slashingPkScript,
+				SlashingRate:           rate,
+				SlashingTxMinFee:       1,
+				SlashingChangeLockTime: slashingTime,
+				Net:                    &chaincfg.MainNetParams,
+			},
+		)
+	}
+
+	// rate 1.0 burns the whole stake without change output
+	slashingTx, si, err := btcstaking.BuildSlashingTxFromStaking(
+		stakingTx, 0, params, 1, slashingPkScript, nil, slashingTime, btcstaking.SatPerKWeight(2500),
+	)
+	require.NoError(t, err)
+	require.Len(t, slashingTx.TxOut, 1)
+	require.Equal(t, slashingPkScript, slashingTx.TxOut[0].PkScript)
+	require.Less(t, slashingTx.TxOut[0].Value, stakingInfo.StakingOutput.Value)
+	require.NoError(t, checkSlashingTx(slashingTx, sdkmath.LegacyOneDec()))
+
+	// acceptance rules of Babylon chain do not allow burning the whole stake
+	err = btcstaking.CheckSlashingTxMatchFundingTx(
+		slashingTx, stakingTx, 0, 1, sdkmath.LegacyOneDec(), slashingPkScript,
+		params.StakerKey, slashingTime, &chaincfg.MainNetParams,
+	)
+	require.ErrorIs(t, err, btcstaking.ErrInvalidSlashingRate)
+
+	sigs := signLeafWithKeys(
+		t, slashingTx, stakingInfo.StakingOutput, si,
+		scenario.StakerKey,
+		scenario.FinalityProviderKeys[0],
+		scenario.CovenantKeys[0], scenario.CovenantKeys[1],
+	)
+	orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+	require.NoError(t, err)
+	witness, err := btcstaking.CreateWitness(si, orderedSigs)
+	require.NoError(t, err)
+	slashingTx.TxIn[0].Witness = witness
+	btctest.AssertSlashingTxExecution(t, stakingInfo.StakingOutput, slashingTx)
+
+	// slashing tx with change output is rejected for rate 1.0, and burn all
+	// slashing tx is rejected for rates below 1.0
+	changePkScript, _, err := btcstaking.BuildSlashingChangeScript(
+		params.StakerKey, params.FinalityProviderKeys, params.CovenantKeys, slashingTime,
+	)
+	require.NoError(t, err)
+	_, _, err = btcstaking.BuildSlashingTxFromStaking(
+		stakingTx, 0, params, 1, slashingPkScript, changePkScript, slashingTime, btcstaking.SatPerKWeight(2500),
+	)
+	require.Error(t, err)
+
+	partialSlashingTx, _, err := btcstaking.BuildSlashingTxFromStaking(
+		stakingTx, 0, params, 0.5, slashingPkScript, changePkScript, slashingTime, btcstaking.SatPerKWeight(2500),
+	)
+	require.NoError(t, err)
+	require.Error(t, checkSlashingTx(partialSlashingTx, sdkmath.LegacyOneDec()))
+	require.Error(t, checkSlashingTx(slashingTx, sdkmath.LegacyNewDecWithPrec(5, 1)))
+
+	// builder of slashing txs checked by Babylon chain does not burn the whole
+	// stake
+	_, err = btcstaking.BuildSlashingTxFromStakingTxStrict(
+		stakingTx, 0, slashingPkScript, params.StakerKey, slashingTime,
+		1000, sdkmath.LegacyOneDec(), &chaincfg.MainNetParams,
+	)
+	require.ErrorIs(t, err, btcstaking.ErrInvalidSlashingRate)
+
+	// change which would be dust is not absorbed into the fee, the slashing tx
+	// is rejected instead
+	_, err = btcstaking.BuildSlashingTxFromStakingTxStrict(
+		stakingTx, 0, slashingPkScript, params.StakerKey, slashingTime,
+		800, sdkmath.LegacyNewDecWithPrec(9999, 4), &chaincfg.MainNetParams,
+	)
+	require.ErrorIs(t, err, btcstaking.ErrDustOutputFound)
+}
+
+func TestBuildUnbondingTx(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		params.StakerKey,
+		params.FinalityProviderKeys,
+		params.CovenantKeys,
+		params.CovenantQuorum,
+		params.StakingTime,
+		params.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	unbondingFee := btcutil.Amount(1000)
+	unbondingInfo, err := btcstaking.BuildUnbondingInfo(
+		params.StakerKey,
+		params.FinalityProviderKeys,
+		params.CovenantKeys,
+		params.CovenantQuorum,
+		params.UnbondingTime,
+		params.StakingAmount-unbondingFee,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	stakingOutpoint := wire.OutPoint{Hash: chainhash.Hash{1}, Index: 0}
+
+	unbondingTx, err := btcstaking.BuildUnbondingTx(
+		stakingOutpoint, params.StakingAmount, unbondingFee, infos.UnbondingTimeLock,
+	)
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.CheckPreSignedUnbondingTxSanity(unbondingTx))
+	require.Equal(t, stakingOutpoint, unbondingTx.TxIn[0].PreviousOutPoint)
+	require.Equal(t, unbondingInfo.UnbondingOutput, unbondingTx.TxOut[0])
+
+	// unbonding tx must be spendable through the unbonding path of staking output
+	sigs := signLeafWithKeys(
+		t, unbondingTx, stakingInfo.StakingOutput, infos.Unbonding,
+		scenario.StakerKey, scenario.CovenantKeys[0], scenario.CovenantKeys[1],
+	)
+	orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, infos.Unbonding.GetPkScriptPath())
+	require.NoError(t, err)
+	witness, err := btcstaking.CreateWitness(infos.Unbonding, orderedSigs)
+	require.NoError(t, err)
+	unbondingTx.TxIn[0].Witness = witness
+	btctest.AssertSlashingTxExecution(t, stakingInfo.StakingOutput, unbondingTx)
+
+	// fee must be less than staking value
+	_, err = btcstaking.BuildUnbondingTx(
+		stakingOutpoint, params.StakingAmount, params.StakingAmount, infos.UnbondingTimeLock,
+	)
+	require.Error(t, err)
+
+	_, err = btcstaking.BuildUnbondingTx(stakingOutpoint, params.StakingAmount, unbondingFee, nil)
+	require.Error(t, err)
+}
+
+func TestVerifyUnbondingTx(t *testing.T) {
+	stakingOutpoint := wire.OutPoint{Hash: chainhash.Hash{1}, Index: 1}
+	expectedValue := btcutil.Amount(100000)
+
+	newUnbondingTx := func(value btcutil.Amount, outpoints ...wire.OutPoint) *wire.MsgTx {
+		tx := wire.NewMsgTx(2)
+		for i := range outpoints {
+			tx.AddTxIn(wire.NewTxIn(&outpoints[i], nil, nil))
+		}
+		tx.AddTxOut(wire.NewTxOut(int64(value), []byte{txscript.OP_TRUE}))
+		return tx
+	}
+
+	otherOutpoint := wire.OutPoint{Hash: chainhash.Hash{2}, Index: 1}
+
+	tests := []struct {
+		name        string
+		tx          *wire.MsgTx
+		expectedErr error
+	}{
+		{"valid", newUnbondingTx(expectedValue, stakingOutpoint), nil},
+		{"valid within tolerance", newUnbondingTx(expectedValue-btcstaking.UnbondingValueTolerance, stakingOutpoint), nil},
+		{"additional input", newUnbondingTx(expectedValue, stakingOutpoint, otherOutpoint), btcstaking.ErrUnbondingWrongInputCount},
+		{"no inputs", newUnbondingTx(expectedValue), btcstaking.ErrUnbondingWrongInputCount},
+		{"wrong outpoint", newUnbondingTx(expectedValue, otherOutpoint), btcstaking.ErrUnbondingWrongOutpoint},
+		{"value too low", newUnbondingTx(expectedValue-btcstaking.UnbondingValueTolerance-1, stakingOutpoint), btcstaking.ErrUnbondingWrongValue},
+		{"value too high", newUnbondingTx(expectedValue+btcstaking.UnbondingValueTolerance+1, stakingOutpoint), btcstaking.ErrUnbondingWrongValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := btcstaking.VerifyUnbondingTx(tt.tx, stakingOutpoint, expectedValue)
+			if tt.expectedErr == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func TestBuildSlashingChangeScript(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+	changeTimelock := uint16(150)
+
+	pkScript, si, err := btcstaking.BuildSlashingChangeScript(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		changeTimelock,
+	)
+	require.NoError(t, err)
+
+	// change output matches the one expected by slashing tx validation
+	expectedInfo, err := btcstaking.BuildRelativeTimelockTaprootScript(
+		scenario.StakerKey.PubKey(), changeTimelock, &chaincfg.SigNetParams,
+	)
+	require.NoError(t, err)
+	require.Equal(t, expectedInfo.PkScript, pkScript)
+
+	// change output is spendable through the time lock path
+	changeOutput := wire.NewTxOut(int64(scenario.StakingAmount), pkScript)
+	spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.9))
+	spendTx.TxIn[0].Sequence = uint32(changeTimelock)
+
+	sigHash, err := btcstaking.TaprootSigHash(
+		spendTx, 0, []*wire.TxOut{changeOutput}, si, txscript.SigHashDefault,
+	)
+	require.NoError(t, err)
+	sig, err := schnorr.Sign(scenario.StakerKey, sigHash)
+	require.NoError(t, err)
+	witness, err := si.CreateTimeLockPathWitness(sig)
+	require.NoError(t, err)
+	spendTx.TxIn[0].Witness = witness
+	btctest.AssertSlashingTxExecution(t, changeOutput, spendTx)
+
+	// staker key must be distinct from finality provider keys
+	_, _, err = btcstaking.BuildSlashingChangeScript(
+		scenario.StakerKey.PubKey(),
+		[]*btcec.PublicKey{scenario.StakerKey.PubKey()},
+		scenario.CovenantPublicKeys(),
+		changeTimelock,
+	)
+	require.Error(t, err)
+}
+
+func TestSignSlashingAsCovenant(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+	stakingPkScript, err := btcstaking.StakingOutputScript(params)
+	require.NoError(t, err)
+
+	stakingTx := wire.NewMsgTx(2)
+	stakingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	stakingTx.AddTxOut(wire.NewTxOut(int64(params.StakingAmount), stakingPkScript))
+	prevOuts := []*wire.TxOut{stakingTx.TxOut[0]}
+
+	slashingAddress, err := genRandomBTCAddress(r)
+	require.NoError(t, err)
+	slashingPkScript, err := txscript.PayToAddrScript(slashingAddress)
+	require.NoError(t, err)
+
+	policy := btcstaking.SlashingPolicy{
+		SlashingPkScript:       slashingPkScript,
+		SlashingRate:           sdkmath.LegacyNewDecWithPrec(1, 1),
+		SlashingTxMinFee:       1000,
+		SlashingChangeLockTime: 100,
+		Net:                    &chaincfg.MainNetParams,
+	}
+
+	slashingTx, err := btcstaking.BuildSlashingTxFromStakingTxStrict(
+		stakingTx,
+		0,
+		policy.SlashingPkScript,
+		params.StakerKey,
+		policy.SlashingChangeLockTime,
+		policy.SlashingTxMinFee,
+		policy.SlashingRate,
+		policy.Net,
+	)
+	require.NoError(t, err)
+
+	covSigner, err := btcstaking.NewPrivateKeySigner(scenario.CovenantKeys[0])
+	require.NoError(t, err)
+
+	sig, err := btcstaking.SignSlashingAsCovenant(covSigner, slashingTx, infos.Slashing, prevOuts, policy)
+	require.NoError(t, err)
+	sigHash, err := btcstaking.TaprootSigHash(slashingTx, 0, prevOuts, infos.Slashing, txscript.SigHashDefault)
+	require.NoError(t, err)
+	require.True(t, sig.Verify(sigHash, covSigner.PubKey()))
+
+	t.Run("slashing tx paying to other slashing address", func(t *testing.T) {
+		otherPolicy := policy
+		otherPolicy.SlashingPkScript = datagen.GenRandomByteArray(r, 22)
+		_, err := btcstaking.SignSlashingAsCovenant(covSigner, slashingTx, infos.Slashing, prevOuts, otherPolicy)
+		require.Error(t, err)
+	})
+
+	t.Run("slashing tx with modified change output", func(t *testing.T) {
+		modifiedTx := slashingTx.Copy()
+		modifiedTx.TxOut[1].PkScript = slashingPkScript
+		_, err := btcstaking.SignSlashingAsCovenant(covSigner, modifiedTx, infos.Slashing, prevOuts, policy)
+		require.Error(t, err)
+	})
+
+	t.Run("signer outside covenant committee", func(t *testing.T) {
+		stakerSigner, err := btcstaking.NewPrivateKeySigner(scenario.StakerKey)
+		require.NoError(t, err)
+		_, err = btcstaking.SignSlashingAsCovenant(stakerSigner, slashingTx, infos.Slashing, prevOuts, policy)
+		require.ErrorIs(t, err, btcstaking.ErrWrongSigningKey)
+	})
+
+	t.Run("spend info of other leaf", func(t *testing.T) {
+		_, err := btcstaking.SignSlashingAsCovenant(covSigner, slashingTx, infos.Unbonding, prevOuts, policy)
+		require.ErrorIs(t, err, btcstaking.ErrUnexpectedLeaf)
+	})
+}
+
+func TestVerifyFpSlashingCommitment(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 2, 3, 2, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+	fpKey := scenario.FinalityProviderKeys[1]
+
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+	stakingPkScript, err := btcstaking.StakingOutputScript(params)
+	require.NoError(t, err)
+	unbondingAddress, err := btcstaking.TaprootAddress(infos.UnbondingSlashing, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	unbondingPkScript, err := txscript.PayToAddrScript(unbondingAddress)
+	require.NoError(t, err)
+
+	stakingOutput := wire.NewTxOut(int64(params.StakingAmount), stakingPkScript)
+	unbondingOutput := wire.NewTxOut(int64(params.StakingAmount)-1000, unbondingPkScript)
+
+	slashingAddress, err := genRandomBTCAddress(r)
+	require.NoError(t, err)
+	slashingTx := wire.NewMsgTx(2)
+	slashingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 0}, nil, nil))
+	slashingTx.AddTxOut(outputFromAddressAndValue(t, slashingAddress, 10000))
+
+	// signature produced by the signing helper and signature over the sighash
+	// computed independently must both verify
+	stakingSig, err := btcstaking.SignTxWithOneScriptSpendInputFromTapLeaf(
+		slashingTx, stakingOutput, fpKey, infos.Slashing.RevealedLeaf,
+	)
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.VerifyFpSlashingCommitment(
+		stakingSig, fpKey.PubKey(), slashingTx, infos.Slashing, stakingOutput,
+	))
+
+	sigHash, err := btcstaking.TaprootSigHash(
+		slashingTx, 0, []*wire.TxOut{unbondingOutput}, infos.UnbondingSlashing, txscript.SigHashDefault,
+	)
+	require.NoError(t, err)
+	unbondingSig, err := schnorr.Sign(fpKey, sigHash)
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.VerifyFpSlashingCommitment(
+		unbondingSig, fpKey.PubKey(), slashingTx, infos.UnbondingSlashing, unbondingOutput,
+	))
+
+	t.Run("signature over the other slashing leaf", func(t *testing.T) {
+		err := btcstaking.VerifyFpSlashingCommitment(
+			stakingSig, fpKey.PubKey(), slashingTx, infos.UnbondingSlashing, unbondingOutput,
+		)
+		require.ErrorIs(t, err, btcstaking.ErrInvalidFpSignature)
+		err = btcstaking.VerifyFpSlashingCommitment(
+			unbondingSig, fpKey.PubKey(), slashingTx, infos.Slashing, stakingOutput,
+		)
+		require.ErrorIs(t, err, btcstaking.ErrInvalidFpSignature)
+	})
+
+	t.Run("spent output not committing to spend info", func(t *testing.T) {
+		err := btcstaking.VerifyFpSlashingCommitment(
+			stakingSig, fpKey.PubKey(), slashingTx, infos.UnbondingSlashing, stakingOutput,
+		)
+		require.Error(t, err)
+	})
+
+	t.Run("signature of other finality provider", func(t *testing.T) {
+		otherPk := scenario.FinalityProviderKeys[0].PubKey()
+		err := btcstaking.VerifyFpSlashingCommitment(
+			stakingSig, otherPk, slashingTx, infos.Slashing, stakingOutput,
+		)
+		require.ErrorIs(t, err, btcstaking.ErrInvalidFpSignature)
+	})
+
+	t.Run("key outside finality providers", func(t *testing.T) {
+		covSig, err := btcstaking.SignTxWithOneScriptSpendInputFromTapLeaf(
+			slashingTx, stakingOutput, scenario.CovenantKeys[0], infos.Slashing.RevealedLeaf,
+		)
+		require.NoError(t, err)
+		err = btcstaking.VerifyFpSlashingCommitment(
+			covSig, scenario.CovenantKeys[0].PubKey(), slashingTx, infos.Slashing, stakingOutput,
+		)
+		require.ErrorIs(t, err, btcstaking.ErrWrongSigningKey)
+	})
+
+	t.Run("spend info of other leaf", func(t *testing.T) {
+		err := btcstaking.VerifyFpSlashingCommitment(
+			stakingSig, fpKey.PubKey(), slashingTx, infos.Unbonding, stakingOutput,
+		)
+		require.ErrorIs(t, err, btcstaking.ErrUnexpectedLeaf)
+	})
+}
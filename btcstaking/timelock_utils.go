@@ -0,0 +1,169 @@
+package btcstaking
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// IsTimelockMatured returns true if the output included in the block at
+// stakingHeight, locked with the given relative time lock, can be spent by a
+// transaction included in the next block i.e at currentHeight+1.
+func IsTimelockMatured(stakingHeight, currentHeight uint32, timelock uint16) bool {
+	return uint64(currentHeight)+1 >= uint64(stakingHeight)+uint64(timelock)
+}
+
+// CanSpendTimelock checks whether the output included in the block at
+// stakingHeight can be spent through the time lock path described by the given
+// spend info in the next block. If it is not spendable yet, it also returns the
+// number of blocks which must be mined before it is.
+func CanSpendTimelock(si *SpendInfo, stakingHeight, currentHeight uint32) (bool, uint32, error) {
+	if si == nil {
+		return false, 0, fmt.Errorf("spend info must not be nil")
+	}
+
+	timelock, err := ExtractTimelock(si.GetPkScriptPath())
+	if err != nil {
+		return false, 0, err
+	}
+
+	if currentHeight < stakingHeight {
+		return false, 0, fmt.Errorf(
+			"current height %d is lower than staking height %d", currentHeight, stakingHeight,
+		)
+	}
+
+	if IsTimelockMatured(stakingHeight, currentHeight, timelock) {
+		return true, 0, nil
+	}
+
+	maturityHeight := uint64(stakingHeight) + uint64(timelock)
+
+	return false, uint32(maturityHeight - uint64(currentHeight) - 1), nil
+}
+
+// VerifyAbsoluteTimelock checks that the given transaction can spend an output
+// locked with OP_CHECKLOCKTIMEVERIFY until requiredHeight i.e the lock time of
+// the transaction is a block height not lower than requiredHeight, and every
+// input has non-final sequence, as otherwise the lock time is not enforced.
+// It complements the relative time lock check done through input sequences.
+func VerifyAbsoluteTimelock(tx *wire.MsgTx, requiredHeight uint32) error {
+	if tx == nil {
+		return fmt.Errorf("tx must not be nil")
+	}
+
+	if requiredHeight >= txscript.LockTimeThreshold {
+		return fmt.Errorf("required height %d is not a block height", requiredHeight)
+	}
+
+	if tx.LockTime >= txscript.LockTimeThreshold {
+		return fmt.Errorf("tx lock time %d is a timestamp, expected block height", tx.LockTime)
+	}
+
+	if tx.LockTime < requiredHeight {
+		return fmt.Errorf("tx lock time %d is lower than required height %d", tx.LockTime, requiredHeight)
+	}
+
+	for i, in := range tx.TxIn {
+		if in.Sequence == wire.MaxTxInSequenceNum {
+			return fmt.Errorf("input %d has final sequence, lock time is not enforced", i)
+		}
+	}
+
+	return nil
+}
+
+// SequenceForTimelock returns the input sequence enforcing the given relative
+// time lock in blocks, as checked by OP_CHECKSEQUENCEVERIFY in Babylon time lock
+// scripts. As defined in BIP68, the disable flag and the type flag are not set,
+// so the time lock is enforced and measured in blocks, not in 512 seconds
+// intervals.
+func SequenceForTimelock(timelock uint16) uint32 {
+	return blockchain.LockTimeToSequence(false, uint32(timelock))
+}
+
+// ComputeInputSequence returns the sequence of the input spending the time lock
+// path described by the given spend info, which enforces the relative time lock
+// of its time lock script.
+func ComputeInputSequence(si *SpendInfo) (uint32, error) {
+	if si == nil {
+		return 0, fmt.Errorf("spend info must not be nil")
+	}
+
+	timelock, err := ExtractTimelock(si.GetPkScriptPath())
+	if err != nil {
+		return 0, err
+	}
+
+	return SequenceForTimelock(timelock), nil
+}
+
+// ValidateRecoverySequence checks that the input with index inputIdx of the
+// given transaction enforces the relative time lock of the recovery leaf
+// described by the spend info. The recovery time lock is long, so a spend
+// with a lower sequence, or with relative time locks disabled, is rejected
+// before broadcasting instead of failing in the mempool.
+func ValidateRecoverySequence(tx *wire.MsgTx, inputIdx int, si *SpendInfo) error {
+	if tx == nil {
+		return fmt.Errorf("tx must not be nil")
+	}
+
+	if inputIdx < 0 || inputIdx >= len(tx.TxIn) {
+		return fmt.Errorf("input index %d out of range, tx has %d inputs", inputIdx, len(tx.TxIn))
+	}
+
+	if si == nil {
+		return fmt.Errorf("spend info must not be nil")
+	}
+
+	timelock, err := ExtractTimelock(si.GetPkScriptPath())
+	if err != nil {
+		return err
+	}
+
+	return validateTimelockSequence(tx, inputIdx, timelock)
+}
+
+// validateTimelockSequence checks that the tx enforces relative time locks and
+// that the sequence of the input with index inputIdx enforces the given time lock
+func validateTimelockSequence(tx *wire.MsgTx, inputIdx int, timelock uint16) error {
+	if err := RequireTxVersion2(tx); err != nil {
+		return err
+	}
+
+	sequence := tx.TxIn[inputIdx].Sequence
+	if sequence&wire.SequenceLockTimeDisabled != 0 {
+		return fmt.Errorf("input %d has relative time lock disabled", inputIdx)
+	}
+
+	if sequence&wire.SequenceLockTimeIsSeconds != 0 {
+		return fmt.Errorf("input %d has time based relative time lock, expected blocks", inputIdx)
+	}
+
+	if blocks := sequence & wire.SequenceLockTimeMask; blocks < uint32(timelock) {
+		return fmt.Errorf(
+			"input %d relative time lock %d is lower than script time lock %d", inputIdx, blocks, timelock,
+		)
+	}
+
+	return nil
+}
+
+// RequireTxVersion2 checks that the tx has version 2 or higher. As defined in
+// BIP68, sequences of inputs of version 1 transactions are not interpreted as
+// relative time locks, so such a tx spending a time lock leaf fails
+// OP_CHECKSEQUENCEVERIFY, while an input sequence meant as a relative time lock
+// would not delay any other spend.
+func RequireTxVersion2(tx *wire.MsgTx) error {
+	if tx == nil {
+		return fmt.Errorf("tx must not be nil")
+	}
+
+	if tx.Version < 2 {
+		return fmt.Errorf("%w: version %d, expected at least 2", ErrRelativeTimelockIgnored, tx.Version)
+	}
+
+	return nil
+}
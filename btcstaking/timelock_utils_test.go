@@ -0,0 +1,184 @@
+package btcstaking_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	"github.com/babylonlabs-io/babylon/testutil/datagen"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractTimelock(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	for _, lockTime := range []uint16{1, 16, 17, 127, 128, 255, 256, 32767, 32768, 65535} {
+		info, err := btcstaking.BuildRelativeTimelockTaprootScript(
+			scenario.StakerKey.PubKey(), lockTime, &chaincfg.MainNetParams,
+		)
+		require.NoError(t, err)
+
+		extracted, err := btcstaking.ExtractTimelock(info.SpendInfo.GetPkScriptPath())
+		require.NoError(t, err)
+		require.Equal(t, lockTime, extracted)
+	}
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(150))
+	require.NoError(t, err)
+
+	extracted, err := btcstaking.ExtractTimelock(infos.UnbondingTimeLock.GetPkScriptPath())
+	require.NoError(t, err)
+	require.Equal(t, uint16(150), extracted)
+
+	_, err = btcstaking.ExtractTimelock(infos.Unbonding.GetPkScriptPath())
+	require.Error(t, err)
+	_, err = btcstaking.ExtractTimelock([]byte{txscript.OP_CHECKSEQUENCEVERIFY})
+	require.Error(t, err)
+}
+
+func TestCanSpendTimelock(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	stakingHeight := uint32(800000)
+
+	tests := []struct {
+		currentHeight   uint32
+		spendable       bool
+		remainingBlocks uint32
+	}{
+		{stakingHeight, false, 999},
+		{stakingHeight + 998, false, 1},
+		// spending tx can be included in the next block
+		{stakingHeight + 999, true, 0},
+		{stakingHeight + 5000, true, 0},
+	}
+
+	for _, tt := range tests {
+		spendable, remaining, err := btcstaking.CanSpendTimelock(infos.TimeLock, stakingHeight, tt.currentHeight)
+		require.NoError(t, err)
+		require.Equal(t, tt.spendable, spendable)
+		require.Equal(t, tt.remainingBlocks, remaining)
+		require.Equal(t, tt.spendable, btcstaking.IsTimelockMatured(stakingHeight, tt.currentHeight, scenario.StakingTime))
+	}
+
+	_, _, err = btcstaking.CanSpendTimelock(infos.TimeLock, stakingHeight, stakingHeight-1)
+	require.Error(t, err)
+	_, _, err = btcstaking.CanSpendTimelock(infos.Slashing, stakingHeight, stakingHeight)
+	require.Error(t, err)
+}
+
+func TestExtractAbsoluteTimelock(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	_, pk, err := datagen.GenRandomBTCKeyPair(r)
+	require.NoError(t, err)
+
+	for _, lockTime := range []int64{1, 16, 840000, txscript.LockTimeThreshold - 1, math.MaxUint32} {
+		script, err := txscript.NewScriptBuilder().
+			AddInt64(lockTime).
+			AddOp(txscript.OP_CHECKLOCKTIMEVERIFY).
+			AddOp(txscript.OP_DROP).
+			AddData(schnorr.SerializePubKey(pk)).
+			AddOp(txscript.OP_CHECKSIG).
+			Script()
+		require.NoError(t, err)
+
+		extracted, err := btcstaking.ExtractAbsoluteTimelock(script)
+		require.NoError(t, err)
+		require.Equal(t, uint32(lockTime), extracted)
+	}
+
+	// babylon time lock script uses relative time lock
+	info, err := btcstaking.BuildRelativeTimelockTaprootScript(pk, 100, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	_, err = btcstaking.ExtractAbsoluteTimelock(info.SpendInfo.GetPkScriptPath())
+	require.Error(t, err)
+}
+
+func TestVerifyAbsoluteTimelock(t *testing.T) {
+	newTx := func(lockTime uint32, sequence uint32) *wire.MsgTx {
+		tx := wire.NewMsgTx(2)
+		in := wire.NewTxIn(&wire.OutPoint{}, nil, nil)
+		in.Sequence = sequence
+		tx.AddTxIn(in)
+		tx.LockTime = lockTime
+		return tx
+	}
+
+	nonFinal := wire.MaxTxInSequenceNum - 1
+
+	require.NoError(t, btcstaking.VerifyAbsoluteTimelock(newTx(100, nonFinal), 100))
+	require.NoError(t, btcstaking.VerifyAbsoluteTimelock(newTx(101, nonFinal), 100))
+
+	// lock time lower than required
+	require.Error(t, btcstaking.VerifyAbsoluteTimelock(newTx(99, nonFinal), 100))
+	// lock time is not enforced with final sequence
+	require.Error(t, btcstaking.VerifyAbsoluteTimelock(newTx(100, wire.MaxTxInSequenceNum), 100))
+	// lock time is a timestamp
+	require.Error(t, btcstaking.VerifyAbsoluteTimelock(newTx(txscript.LockTimeThreshold, nonFinal), 100))
+	// required height is a timestamp
+	require.Error(t, btcstaking.VerifyAbsoluteTimelock(newTx(100, nonFinal), txscript.LockTimeThreshold))
+}
+
+func TestSequenceForTimelock(t *testing.T) {
+	for _, timelock := range []uint16{0, 1, 64000, math.MaxUint16} {
+		sequence := btcstaking.SequenceForTimelock(timelock)
+		require.Equal(t, uint32(timelock), sequence&wire.SequenceLockTimeMask)
+		require.Zero(t, sequence&wire.SequenceLockTimeDisabled)
+		require.Zero(t, sequence&wire.SequenceLockTimeIsSeconds)
+		require.NotEqual(t, wire.MaxTxInSequenceNum, sequence)
+	}
+
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), math.MaxUint16)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+	si, err := stakingInfo.TimeLockPathSpendInfo()
+	require.NoError(t, err)
+
+	sequence, err := btcstaking.ComputeInputSequence(si)
+	require.NoError(t, err)
+	require.Equal(t, btcstaking.SequenceForTimelock(math.MaxUint16), sequence)
+
+	spend := func(sequence uint32) error {
+		spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+		spendTx.TxIn[0].Sequence = sequence
+		sig, err := btcstaking.SignTxWithOneScriptSpendInputFromTapLeaf(
+			spendTx, stakingInfo.StakingOutput, scenario.StakerKey, si.RevealedLeaf,
+		)
+		require.NoError(t, err)
+		witness, err := si.CreateTimeLockPathWitness(sig)
+		require.NoError(t, err)
+		return btcstaking.ValidateWitness(stakingInfo.StakingOutput, spendTx, 0, witness)
+	}
+
+	require.NoError(t, spend(sequence))
+	require.Error(t, spend(sequence-1))
+	// same value in 512 seconds intervals does not satisfy block based time lock
+	require.Error(t, spend(sequence|wire.SequenceLockTimeIsSeconds))
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+	_, err = btcstaking.ComputeInputSequence(infos.Unbonding)
+	require.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package btcstaking
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 
 	bbn "github.com/babylonlabs-io/babylon/types"
 	sdk "github.com/cosmos/cosmos-sdk/types"
@@ -202,6 +204,18 @@ func (sti *StakingInfo) GetOutputFetcher() *txscript.CannedPrevOutputFetcher {
 	)
 }
 
+// WitnessVersion is the segwit version of the output spent with a SpendInfo
+type WitnessVersion byte
+
+const (
+	// WitnessV0 outputs i.e P2WSH are spent by revealing the witness script
+	// without control block
+	WitnessV0 WitnessVersion = 0
+	// WitnessV1Taproot outputs are spent by revealing the leaf script together
+	// with the control block. All Babylon outputs are taproot outputs.
+	WitnessV1Taproot WitnessVersion = 1
+)
+
 // SpendInfo contains information necessary to create witness for given script
 type SpendInfo struct {
 	// Control block contains merkle proof of inclusion of revealed script path
@@ -209,6 +223,39 @@ type SpendInfo struct {
 	// RevealedLeaf is the leaf of the script tree which is revealed i.e scriptpath
 	// which is being executed
 	RevealedLeaf txscript.TapLeaf
+	// witnessV0 is set for spend infos created by NewWitnessV0SpendInfo, so
+	// spend infos built as struct literals describe taproot outputs
+	witnessV0 bool
+}
+
+// NewWitnessV0SpendInfo creates spend info of the P2WSH output committing to
+// the given witness script. Only witness construction supports such spend
+// infos, taproot specific helpers e.g TaprootSigHash must not be used with them.
+func NewWitnessV0SpendInfo(witnessScript []byte) (*SpendInfo, error) {
+	if len(witnessScript) == 0 {
+		return nil, fmt.Errorf("witness script must not be empty")
+	}
+
+	if len(witnessScript) > txscript.MaxScriptSize {
+		return nil, fmt.Errorf(
+			"witness script has %d bytes, exceeding max size of %d bytes", len(witnessScript), txscript.MaxScriptSize,
+		)
+	}
+
+	return &SpendInfo{
+		RevealedLeaf: txscript.TapLeaf{Script: witnessScript},
+		witnessV0:    true,
+	}, nil
+}
+
+// WitnessVersion returns the segwit version of the output described by the
+// spend info, which determines the layout of the spending witness
+func (si *SpendInfo) WitnessVersion() WitnessVersion {
+	if si.witnessV0 {
+		return WitnessV0
+	}
+
+	return WitnessV1Taproot
 }
 
 // GetPkScriptPath returns the path of the taproot pkscript corresponding
@@ -217,6 +264,205 @@ func (si *SpendInfo) GetPkScriptPath() []byte {
 	return si.RevealedLeaf.Script
 }
 
+// MerkleRoot returns the root of the taproot script tree committed by the
+// output, computed from the revealed leaf and the branch proven by the control
+// block. Together with the internal key it determines the output key, and it is
+// the value expected in the PSBT taproot merkle root field.
+func (si *SpendInfo) MerkleRoot() (chainhash.Hash, error) {
+	if si == nil {
+		return chainhash.Hash{}, fmt.Errorf("spend info must not be nil")
+	}
+
+	if si.ControlBlock.InternalKey == nil {
+		return chainhash.Hash{}, fmt.Errorf("control block internal key is nil")
+	}
+
+	proofLen := len(si.ControlBlock.InclusionProof)
+	if proofLen%chainhash.HashSize != 0 {
+		return chainhash.Hash{}, fmt.Errorf("invalid inclusion proof length %d", proofLen)
+	}
+
+	if proofLen/chainhash.HashSize > txscript.ControlBlockMaxNodeCount {
+		return chainhash.Hash{}, fmt.Errorf(
+			"inclusion proof has %d nodes, maximum is %d",
+			proofLen/chainhash.HashSize, txscript.ControlBlockMaxNodeCount,
+		)
+	}
+
+	var root chainhash.Hash
+	copy(root[:], si.ControlBlock.RootHash(si.RevealedLeaf.Script))
+
+	return root, nil
+}
+
+// taprootPkScript returns the P2TR pk script of the output committing to the
+// script revealed by the spend info
+func (si *SpendInfo) taprootPkScript() ([]byte, error) {
+	outputKey, err := TaprootOutputKey(si)
+	if err != nil {
+		return nil, err
+	}
+
+	return txscript.PayToTaprootScript(outputKey)
+}
+
+// TaprootOutputKey returns the taproot output key of the output committing to
+// the script revealed by the spend info, derived from the internal key and the
+// merkle root proven by the control block
+func TaprootOutputKey(si *SpendInfo) (*btcec.PublicKey, error) {
+	if si == nil {
+		return nil, fmt.Errorf("spend info must not be nil")
+	}
+
+	if si.ControlBlock.InternalKey == nil {
+		return nil, fmt.Errorf("control block internal key is nil")
+	}
+
+	rootHash := si.ControlBlock.RootHash(si.RevealedLeaf.Script)
+
+	return txscript.ComputeTaprootOutputKey(si.ControlBlock.InternalKey, rootHash), nil
+}
+
+// TaprootAddress returns the address of the taproot output committing to the
+// script revealed by the spend info on the given network
+func TaprootAddress(si *SpendInfo, net *chaincfg.Params) (*btcutil.AddressTaproot, error) {
+	outputKey, err := TaprootOutputKey(si)
+	if err != nil {
+		return nil, err
+	}
+
+	return btcutil.NewAddressTaproot(schnorr.SerializePubKey(outputKey), net)
+}
+
+// VerifyScriptInclusion checks that the spend info proves inclusion of its
+// revealed script in the taproot output with the given pk script. Apart from
+// the merkle proof, it checks that the output key parity encoded in the control
+// block matches the parity of the output key, as a mismatch makes the spend
+// invalid even though the proof itself is correct.
+func VerifyScriptInclusion(si *SpendInfo, outputPkScript []byte) error {
+	if si == nil {
+		return fmt.Errorf("spend info must not be nil")
+	}
+
+	if si.ControlBlock.InternalKey == nil {
+		return fmt.Errorf("control block internal key is nil")
+	}
+
+	if !txscript.IsPayToTaproot(outputPkScript) {
+		return fmt.Errorf("output pk script is not a taproot pk script")
+	}
+
+	rootHash := si.ControlBlock.RootHash(si.RevealedLeaf.Script)
+	outputKey := txscript.ComputeTaprootOutputKey(si.ControlBlock.InternalKey, rootHash)
+
+	// taproot pk script is OP_1 OP_DATA_32 <x-only output key>
+	if !bytes.Equal(schnorr.SerializePubKey(outputKey), outputPkScript[2:]) {
+		return fmt.Errorf("revealed script is not committed in the output")
+	}
+
+	actualYIsOdd := outputKey.SerializeCompressed()[0] == secp256k1.PubKeyFormatCompressedOdd
+	if si.ControlBlock.OutputKeyYIsOdd != actualYIsOdd {
+		return fmt.Errorf(
+			"%w: control block has odd parity %t, output key has odd parity %t",
+			ErrControlBlockParityMismatch, si.ControlBlock.OutputKeyYIsOdd, actualYIsOdd,
+		)
+	}
+
+	return nil
+}
+
+// CrossCheckScriptAndControlBlock detects spend infos mixing the script of one
+// leaf with the control block of another leaf of the same tree. The control
+// block of a leaf proves inclusion through the hashes of the other nodes of the
+// tree, so if the hash of the revealed leaf appears among them, the control block
+// belongs to a different leaf. It also checks that the leaf version of the
+// revealed leaf matches the one in the control block.
+// Unlike VerifyScriptInclusion, it does not require the output, but it only
+// detects mix ups in which the revealed leaf is a direct sibling on the proven
+// path, e.g. any mix up in a two leaves tree. Mix ups with control blocks of
+// leaves higher in the tree, or of unrelated trees, require checking against
+// the output with VerifyScriptInclusion.
+func CrossCheckScriptAndControlBlock(si *SpendInfo) error {
+	if si == nil {
+		return fmt.Errorf("spend info must not be nil")
+	}
+
+	if si.RevealedLeaf.LeafVersion != si.ControlBlock.LeafVersion {
+		return fmt.Errorf(
+			"%w: leaf version 0x%x does not match control block leaf version 0x%x",
+			ErrScriptNotCommitted, si.RevealedLeaf.LeafVersion, si.ControlBlock.LeafVersion,
+		)
+	}
+
+	proof := si.ControlBlock.InclusionProof
+	if len(proof)%chainhash.HashSize != 0 {
+		return fmt.Errorf("invalid inclusion proof length %d", len(proof))
+	}
+
+	leafHash := txscript.NewTapLeaf(si.ControlBlock.LeafVersion, si.RevealedLeaf.Script).TapHash()
+
+	for i := 0; i < len(proof); i += chainhash.HashSize {
+		if bytes.Equal(proof[i:i+chainhash.HashSize], leafHash[:]) {
+			return fmt.Errorf(
+				"%w: revealed script is a sibling at depth %d of the proven leaf",
+				ErrScriptNotCommitted, i/chainhash.HashSize,
+			)
+		}
+	}
+
+	return nil
+}
+
+// RecomputeControlBlock replaces the control block of the spend info with the
+// control block of the same leaf in a different taproot tree, e.g after a leaf
+// was added to the tree of the delegation. newMerkleProof contains the hashes of
+// the sibling nodes on the path from the leaf to the new root, starting from the
+// leaf. The spend info is modified only if the new control block is a valid
+// inclusion proof of the revealed leaf.
+func RecomputeControlBlock(si *SpendInfo, newMerkleProof [][]byte, newInternalKey *btcec.PublicKey) error {
+	if si == nil {
+		return fmt.Errorf("spend info must not be nil")
+	}
+
+	if newInternalKey == nil {
+		return fmt.Errorf("internal key must not be nil")
+	}
+
+	if len(newMerkleProof) > txscript.ControlBlockMaxNodeCount {
+		return fmt.Errorf(
+			"merkle proof has %d nodes, maximum is %d", len(newMerkleProof), txscript.ControlBlockMaxNodeCount,
+		)
+	}
+
+	inclusionProof := make([]byte, 0, len(newMerkleProof)*chainhash.HashSize)
+	for i, node := range newMerkleProof {
+		if len(node) != chainhash.HashSize {
+			return fmt.Errorf("merkle proof node %d must have %d bytes, got %d", i, chainhash.HashSize, len(node))
+		}
+		inclusionProof = append(inclusionProof, node...)
+	}
+
+	cb := txscript.ControlBlock{
+		InternalKey:    newInternalKey,
+		LeafVersion:    si.RevealedLeaf.LeafVersion,
+		InclusionProof: inclusionProof,
+	}
+
+	rootHash := cb.RootHash(si.RevealedLeaf.Script)
+	outputKey := txscript.ComputeTaprootOutputKey(newInternalKey, rootHash)
+	cb.OutputKeyYIsOdd = outputKey.SerializeCompressed()[0] == secp256k1.PubKeyFormatCompressedOdd
+
+	if err := txscript.VerifyTaprootLeafCommitment(
+		&cb, schnorr.SerializePubKey(outputKey), si.RevealedLeaf.Script,
+	); err != nil {
+		return fmt.Errorf("%w: %w", ErrScriptNotCommitted, err)
+	}
+
+	si.ControlBlock = cb
+
+	return nil
+}
+
 func SpendInfoFromRevealedScript(
 	revealedScript []byte,
 	internalKey *btcec.PublicKey,
@@ -325,8 +571,13 @@ func newBabylonScriptPaths(
 		return nil, err
 	}
 
-	covenantMultisigScript, err := buildMultiSigScript(
-		covenantKeys,
+	covenantCommittee, err := NewCovenantCommittee(covenantKeys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	covenantMultisigScript, err := covenantCommittee.multisigScript(
 		covenantQuorum,
 		// covenant multisig is always last in script so we do not run verify and leave
 		// last value on the stack. If we do not leave at least one element on the stack
@@ -529,6 +780,18 @@ func (i *UnbondingInfo) SlashingPathSpendInfo() (*SpendInfo, error) {
 	return i.scriptHolder.scriptSpendInfoByName(i.slashingPathLeafHash)
 }
 
+// IsBurnAllSlashingRate checks if the given rate burns the whole stake. Slashing
+// transactions with such rate have only the slashing output and no change output.
+func IsBurnAllSlashingRate(rate sdkmath.LegacyDec) bool {
+	return rate.Equal(sdkmath.LegacyOneDec())
+}
+
+// isSlashingTxRateValid checks if slashing transactions can be built and
+// validated with the given rate i.e the rate is valid or burns the whole stake
+func isSlashingTxRateValid(rate sdkmath.LegacyDec) bool {
+	return IsSlashingRateValid(rate) || IsBurnAllSlashingRate(rate)
+}
+
 // IsSlashingRateValid checks if the given rate is between the valid range i.e., (0,1) with a precision of at most 4 decimal places.
 func IsSlashingRateValid(rate sdkmath.LegacyDec) bool {
 	// Check if the slashing rate is between 0 and 1
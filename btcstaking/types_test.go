@@ -1,10 +1,17 @@
 package btcstaking_test
 
 import (
+	"math/rand"
 	"testing"
+	"time"
 
 	sdkmath "cosmossdk.io/math"
 	"github.com/babylonlabs-io/babylon/btcstaking"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/stretchr/testify/require"
 )
 
@@ -73,3 +80,219 @@ func TestIsSlashingRateValid(t *testing.T) {
 		})
 	}
 }
+
+func TestVerifyScriptInclusion(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	si, err := stakingInfo.SlashingPathSpendInfo()
+	require.NoError(t, err)
+	pkScript := stakingInfo.StakingOutput.PkScript
+
+	require.NoError(t, btcstaking.VerifyScriptInclusion(si, pkScript))
+
+	// flipped parity bit is detected
+	flipped := *si
+	flipped.ControlBlock.OutputKeyYIsOdd = !si.ControlBlock.OutputKeyYIsOdd
+	err = btcstaking.VerifyScriptInclusion(&flipped, pkScript)
+	require.ErrorIs(t, err, btcstaking.ErrControlBlockParityMismatch)
+
+	// script of other output is not included
+	otherInfo, err := btcstaking.BuildRelativeTimelockTaprootScript(
+		scenario.StakerKey.PubKey(), scenario.StakingTime, &chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+	err = btcstaking.VerifyScriptInclusion(otherInfo.SpendInfo, pkScript)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, btcstaking.ErrControlBlockParityMismatch)
+
+	err = btcstaking.VerifyScriptInclusion(si, []byte{txscript.OP_TRUE})
+	require.Error(t, err)
+}
+
+func TestCrossCheckScriptAndControlBlock(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	mix := func(scriptInfo, controlBlockInfo *btcstaking.SpendInfo) *btcstaking.SpendInfo {
+		return &btcstaking.SpendInfo{
+			ControlBlock: controlBlockInfo.ControlBlock,
+			RevealedLeaf: scriptInfo.RevealedLeaf,
+		}
+	}
+
+	all := []*btcstaking.SpendInfo{
+		infos.TimeLock, infos.Unbonding, infos.Slashing, infos.UnbondingTimeLock, infos.UnbondingSlashing,
+	}
+	for _, si := range all {
+		require.NoError(t, btcstaking.CrossCheckScriptAndControlBlock(si))
+	}
+
+	// in two leaves tree every mix up is detected
+	err = btcstaking.CrossCheckScriptAndControlBlock(mix(infos.UnbondingTimeLock, infos.UnbondingSlashing))
+	require.ErrorIs(t, err, btcstaking.ErrScriptNotCommitted)
+	err = btcstaking.CrossCheckScriptAndControlBlock(mix(infos.UnbondingSlashing, infos.UnbondingTimeLock))
+	require.ErrorIs(t, err, btcstaking.ErrScriptNotCommitted)
+
+	// in staking tree, leaves paired at the lowest level are detected, as is
+	// any script revealed with the control block of those leaves
+	detected := [][2]*btcstaking.SpendInfo{
+		{infos.TimeLock, infos.Unbonding},
+		{infos.Unbonding, infos.TimeLock},
+		{infos.Slashing, infos.TimeLock},
+		{infos.Slashing, infos.Unbonding},
+	}
+	for _, d := range detected {
+		err = btcstaking.CrossCheckScriptAndControlBlock(mix(d[0], d[1]))
+		require.ErrorIs(t, err, btcstaking.ErrScriptNotCommitted)
+	}
+
+	// script revealed with the control block of the leaf at the top level is
+	// not detected, and requires checking against the output
+	undetected := mix(infos.TimeLock, infos.Slashing)
+	require.NoError(t, btcstaking.CrossCheckScriptAndControlBlock(undetected))
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+	require.Error(t, btcstaking.VerifyScriptInclusion(undetected, stakingInfo.StakingOutput.PkScript))
+
+	// leaf version must match the control block
+	mixedVersion := *infos.TimeLock
+	mixedVersion.RevealedLeaf = txscript.NewTapLeaf(0xc2, infos.TimeLock.GetPkScriptPath())
+	err = btcstaking.CrossCheckScriptAndControlBlock(&mixedVersion)
+	require.ErrorIs(t, err, btcstaking.ErrScriptNotCommitted)
+}
+
+func TestRecomputeControlBlock(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	// staking tree extended with additional leaf
+	extraScript, err := txscript.NewScriptBuilder().
+		AddData(schnorr.SerializePubKey(scenario.StakerKey.PubKey())).
+		AddOp(txscript.OP_CHECKSIG).
+		Script()
+	require.NoError(t, err)
+
+	leaves := []txscript.TapLeaf{
+		infos.TimeLock.RevealedLeaf,
+		infos.Unbonding.RevealedLeaf,
+		infos.Slashing.RevealedLeaf,
+		txscript.NewBaseTapLeaf(extraScript),
+	}
+	tree := txscript.AssembleTaprootScriptTree(leaves...)
+	internalKey := infos.TimeLock.ControlBlock.InternalKey
+	rootHash := tree.RootNode.TapHash()
+	outputKey := txscript.ComputeTaprootOutputKey(internalKey, rootHash[:])
+	pkScript, err := txscript.PayToTaprootScript(outputKey)
+	require.NoError(t, err)
+
+	si := &btcstaking.SpendInfo{
+		ControlBlock: infos.Slashing.ControlBlock,
+		RevealedLeaf: infos.Slashing.RevealedLeaf,
+	}
+	require.Error(t, btcstaking.VerifyScriptInclusion(si, pkScript))
+
+	proofIdx := tree.LeafProofIndex[si.RevealedLeaf.TapHash()]
+	flatProof := tree.LeafMerkleProofs[proofIdx].InclusionProof
+	var merkleProof [][]byte
+	for i := 0; i < len(flatProof); i += chainhash.HashSize {
+		merkleProof = append(merkleProof, flatProof[i:i+chainhash.HashSize])
+	}
+
+	require.NoError(t, btcstaking.RecomputeControlBlock(si, merkleProof, internalKey))
+	require.NoError(t, btcstaking.VerifyScriptInclusion(si, pkScript))
+	require.Equal(t, tree.LeafMerkleProofs[proofIdx].ToControlBlock(internalKey), si.ControlBlock)
+
+	// spend info is not modified on invalid input
+	cb := si.ControlBlock
+	require.Error(t, btcstaking.RecomputeControlBlock(si, [][]byte{merkleProof[0][:31]}, internalKey))
+	require.Error(t, btcstaking.RecomputeControlBlock(si, merkleProof, nil))
+	require.Error(t, btcstaking.RecomputeControlBlock(nil, merkleProof, internalKey))
+	require.Error(t, btcstaking.RecomputeControlBlock(si, make([][]byte, txscript.ControlBlockMaxNodeCount+1), internalKey))
+	require.Equal(t, cb, si.ControlBlock)
+}
+
+func TestSpendInfoMerkleRoot(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		1000,
+		btcutil.Amount(2*10e8),
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	tree := txscript.AssembleTaprootScriptTree(
+		infos.TimeLock.RevealedLeaf,
+		infos.Unbonding.RevealedLeaf,
+		infos.Slashing.RevealedLeaf,
+	)
+	expectedRoot := tree.RootNode.TapHash()
+
+	for _, si := range []*btcstaking.SpendInfo{infos.TimeLock, infos.Unbonding, infos.Slashing} {
+		root, err := si.MerkleRoot()
+		require.NoError(t, err)
+		require.Equal(t, expectedRoot, root)
+
+		// merkle root and internal key determine the output key
+		outputKey := txscript.ComputeTaprootOutputKey(si.ControlBlock.InternalKey, root[:])
+		pkScript, err := txscript.PayToTaprootScript(outputKey)
+		require.NoError(t, err)
+		require.Equal(t, stakingInfo.StakingOutput.PkScript, pkScript)
+	}
+
+	// in a single leaf tree the root is the leaf hash
+	single := txscript.AssembleTaprootScriptTree(infos.TimeLock.RevealedLeaf)
+	si := &btcstaking.SpendInfo{
+		ControlBlock: single.LeafMerkleProofs[0].ToControlBlock(infos.TimeLock.ControlBlock.InternalKey),
+		RevealedLeaf: infos.TimeLock.RevealedLeaf,
+	}
+	root, err := si.MerkleRoot()
+	require.NoError(t, err)
+	require.Equal(t, infos.TimeLock.RevealedLeaf.TapHash(), root)
+
+	si.ControlBlock.InclusionProof = []byte{0x01}
+	_, err = si.MerkleRoot()
+	require.Error(t, err)
+
+	si.ControlBlock.InternalKey = nil
+	_, err = si.MerkleRoot()
+	require.Error(t, err)
+
+	var nilInfo *btcstaking.SpendInfo
+	_, err = nilInfo.MerkleRoot()
+	require.Error(t, err)
+}
@@ -0,0 +1,411 @@
+package btcstaking
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// ParsedWitness contains the elements of a taproot script path spend witness
+type ParsedWitness struct {
+	// Signatures are all the witness elements preceding the revealed script.
+	// Empty elements are placeholders for missing signatures.
+	Signatures [][]byte
+	// Script is the revealed script being executed
+	Script []byte
+	// ControlBlock is the serialized control block proving inclusion of the
+	// revealed script in the taproot output
+	ControlBlock []byte
+	// Annex is the optional last witness element starting with 0x50. It is
+	// nil if the witness does not carry an annex.
+	Annex []byte
+}
+
+// hasAnnex returns true if the witness carries an annex as defined in BIP341 i.e
+// it has at least two elements and the last one starts with 0x50
+func hasAnnex(witness wire.TxWitness) bool {
+	if len(witness) < 2 {
+		return false
+	}
+
+	lastElement := witness[len(witness)-1]
+
+	return len(lastElement) > 0 && lastElement[0] == txscript.TaprootAnnexTag
+}
+
+// ParseWitness splits the taproot script path spend witness into signatures,
+// revealed script, control block and optional annex.
+// The returned witness elements are not copied.
+func ParseWitness(witness wire.TxWitness) (*ParsedWitness, error) {
+	var annex []byte
+	if hasAnnex(witness) {
+		annex = witness[len(witness)-1]
+		witness = witness[:len(witness)-1]
+	}
+
+	// witness must contain at least revealed script and control block
+	if len(witness) < 2 {
+		return nil, fmt.Errorf("witness must have at least 2 elements, got %d", len(witness))
+	}
+
+	numSignatures := len(witness) - 2
+
+	return &ParsedWitness{
+		Signatures:   witness[:numSignatures],
+		Script:       witness[numSignatures],
+		ControlBlock: witness[numSignatures+1],
+		Annex:        annex,
+	}, nil
+}
+
+// WitnessScript returns the revealed script of the taproot script path spend witness
+func WitnessScript(witness wire.TxWitness) ([]byte, error) {
+	parsed, err := ParseWitness(witness)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed.Script, nil
+}
+
+// WitnessControlBlock returns the serialized control block of the taproot script
+// path spend witness
+func WitnessControlBlock(witness wire.TxWitness) ([]byte, error) {
+	parsed, err := ParseWitness(witness)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed.ControlBlock, nil
+}
+
+// ValidateControlBlockLength checks that the length of the given serialized
+// control block is 33 + 32*m bytes, for a merkle branch of depth m not higher
+// than 128. Other lengths are the usual symptom of a truncated control block, or
+// of one concatenated with other data, which is reported with the length instead
+// of a generic parsing error.
+func ValidateControlBlockLength(controlBlock []byte) error {
+	size := len(controlBlock)
+
+	if size < txscript.ControlBlockBaseSize {
+		return fmt.Errorf(
+			"%w: %d bytes, expected at least %d", ErrInvalidControlBlockLength, size, txscript.ControlBlockBaseSize,
+		)
+	}
+
+	if size > txscript.ControlBlockMaxSize {
+		return fmt.Errorf(
+			"%w: %d bytes, expected at most %d", ErrInvalidControlBlockLength, size, txscript.ControlBlockMaxSize,
+		)
+	}
+
+	if extra := (size - txscript.ControlBlockBaseSize) % txscript.ControlBlockNodeSize; extra != 0 {
+		return fmt.Errorf(
+			"%w: %d bytes, expected %d + %d*m, got %d trailing bytes after merkle branch of depth %d",
+			ErrInvalidControlBlockLength, size, txscript.ControlBlockBaseSize, txscript.ControlBlockNodeSize,
+			extra, (size-txscript.ControlBlockBaseSize)/txscript.ControlBlockNodeSize,
+		)
+	}
+
+	return nil
+}
+
+// parseControlBlock parses the given serialized control block, checking its
+// length with ValidateControlBlockLength first
+func parseControlBlock(controlBlock []byte) (*txscript.ControlBlock, error) {
+	if err := ValidateControlBlockLength(controlBlock); err != nil {
+		return nil, err
+	}
+
+	return txscript.ParseControlBlock(controlBlock)
+}
+
+// ExtractInternalKey returns the taproot internal key committed to in the given
+// serialized control block. The control block must be well formed and the key
+// must be a valid point on the curve.
+func ExtractInternalKey(controlBlock []byte) (*btcec.PublicKey, error) {
+	cb, err := parseControlBlock(controlBlock)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control block: %w", err)
+	}
+
+	return cb.InternalKey, nil
+}
+
+// OutputKeyFromWitness returns the taproot output key of the output spent by
+// the given script path spend witness. The key is computed by tweaking the
+// internal key of the control block with the merkle root proven by the control
+// block for the revealed script, so an indexer can match it against the spent
+// output pk script without any other data. The witness proves only that the
+// script is committed in the returned key, it is not executed.
+func OutputKeyFromWitness(witness wire.TxWitness) (*btcec.PublicKey, error) {
+	parsed, err := ParseWitness(witness)
+	if err != nil {
+		return nil, err
+	}
+
+	cb, err := parseControlBlock(parsed.ControlBlock)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control block: %w", err)
+	}
+
+	// root hash starts from the tap leaf hash of the revealed script
+	rootHash := cb.RootHash(parsed.Script)
+
+	return txscript.ComputeTaprootOutputKey(cb.InternalKey, rootHash), nil
+}
+
+// CountSignatures returns the number of non-empty signatures in the taproot
+// script path spend witness. Empty elements are placeholders of missing signatures.
+func CountSignatures(witness wire.TxWitness) (int, error) {
+	parsed, err := ParseWitness(witness)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, sig := range parsed.Signatures {
+		if len(sig) > 0 {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// IsQuorumOptimal checks whether the unbonding path witness contains exactly
+// quorum covenant signatures. It returns the number of covenant signatures
+// exceeding the quorum, which needlessly increase the spend fee.
+// Witness which can't be parsed or which does not contain enough covenant
+// signatures is not optimal.
+func IsQuorumOptimal(witness wire.TxWitness, quorum, committeeSize int) (bool, int) {
+	parsed, err := ParseWitness(witness)
+	if err != nil {
+		return false, 0
+	}
+
+	// unbonding witness has one slot per covenant member and the delegator slot
+	if len(parsed.Signatures) != committeeSize+1 {
+		return false, 0
+	}
+
+	count, err := CountSignatures(witness)
+	if err != nil {
+		return false, 0
+	}
+
+	// delegator signature is always required
+	covenantSigs := count - 1
+	if covenantSigs < quorum {
+		return false, 0
+	}
+
+	extra := covenantSigs - quorum
+
+	return extra == 0, extra
+}
+
+const (
+	// sigOpsDelta is the validation weight of a single signature operation
+	// in tapscript, as defined in BIP342
+	sigOpsDelta = 50
+	// sigOpsBudgetOffset is the validation weight budget granted to every
+	// tapscript witness on top of its serialized size, as defined in BIP342
+	sigOpsBudgetOffset = 50
+)
+
+// WitnessSigops returns the number of signature operations i.e OP_CHECKSIG,
+// OP_CHECKSIGVERIFY and OP_CHECKSIGADD in the script revealed by the taproot
+// script path spend witness. It returns 0 if the witness can't be parsed.
+func WitnessSigops(witness wire.TxWitness) int {
+	script, err := WitnessScript(witness)
+	if err != nil {
+		return 0
+	}
+
+	sigops := 0
+
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	for tokenizer.Next() {
+		switch tokenizer.Opcode() {
+		case txscript.OP_CHECKSIG, txscript.OP_CHECKSIGVERIFY, txscript.OP_CHECKSIGADD:
+			sigops++
+		}
+	}
+
+	return sigops
+}
+
+// CheckSigopsBudget checks that all signature operations of the revealed
+// script fit in the BIP342 budget of the witness, i.e one signature operation
+// per 50 bytes of serialized witness plus one. The check is conservative, as
+// operations with empty signature do not consume the budget during execution.
+func CheckSigopsBudget(witness wire.TxWitness) error {
+	if _, err := ParseWitness(witness); err != nil {
+		return err
+	}
+
+	sigops := WitnessSigops(witness)
+	budget := (witness.SerializeSize() + sigOpsBudgetOffset) / sigOpsDelta
+
+	if sigops > budget {
+		return fmt.Errorf("%w: script has %d sigops, budget is %d", ErrSigopsBudgetExceeded, sigops, budget)
+	}
+
+	return nil
+}
+
+// scriptDisasmDiff returns human readable difference between two scripts,
+// pointing to the first differing opcode of their disassembly
+func scriptDisasmDiff(expected, got []byte) string {
+	expectedDisasm, _ := txscript.DisasmString(expected)
+	gotDisasm, _ := txscript.DisasmString(got)
+
+	expectedOps := strings.Fields(expectedDisasm)
+	gotOps := strings.Fields(gotDisasm)
+
+	idx := 0
+	for idx < len(expectedOps) && idx < len(gotOps) && expectedOps[idx] == gotOps[idx] {
+		idx++
+	}
+
+	opAt := func(ops []string) string {
+		if idx < len(ops) {
+			return ops[idx]
+		}
+		return "<end of script>"
+	}
+
+	return fmt.Sprintf(
+		"first difference at opcode %d: expected %s, got %s\nexpected: %s\ngot:      %s",
+		idx, opAt(expectedOps), opAt(gotOps), expectedDisasm, gotDisasm,
+	)
+}
+
+// AssertWitnessUsesLeaf checks that the taproot script path spend witness
+// reveals exactly the expected leaf script, e.g to tell whether the staking
+// output was unbonded or slashed. On mismatch, the returned error contains the
+// disassembly of both scripts and points to the first differing opcode.
+// The control block must be well formed, use the base leaf version and must not
+// prove inclusion through the hash of the expected leaf itself, which would mean
+// it belongs to a sibling leaf (see CrossCheckScriptAndControlBlock). Checking
+// that the control block commits to the spent output requires the output, and
+// is done by VerifyScriptInclusion.
+func AssertWitnessUsesLeaf(witness wire.TxWitness, expectedLeafScript []byte) error {
+	parsed, err := ParseWitness(witness)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(parsed.Script, expectedLeafScript) {
+		return fmt.Errorf("%w: %s", ErrUnexpectedLeaf, scriptDisasmDiff(expectedLeafScript, parsed.Script))
+	}
+
+	cb, err := parseControlBlock(parsed.ControlBlock)
+	if err != nil {
+		return fmt.Errorf("invalid control block: %w", err)
+	}
+
+	return CrossCheckScriptAndControlBlock(&SpendInfo{
+		ControlBlock: *cb,
+		RevealedLeaf: txscript.NewBaseTapLeaf(parsed.Script),
+	})
+}
+
+// WitnessToAuditText renders the taproot script path spend witness as labeled
+// multi-line text for manual review, e.g of offline signing artifacts:
+// - every signature slot marked as SIGNED, with the signature and its sighash
+// type, or as EMPTY
+// - the number of signatures compared with sigCount, the number of signatures
+// the reviewer expects, e.g covenant quorum plus staker signature
+// - the revealed script disassembled one opcode per line
+// - the control block split into leaf version, output key parity, internal key
+// and merkle branch
+// The output only depends on the witness and sigCount, so renderings of two
+// artifacts can be diffed. Malformed elements are rendered as hex with the
+// reason they could not be parsed.
+func WitnessToAuditText(witness wire.TxWitness, sigCount int) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "witness elements: %d\n", len(witness))
+
+	parsed, err := ParseWitness(witness)
+	if err != nil {
+		fmt.Fprintf(&b, "INVALID WITNESS: %v\n", err)
+		for i, element := range witness {
+			fmt.Fprintf(&b, "element %d: %x\n", i, element)
+		}
+		return b.String()
+	}
+
+	signed := 0
+	for _, sig := range parsed.Signatures {
+		if len(sig) > 0 {
+			signed++
+		}
+	}
+
+	status := "OK"
+	if signed != sigCount {
+		status = "MISMATCH"
+	}
+	fmt.Fprintf(&b, "signatures: %d signed, %d expected [%s]\n", signed, sigCount, status)
+
+	for i, sig := range parsed.Signatures {
+		switch len(sig) {
+		case 0:
+			fmt.Fprintf(&b, "  slot %d: EMPTY\n", i)
+		case schnorr.SignatureSize:
+			fmt.Fprintf(&b, "  slot %d: SIGNED %x sighash=default\n", i, sig)
+		case schnorr.SignatureSize + 1:
+			fmt.Fprintf(
+				&b, "  slot %d: SIGNED %x sighash=0x%02x\n",
+				i, sig[:schnorr.SignatureSize], sig[schnorr.SignatureSize],
+			)
+		default:
+			fmt.Fprintf(&b, "  slot %d: INVALID LENGTH %d %x\n", i, len(sig), sig)
+		}
+	}
+
+	fmt.Fprintf(&b, "script: %d bytes\n", len(parsed.Script))
+	disasm, err := txscript.DisasmString(parsed.Script)
+	for _, op := range strings.Fields(disasm) {
+		fmt.Fprintf(&b, "  %s\n", op)
+	}
+	if err != nil {
+		fmt.Fprintf(&b, "  INVALID SCRIPT: %v\n", err)
+	}
+
+	fmt.Fprintf(&b, "control block: %d bytes\n", len(parsed.ControlBlock))
+	cb, err := parseControlBlock(parsed.ControlBlock)
+	if err != nil {
+		fmt.Fprintf(&b, "  INVALID CONTROL BLOCK: %v\n", err)
+		fmt.Fprintf(&b, "  raw: %x\n", parsed.ControlBlock)
+	} else {
+		parity := "even"
+		if cb.OutputKeyYIsOdd {
+			parity = "odd"
+		}
+		fmt.Fprintf(&b, "  leaf version: 0x%02x\n", byte(cb.LeafVersion))
+		fmt.Fprintf(&b, "  output key parity: %s\n", parity)
+		fmt.Fprintf(&b, "  internal key: %x\n", schnorr.SerializePubKey(cb.InternalKey))
+
+		numNodes := len(cb.InclusionProof) / txscript.ControlBlockNodeSize
+		fmt.Fprintf(&b, "  merkle branch: %d nodes\n", numNodes)
+		for i := 0; i < numNodes; i++ {
+			node := cb.InclusionProof[i*txscript.ControlBlockNodeSize : (i+1)*txscript.ControlBlockNodeSize]
+			fmt.Fprintf(&b, "    node %d: %x\n", i, node)
+		}
+	}
+
+	if parsed.Annex != nil {
+		fmt.Fprintf(&b, "annex: %x\n", parsed.Annex)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,388 @@
+package btcstaking_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	"github.com/babylonlabs-io/babylon/testutil/datagen"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWitness(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	sigs := [][]byte{
+		datagen.GenRandomByteArray(r, 64), {}, datagen.GenRandomByteArray(r, 64), {}, datagen.GenRandomByteArray(r, 64), datagen.GenRandomByteArray(r, 64),
+	}
+	witness, err := btcstaking.CreateWitness(infos.Unbonding, sigs)
+	require.NoError(t, err)
+
+	controlBlock, err := infos.Unbonding.ControlBlock.ToBytes()
+	require.NoError(t, err)
+
+	parsed, err := btcstaking.ParseWitness(witness)
+	require.NoError(t, err)
+	require.Equal(t, sigs, parsed.Signatures)
+	require.Equal(t, infos.Unbonding.GetPkScriptPath(), parsed.Script)
+	require.Equal(t, controlBlock, parsed.ControlBlock)
+	require.Nil(t, parsed.Annex)
+
+	// witness with annex must have script and control block shifted by one
+	annex := []byte{0x50, 0x01, 0x02}
+	annexedWitness := append(wire.TxWitness{}, witness...)
+	annexedWitness = append(annexedWitness, annex)
+
+	parsed, err = btcstaking.ParseWitness(annexedWitness)
+	require.NoError(t, err)
+	require.Equal(t, sigs, parsed.Signatures)
+	require.Equal(t, infos.Unbonding.GetPkScriptPath(), parsed.Script)
+	require.Equal(t, controlBlock, parsed.ControlBlock)
+	require.Equal(t, annex, parsed.Annex)
+
+	script, err := btcstaking.WitnessScript(annexedWitness)
+	require.NoError(t, err)
+	require.Equal(t, infos.Unbonding.GetPkScriptPath(), script)
+
+	cb, err := btcstaking.WitnessControlBlock(annexedWitness)
+	require.NoError(t, err)
+	require.Equal(t, controlBlock, cb)
+
+	// annex alone does not make a valid script path witness
+	_, err = btcstaking.ParseWitness(wire.TxWitness{controlBlock, annex})
+	require.Error(t, err)
+}
+
+func TestIsQuorumOptimal(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	sig := func() []byte { return datagen.GenRandomByteArray(r, 64) }
+
+	testCases := []struct {
+		name     string
+		sigs     [][]byte
+		optimal  bool
+		extraSig int
+	}{
+		{"exact quorum", [][]byte{sig(), {}, sig(), {}, sig(), sig()}, true, 0},
+		{"one extra", [][]byte{sig(), sig(), sig(), {}, sig(), sig()}, false, 1},
+		{"whole committee", [][]byte{sig(), sig(), sig(), sig(), sig(), sig()}, false, 2},
+		{"below quorum", [][]byte{sig(), {}, {}, {}, sig(), sig()}, false, 0},
+		{"wrong committee size", [][]byte{sig(), sig(), sig(), sig()}, false, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			witness, err := btcstaking.CreateWitness(infos.Unbonding, tc.sigs)
+			require.NoError(t, err)
+
+			optimal, extra := btcstaking.IsQuorumOptimal(witness, 3, 5)
+			require.Equal(t, tc.optimal, optimal)
+			require.Equal(t, tc.extraSig, extra)
+		})
+	}
+}
+
+func TestCheckSigopsBudget(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 15, 10, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	// staker key and 15 covenant keys
+	sigs := make([][]byte, 16)
+	for i := range sigs {
+		sigs[i] = datagen.GenRandomByteArray(r, 64)
+	}
+
+	witness, err := btcstaking.CreateWitness(infos.Unbonding, sigs)
+	require.NoError(t, err)
+	require.Equal(t, 16, btcstaking.WitnessSigops(witness))
+	require.NoError(t, btcstaking.CheckSigopsBudget(witness))
+
+	// without signatures the witness is too small to pay for all sigops
+	for i := range sigs {
+		sigs[i] = []byte{}
+	}
+	witness, err = btcstaking.CreateWitness(infos.Unbonding, sigs)
+	require.NoError(t, err)
+
+	err = btcstaking.CheckSigopsBudget(witness)
+	require.ErrorIs(t, err, btcstaking.ErrSigopsBudgetExceeded)
+	require.Contains(t, err.Error(), "16 sigops")
+
+	require.Zero(t, btcstaking.WitnessSigops(wire.TxWitness{}))
+}
+
+func TestGenWitness(t *testing.T) {
+	testCases := []struct {
+		path     btcstaking.SpendPath
+		numSlots int
+		numSigs  int
+	}{
+		{btcstaking.TimeLockPath, 1, 1},
+		{btcstaking.UnbondingPath, 6, 4},
+		{btcstaking.SlashingPath, 7, 5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path.String(), func(t *testing.T) {
+			seed := time.Now().UnixNano()
+
+			witness := datagen.GenWitness(seed, tc.path, 5, 3)
+			require.Equal(t, witness, datagen.GenWitness(seed, tc.path, 5, 3))
+
+			parsed, err := btcstaking.ParseWitness(witness)
+			require.NoError(t, err)
+			require.Len(t, parsed.Signatures, tc.numSlots)
+
+			path, err := btcstaking.SpendPathFromScript(parsed.Script)
+			require.NoError(t, err)
+			require.Equal(t, tc.path, path)
+
+			numSigs, err := btcstaking.CountSignatures(witness)
+			require.NoError(t, err)
+			require.Equal(t, tc.numSigs, numSigs)
+
+			for _, sig := range parsed.Signatures {
+				if len(sig) > 0 {
+					require.True(t, datagen.IsSyntheticSig(sig))
+				}
+			}
+		})
+	}
+}
+
+func TestExtractInternalKey(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	cb, err := infos.Slashing.ControlBlock.ToBytes()
+	require.NoError(t, err)
+
+	key, err := btcstaking.ExtractInternalKey(cb)
+	require.NoError(t, err)
+	require.True(t, key.IsEqual(infos.Slashing.ControlBlock.InternalKey))
+
+	// truncated control block
+	_, err = btcstaking.ExtractInternalKey(cb[:32])
+	require.Error(t, err)
+
+	// control block with trailing partial proof element
+	_, err = btcstaking.ExtractInternalKey(append(cb, 0x01))
+	require.Error(t, err)
+
+	// internal key which is not a valid point, x coordinate above field size
+	invalidKey := bytes.Clone(cb)
+	copy(invalidKey[1:33], bytes.Repeat([]byte{0xff}, 32))
+	_, err = btcstaking.ExtractInternalKey(invalidKey)
+	require.Error(t, err)
+}
+
+func TestValidateControlBlockLength(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	internalKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	// first leaf of the balanced tree of 2^depth leaves has a merkle branch of
+	// the given depth
+	var valid []byte
+	for depth := 0; depth <= 3; depth++ {
+		leaves := make([]txscript.TapLeaf, 1<<depth)
+		for i := range leaves {
+			leaves[i] = txscript.NewBaseTapLeaf(datagen.GenRandomByteArray(r, 40))
+		}
+		tree := txscript.AssembleTaprootScriptTree(leaves...)
+		cb := tree.LeafMerkleProofs[0].ToControlBlock(internalKey.PubKey())
+		cbBytes, err := cb.ToBytes()
+		require.NoError(t, err)
+		require.Len(t, cbBytes, 33+32*depth)
+
+		require.NoError(t, btcstaking.ValidateControlBlockLength(cbBytes), "depth %d", depth)
+		key, err := btcstaking.ExtractInternalKey(cbBytes)
+		require.NoError(t, err)
+		require.Equal(t, schnorr.SerializePubKey(internalKey.PubKey()), schnorr.SerializePubKey(key))
+		valid = cbBytes
+	}
+
+	for _, size := range []int{0, 1, 32, 34, 64, 66, 33 + 32*3 - 1, 33 + 32*3 + 1, 33 + 32*129} {
+		err := btcstaking.ValidateControlBlockLength(make([]byte, size))
+		require.ErrorIs(t, err, btcstaking.ErrInvalidControlBlockLength, "size %d", size)
+	}
+
+	// truncated control block and control block concatenated with other data
+	// are reported by entry points parsing control blocks
+	_, err = btcstaking.ExtractInternalKey(valid[:len(valid)-1])
+	require.ErrorIs(t, err, btcstaking.ErrInvalidControlBlockLength)
+	_, err = btcstaking.ExtractInternalKey(append(bytes.Clone(valid), valid[:33]...))
+	require.ErrorIs(t, err, btcstaking.ErrInvalidControlBlockLength)
+	_, err = btcstaking.CreateWitnessWithControlBlockBytes([]byte{txscript.OP_TRUE}, valid[:40], nil)
+	require.ErrorIs(t, err, btcstaking.ErrInvalidControlBlockLength)
+}
+
+func TestAssertWitnessUsesLeaf(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	unbondingWitness := datagen.GenWitness(r.Int63(), btcstaking.UnbondingPath, 3, 2)
+	slashingWitness := datagen.GenWitness(r.Int63(), btcstaking.SlashingPath, 3, 2)
+
+	witness, err := btcstaking.CreateWitness(infos.Unbonding, unbondingWitness[:len(unbondingWitness)-2])
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.AssertWitnessUsesLeaf(witness, infos.Unbonding.GetPkScriptPath()))
+
+	// unbonding witness does not spend the slashing leaf
+	err = btcstaking.AssertWitnessUsesLeaf(witness, infos.Slashing.GetPkScriptPath())
+	require.ErrorIs(t, err, btcstaking.ErrUnexpectedLeaf)
+	require.Contains(t, err.Error(), "first difference at opcode 2")
+
+	witness, err = btcstaking.CreateWitness(infos.Slashing, slashingWitness[:len(slashingWitness)-2])
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.AssertWitnessUsesLeaf(witness, infos.Slashing.GetPkScriptPath()))
+	require.ErrorIs(t,
+		btcstaking.AssertWitnessUsesLeaf(witness, infos.Unbonding.GetPkScriptPath()),
+		btcstaking.ErrUnexpectedLeaf,
+	)
+
+	// expected script revealed with control block of the sibling leaf
+	mixed := &btcstaking.SpendInfo{
+		ControlBlock: infos.TimeLock.ControlBlock,
+		RevealedLeaf: infos.Unbonding.RevealedLeaf,
+	}
+	witness, err = btcstaking.CreateWitness(mixed, unbondingWitness[:len(unbondingWitness)-2])
+	require.NoError(t, err)
+	require.ErrorIs(t,
+		btcstaking.AssertWitnessUsesLeaf(witness, infos.Unbonding.GetPkScriptPath()),
+		btcstaking.ErrScriptNotCommitted,
+	)
+
+	require.Error(t, btcstaking.AssertWitnessUsesLeaf(wire.TxWitness{{}}, infos.Unbonding.GetPkScriptPath()))
+}
+
+func TestOutputKeyFromWitness(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+	params := scenario.StakingParams(100)
+
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(t, err)
+	stakingPkScript, err := btcstaking.StakingOutputScript(params)
+	require.NoError(t, err)
+
+	for _, si := range []*btcstaking.SpendInfo{infos.TimeLock, infos.Unbonding, infos.Slashing} {
+		cb, err := si.ControlBlock.ToBytes()
+		require.NoError(t, err)
+		witness := wire.TxWitness{datagen.GenRandomByteArray(r, 64), si.GetPkScriptPath(), cb}
+
+		outputKey, err := btcstaking.OutputKeyFromWitness(witness)
+		require.NoError(t, err)
+
+		pkScript, err := txscript.PayToTaprootScript(outputKey)
+		require.NoError(t, err)
+		require.Equal(t, stakingPkScript, pkScript)
+	}
+
+	// unbonding slashing leaf commits to the unbonding output
+	cb, err := infos.UnbondingSlashing.ControlBlock.ToBytes()
+	require.NoError(t, err)
+	outputKey, err := btcstaking.OutputKeyFromWitness(wire.TxWitness{infos.UnbondingSlashing.GetPkScriptPath(), cb})
+	require.NoError(t, err)
+	pkScript, err := txscript.PayToTaprootScript(outputKey)
+	require.NoError(t, err)
+	require.NotEqual(t, stakingPkScript, pkScript)
+	require.NoError(t, btcstaking.VerifyScriptInclusion(infos.UnbondingSlashing, pkScript))
+
+	_, err = btcstaking.OutputKeyFromWitness(wire.TxWitness{cb})
+	require.Error(t, err)
+
+	_, err = btcstaking.OutputKeyFromWitness(wire.TxWitness{infos.Slashing.GetPkScriptPath(), cb[:10]})
+	require.Error(t, err)
+}
+
+func FuzzOutputKeyFromWitness(f *testing.F) {
+	datagen.AddRandomSeedsToFuzzer(f, 10)
+	f.Fuzz(func(t *testing.T, seed int64) {
+		r := rand.New(rand.NewSource(seed))
+
+		witness := make(wire.TxWitness, r.Intn(6))
+		for i := range witness {
+			witness[i] = datagen.GenRandomByteArray(r, uint64(r.Intn(100)))
+		}
+
+		// control block of valid length, most likely with invalid internal key
+		if len(witness) >= 2 && r.Intn(2) == 0 {
+			cb := datagen.GenRandomByteArray(r, uint64(txscript.ControlBlockBaseSize+txscript.ControlBlockNodeSize*r.Intn(4)))
+			cb[0] = byte(txscript.BaseLeafVersion) | byte(r.Intn(2))
+			witness[len(witness)-1] = cb
+		}
+
+		outputKey, err := btcstaking.OutputKeyFromWitness(witness)
+		if err == nil {
+			require.NotNil(t, outputKey)
+		}
+	})
+}
+
+func TestWitnessToAuditText(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+	si := infos.Unbonding
+
+	sig := datagen.GenRandomByteArray(r, 64)
+	sigWithSigHash := append(datagen.GenRandomByteArray(r, 64), byte(txscript.SigHashAll))
+	witness, err := btcstaking.CreateWitness(si, [][]byte{sig, {}, sigWithSigHash, sig})
+	require.NoError(t, err)
+
+	text := btcstaking.WitnessToAuditText(witness, 3)
+	require.Equal(t, text, btcstaking.WitnessToAuditText(witness, 3))
+
+	require.Contains(t, text, "signatures: 3 signed, 3 expected [OK]")
+	require.Contains(t, text, "  slot 0: SIGNED "+hex.EncodeToString(sig)+" sighash=default\n")
+	require.Contains(t, text, "  slot 1: EMPTY\n")
+	require.Contains(t, text, "  slot 2: SIGNED "+hex.EncodeToString(sigWithSigHash[:64])+" sighash=0x01\n")
+	require.Contains(t, text, "  OP_CHECKSIGVERIFY\n")
+	require.Contains(t, text, "  OP_NUMEQUAL\n")
+	require.Contains(t, text, "  internal key: "+hex.EncodeToString(schnorr.SerializePubKey(si.ControlBlock.InternalKey)))
+	require.Contains(t, text, "  merkle branch: 2 nodes\n")
+	require.Contains(t, text, "    node 0: "+hex.EncodeToString(si.ControlBlock.InclusionProof[:32]))
+	require.NotContains(t, text, "annex")
+
+	require.Contains(t, btcstaking.WitnessToAuditText(witness, 4), "signatures: 3 signed, 4 expected [MISMATCH]")
+
+	// different witnesses render differently
+	otherWitness, err := btcstaking.CreateWitness(si, [][]byte{{}, sig, sigWithSigHash, sig})
+	require.NoError(t, err)
+	require.NotEqual(t, text, btcstaking.WitnessToAuditText(otherWitness, 3))
+
+	invalidCb := append(wire.TxWitness{}, witness...)
+	invalidCb[len(invalidCb)-1] = []byte{0xc0, 0x01}
+	require.Contains(t, btcstaking.WitnessToAuditText(invalidCb, 3), "INVALID CONTROL BLOCK")
+
+	require.Contains(t, btcstaking.WitnessToAuditText(wire.TxWitness{sig}, 1), "INVALID WITNESS")
+}
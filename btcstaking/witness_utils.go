@@ -1,10 +1,17 @@
 package btcstaking
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"sort"
+	"sync"
 
+	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 )
 
 func (si *SpendInfo) CreateTimeLockPathWitness(delegatorSig *schnorr.Signature) (wire.TxWitness, error) {
@@ -17,10 +24,106 @@ func (si *SpendInfo) CreateTimeLockPathWitness(delegatorSig *schnorr.Signature)
 	return CreateWitness(si, [][]byte{delegatorSig.Serialize()})
 }
 
+// CreateTimeLockPathWitnessStrict creates a witness spending the time lock path,
+// like CreateTimeLockPathWitness, after checking that the input with index
+// inputIdx of the spending tx can satisfy the time lock, i.e the tx has version
+// 2 or higher (see RequireTxVersion2) and the input sequence enforces the time
+// lock of the script. It applies to time lock leaves of both the staking and
+// the unbonding outputs.
+func (si *SpendInfo) CreateTimeLockPathWitnessStrict(
+	tx *wire.MsgTx,
+	inputIdx int,
+	delegatorSig *schnorr.Signature,
+) (wire.TxWitness, error) {
+	if si == nil {
+		panic("cannot build witness without spend info")
+	}
+
+	if tx == nil {
+		return nil, fmt.Errorf("tx must not be nil")
+	}
+
+	if inputIdx < 0 || inputIdx >= len(tx.TxIn) {
+		return nil, fmt.Errorf("input index %d out of range, tx has %d inputs", inputIdx, len(tx.TxIn))
+	}
+
+	timelock, err := ExtractTimelock(si.GetPkScriptPath())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateTimelockSequence(tx, inputIdx, timelock); err != nil {
+		return nil, err
+	}
+
+	return si.CreateTimeLockPathWitness(delegatorSig)
+}
+
+// CreateTimeLockPathWitnessAnyoneCanPay creates a witness spending the time lock
+// path with a delegator signature over the SIGHASH_ALL|ANYONECANPAY signature
+// hash. Such a signature commits only to its own input and to all outputs, so
+// the staker can add inputs to bump the fee of the withdrawal without
+// invalidating it.
+// delegatorSig is either a 64 bytes signature, to which the sighash byte is
+// appended, or a 65 bytes signature which must already carry the
+// SIGHASH_ALL|ANYONECANPAY byte. It is up to the caller to ensure a 64 bytes
+// signature was created over the SIGHASH_ALL|ANYONECANPAY signature hash.
+func (si *SpendInfo) CreateTimeLockPathWitnessAnyoneCanPay(delegatorSig []byte) (wire.TxWitness, error) {
+	if si == nil {
+		panic("cannot build witness without spend info")
+	}
+
+	sigHashType := txscript.SigHashAll | txscript.SigHashAnyOneCanPay
+
+	var sig []byte
+	switch len(delegatorSig) {
+	case schnorr.SignatureSize:
+		sig = make([]byte, 0, schnorr.SignatureSize+1)
+		sig = append(sig, delegatorSig...)
+		sig = append(sig, byte(sigHashType))
+	case schnorr.SignatureSize + 1:
+		if txscript.SigHashType(delegatorSig[schnorr.SignatureSize]) != sigHashType {
+			return nil, fmt.Errorf(
+				"%w: sighash type 0x%x, expected 0x%x",
+				ErrInvalidDelegatorSignature, delegatorSig[schnorr.SignatureSize], byte(sigHashType),
+			)
+		}
+		sig = delegatorSig
+	default:
+		return nil, fmt.Errorf("%w: invalid signature length %d", ErrInvalidDelegatorSignature, len(delegatorSig))
+	}
+
+	return CreateWitness(si, [][]byte{sig})
+}
+
+// CreateRecoveryPathWitness creates a witness spending the recovery leaf built by
+// BuildSpendInfosWithRecovery. The recovery leaf has the shape of the time lock
+// leaf, so the spend info must reveal a script requiring only the staker
+// signature after a relative time lock. The input spending the recovery leaf
+// must have the sequence returned by ComputeInputSequence.
+func (si *SpendInfo) CreateRecoveryPathWitness(delegatorSig *schnorr.Signature) (wire.TxWitness, error) {
+	if si == nil {
+		panic("cannot build witness without spend info")
+	}
+
+	if delegatorSig == nil {
+		return nil, fmt.Errorf("delegator signature should not be nil")
+	}
+
+	if path, err := SpendPathFromScript(si.GetPkScriptPath()); err != nil || path != TimeLockPath {
+		return nil, fmt.Errorf("spend info does not describe recovery path")
+	}
+
+	return CreateWitness(si, [][]byte{delegatorSig.Serialize()})
+}
+
 // CreateUnbondingPathWitness helper function to create a witness to spend
 // transaction through the unbonding path.
 // It is up to the caller to ensure that the amount of covenantSigs matches the
 // expected quorum of covenenant members and the transaction has unbonding path.
+// covenantSigs must contain one slot per covenant key of the leaf, with nil
+// for members which did not sign. The signatures are serialized directly into
+// the preallocated signature stack, which is then passed to CreateWitness.
 func (si *SpendInfo) CreateUnbondingPathWitness(
 	covenantSigs []*schnorr.Signature,
 	delegatorSig *schnorr.Signature,
@@ -29,26 +132,27 @@ func (si *SpendInfo) CreateUnbondingPathWitness(
 		panic("cannot build witness without spend info")
 	}
 
-	var witnessStack [][]byte
-
 	// add covenant signatures to witness stack
 	// NOTE: only a quorum number of covenant signatures needs to be non-nil
 	if len(covenantSigs) == 0 {
 		return nil, fmt.Errorf("covenant signatures should not be empty")
 	}
-	for _, covSig := range covenantSigs {
+
+	if delegatorSig == nil {
+		return nil, fmt.Errorf("delegator signature should not be nil")
+	}
+
+	witnessStack := make([][]byte, len(covenantSigs)+1)
+	for i, covSig := range covenantSigs {
 		if covSig == nil {
-			witnessStack = append(witnessStack, []byte{})
+			witnessStack[i] = []byte{}
 		} else {
-			witnessStack = append(witnessStack, covSig.Serialize())
+			witnessStack[i] = covSig.Serialize()
 		}
 	}
 
 	// add delegator signature to witness stack
-	if delegatorSig == nil {
-		return nil, fmt.Errorf("delegator signature should not be nil")
-	}
-	witnessStack = append(witnessStack, delegatorSig.Serialize())
+	witnessStack[len(covenantSigs)] = delegatorSig.Serialize()
 
 	return CreateWitness(si, witnessStack)
 }
@@ -58,6 +162,8 @@ func (si *SpendInfo) CreateUnbondingPathWitness(
 // It is up to the caller to ensure that the amount of covenantSigs matches the
 // expected quorum of covenenant members, the finality provider sigs respect the finality providers
 // that the delegation belongs to, and the transaction has slashing path.
+// The number of fpSigs must be equal to the number of finality providers in
+// the script, with empty slots for finality providers which did not sign.
 func (si *SpendInfo) CreateSlashingPathWitness(
 	covenantSigs []*schnorr.Signature,
 	fpSigs []*schnorr.Signature,
@@ -87,6 +193,20 @@ func (si *SpendInfo) CreateSlashingPathWitness(
 	if len(fpSigs) == 0 {
 		return nil, fmt.Errorf("finality provider signatures should not be empty")
 	}
+	// every finality provider in the script must have a signature slot, otherwise
+	// signatures are shifted against the keys and the witness is invalid
+	segments, err := countKeysPerSegment(si.GetPkScriptPath())
+	if err != nil {
+		return nil, err
+	}
+	// slashing script has staker, finality providers and covenant segments
+	if len(segments) != 3 {
+		return nil, fmt.Errorf("spend info does not describe slashing path")
+	}
+	if len(fpSigs) != segments[1] {
+		return nil, fmt.Errorf("%w: got %d signatures, script has %d finality providers",
+			ErrFinalityProviderSigsMismatch, len(fpSigs), segments[1])
+	}
 	for _, fpSig := range fpSigs {
 		if fpSig == nil {
 			witnessStack = append(witnessStack, []byte{})
@@ -109,10 +229,23 @@ func (si *SpendInfo) CreateSlashingPathWitness(
 // The returned witness stack follows the structure below:
 // - first come signatures
 // - then whole revealed script
-// - then control block
+// - then control block, only for taproot outputs
 func CreateWitness(si *SpendInfo, signatures [][]byte) (wire.TxWitness, error) {
 	numSignatures := len(signatures)
 
+	if si.WitnessVersion() == WitnessV0 {
+		// P2WSH witness has no control block, and its ECDSA signatures can't be
+		// checked as Babylon taproot signatures
+		witnessStack := wire.TxWitness(make([][]byte, numSignatures+1))
+		copy(witnessStack, signatures)
+		witnessStack[numSignatures] = si.GetPkScriptPath()
+		return witnessStack, nil
+	}
+
+	if err := checkFpSigHashes(si.GetPkScriptPath(), signatures); err != nil {
+		return nil, err
+	}
+
 	controlBlockBytes, err := si.ControlBlock.ToBytes()
 	if err != nil {
 		return nil, err
@@ -134,3 +267,1036 @@ func CreateWitness(si *SpendInfo, signatures [][]byte) (wire.TxWitness, error) {
 
 	return witnessStack, nil
 }
+
+// ValidateFpSigHash checks that the finality provider signature, as it appears
+// in the witness, commits to the whole slashing tx. The signature of a finality
+// provider authorizes slashing, so with SIGHASH_NONE, SIGHASH_SINGLE or
+// SIGHASH_ANYONECANPAY anyone could redirect the slashed funds or change the
+// slashing tx inputs. Only 64 bytes signatures (SIGHASH_DEFAULT) and 65 bytes
+// signatures with SIGHASH_ALL are accepted. Empty placeholders are accepted.
+func ValidateFpSigHash(sig []byte) error {
+	switch len(sig) {
+	case 0, schnorr.SignatureSize:
+		return nil
+	case schnorr.SignatureSize + 1:
+		sigHashType := txscript.SigHashType(sig[schnorr.SignatureSize])
+		if sigHashType != txscript.SigHashAll {
+			return fmt.Errorf("%w: sighash type 0x%x", ErrUnsafeFpSigHash, byte(sigHashType))
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid signature length %d", len(sig))
+	}
+}
+
+// checkFpSigHashes validates sighash types of the finality provider signatures
+// if the script is a slashing script and the witness stack has a signature slot
+// for every key of the script. The script is only parsed if any signature carries
+// a sighash byte, as 64 bytes signatures always commit to the whole tx.
+func checkFpSigHashes(script []byte, signatures [][]byte) error {
+	withSigHash := false
+	for _, sig := range signatures {
+		if len(sig) == schnorr.SignatureSize+1 {
+			withSigHash = true
+			break
+		}
+	}
+
+	if !withSigHash {
+		return nil
+	}
+
+	segments, err := countKeysPerSegment(script)
+	// slashing script has staker, finality providers and covenant segments
+	if err != nil || len(segments) != 3 {
+		return nil
+	}
+
+	numKeys := segments[0] + segments[1] + segments[2]
+	if len(signatures) != numKeys {
+		return nil
+	}
+
+	// signatures are in the reverse order of keys in the witness
+	for i := segments[0]; i < segments[0]+segments[1]; i++ {
+		if err := ValidateFpSigHash(signatures[numKeys-1-i]); err != nil {
+			return fmt.Errorf("finality provider signature %d: %w", i-segments[0], err)
+		}
+	}
+
+	return nil
+}
+
+// ValidateWitness executes the witness of the input with the given index of the
+// transaction against the given previous output with the script engine, using
+// standard verification flags. The witness is checked as if it was set on the
+// input, without modifying the transaction.
+// The given previous output is used as the output spent by every input of the
+// transaction, so signatures committing to all spent outputs only validate in
+// transactions spending a single input, as Babylon unbonding, slashing and
+// withdrawal transactions do.
+func ValidateWitness(prevOut *wire.TxOut, tx *wire.MsgTx, inputIdx int, witness wire.TxWitness) error {
+	if prevOut == nil {
+		return fmt.Errorf("previous output must not be nil")
+	}
+
+	if tx == nil {
+		return fmt.Errorf("tx must not be nil")
+	}
+
+	if inputIdx < 0 || inputIdx >= len(tx.TxIn) {
+		return fmt.Errorf("input index %d out of range, tx has %d inputs", inputIdx, len(tx.TxIn))
+	}
+
+	// only the validated input is copied, so the witness of the caller's
+	// transaction is left untouched
+	txWithWitness := *tx
+	txWithWitness.TxIn = append([]*wire.TxIn(nil), tx.TxIn...)
+	in := *tx.TxIn[inputIdx]
+	in.Witness = witness
+	txWithWitness.TxIn[inputIdx] = &in
+
+	fetcher := txscript.NewCannedPrevOutputFetcher(prevOut.PkScript, prevOut.Value)
+
+	engine, err := txscript.NewEngine(
+		prevOut.PkScript, &txWithWitness, inputIdx, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(&txWithWitness, fetcher), prevOut.Value, fetcher,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create script engine: %w", err)
+	}
+
+	if err := engine.Execute(); err != nil {
+		return fmt.Errorf("witness does not spend the output: %w", err)
+	}
+
+	return nil
+}
+
+// MatchPrevOut returns the index of the first of the candidate outputs which the
+// witness spends when attached to the input with index inputIdx of the given
+// transaction. It helps to reconstruct which output was spent when the outpoint
+// of the input cannot be resolved unambiguously. Every candidate is validated
+// with ValidateWitness, so the cost grows linearly with the number of candidates.
+func MatchPrevOut(tx *wire.MsgTx, inputIdx int, witness wire.TxWitness, candidates []*wire.TxOut) (int, error) {
+	if tx == nil {
+		return -1, fmt.Errorf("tx must not be nil")
+	}
+
+	if inputIdx < 0 || inputIdx >= len(tx.TxIn) {
+		return -1, fmt.Errorf("input index %d out of range, tx has %d inputs", inputIdx, len(tx.TxIn))
+	}
+
+	for i, candidate := range candidates {
+		if candidate == nil {
+			continue
+		}
+
+		if err := ValidateWitness(candidate, tx, inputIdx, witness); err == nil {
+			return i, nil
+		}
+	}
+
+	return -1, fmt.Errorf("witness does not spend any of %d candidate outputs", len(candidates))
+}
+
+// ValidationJob is a single witness validated by ValidateWitnessesParallel
+type ValidationJob struct {
+	// Tx is the transaction spending the output
+	Tx *wire.MsgTx
+	// InputIdx is the index of the input spending the output
+	InputIdx int
+	// PrevOut is the output spent by the input
+	PrevOut *wire.TxOut
+	// Witness is the witness of the input
+	Witness wire.TxWitness
+}
+
+// parallelFor calls fn for every index in [0, n) using the given number of
+// workers, and returns once all calls are done. If workers is lower than 1, a
+// single worker is used. Every index is passed to exactly one call, so fn can
+// write the result of index i to the i-th element of a preallocated slice
+// without synchronization.
+func parallelFor(n, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	if workers > n {
+		workers = n
+	}
+
+	idxs := make(chan int)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+
+			for i := range idxs {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		idxs <- i
+	}
+	close(idxs)
+
+	wg.Wait()
+}
+
+// ValidateWitnessesParallel validates the witnesses of all the jobs with
+// ValidateWitness, using the given number of workers. It returns the result of
+// every job in the order of jobs, with nil for valid witnesses. If workers is
+// lower than 1, a single worker is used.
+// Transactions shared between jobs are only read, so jobs can validate
+// different inputs of the same transaction.
+func ValidateWitnessesParallel(jobs []ValidationJob, workers int) []error {
+	errs := make([]error, len(jobs))
+
+	parallelFor(len(jobs), workers, func(i int) {
+		job := jobs[i]
+		errs[i] = ValidateWitness(job.PrevOut, job.Tx, job.InputIdx, job.Witness)
+	})
+
+	return errs
+}
+
+// CreateWitnessValidated creates the witness in the same way as CreateWitness and
+// validates it with ValidateWitness, returning an error if the witness would
+// not spend the given previous output. Executing the scripts makes it much
+// slower than CreateWitness, so it is meant for integrators which prefer paying
+// the validation cost over broadcasting invalid transactions, and should not be
+// used on hot paths.
+func CreateWitnessValidated(
+	prevOut *wire.TxOut,
+	tx *wire.MsgTx,
+	inputIdx int,
+	si *SpendInfo,
+	sigs [][]byte,
+) (wire.TxWitness, error) {
+	if si == nil {
+		return nil, fmt.Errorf("spend info must not be nil")
+	}
+
+	witness, err := CreateWitness(si, sigs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ValidateWitness(prevOut, tx, inputIdx, witness); err != nil {
+		return nil, err
+	}
+
+	return witness, nil
+}
+
+// FinalizeSpend completes the witness template, in which covenant members and
+// finality providers already filled their signatures, with the delegator
+// signature, and returns copy of the transaction with the completed witness
+// attached to the input with index inputIdx. It models the usual signing order
+// in which the delegator signs last. delegatorSlot is the index of the
+// delegator signature in the template, which must be an empty placeholder,
+// e.g the slot produced by ReorderSigsForLeaf for a delegator without signature.
+// The template is not modified.
+func FinalizeSpend(
+	tx *wire.MsgTx,
+	inputIdx int,
+	template wire.TxWitness,
+	delegatorSig *schnorr.Signature,
+	delegatorSlot int,
+) (*wire.MsgTx, error) {
+	if tx == nil {
+		return nil, fmt.Errorf("tx must not be nil")
+	}
+
+	if inputIdx < 0 || inputIdx >= len(tx.TxIn) {
+		return nil, fmt.Errorf("input index %d out of range, tx has %d inputs", inputIdx, len(tx.TxIn))
+	}
+
+	if delegatorSig == nil {
+		return nil, fmt.Errorf("delegator signature should not be nil")
+	}
+
+	// the last two elements of the template are the script and the control block
+	if len(template) < 3 {
+		return nil, fmt.Errorf("witness template must have at least 3 elements, got %d", len(template))
+	}
+
+	if delegatorSlot < 0 || delegatorSlot >= len(template)-2 {
+		return nil, fmt.Errorf(
+			"delegator slot %d out of range, template has %d signature slots", delegatorSlot, len(template)-2,
+		)
+	}
+
+	if len(template[delegatorSlot]) != 0 {
+		return nil, fmt.Errorf("delegator slot %d is not an empty placeholder", delegatorSlot)
+	}
+
+	witness := make(wire.TxWitness, len(template))
+	copy(witness, template)
+	witness[delegatorSlot] = delegatorSig.Serialize()
+
+	finalTx := tx.Copy()
+	finalTx.TxIn[inputIdx].Witness = witness
+
+	return finalTx, nil
+}
+
+// ReorderSigsForLeaf orders the given signatures in the order expected by the
+// witness of the given leaf script. The order is read directly from the public
+// keys committed in the leaf, so the same signature map can be safely used for
+// different leaves even if they list keys in a different order.
+// Signatures are keyed by hex encoded BIP340 public key of the signer. Keys
+// from the leaf without signature get an empty placeholder, and signatures of
+// keys not present in the leaf are ignored.
+// The returned stack can be passed directly to CreateWitness.
+func ReorderSigsForLeaf(
+	sigs map[string]*schnorr.Signature,
+	leafScript []byte,
+) ([][]byte, error) {
+	keys, err := ExtractScriptPubKeys(leafScript)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("leaf script does not contain any public keys")
+	}
+
+	// keys are checked in script order, so signature of the first key in
+	// the script must be on top of the stack i.e last in the witness
+	orderedSigs := make([][]byte, len(keys))
+	found := 0
+
+	for i, key := range keys {
+		witnessIdx := len(keys) - 1 - i
+
+		sig, ok := sigs[keyToString(key)]
+		if !ok || sig == nil {
+			orderedSigs[witnessIdx] = []byte{}
+			continue
+		}
+
+		orderedSigs[witnessIdx] = sig.Serialize()
+		found++
+	}
+
+	if found == 0 {
+		return nil, fmt.Errorf("none of the provided signatures belongs to keys in the leaf script")
+	}
+
+	return orderedSigs, nil
+}
+
+// SafeCreateWitness is the same as CreateWitness, but it converts any panic
+// raised while building the witness (e.g. on nil spend info) into an error.
+// It should be used whenever inputs come from untrusted sources, like rpc
+// requests. Internal callers which guarantee non-nil inputs can keep using the
+// panicking builders.
+func SafeCreateWitness(si *SpendInfo, signatures [][]byte) (witness wire.TxWitness, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			witness = nil
+			err = fmt.Errorf("failed to create witness: %v", r)
+		}
+	}()
+
+	if si == nil {
+		panic("cannot build witness without spend info")
+	}
+
+	return CreateWitness(si, signatures)
+}
+
+// CreateWitnessWithSighash creates witness in the same way as CreateWitness,
+// but appends the given sighash type to every non-empty signature, as required
+// by BIP341 for signatures with sighash type other than SIGHASH_DEFAULT.
+// The sighash type must be allowed for the given spend path by AllowedSigHashTypes.
+// Signatures must be 64 bytes BIP340 signatures without sighash byte.
+func CreateWitnessWithSighash(
+	si *SpendInfo,
+	path SpendPath,
+	signatures [][]byte,
+	sigHashType txscript.SigHashType,
+) (wire.TxWitness, error) {
+	if si == nil {
+		panic("cannot build witness without spend info")
+	}
+
+	if err := ValidateSigHashForPath(path, sigHashType); err != nil {
+		return nil, err
+	}
+
+	sigs := make([][]byte, len(signatures))
+
+	for i, sig := range signatures {
+		withSigHash, err := appendSigHashByte(i, sig, sigHashType)
+		if err != nil {
+			return nil, err
+		}
+		sigs[i] = withSigHash
+	}
+
+	return CreateWitness(si, sigs)
+}
+
+// appendSigHashByte returns the i-th witness signature with the sighash byte
+// appended, unless the signature is an empty placeholder or the sighash type
+// is SIGHASH_DEFAULT
+func appendSigHashByte(i int, sig []byte, sigHashType txscript.SigHashType) ([]byte, error) {
+	switch {
+	case len(sig) == 0:
+		return []byte{}, nil
+	case len(sig) != schnorr.SignatureSize:
+		return nil, fmt.Errorf("signature %d must have %d bytes, got %d", i, schnorr.SignatureSize, len(sig))
+	case sigHashType == txscript.SigHashDefault:
+		return sig, nil
+	default:
+		return append(append(make([]byte, 0, len(sig)+1), sig...), byte(sigHashType)), nil
+	}
+}
+
+// SigHashesByCategory contains the sighash types of signatures of each category
+// of signers of a Babylon leaf. Categories absent from the leaf are ignored.
+type SigHashesByCategory struct {
+	Covenant         txscript.SigHashType
+	FinalityProvider txscript.SigHashType
+	Delegator        txscript.SigHashType
+}
+
+// CreateWitnessWithCategorySighashes creates witness in the same way as
+// CreateWitnessWithSighash, but appends to the signatures of every category of
+// signers its own sighash type, e.g in slashing designs where covenant members
+// sign with SIGHASH_ALL while finality providers use SIGHASH_DEFAULT.
+// Categories are determined from the signature check segments of the revealed
+// script, so the witness stack must have a slot for every key of the script.
+// Every sighash type must be allowed for the given spend path by
+// AllowedSigHashTypes, and finality provider sighash type must also pass
+// ValidateFpSigHash.
+func CreateWitnessWithCategorySighashes(
+	si *SpendInfo,
+	path SpendPath,
+	signatures [][]byte,
+	sigHashes SigHashesByCategory,
+) (wire.TxWitness, error) {
+	if si == nil {
+		panic("cannot build witness without spend info")
+	}
+
+	segments, err := countKeysPerSegment(si.GetPkScriptPath())
+	if err != nil {
+		return nil, err
+	}
+
+	// segments follow the script order: staker, finality providers if the
+	// leaf is slashing leaf, and covenant committee
+	var segmentSigHashes []txscript.SigHashType
+	switch len(segments) {
+	case 1:
+		segmentSigHashes = []txscript.SigHashType{sigHashes.Delegator}
+	case 2:
+		segmentSigHashes = []txscript.SigHashType{sigHashes.Delegator, sigHashes.Covenant}
+	case 3:
+		segmentSigHashes = []txscript.SigHashType{sigHashes.Delegator, sigHashes.FinalityProvider, sigHashes.Covenant}
+	default:
+		return nil, fmt.Errorf("script has %d signature checks, expected Babylon leaf script", len(segments))
+	}
+
+	numKeys := 0
+	for i, numSegmentKeys := range segments {
+		if err := ValidateSigHashForPath(path, segmentSigHashes[i]); err != nil {
+			return nil, err
+		}
+		numKeys += numSegmentKeys
+	}
+
+	if len(signatures) != numKeys {
+		return nil, fmt.Errorf("got %d signatures, script has %d keys", len(signatures), numKeys)
+	}
+
+	sigs := make([][]byte, len(signatures))
+
+	// signatures are in the reverse order of keys in the witness
+	keyIdx := 0
+	for segmentIdx, numSegmentKeys := range segments {
+		for j := 0; j < numSegmentKeys; j++ {
+			slot := numKeys - 1 - keyIdx
+			withSigHash, err := appendSigHashByte(slot, signatures[slot], segmentSigHashes[segmentIdx])
+			if err != nil {
+				return nil, err
+			}
+			sigs[slot] = withSigHash
+			keyIdx++
+		}
+	}
+
+	return CreateWitness(si, sigs)
+}
+
+// VerifyDelegatorSig verifies that the delegator signature over the given sighash
+// was produced by the staker key. As the delegator signature is required by
+// every spend path, it should be checked before building any path witness.
+func VerifyDelegatorSig(
+	delegatorSig *schnorr.Signature,
+	stakerPk *btcec.PublicKey,
+	sigHash []byte,
+) error {
+	if delegatorSig == nil {
+		return fmt.Errorf("%w: signature is nil", ErrInvalidDelegatorSignature)
+	}
+
+	if stakerPk == nil {
+		return fmt.Errorf("staker public key must not be nil")
+	}
+
+	if !delegatorSig.Verify(sigHash, stakerPk) {
+		return fmt.Errorf(
+			"%w: signature does not verify under staker key %s",
+			ErrInvalidDelegatorSignature,
+			keyToString(stakerPk),
+		)
+	}
+
+	return nil
+}
+
+// VerifyTimelockSigner checks that the time lock leaf described by the spend
+// info is spendable by the given staker key. It should be called before signing
+// the withdrawal, as signatures of any other key, e.g. wrongly derived by the
+// wallet, are rejected only once the transaction is broadcast.
+func VerifyTimelockSigner(si *SpendInfo, stakerPk *btcec.PublicKey) error {
+	if si == nil {
+		return fmt.Errorf("spend info must not be nil")
+	}
+
+	if stakerPk == nil {
+		return fmt.Errorf("staker public key must not be nil")
+	}
+
+	script := si.GetPkScriptPath()
+
+	if _, err := ExtractTimelock(script); err != nil {
+		return fmt.Errorf("spend info does not describe time lock path: %w", err)
+	}
+
+	keys, err := ExtractScriptPubKeys(script)
+	if err != nil {
+		return err
+	}
+
+	if len(keys) != 1 {
+		return fmt.Errorf("time lock script must have exactly one key, got %d", len(keys))
+	}
+
+	if keyToString(keys[0]) != keyToString(stakerPk) {
+		return fmt.Errorf(
+			"%w: time lock script requires key %s, got %s",
+			ErrWrongSigningKey, keyToString(keys[0]), keyToString(stakerPk),
+		)
+	}
+
+	return nil
+}
+
+// CreateWitnessWithControlBlockBytes creates witness for spending through the
+// given script path using already serialized control block, e.g. taken from
+// a PSBT field. The control block is validated to parse correctly.
+// CreateWitness remains the primary way to build witnesses when SpendInfo is
+// available.
+func CreateWitnessWithControlBlockBytes(
+	scriptPath []byte,
+	controlBlock []byte,
+	signatures [][]byte,
+) (wire.TxWitness, error) {
+	if len(scriptPath) == 0 {
+		return nil, fmt.Errorf("script path must not be empty")
+	}
+
+	parsedControlBlock, err := parseControlBlock(controlBlock)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control block: %w", err)
+	}
+
+	si := &SpendInfo{
+		ControlBlock: *parsedControlBlock,
+		RevealedLeaf: txscript.NewTapLeaf(parsedControlBlock.LeafVersion, scriptPath),
+	}
+
+	return CreateWitness(si, signatures)
+}
+
+// CreateWitnessWithLeafVersion creates witness for spending through the script
+// path of the given spend info, using the provided leaf version instead of the
+// one stored in the spend info, e.g. to test leaf versions of upcoming tapscript
+// upgrades without rebuilding spend infos. Both the control block version byte
+// and the leaf hash are recomputed with the override, while the merkle branch
+// of the stored control block is kept.
+// As the leaf version is committed in the leaf hash, the override changes the
+// merkle root and the output key, so outputKey must be the output key of the
+// spent output, committing to the leaf under the overridden version. An error is
+// returned if the overridden leaf and the stored merkle branch do not lead to
+// it. The output key parity of the control block is set from the recomputed
+// key. CreateWitness uses the stored version.
+func CreateWitnessWithLeafVersion(
+	si *SpendInfo,
+	signatures [][]byte,
+	leafVersion byte,
+	outputKey *btcec.PublicKey,
+) (wire.TxWitness, error) {
+	if si == nil {
+		return nil, fmt.Errorf("spend info must not be nil")
+	}
+
+	if outputKey == nil {
+		return nil, fmt.Errorf("output key must not be nil")
+	}
+
+	// lowest bit of the control block first byte is the output key parity, and
+	// 0x50 would be confused with the annex tag
+	if leafVersion&0x01 != 0 || leafVersion == txscript.TaprootAnnexTag {
+		return nil, fmt.Errorf("invalid leaf version 0x%x", leafVersion)
+	}
+
+	if si.ControlBlock.InternalKey == nil {
+		return nil, fmt.Errorf("control block internal key is nil")
+	}
+
+	overridden := &SpendInfo{
+		ControlBlock: si.ControlBlock,
+		RevealedLeaf: txscript.NewTapLeaf(txscript.TapscriptLeafVersion(leafVersion), si.RevealedLeaf.Script),
+	}
+	overridden.ControlBlock.LeafVersion = txscript.TapscriptLeafVersion(leafVersion)
+
+	overriddenRoot := overridden.ControlBlock.RootHash(overridden.RevealedLeaf.Script)
+	overriddenKey := txscript.ComputeTaprootOutputKey(overridden.ControlBlock.InternalKey, overriddenRoot)
+
+	if !bytes.Equal(schnorr.SerializePubKey(overriddenKey), schnorr.SerializePubKey(outputKey)) {
+		return nil, fmt.Errorf(
+			"%w: leaf version 0x%x with the stored merkle branch does not lead to output key %s",
+			ErrScriptNotCommitted, leafVersion, keyToString(outputKey),
+		)
+	}
+
+	overridden.ControlBlock.OutputKeyYIsOdd = overriddenKey.SerializeCompressed()[0] == secp256k1.PubKeyFormatCompressedOdd
+
+	return CreateWitness(overridden, signatures)
+}
+
+// splitWitnessSig splits the witness signature into BIP340 signature and its
+// sighash type. 64 bytes signatures use SIGHASH_DEFAULT.
+func splitWitnessSig(sig []byte) (*schnorr.Signature, txscript.SigHashType, error) {
+	sigHashType := txscript.SigHashDefault
+
+	switch len(sig) {
+	case schnorr.SignatureSize:
+	case schnorr.SignatureSize + 1:
+		sigHashType = txscript.SigHashType(sig[schnorr.SignatureSize])
+		sig = sig[:schnorr.SignatureSize]
+	default:
+		return nil, 0, fmt.Errorf("invalid signature length %d", len(sig))
+	}
+
+	parsed, err := schnorr.ParseSignature(sig)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return parsed, sigHashType, nil
+}
+
+// RecomputeAffectedWitnesses re-signs the inputs of the transaction whose
+// witnesses were invalidated by a structural edit, e.g. adding an input to
+// anchor the unbonding transaction with CPFP. infos maps index of every input
+// spent through a script path to its spend info. prevOuts must contain the
+// outputs spent by every input of the transaction, in input order.
+// For every input in infos, the signature of the signer is verified against
+// the current sighash, using the sighash type of the existing signature, and
+// replaced with a fresh one if it does not verify anymore. Inputs without
+// witness or without signer key in their leaf are skipped. Witnesses with annex
+// are not supported.
+// Inputs are processed in index order and new witnesses are attached only once
+// all of them are built, so on any other error the tx is left unchanged.
+// Other signatures can't be recomputed by the signer, so an error listing the
+// inputs with stale signatures of other keys is returned after all signer
+// witnesses are updated.
+func RecomputeAffectedWitnesses(
+	tx *wire.MsgTx,
+	infos map[int]*SpendInfo,
+	prevOuts []*wire.TxOut,
+	signer Signer,
+) error {
+	if tx == nil {
+		return fmt.Errorf("tx must not be nil")
+	}
+
+	if signer == nil {
+		return fmt.Errorf("signer must not be nil")
+	}
+
+	if _, err := newPrevOutFetcher(tx, prevOuts); err != nil {
+		return err
+	}
+
+	inputIdxs := make([]int, 0, len(infos))
+	for inputIdx, si := range infos {
+		if inputIdx < 0 || inputIdx >= len(tx.TxIn) {
+			return fmt.Errorf("invalid input index %d, tx has %d inputs", inputIdx, len(tx.TxIn))
+		}
+
+		if si == nil {
+			return fmt.Errorf("spend info of input %d must not be nil", inputIdx)
+		}
+
+		inputIdxs = append(inputIdxs, inputIdx)
+	}
+	sort.Ints(inputIdxs)
+
+	signerKey := keyToString(signer.PubKey())
+
+	var staleInputs []int
+	// witnesses are replaced only after all of them are built, so the tx is not
+	// left partially re-signed on error
+	newWitnesses := make(map[int]wire.TxWitness)
+
+	for _, inputIdx := range inputIdxs {
+		si := infos[inputIdx]
+		witness := tx.TxIn[inputIdx].Witness
+		if len(witness) == 0 {
+			continue
+		}
+
+		parsed, err := ParseWitness(witness)
+		if err != nil {
+			return fmt.Errorf("invalid witness of input %d: %w", inputIdx, err)
+		}
+
+		// annex is committed in the sighash, but TaprootSigHash does not
+		// account for it
+		if parsed.Annex != nil {
+			return fmt.Errorf("witness of input %d has annex, which is not supported", inputIdx)
+		}
+
+		keys, err := ExtractScriptPubKeys(si.GetPkScriptPath())
+		if err != nil {
+			return err
+		}
+
+		if len(keys) != len(parsed.Signatures) {
+			return fmt.Errorf(
+				"witness of input %d has %d signatures, leaf has %d keys",
+				inputIdx, len(parsed.Signatures), len(keys),
+			)
+		}
+
+		var (
+			newSigs = make([][]byte, len(parsed.Signatures))
+			changed bool
+			stale   bool
+		)
+		copy(newSigs, parsed.Signatures)
+
+		for i, key := range keys {
+			// signature of the first key in the script is last in the witness
+			witnessIdx := len(keys) - 1 - i
+			rawSig := parsed.Signatures[witnessIdx]
+
+			if len(rawSig) == 0 {
+				continue
+			}
+
+			sig, sigHashType, err := splitWitnessSig(rawSig)
+			if err != nil {
+				return fmt.Errorf("invalid signature in witness of input %d: %w", inputIdx, err)
+			}
+
+			sigHash, err := TaprootSigHash(tx, inputIdx, prevOuts, si, sigHashType)
+			if err != nil {
+				return err
+			}
+
+			if sig.Verify(sigHash, key) {
+				continue
+			}
+
+			if keyToString(key) != signerKey {
+				stale = true
+				continue
+			}
+
+			newSig, err := signer.Sign(sigHash)
+			if err != nil {
+				return fmt.Errorf("failed to sign input %d: %w", inputIdx, err)
+			}
+
+			newSigs[witnessIdx] = newSig.Serialize()
+			if sigHashType != txscript.SigHashDefault {
+				newSigs[witnessIdx] = append(newSigs[witnessIdx], byte(sigHashType))
+			}
+			changed = true
+		}
+
+		if stale {
+			staleInputs = append(staleInputs, inputIdx)
+		}
+
+		if !changed {
+			continue
+		}
+
+		newWitness, err := CreateWitness(si, newSigs)
+		if err != nil {
+			return err
+		}
+
+		newWitnesses[inputIdx] = newWitness
+	}
+
+	for inputIdx, witness := range newWitnesses {
+		tx.TxIn[inputIdx].Witness = witness
+	}
+
+	if len(staleInputs) > 0 {
+		return fmt.Errorf("inputs %v have stale signatures of other signers", staleInputs)
+	}
+
+	return nil
+}
+
+// WriteWitness serializes the witness spending through the script path of the
+// given spend info directly to w, in the same consensus format as
+// wire.TxWitness is serialized in transactions, without materializing the
+// witness stack. The layout and the checks follow CreateWitness for the
+// witness version of the spend info. It returns the number of written bytes.
+func WriteWitness(w io.Writer, si *SpendInfo, signatures [][]byte) (int, error) {
+	if si == nil {
+		return 0, fmt.Errorf("spend info must not be nil")
+	}
+
+	// witness stack has all signatures, whole revealed script and, only for
+	// taproot outputs, control block
+	var controlBlockBytes []byte
+	numElements := uint64(len(signatures) + 1)
+	if si.WitnessVersion() == WitnessV1Taproot {
+		if err := checkFpSigHashes(si.GetPkScriptPath(), signatures); err != nil {
+			return 0, err
+		}
+
+		var err error
+		controlBlockBytes, err = si.ControlBlock.ToBytes()
+		if err != nil {
+			return 0, err
+		}
+		numElements++
+	}
+
+	if err := wire.WriteVarInt(w, 0, numElements); err != nil {
+		return 0, err
+	}
+	written := wire.VarIntSerializeSize(numElements)
+
+	writeElement := func(element []byte) error {
+		if err := wire.WriteVarBytes(w, 0, element); err != nil {
+			return err
+		}
+		written += wire.VarIntSerializeSize(uint64(len(element))) + len(element)
+		return nil
+	}
+
+	for _, sig := range signatures {
+		if err := writeElement(sig); err != nil {
+			return written, err
+		}
+	}
+
+	if err := writeElement(si.GetPkScriptPath()); err != nil {
+		return written, err
+	}
+
+	if controlBlockBytes != nil {
+		if err := writeElement(controlBlockBytes); err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// OpCheckSigFromStack is the OP_CHECKSIGFROMSTACK opcode defined in BIP348. It
+// is not yet known to txscript, where it is still OP_UNKNOWN204.
+const OpCheckSigFromStack = txscript.OP_UNKNOWN204
+
+// CreateCsfsPathWitness creates witness for spending through the leaf which
+// checks one of its keys with OP_CHECKSIGFROMSTACK instead of OP_CHECKSIG, i.e
+// leaf in which exactly one key is followed by OP_CHECKSIGFROMSTACK.
+// Signatures must be ordered as for CreateWitness, with one slot per key of the
+// leaf. OP_CHECKSIGFROMSTACK consumes the message and the signature from the
+// stack, message first, so the message is placed in the witness right after
+// the signature of the CSFS key.
+func CreateCsfsPathWitness(si *SpendInfo, message []byte, signatures [][]byte) (wire.TxWitness, error) {
+	if si == nil {
+		return nil, fmt.Errorf("spend info must not be nil")
+	}
+
+	var (
+		keyIdx  int
+		csfsIdx = -1
+		prevKey bool
+	)
+
+	tokenizer := txscript.MakeScriptTokenizer(0, si.GetPkScriptPath())
+	for tokenizer.Next() {
+		isKey := len(tokenizer.Data()) == schnorr.PubKeyBytesLen
+
+		if tokenizer.Opcode() == OpCheckSigFromStack && prevKey {
+			if csfsIdx != -1 {
+				return nil, fmt.Errorf("leaf script must check exactly one key with OP_CHECKSIGFROMSTACK")
+			}
+			csfsIdx = keyIdx - 1
+		}
+
+		if isKey {
+			keyIdx++
+		}
+		prevKey = isKey
+	}
+
+	if err := tokenizer.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse script: %w", err)
+	}
+
+	if csfsIdx == -1 {
+		return nil, fmt.Errorf("leaf script does not check any key with OP_CHECKSIGFROMSTACK")
+	}
+
+	if len(signatures) != keyIdx {
+		return nil, fmt.Errorf("expected %d signatures, got %d", keyIdx, len(signatures))
+	}
+
+	// signature of the first key in the script is last in the witness
+	sigWitnessIdx := keyIdx - 1 - csfsIdx
+
+	stack := make([][]byte, 0, len(signatures)+1)
+	stack = append(stack, signatures[:sigWitnessIdx+1]...)
+	stack = append(stack, message)
+	stack = append(stack, signatures[sigWitnessIdx+1:]...)
+
+	return CreateWitness(si, stack)
+}
+
+// MergeWitnessTemplates merges two partially signed witnesses spending the same
+// script path, e.g collected by different coordinators. Both witnesses must have
+// sigCount signature slots followed by the same script and control block. Every
+// slot of the merged witness takes the signature from whichever witness has it.
+// It returns an error if both witnesses have different signatures in the same slot.
+func MergeWitnessTemplates(a, b wire.TxWitness, sigCount int) (wire.TxWitness, error) {
+	if sigCount < 0 {
+		return nil, fmt.Errorf("signature count must not be negative")
+	}
+
+	for _, w := range []wire.TxWitness{a, b} {
+		if len(w) != sigCount+2 {
+			return nil, fmt.Errorf("witness has %d elements, expected %d signatures, script and control block", len(w), sigCount)
+		}
+	}
+
+	if !bytes.Equal(a[sigCount], b[sigCount]) {
+		return nil, fmt.Errorf("witnesses reveal different scripts")
+	}
+
+	if !bytes.Equal(a[sigCount+1], b[sigCount+1]) {
+		return nil, fmt.Errorf("witnesses have different control blocks")
+	}
+
+	merged := make(wire.TxWitness, sigCount+2)
+
+	for i := 0; i < sigCount; i++ {
+		switch {
+		case len(a[i]) == 0:
+			merged[i] = b[i]
+		case len(b[i]) == 0 || bytes.Equal(a[i], b[i]):
+			merged[i] = a[i]
+		default:
+			return nil, fmt.Errorf("witnesses have conflicting signatures in slot %d", i)
+		}
+	}
+
+	merged[sigCount] = a[sigCount]
+	merged[sigCount+1] = a[sigCount+1]
+
+	return merged, nil
+}
+
+// countNonNilSigs returns the number of provided signatures
+func countNonNilSigs(sigs []*schnorr.Signature) int {
+	n := 0
+	for _, sig := range sigs {
+		if sig != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// CanFinalize checks whether the given signatures are enough to build a witness
+// spending the given path. It returns true if the witness can be built, otherwise
+// it returns false together with human readable reasons why it cannot.
+// Multisig leaves require exactly a threshold number of signatures, so both
+// missing and surplus covenant signatures are reported, and slashing paths
+// require exactly one finality provider signature. Nil signatures are treated as
+// placeholders of members which did not sign.
+func CanFinalize(
+	path SpendPath,
+	covenantSigs []*schnorr.Signature,
+	fpSigs []*schnorr.Signature,
+	delegatorSig *schnorr.Signature,
+	quorum int,
+) (bool, []string) {
+	var reasons []string
+
+	requiresCovenant := false
+	requiresFp := false
+
+	switch path {
+	case TimeLockPath, RecoveryPath:
+	case UnbondingPath:
+		requiresCovenant = true
+	case SlashingPath, UnbondingSlashingPath:
+		requiresCovenant = true
+		requiresFp = true
+	default:
+		return false, []string{fmt.Sprintf("unsupported spend path %s", path)}
+	}
+
+	if delegatorSig == nil {
+		reasons = append(reasons, "missing delegator sig")
+	}
+
+	if requiresCovenant {
+		if err := ValidateQuorum(len(covenantSigs), quorum); err != nil {
+			reasons = append(reasons, err.Error())
+		} else {
+			switch have := countNonNilSigs(covenantSigs); {
+			case have < quorum:
+				reasons = append(reasons, fmt.Sprintf("need %d more covenant sigs", quorum-have))
+			case have > quorum:
+				reasons = append(reasons, fmt.Sprintf("need %d fewer covenant sigs", have-quorum))
+			}
+		}
+	}
+
+	if requiresFp {
+		switch have := countNonNilSigs(fpSigs); {
+		case have == 0:
+			reasons = append(reasons, "missing finality provider sig")
+		case have > 1:
+			reasons = append(reasons, fmt.Sprintf("need exactly 1 finality provider sig, got %d", have))
+		}
+	}
+
+	return len(reasons) == 0, reasons
+}
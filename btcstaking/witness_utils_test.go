@@ -0,0 +1,1412 @@
+package btcstaking_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"maps"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	btctest "github.com/babylonlabs-io/babylon/testutil/bitcoin"
+	"github.com/babylonlabs-io/babylon/testutil/datagen"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSafeCreateWitness(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	sigs := [][]byte{datagen.GenRandomByteArray(r, 64)}
+
+	witness, err := btcstaking.SafeCreateWitness(infos.TimeLock, sigs)
+	require.NoError(t, err)
+	expectedWitness, err := btcstaking.CreateWitness(infos.TimeLock, sigs)
+	require.NoError(t, err)
+	require.Equal(t, expectedWitness, witness)
+
+	witness, err = btcstaking.SafeCreateWitness(nil, sigs)
+	require.Error(t, err)
+	require.Nil(t, witness)
+}
+
+// signLeafWithSigHash signs the only input of the tx with every given key using
+// the provided sighash type and returns raw signatures keyed by hex encoded
+// BIP340 public key
+func signLeafWithSigHash(
+	t *testing.T,
+	tx *wire.MsgTx,
+	fundingOutput *wire.TxOut,
+	si *btcstaking.SpendInfo,
+	sigHashType txscript.SigHashType,
+	keys ...*btcec.PrivateKey,
+) map[string]*schnorr.Signature {
+	sigHash, err := btcstaking.TaprootSigHash(tx, 0, []*wire.TxOut{fundingOutput}, si, sigHashType)
+	require.NoError(t, err)
+
+	sigs := make(map[string]*schnorr.Signature)
+	for _, key := range keys {
+		sig, err := schnorr.Sign(key, sigHash)
+		require.NoError(t, err)
+		sigs[hex.EncodeToString(schnorr.SerializePubKey(key.PubKey()))] = sig
+	}
+	return sigs
+}
+
+func TestCreateWitnessWithSighash(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	si, err := stakingInfo.SlashingPathSpendInfo()
+	require.NoError(t, err)
+
+	spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+	sigs := signLeafWithSigHash(
+		t, spendTx, stakingInfo.StakingOutput, si, txscript.SigHashAll,
+		scenario.StakerKey, scenario.FinalityProviderKeys[0], scenario.CovenantKeys[0], scenario.CovenantKeys[2],
+	)
+	orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+	require.NoError(t, err)
+
+	witness, err := btcstaking.CreateWitnessWithSighash(si, btcstaking.SlashingPath, orderedSigs, txscript.SigHashAll)
+	require.NoError(t, err)
+	spendTx.TxIn[0].Witness = witness
+	btctest.AssertSlashingTxExecution(t, stakingInfo.StakingOutput, spendTx)
+
+	// SIGHASH_NONE would allow anyone to change slashing outputs
+	_, err = btcstaking.CreateWitnessWithSighash(si, btcstaking.SlashingPath, orderedSigs, txscript.SigHashNone)
+	require.Error(t, err)
+	_, err = btcstaking.CreateWitnessWithSighash(
+		si, btcstaking.SlashingPath, orderedSigs, txscript.SigHashAll|txscript.SigHashAnyOneCanPay,
+	)
+	require.Error(t, err)
+
+	// time lock path can be fee bumped with ANYONECANPAY
+	require.NoError(t, btcstaking.ValidateSigHashForPath(
+		btcstaking.TimeLockPath, txscript.SigHashAll|txscript.SigHashAnyOneCanPay,
+	))
+	require.Error(t, btcstaking.ValidateSigHashForPath(btcstaking.UnbondingPath, txscript.SigHashSingle))
+
+	// policy can't be relaxed through the returned sighash types
+	allowed := btcstaking.AllowedSigHashTypes(btcstaking.SlashingPath)
+	require.Equal(t, []txscript.SigHashType{txscript.SigHashDefault, txscript.SigHashAll}, allowed)
+	allowed[0] = txscript.SigHashNone
+	require.Error(t, btcstaking.ValidateSigHashForPath(btcstaking.SlashingPath, txscript.SigHashNone))
+	require.Equal(t, txscript.SigHashDefault, btcstaking.AllowedSigHashTypes(btcstaking.SlashingPath)[0])
+	require.Nil(t, btcstaking.AllowedSigHashTypes(btcstaking.SpendPath(100)))
+}
+
+func TestCreateWitnessWithCategorySighashes(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	si, err := stakingInfo.SlashingPathSpendInfo()
+	require.NoError(t, err)
+
+	spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+
+	// covenant members and staker sign with SIGHASH_ALL, finality provider
+	// with SIGHASH_DEFAULT
+	sigs := signLeafWithSigHash(
+		t, spendTx, stakingInfo.StakingOutput, si, txscript.SigHashAll,
+		scenario.StakerKey, scenario.CovenantKeys[0], scenario.CovenantKeys[2],
+	)
+	maps.Copy(sigs, signLeafWithSigHash(
+		t, spendTx, stakingInfo.StakingOutput, si, txscript.SigHashDefault, scenario.FinalityProviderKeys[0],
+	))
+	orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+	require.NoError(t, err)
+
+	sigHashes := btcstaking.SigHashesByCategory{
+		Covenant:         txscript.SigHashAll,
+		FinalityProvider: txscript.SigHashDefault,
+		Delegator:        txscript.SigHashAll,
+	}
+	witness, err := btcstaking.CreateWitnessWithCategorySighashes(si, btcstaking.SlashingPath, orderedSigs, sigHashes)
+	require.NoError(t, err)
+	spendTx.TxIn[0].Witness = witness
+	btctest.AssertSlashingTxExecution(t, stakingInfo.StakingOutput, spendTx)
+
+	// finality provider signature is the only one without the sighash byte
+	signers, err := btcstaking.RequiredSigners(si.GetPkScriptPath())
+	require.NoError(t, err)
+	for i, key := range signers.WitnessOrder() {
+		if len(witness[i]) == 0 {
+			continue
+		}
+		if bytes.Equal(schnorr.SerializePubKey(key), schnorr.SerializePubKey(scenario.FinalityProviderKeys[0].PubKey())) {
+			require.Len(t, witness[i], schnorr.SignatureSize)
+		} else {
+			require.Len(t, witness[i], schnorr.SignatureSize+1)
+		}
+	}
+
+	// single sighash type for all signatures produces invalid witness
+	uniformWitness, err := btcstaking.CreateWitnessWithSighash(si, btcstaking.SlashingPath, orderedSigs, txscript.SigHashAll)
+	require.NoError(t, err)
+	require.Error(t, btcstaking.ValidateWitness(stakingInfo.StakingOutput, spendTx, 0, uniformWitness))
+
+	// sighash types are still checked against the path policy
+	unsafe := sigHashes
+	unsafe.FinalityProvider = txscript.SigHashNone
+	_, err = btcstaking.CreateWitnessWithCategorySighashes(si, btcstaking.SlashingPath, orderedSigs, unsafe)
+	require.Error(t, err)
+
+	// every key of the script must have a slot
+	_, err = btcstaking.CreateWitnessWithCategorySighashes(si, btcstaking.SlashingPath, orderedSigs[1:], sigHashes)
+	require.Error(t, err)
+}
+
+func TestVerifyDelegatorSig(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	stakingOutput := taprootOutputWithValue(t, r, scenario.StakingAmount)
+	spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+	sigHash, err := btcstaking.TaprootSigHash(
+		spendTx, 0, []*wire.TxOut{stakingOutput}, infos.TimeLock, txscript.SigHashDefault,
+	)
+	require.NoError(t, err)
+
+	stakerSig, err := schnorr.Sign(scenario.StakerKey, sigHash)
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.VerifyDelegatorSig(stakerSig, scenario.StakerKey.PubKey(), sigHash))
+
+	// signature produced by a wrong key
+	wrongSig, err := schnorr.Sign(scenario.CovenantKeys[0], sigHash)
+	require.NoError(t, err)
+	err = btcstaking.VerifyDelegatorSig(wrongSig, scenario.StakerKey.PubKey(), sigHash)
+	require.ErrorIs(t, err, btcstaking.ErrInvalidDelegatorSignature)
+
+	err = btcstaking.VerifyDelegatorSig(nil, scenario.StakerKey.PubKey(), sigHash)
+	require.ErrorIs(t, err, btcstaking.ErrInvalidDelegatorSignature)
+}
+
+func TestCreateWitnessWithControlBlockBytes(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	sigs := [][]byte{datagen.GenRandomByteArray(r, 64), {}, datagen.GenRandomByteArray(r, 64), datagen.GenRandomByteArray(r, 64)}
+	expectedWitness, err := btcstaking.CreateWitness(infos.Unbonding, sigs)
+	require.NoError(t, err)
+
+	controlBlock, err := infos.Unbonding.ControlBlock.ToBytes()
+	require.NoError(t, err)
+
+	witness, err := btcstaking.CreateWitnessWithControlBlockBytes(
+		infos.Unbonding.GetPkScriptPath(), controlBlock, sigs,
+	)
+	require.NoError(t, err)
+	require.Equal(t, expectedWitness, witness)
+
+	// truncated control block must be rejected
+	_, err = btcstaking.CreateWitnessWithControlBlockBytes(
+		infos.Unbonding.GetPkScriptPath(), controlBlock[:len(controlBlock)-1], sigs,
+	)
+	require.Error(t, err)
+
+	_, err = btcstaking.CreateWitnessWithControlBlockBytes(nil, controlBlock, sigs)
+	require.Error(t, err)
+}
+
+func TestCreateWitnessWithLeafVersion(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+	storedKey, err := btcstaking.TaprootOutputKey(infos.TimeLock)
+	require.NoError(t, err)
+
+	sigs := [][]byte{datagen.GenRandomByteArray(r, 64)}
+
+	// overriding with the stored version is the same as CreateWitness
+	witness, err := btcstaking.CreateWitnessWithLeafVersion(
+		infos.TimeLock, sigs, byte(txscript.BaseLeafVersion), storedKey,
+	)
+	require.NoError(t, err)
+	expectedWitness, err := btcstaking.CreateWitness(infos.TimeLock, sigs)
+	require.NoError(t, err)
+	require.Equal(t, expectedWitness, witness)
+
+	// leaf version is committed in the leaf hash, so the stored output key does
+	// not commit to the leaf under other version
+	_, err = btcstaking.CreateWitnessWithLeafVersion(infos.TimeLock, sigs, 0xc2, storedKey)
+	require.ErrorIs(t, err, btcstaking.ErrScriptNotCommitted)
+
+	// spend info built for the base version can spend the output committing to
+	// the same script under the future version, with the same sibling leaves
+	const futureVersion = 0xc2
+	script := datagen.GenRandomByteArray(r, 40)
+	sibling := txscript.NewBaseTapLeaf(datagen.GenRandomByteArray(r, 40))
+	internalKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	baseTree := txscript.AssembleTaprootScriptTree(txscript.NewBaseTapLeaf(script), sibling)
+	si, err := btcstaking.SpendInfoFromRevealedScript(script, internalKey.PubKey(), baseTree)
+	require.NoError(t, err)
+
+	futureTree := txscript.AssembleTaprootScriptTree(txscript.NewTapLeaf(futureVersion, script), sibling)
+	futureRoot := futureTree.RootNode.TapHash()
+	futureKey := txscript.ComputeTaprootOutputKey(internalKey.PubKey(), futureRoot[:])
+
+	witness, err = btcstaking.CreateWitnessWithLeafVersion(si, sigs, futureVersion, futureKey)
+	require.NoError(t, err)
+	parsed, err := btcstaking.ParseWitness(witness)
+	require.NoError(t, err)
+	require.Equal(t, script, parsed.Script)
+	cb, err := txscript.ParseControlBlock(parsed.ControlBlock)
+	require.NoError(t, err)
+	require.Equal(t, txscript.TapscriptLeafVersion(futureVersion), cb.LeafVersion)
+	require.NoError(t, txscript.VerifyTaprootLeafCommitment(cb, schnorr.SerializePubKey(futureKey), script))
+
+	// base version does not lead to the future output key
+	_, err = btcstaking.CreateWitnessWithLeafVersion(si, sigs, byte(txscript.BaseLeafVersion), futureKey)
+	require.ErrorIs(t, err, btcstaking.ErrScriptNotCommitted)
+
+	// odd leaf versions and annex tag are invalid
+	_, err = btcstaking.CreateWitnessWithLeafVersion(infos.TimeLock, sigs, 0xc1, storedKey)
+	require.Error(t, err)
+	_, err = btcstaking.CreateWitnessWithLeafVersion(infos.TimeLock, sigs, txscript.TaprootAnnexTag, storedKey)
+	require.Error(t, err)
+	_, err = btcstaking.CreateWitnessWithLeafVersion(infos.TimeLock, sigs, futureVersion, nil)
+	require.Error(t, err)
+}
+
+func TestRunWitnessConformance(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 2, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	tests := []struct {
+		si   *btcstaking.SpendInfo
+		path btcstaking.SpendPath
+		keys []*btcec.PrivateKey
+	}{
+		{infos.TimeLock, btcstaking.TimeLockPath, []*btcec.PrivateKey{scenario.StakerKey}},
+		{infos.Unbonding, btcstaking.UnbondingPath, []*btcec.PrivateKey{
+			scenario.StakerKey, scenario.CovenantKeys[0], scenario.CovenantKeys[2],
+		}},
+		{infos.Slashing, btcstaking.SlashingPath, []*btcec.PrivateKey{
+			scenario.StakerKey, scenario.FinalityProviderKeys[1], scenario.CovenantKeys[0], scenario.CovenantKeys[1],
+		}},
+		{infos.UnbondingTimeLock, btcstaking.TimeLockPath, []*btcec.PrivateKey{scenario.StakerKey}},
+		{infos.UnbondingSlashing, btcstaking.SlashingPath, []*btcec.PrivateKey{
+			scenario.StakerKey, scenario.FinalityProviderKeys[0], scenario.CovenantKeys[1], scenario.CovenantKeys[2],
+		}},
+	}
+
+	for _, tt := range tests {
+		btctest.RunWitnessConformance(t, tt.si, tt.path, func() [][]byte {
+			sigs := signLeafWithKeys(
+				t,
+				btctest.ConformanceSpendTx(t, tt.si),
+				btctest.ConformancePrevOut(t, tt.si),
+				tt.si,
+				tt.keys...,
+			)
+			orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, tt.si.GetPkScriptPath())
+			require.NoError(t, err)
+			return orderedSigs
+		})
+	}
+}
+
+func TestRecomputeAffectedWitnesses(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 5)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	timeLockInfo, err := stakingInfo.TimeLockPathSpendInfo()
+	require.NoError(t, err)
+	unbondingInfo, err := stakingInfo.UnbondingPathSpendInfo()
+	require.NoError(t, err)
+
+	signer, err := btcstaking.NewPrivateKeySigner(scenario.StakerKey)
+	require.NoError(t, err)
+
+	// anchorOutput is spent by the input added to the transaction
+	anchorOutput := taprootOutputWithValue(t, r, 10000)
+
+	addAnchorInput := func(tx *wire.MsgTx) {
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+	}
+
+	assertInputExecution := func(tx *wire.MsgTx, prevOuts []*wire.TxOut, valid bool) {
+		fetcher := txscript.NewMultiPrevOutFetcher(nil)
+		for i, in := range tx.TxIn {
+			fetcher.AddPrevOut(in.PreviousOutPoint, prevOuts[i])
+		}
+		btctest.AssertEngineExecution(t, 0, valid, func() (*txscript.Engine, error) {
+			return txscript.NewEngine(
+				prevOuts[0].PkScript, tx, 0, txscript.StandardVerifyFlags, nil,
+				txscript.NewTxSigHashes(tx, fetcher), prevOuts[0].Value, fetcher,
+			)
+		})
+	}
+
+	t.Run("timelock witness is re-signed", func(t *testing.T) {
+		tx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.9))
+		tx.TxIn[0].Sequence = uint32(scenario.StakingTime)
+		sigs := signLeafWithKeys(t, tx, stakingInfo.StakingOutput, timeLockInfo, scenario.StakerKey)
+		orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, timeLockInfo.GetPkScriptPath())
+		require.NoError(t, err)
+		tx.TxIn[0].Witness, err = btcstaking.CreateWitness(timeLockInfo, orderedSigs)
+		require.NoError(t, err)
+
+		addAnchorInput(tx)
+		prevOuts := []*wire.TxOut{stakingInfo.StakingOutput, anchorOutput}
+		assertInputExecution(tx, prevOuts, false)
+
+		err = btcstaking.RecomputeAffectedWitnesses(
+			tx, map[int]*btcstaking.SpendInfo{0: timeLockInfo}, prevOuts, signer,
+		)
+		require.NoError(t, err)
+		assertInputExecution(tx, prevOuts, true)
+	})
+
+	t.Run("anyone can pay witness is not affected", func(t *testing.T) {
+		tx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.9))
+		tx.TxIn[0].Sequence = uint32(scenario.StakingTime)
+		sigs := signLeafWithSigHash(
+			t, tx, stakingInfo.StakingOutput, timeLockInfo,
+			txscript.SigHashAll|txscript.SigHashAnyOneCanPay, scenario.StakerKey,
+		)
+		orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, timeLockInfo.GetPkScriptPath())
+		require.NoError(t, err)
+		tx.TxIn[0].Witness, err = btcstaking.CreateWitnessWithSighash(
+			timeLockInfo, btcstaking.TimeLockPath, orderedSigs,
+			txscript.SigHashAll|txscript.SigHashAnyOneCanPay,
+		)
+		require.NoError(t, err)
+		witness := tx.TxIn[0].Witness
+
+		addAnchorInput(tx)
+		prevOuts := []*wire.TxOut{stakingInfo.StakingOutput, anchorOutput}
+
+		err = btcstaking.RecomputeAffectedWitnesses(
+			tx, map[int]*btcstaking.SpendInfo{0: timeLockInfo}, prevOuts, signer,
+		)
+		require.NoError(t, err)
+		require.Equal(t, witness, tx.TxIn[0].Witness)
+		assertInputExecution(tx, prevOuts, true)
+	})
+
+	t.Run("tx is unchanged on error", func(t *testing.T) {
+		tx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.9))
+		tx.TxIn[0].Sequence = uint32(scenario.StakingTime)
+		sigs := signLeafWithKeys(t, tx, stakingInfo.StakingOutput, timeLockInfo, scenario.StakerKey)
+		orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, timeLockInfo.GetPkScriptPath())
+		require.NoError(t, err)
+		tx.TxIn[0].Witness, err = btcstaking.CreateWitness(timeLockInfo, orderedSigs)
+		require.NoError(t, err)
+
+		// added input has witness with annex, which is rejected after the
+		// first input is re-signed
+		addAnchorInput(tx)
+		tx.TxIn[1].Witness = append(wire.TxWitness{}, tx.TxIn[0].Witness...)
+		tx.TxIn[1].Witness = append(tx.TxIn[1].Witness, []byte{txscript.TaprootAnnexTag})
+		prevOuts := []*wire.TxOut{stakingInfo.StakingOutput, anchorOutput}
+		original := tx.Copy()
+
+		for i := 0; i < 10; i++ {
+			err = btcstaking.RecomputeAffectedWitnesses(
+				tx, map[int]*btcstaking.SpendInfo{0: timeLockInfo, 1: timeLockInfo}, prevOuts, signer,
+			)
+			require.Error(t, err)
+			require.Equal(t, original, tx)
+		}
+
+		err = btcstaking.RecomputeAffectedWitnesses(
+			tx, map[int]*btcstaking.SpendInfo{0: timeLockInfo, 2: timeLockInfo}, prevOuts, signer,
+		)
+		require.Error(t, err)
+		require.Equal(t, original, tx)
+	})
+
+	t.Run("stale covenant signatures are reported", func(t *testing.T) {
+		tx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.9))
+		sigs := signLeafWithKeys(
+			t, tx, stakingInfo.StakingOutput, unbondingInfo,
+			scenario.StakerKey, scenario.CovenantKeys[0], scenario.CovenantKeys[1],
+		)
+		orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, unbondingInfo.GetPkScriptPath())
+		require.NoError(t, err)
+		tx.TxIn[0].Witness, err = btcstaking.CreateWitness(unbondingInfo, orderedSigs)
+		require.NoError(t, err)
+
+		addAnchorInput(tx)
+		prevOuts := []*wire.TxOut{stakingInfo.StakingOutput, anchorOutput}
+
+		err = btcstaking.RecomputeAffectedWitnesses(
+			tx, map[int]*btcstaking.SpendInfo{0: unbondingInfo}, prevOuts, signer,
+		)
+		require.Error(t, err)
+
+		// staker signature is nevertheless recomputed
+		sigHash, err := btcstaking.TaprootSigHash(tx, 0, prevOuts, unbondingInfo, txscript.SigHashDefault)
+		require.NoError(t, err)
+		parsed, err := btcstaking.ParseWitness(tx.TxIn[0].Witness)
+		require.NoError(t, err)
+		stakerSig, err := schnorr.ParseSignature(parsed.Signatures[len(parsed.Signatures)-1])
+		require.NoError(t, err)
+		require.True(t, stakerSig.Verify(sigHash, scenario.StakerKey.PubKey()))
+	})
+}
+
+func TestWriteWitness(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	sigs := [][]byte{
+		datagen.GenRandomByteArray(r, 64),
+		{},
+		datagen.GenRandomByteArray(r, 64),
+		datagen.GenRandomByteArray(r, 65),
+		{},
+		datagen.GenRandomByteArray(r, 64),
+	}
+
+	witness, err := btcstaking.CreateWitness(infos.Unbonding, sigs)
+	require.NoError(t, err)
+
+	// serialized witness is the same as witness serialized within the tx
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, witness))
+	var txBuf bytes.Buffer
+	require.NoError(t, tx.Serialize(&txBuf))
+
+	var buf bytes.Buffer
+	n, err := btcstaking.WriteWitness(&buf, infos.Unbonding, sigs)
+	require.NoError(t, err)
+	require.Equal(t, buf.Len(), n)
+	require.Equal(t, witness.SerializeSize(), n)
+	require.True(t, bytes.Contains(txBuf.Bytes(), buf.Bytes()))
+
+	_, err = btcstaking.WriteWitness(&buf, nil, sigs)
+	require.Error(t, err)
+}
+
+// largeCommitteeUnbondingWitnessData returns the unbonding path spend info and
+// full set of signatures for a committee with given number of members
+func largeCommitteeUnbondingWitnessData(b *testing.B, committeeSize int) (*btcstaking.SpendInfo, [][]byte) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+
+	newKey := func() *btcec.PublicKey {
+		key, err := btcec.NewPrivateKey()
+		require.NoError(b, err)
+		return key.PubKey()
+	}
+
+	covenantKeys := make([]*btcec.PublicKey, committeeSize)
+	for i := range covenantKeys {
+		covenantKeys[i] = newKey()
+	}
+
+	infos, err := btcstaking.BuildAllSpendInfos(btcstaking.StakingParams{
+		StakerKey:            newKey(),
+		FinalityProviderKeys: []*btcec.PublicKey{newKey()},
+		CovenantKeys:         covenantKeys,
+		CovenantQuorum:       uint32(committeeSize*2/3 + 1),
+		StakingTime:          1000,
+		UnbondingTime:        100,
+		StakingAmount:        btcutil.Amount(2 * 10e8),
+	})
+	require.NoError(b, err)
+
+	sigs := make([][]byte, committeeSize+1)
+	for i := range sigs {
+		sigs[i] = datagen.GenRandomByteArray(r, 64)
+	}
+
+	return infos.Unbonding, sigs
+}
+
+func BenchmarkCreateWitnessSerialize100(b *testing.B) {
+	si, sigs := largeCommitteeUnbondingWitnessData(b, 100)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		witness, err := btcstaking.CreateWitness(si, sigs)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, element := range witness {
+			if err := wire.WriteVarBytes(io.Discard, 0, element); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkWriteWitness100(b *testing.B) {
+	si, sigs := largeCommitteeUnbondingWitnessData(b, 100)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := btcstaking.WriteWitness(io.Discard, si, sigs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCreateCsfsPathWitness(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+
+	stakerKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	oracleKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	// SCRIPT: <StakerPk> OP_CHECKSIGVERIFY <OraclePk> OP_CHECKSIGFROMSTACK
+	script, err := txscript.NewScriptBuilder().
+		AddData(schnorr.SerializePubKey(stakerKey.PubKey())).
+		AddOp(txscript.OP_CHECKSIGVERIFY).
+		AddData(schnorr.SerializePubKey(oracleKey.PubKey())).
+		AddOp(btcstaking.OpCheckSigFromStack).
+		Script()
+	require.NoError(t, err)
+
+	leaf := txscript.NewBaseTapLeaf(script)
+	tree := txscript.AssembleTaprootScriptTree(leaf)
+	internalKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	si := &btcstaking.SpendInfo{
+		ControlBlock: tree.LeafMerkleProofs[0].ToControlBlock(internalKey.PubKey()),
+		RevealedLeaf: leaf,
+	}
+
+	message := datagen.GenRandomByteArray(r, 32)
+	oracleSig := datagen.GenRandomByteArray(r, 64)
+	stakerSig := datagen.GenRandomByteArray(r, 64)
+
+	// staker key is checked first, so its signature is on top of the stack.
+	// CSFS pops the message before the signature, so message is above the
+	// oracle signature. txscript does not implement OP_CHECKSIGFROMSTACK yet,
+	// so the witness can't be executed by the script engine.
+	witness, err := btcstaking.CreateCsfsPathWitness(si, message, [][]byte{oracleSig, stakerSig})
+	require.NoError(t, err)
+	require.Len(t, witness, 5)
+	require.Equal(t, oracleSig, witness[0])
+	require.Equal(t, message, witness[1])
+	require.Equal(t, stakerSig, witness[2])
+	require.Equal(t, script, witness[3])
+
+	_, err = btcstaking.CreateCsfsPathWitness(si, message, [][]byte{oracleSig})
+	require.Error(t, err)
+
+	// leaf without CSFS is rejected
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+	_, err = btcstaking.CreateCsfsPathWitness(infos.TimeLock, message, [][]byte{stakerSig})
+	require.Error(t, err)
+}
+
+func TestCreateSlashingPathWitnessFpCount(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 3, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	sig, err := schnorr.Sign(scenario.StakerKey, datagen.GenRandomByteArray(r, 32))
+	require.NoError(t, err)
+
+	covenantSigs := []*schnorr.Signature{sig, sig, nil}
+
+	for _, numFpSigs := range []int{2, 4} {
+		fpSigs := make([]*schnorr.Signature, numFpSigs)
+		fpSigs[0] = sig
+
+		_, err := infos.Slashing.CreateSlashingPathWitness(covenantSigs, fpSigs, sig)
+		require.ErrorIs(t, err, btcstaking.ErrFinalityProviderSigsMismatch)
+	}
+
+	witness, err := infos.Slashing.CreateSlashingPathWitness(covenantSigs, []*schnorr.Signature{nil, sig, nil}, sig)
+	require.NoError(t, err)
+	require.Len(t, witness, 3+3+1+2)
+
+	// unbonding leaf has no finality provider segment
+	_, err = infos.Unbonding.CreateSlashingPathWitness(covenantSigs, []*schnorr.Signature{sig}, sig)
+	require.Error(t, err)
+}
+
+func TestMergeWitnessTemplates(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 5, 3, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+
+	fundingOutput := stakingInfo.StakingOutput
+	tx := createSpendStakeTx(scenario.StakingAmount - 1000)
+	si, err := stakingInfo.UnbondingPathSpendInfo()
+	require.NoError(t, err)
+
+	// each coordinator collected a part of covenant signatures, and both have
+	// the staker signature
+	sigsA := signLeafWithKeys(t, tx, fundingOutput, si, scenario.StakerKey, scenario.CovenantKeys[0])
+	sigsB := signLeafWithKeys(t, tx, fundingOutput, si, scenario.StakerKey, scenario.CovenantKeys[2], scenario.CovenantKeys[4])
+
+	templateA, err := btcstaking.ReorderSigsForLeaf(sigsA, si.GetPkScriptPath())
+	require.NoError(t, err)
+	templateB, err := btcstaking.ReorderSigsForLeaf(sigsB, si.GetPkScriptPath())
+	require.NoError(t, err)
+
+	witnessA, err := btcstaking.CreateWitness(si, templateA)
+	require.NoError(t, err)
+	witnessB, err := btcstaking.CreateWitness(si, templateB)
+	require.NoError(t, err)
+
+	merged, err := btcstaking.MergeWitnessTemplates(witnessA, witnessB, 6)
+	require.NoError(t, err)
+
+	numSigs, err := btcstaking.CountSignatures(merged)
+	require.NoError(t, err)
+	require.Equal(t, 4, numSigs)
+
+	tx.TxIn[0].Witness = merged
+	btctest.AssertSlashingTxExecution(t, fundingOutput, tx)
+
+	// conflicting signatures in the same slot
+	conflicting := append(wire.TxWitness{}, witnessB...)
+	conflicting[5] = datagen.GenRandomByteArray(r, 64)
+	_, err = btcstaking.MergeWitnessTemplates(witnessA, conflicting, 6)
+	require.Error(t, err)
+
+	// templates of different leaves
+	otherLeaf, err := btcstaking.CreateWitness(infos.Slashing, make([][]byte, 6))
+	require.NoError(t, err)
+	_, err = btcstaking.MergeWitnessTemplates(witnessA, otherLeaf, 6)
+	require.Error(t, err)
+
+	// wrong signature count
+	_, err = btcstaking.MergeWitnessTemplates(witnessA, witnessB, 5)
+	require.Error(t, err)
+}
+
+func TestVerifyTimelockSigner(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	stakerPk := scenario.StakerKey.PubKey()
+
+	require.NoError(t, btcstaking.VerifyTimelockSigner(infos.TimeLock, stakerPk))
+	require.NoError(t, btcstaking.VerifyTimelockSigner(infos.UnbondingTimeLock, stakerPk))
+
+	// wallet derived a different key
+	wrongKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	err = btcstaking.VerifyTimelockSigner(infos.TimeLock, wrongKey.PubKey())
+	require.ErrorIs(t, err, btcstaking.ErrWrongSigningKey)
+
+	// leaves other than time lock are rejected
+	require.Error(t, btcstaking.VerifyTimelockSigner(infos.Unbonding, stakerPk))
+	require.Error(t, btcstaking.VerifyTimelockSigner(infos.Slashing, stakerPk))
+}
+
+func TestCanFinalize(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	key, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	sig, err := schnorr.Sign(key, datagen.GenRandomByteArray(r, 32))
+	require.NoError(t, err)
+
+	covenantSigs := func(signed ...bool) []*schnorr.Signature {
+		sigs := make([]*schnorr.Signature, len(signed))
+		for i, s := range signed {
+			if s {
+				sigs[i] = sig
+			}
+		}
+		return sigs
+	}
+
+	tests := []struct {
+		name         string
+		path         btcstaking.SpendPath
+		covenantSigs []*schnorr.Signature
+		fpSigs       []*schnorr.Signature
+		delegatorSig *schnorr.Signature
+		quorum       int
+		reasons      []string
+	}{
+		{"timelock ready", btcstaking.TimeLockPath, nil, nil, sig, 0, nil},
+		{"timelock missing delegator", btcstaking.TimeLockPath, nil, nil, nil, 0,
+			[]string{"missing delegator sig"}},
+		{"unbonding ready", btcstaking.UnbondingPath, covenantSigs(true, false, true), nil, sig, 2, nil},
+		{"unbonding missing covenant sigs", btcstaking.UnbondingPath, covenantSigs(false, false, true), nil, sig, 3,
+			[]string{"need 2 more covenant sigs"}},
+		{"unbonding surplus covenant sigs", btcstaking.UnbondingPath, covenantSigs(true, true, true), nil, sig, 2,
+			[]string{"need 1 fewer covenant sigs"}},
+		{"slashing ready", btcstaking.SlashingPath, covenantSigs(true, true, false),
+			[]*schnorr.Signature{nil, sig}, sig, 2, nil},
+		{"slashing missing everything", btcstaking.SlashingPath, covenantSigs(false, true, false),
+			[]*schnorr.Signature{nil, nil}, nil, 2,
+			[]string{"missing delegator sig", "need 1 more covenant sigs", "missing finality provider sig"}},
+		{"slashing two finality provider sigs", btcstaking.SlashingPath, covenantSigs(true, true),
+			[]*schnorr.Signature{sig, sig}, sig, 2,
+			[]string{"need exactly 1 finality provider sig, got 2"}},
+		{"unbonding slashing ready", btcstaking.UnbondingSlashingPath, covenantSigs(true, false),
+			[]*schnorr.Signature{sig}, sig, 1, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ok, reasons := btcstaking.CanFinalize(tc.path, tc.covenantSigs, tc.fpSigs, tc.delegatorSig, tc.quorum)
+			require.Equal(t, len(tc.reasons) == 0, ok)
+			require.Equal(t, tc.reasons, reasons)
+		})
+	}
+
+	// quorum which cannot be reached by the committee
+	ok, reasons := btcstaking.CanFinalize(btcstaking.UnbondingPath, covenantSigs(true, true), nil, sig, 3)
+	require.False(t, ok)
+	require.Len(t, reasons, 1)
+
+	ok, reasons = btcstaking.CanFinalize(btcstaking.SpendPath(100), nil, nil, sig, 0)
+	require.False(t, ok)
+	require.Len(t, reasons, 1)
+}
+
+// unbondingWitnessData6of9 returns the unbonding spend info of a 6-of-9 covenant
+// committee, covenant signatures of 6 members with empty slots of the other 3
+// in witness order and the staker signature, together with the tx spending the
+// staking output which they sign
+func unbondingWitnessData6of9(tb testing.TB) (
+	*btcstaking.SpendInfo, []*schnorr.Signature, *schnorr.Signature, *wire.MsgTx, *wire.TxOut,
+) {
+	newKey := func() *btcec.PrivateKey {
+		key, err := btcec.NewPrivateKey()
+		require.NoError(tb, err)
+		return key
+	}
+
+	stakerKey := newKey()
+	covenantKeys := make([]*btcec.PrivateKey, 9)
+	covenantPks := make([]*btcec.PublicKey, 9)
+	for i := range covenantKeys {
+		covenantKeys[i] = newKey()
+		covenantPks[i] = covenantKeys[i].PubKey()
+	}
+
+	params := btcstaking.StakingParams{
+		StakerKey:            stakerKey.PubKey(),
+		FinalityProviderKeys: []*btcec.PublicKey{newKey().PubKey()},
+		CovenantKeys:         covenantPks,
+		CovenantQuorum:       6,
+		StakingTime:          1000,
+		UnbondingTime:        100,
+		StakingAmount:        btcutil.Amount(2 * 10e8),
+	}
+	infos, err := btcstaking.BuildAllSpendInfos(params)
+	require.NoError(tb, err)
+	stakingPkScript, err := btcstaking.StakingOutputScript(params)
+	require.NoError(tb, err)
+	prevOut := wire.NewTxOut(int64(params.StakingAmount), stakingPkScript)
+	spendTx := createSpendStakeTx(params.StakingAmount - 1000)
+
+	si := infos.Unbonding
+	sign := func(key *btcec.PrivateKey) *schnorr.Signature {
+		sig, err := btcstaking.SignTxWithOneScriptSpendInputFromTapLeaf(spendTx, prevOut, key, si.RevealedLeaf)
+		require.NoError(tb, err)
+		return sig
+	}
+
+	sigs := make(map[string]*schnorr.Signature)
+	for _, key := range covenantKeys[:6] {
+		sigs[hex.EncodeToString(schnorr.SerializePubKey(key.PubKey()))] = sign(key)
+	}
+
+	// witness order of the covenant slots is the reverse of the script order
+	scriptKeys, err := btcstaking.ExtractScriptPubKeys(si.GetPkScriptPath())
+	require.NoError(tb, err)
+	covenantScriptKeys := scriptKeys[1:]
+	covenantSigs := make([]*schnorr.Signature, len(covenantScriptKeys))
+	for i, key := range covenantScriptKeys {
+		covenantSigs[len(covenantScriptKeys)-1-i] = sigs[hex.EncodeToString(schnorr.SerializePubKey(key))]
+	}
+
+	return si, covenantSigs, sign(stakerKey), spendTx, prevOut
+}
+
+// createUnbondingPathWitnessGeneral builds the unbonding witness by appending
+// every signature slot to the witness stack
+func createUnbondingPathWitnessGeneral(
+	si *btcstaking.SpendInfo,
+	covenantSigs []*schnorr.Signature,
+	delegatorSig *schnorr.Signature,
+) (wire.TxWitness, error) {
+	var witnessStack [][]byte
+	for _, covSig := range covenantSigs {
+		if covSig == nil {
+			witnessStack = append(witnessStack, []byte{})
+		} else {
+			witnessStack = append(witnessStack, covSig.Serialize())
+		}
+	}
+	witnessStack = append(witnessStack, delegatorSig.Serialize())
+
+	return btcstaking.CreateWitness(si, witnessStack)
+}
+
+func TestCreateUnbondingPathWitness6of9(t *testing.T) {
+	si, covenantSigs, stakerSig, spendTx, prevOut := unbondingWitnessData6of9(t)
+	require.Len(t, covenantSigs, 9)
+
+	expected, err := createUnbondingPathWitnessGeneral(si, covenantSigs, stakerSig)
+	require.NoError(t, err)
+	witness, err := si.CreateUnbondingPathWitness(covenantSigs, stakerSig)
+	require.NoError(t, err)
+	require.Equal(t, expected, witness)
+	require.NoError(t, btcstaking.ValidateWitness(prevOut, spendTx, 0, witness))
+
+	// witness without the slots of members which did not sign can't be spent
+	var quorumSigs []*schnorr.Signature
+	for _, sig := range covenantSigs {
+		if sig != nil {
+			quorumSigs = append(quorumSigs, sig)
+		}
+	}
+	witness, err = si.CreateUnbondingPathWitness(quorumSigs, stakerSig)
+	require.NoError(t, err)
+	require.Error(t, btcstaking.ValidateWitness(prevOut, spendTx, 0, witness))
+
+	_, err = si.CreateUnbondingPathWitness(covenantSigs, nil)
+	require.Error(t, err)
+	_, err = si.CreateUnbondingPathWitness(nil, stakerSig)
+	require.Error(t, err)
+}
+
+func BenchmarkCreateUnbondingPathWitness6of9General(b *testing.B) {
+	si, covenantSigs, stakerSig, _, _ := unbondingWitnessData6of9(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := createUnbondingPathWitnessGeneral(si, covenantSigs, stakerSig); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCreateUnbondingPathWitness6of9(b *testing.B) {
+	si, covenantSigs, stakerSig, _, _ := unbondingWitnessData6of9(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := si.CreateUnbondingPathWitness(covenantSigs, stakerSig); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCreateWitnessRejectsUnsafeFpSigHash(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 2, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	withSigHash := func(sigHashType txscript.SigHashType) []byte {
+		return append(datagen.GenRandomByteArray(r, 64), byte(sigHashType))
+	}
+
+	// witness stack: 3 covenant slots, 2 finality provider slots, staker slot
+	stack := func(fpSig []byte) [][]byte {
+		return [][]byte{
+			withSigHash(txscript.SigHashAll), {}, withSigHash(txscript.SigHashAll),
+			{}, fpSig,
+			withSigHash(txscript.SigHashAll),
+		}
+	}
+
+	for _, si := range []*btcstaking.SpendInfo{infos.Slashing, infos.UnbondingSlashing} {
+		_, err = btcstaking.CreateWitness(si, stack(withSigHash(txscript.SigHashAll)))
+		require.NoError(t, err)
+		_, err = btcstaking.CreateWitness(si, stack(datagen.GenRandomByteArray(r, 64)))
+		require.NoError(t, err)
+
+		for _, sigHashType := range []txscript.SigHashType{
+			txscript.SigHashNone,
+			txscript.SigHashSingle,
+			txscript.SigHashAll | txscript.SigHashAnyOneCanPay,
+			txscript.SigHashNone | txscript.SigHashAnyOneCanPay,
+		} {
+			_, err = btcstaking.CreateWitness(si, stack(withSigHash(sigHashType)))
+			require.ErrorIs(t, err, btcstaking.ErrUnsafeFpSigHash)
+		}
+	}
+
+	require.NoError(t, btcstaking.ValidateFpSigHash(nil))
+	require.ErrorIs(t, btcstaking.ValidateFpSigHash(withSigHash(txscript.SigHashDefault)), btcstaking.ErrUnsafeFpSigHash)
+	require.Error(t, btcstaking.ValidateFpSigHash(datagen.GenRandomByteArray(r, 63)))
+}
+
+func TestCreateWitnessValidated(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+	si, err := stakingInfo.UnbondingPathSpendInfo()
+	require.NoError(t, err)
+
+	spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+	prevOut := stakingInfo.StakingOutput
+
+	sigs := signLeafWithKeys(t, spendTx, prevOut, si,
+		scenario.StakerKey, scenario.CovenantKeys[0], scenario.CovenantKeys[2],
+	)
+	orderedSigs, err := btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+	require.NoError(t, err)
+
+	witness, err := btcstaking.CreateWitnessValidated(prevOut, spendTx, 0, si, orderedSigs)
+	require.NoError(t, err)
+	require.Empty(t, spendTx.TxIn[0].Witness, "tx must not be modified")
+	expected, err := btcstaking.CreateWitness(si, orderedSigs)
+	require.NoError(t, err)
+	require.Equal(t, expected, witness)
+
+	// covenant quorum not reached
+	sigs = signLeafWithKeys(t, spendTx, prevOut, si, scenario.StakerKey, scenario.CovenantKeys[0])
+	orderedSigs, err = btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+	require.NoError(t, err)
+	_, err = btcstaking.CreateWitnessValidated(prevOut, spendTx, 0, si, orderedSigs)
+	require.Error(t, err)
+
+	// signatures made over other prev out value
+	sigs = signLeafWithKeys(t, spendTx, prevOut, si,
+		scenario.StakerKey, scenario.CovenantKeys[0], scenario.CovenantKeys[2],
+	)
+	orderedSigs, err = btcstaking.ReorderSigsForLeaf(sigs, si.GetPkScriptPath())
+	require.NoError(t, err)
+	otherPrevOut := wire.NewTxOut(prevOut.Value+1, prevOut.PkScript)
+	_, err = btcstaking.CreateWitnessValidated(otherPrevOut, spendTx, 0, si, orderedSigs)
+	require.Error(t, err)
+
+	_, err = btcstaking.CreateWitnessValidated(prevOut, spendTx, 1, si, orderedSigs)
+	require.Error(t, err)
+	_, err = btcstaking.CreateWitnessValidated(nil, spendTx, 0, si, orderedSigs)
+	require.Error(t, err)
+	_, err = btcstaking.CreateWitnessValidated(prevOut, spendTx, 0, nil, orderedSigs)
+	require.Error(t, err)
+}
+
+// validationJobs returns n jobs validating the time lock path spend of the
+// staking output, with the witness of every job listed in invalid corrupted
+func validationJobs(tb testing.TB, n int, invalid ...int) []btcstaking.ValidationJob {
+	stakerKey, err := btcec.NewPrivateKey()
+	require.NoError(tb, err)
+
+	info, err := btcstaking.BuildRelativeTimelockTaprootScript(stakerKey.PubKey(), 10, &chaincfg.MainNetParams)
+	require.NoError(tb, err)
+	prevOut := wire.NewTxOut(100000, info.PkScript)
+
+	spendTx := createSpendStakeTx(50000)
+	spendTx.TxIn[0].Sequence = btcstaking.SequenceForTimelock(10)
+
+	sig, err := btcstaking.SignTxWithOneScriptSpendInputFromTapLeaf(spendTx, prevOut, stakerKey, info.SpendInfo.RevealedLeaf)
+	require.NoError(tb, err)
+	witness, err := info.SpendInfo.CreateTimeLockPathWitness(sig)
+	require.NoError(tb, err)
+
+	corrupted := make(wire.TxWitness, len(witness))
+	copy(corrupted, witness)
+	corrupted[0] = append([]byte{}, witness[0]...)
+	corrupted[0][0] ^= 0x01
+
+	jobs := make([]btcstaking.ValidationJob, n)
+	for i := range jobs {
+		jobs[i] = btcstaking.ValidationJob{Tx: spendTx, InputIdx: 0, PrevOut: prevOut, Witness: witness}
+	}
+	for _, i := range invalid {
+		jobs[i].Witness = corrupted
+	}
+
+	return jobs
+}
+
+func TestValidateWitnessesParallel(t *testing.T) {
+	jobs := validationJobs(t, 50, 3, 17, 49)
+
+	for _, workers := range []int{0, 1, 4, 100} {
+		errs := btcstaking.ValidateWitnessesParallel(jobs, workers)
+		require.Len(t, errs, len(jobs))
+		for i, err := range errs {
+			if i == 3 || i == 17 || i == 49 {
+				require.Error(t, err, "job %d", i)
+			} else {
+				require.NoError(t, err, "job %d", i)
+			}
+		}
+	}
+
+	require.Empty(t, btcstaking.ValidateWitnessesParallel(nil, 4))
+}
+
+func BenchmarkValidate1000WitnessesSequential(b *testing.B) {
+	jobs := validationJobs(b, 1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		btcstaking.ValidateWitnessesParallel(jobs, 1)
+	}
+}
+
+func BenchmarkValidate1000WitnessesParallel(b *testing.B) {
+	jobs := validationJobs(b, 1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		btcstaking.ValidateWitnessesParallel(jobs, runtime.NumCPU())
+	}
+}
+
+func TestCreateTimeLockPathWitnessAnyoneCanPay(t *testing.T) {
+	stakerKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	info, err := btcstaking.BuildRelativeTimelockTaprootScript(stakerKey.PubKey(), 10, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	prevOut := wire.NewTxOut(100000, info.PkScript)
+
+	spendTx := createSpendStakeTx(90000)
+	spendTx.TxIn[0].Sequence = btcstaking.SequenceForTimelock(10)
+
+	sign := func(sigHashType txscript.SigHashType) *schnorr.Signature {
+		sigHash, err := btcstaking.TaprootSigHash(spendTx, 0, []*wire.TxOut{prevOut}, info.SpendInfo, sigHashType)
+		require.NoError(t, err)
+		sig, err := schnorr.Sign(stakerKey, sigHash)
+		require.NoError(t, err)
+		return sig
+	}
+
+	acpSig := sign(txscript.SigHashAll | txscript.SigHashAnyOneCanPay).Serialize()
+	defaultSig := sign(txscript.SigHashDefault)
+
+	witness, err := info.SpendInfo.CreateTimeLockPathWitnessAnyoneCanPay(acpSig)
+	require.NoError(t, err)
+	require.Len(t, witness[0], schnorr.SignatureSize+1)
+	require.NoError(t, btcstaking.ValidateWitness(prevOut, spendTx, 0, witness))
+
+	// signature already carrying the sighash byte is accepted as is
+	witnessWithFlag, err := info.SpendInfo.CreateTimeLockPathWitnessAnyoneCanPay(witness[0])
+	require.NoError(t, err)
+	require.Equal(t, witness, witnessWithFlag)
+
+	defaultWitness, err := info.SpendInfo.CreateTimeLockPathWitness(defaultSig)
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.ValidateWitness(prevOut, spendTx, 0, defaultWitness))
+
+	// staker adds fee input after signing
+	spendTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+
+	require.NoError(t, btcstaking.ValidateWitness(prevOut, spendTx, 0, witness))
+	require.Error(t, btcstaking.ValidateWitness(prevOut, spendTx, 0, defaultWitness))
+
+	// sighash byte other than SIGHASH_ALL|ANYONECANPAY is rejected
+	allSig := append(append([]byte{}, acpSig...), byte(txscript.SigHashAll))
+	_, err = info.SpendInfo.CreateTimeLockPathWitnessAnyoneCanPay(allSig)
+	require.ErrorIs(t, err, btcstaking.ErrInvalidDelegatorSignature)
+
+	_, err = info.SpendInfo.CreateTimeLockPathWitnessAnyoneCanPay(acpSig[:10])
+	require.ErrorIs(t, err, btcstaking.ErrInvalidDelegatorSignature)
+}
+
+func TestFinalizeSpend(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	stakingInfo, err := btcstaking.BuildStakingInfo(
+		scenario.StakerKey.PubKey(),
+		scenario.FinalityProviderPublicKeys(),
+		scenario.CovenantPublicKeys(),
+		scenario.RequiredCovenantSigs,
+		scenario.StakingTime,
+		scenario.StakingAmount,
+		&chaincfg.MainNetParams,
+	)
+	require.NoError(t, err)
+	si, err := stakingInfo.UnbondingPathSpendInfo()
+	require.NoError(t, err)
+
+	spendTx := createSpendStakeTx(scenario.StakingAmount.MulF64(0.5))
+	prevOut := stakingInfo.StakingOutput
+
+	// covenant members sign first, delegator slot stays empty
+	covSigs := signLeafWithKeys(t, spendTx, prevOut, si, scenario.CovenantKeys[0], scenario.CovenantKeys[2])
+	orderedSigs, err := btcstaking.ReorderSigsForLeaf(covSigs, si.GetPkScriptPath())
+	require.NoError(t, err)
+	template, err := btcstaking.CreateWitness(si, orderedSigs)
+	require.NoError(t, err)
+	// staker key is the first key of the script, so its signature is the last one
+	delegatorSlot := len(orderedSigs) - 1
+	require.Empty(t, template[delegatorSlot])
+
+	delegatorSig, err := btcstaking.SignTxWithOneScriptSpendInputFromTapLeaf(
+		spendTx, prevOut, scenario.StakerKey, si.RevealedLeaf,
+	)
+	require.NoError(t, err)
+
+	finalTx, err := btcstaking.FinalizeSpend(spendTx, 0, template, delegatorSig, delegatorSlot)
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.ValidateWitness(prevOut, finalTx, 0, finalTx.TxIn[0].Witness))
+	require.Equal(t, delegatorSig.Serialize(), []byte(finalTx.TxIn[0].Witness[delegatorSlot]))
+	require.Empty(t, template[delegatorSlot], "template must not be modified")
+	require.Empty(t, spendTx.TxIn[0].Witness, "tx must not be modified")
+
+	// slot already filled
+	_, err = btcstaking.FinalizeSpend(spendTx, 0, finalTx.TxIn[0].Witness, delegatorSig, delegatorSlot)
+	require.Error(t, err)
+	// covenant slot which is filled
+	for i := 0; i < delegatorSlot; i++ {
+		if len(template[i]) > 0 {
+			_, err = btcstaking.FinalizeSpend(spendTx, 0, template, delegatorSig, i)
+			require.Error(t, err)
+		}
+	}
+	// script and control block are not signature slots
+	_, err = btcstaking.FinalizeSpend(spendTx, 0, template, delegatorSig, len(template)-2)
+	require.Error(t, err)
+	_, err = btcstaking.FinalizeSpend(spendTx, 0, template, delegatorSig, -1)
+	require.Error(t, err)
+	_, err = btcstaking.FinalizeSpend(spendTx, 1, template, delegatorSig, delegatorSlot)
+	require.Error(t, err)
+	_, err = btcstaking.FinalizeSpend(spendTx, 0, template, nil, delegatorSlot)
+	require.Error(t, err)
+	_, err = btcstaking.FinalizeSpend(spendTx, 0, template[:2], delegatorSig, 0)
+	require.Error(t, err)
+	_, err = btcstaking.FinalizeSpend(nil, 0, template, delegatorSig, delegatorSlot)
+	require.Error(t, err)
+}
+
+func TestMatchPrevOut(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	jobs := validationJobs(t, 1)
+	job := jobs[0]
+
+	otherValue := wire.NewTxOut(job.PrevOut.Value+1, job.PrevOut.PkScript)
+	otherScript := taprootOutputWithValue(t, r, btcutil.Amount(job.PrevOut.Value))
+
+	idx, err := btcstaking.MatchPrevOut(
+		job.Tx, job.InputIdx, job.Witness, []*wire.TxOut{otherScript, nil, otherValue, job.PrevOut},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 3, idx)
+
+	idx, err = btcstaking.MatchPrevOut(job.Tx, job.InputIdx, job.Witness, []*wire.TxOut{job.PrevOut, job.PrevOut})
+	require.NoError(t, err)
+	require.Equal(t, 0, idx)
+
+	_, err = btcstaking.MatchPrevOut(job.Tx, job.InputIdx, job.Witness, []*wire.TxOut{otherScript, otherValue})
+	require.Error(t, err)
+	_, err = btcstaking.MatchPrevOut(job.Tx, job.InputIdx, job.Witness, nil)
+	require.Error(t, err)
+	_, err = btcstaking.MatchPrevOut(job.Tx, 1, job.Witness, []*wire.TxOut{job.PrevOut})
+	require.Error(t, err)
+	_, err = btcstaking.MatchPrevOut(nil, 0, job.Witness, []*wire.TxOut{job.PrevOut})
+	require.Error(t, err)
+}
+
+func TestCreateTimeLockPathWitnessStrict(t *testing.T) {
+	stakerKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	info, err := btcstaking.BuildRelativeTimelockTaprootScript(stakerKey.PubKey(), 10, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	prevOut := wire.NewTxOut(100000, info.PkScript)
+
+	sign := func(tx *wire.MsgTx) *schnorr.Signature {
+		sigHash, err := btcstaking.TaprootSigHash(tx, 0, []*wire.TxOut{prevOut}, info.SpendInfo, txscript.SigHashDefault)
+		require.NoError(t, err)
+		sig, err := schnorr.Sign(stakerKey, sigHash)
+		require.NoError(t, err)
+		return sig
+	}
+
+	spendTx := createSpendStakeTx(90000)
+	spendTx.TxIn[0].Sequence = btcstaking.SequenceForTimelock(10)
+	require.NoError(t, btcstaking.RequireTxVersion2(spendTx))
+
+	witness, err := info.SpendInfo.CreateTimeLockPathWitnessStrict(spendTx, 0, sign(spendTx))
+	require.NoError(t, err)
+	require.NoError(t, btcstaking.ValidateWitness(prevOut, spendTx, 0, witness))
+
+	// version 1 tx ignores the relative time lock, so the spend is invalid
+	v1Tx := spendTx.Copy()
+	v1Tx.Version = 1
+	require.ErrorIs(t, btcstaking.RequireTxVersion2(v1Tx), btcstaking.ErrRelativeTimelockIgnored)
+
+	v1Sig := sign(v1Tx)
+	_, err = info.SpendInfo.CreateTimeLockPathWitnessStrict(v1Tx, 0, v1Sig)
+	require.ErrorIs(t, err, btcstaking.ErrRelativeTimelockIgnored)
+
+	v1Witness, err := info.SpendInfo.CreateTimeLockPathWitness(v1Sig)
+	require.NoError(t, err)
+	require.Error(t, btcstaking.ValidateWitness(prevOut, v1Tx, 0, v1Witness))
+
+	// sequence lower than the time lock
+	earlyTx := spendTx.Copy()
+	earlyTx.TxIn[0].Sequence = btcstaking.SequenceForTimelock(9)
+	_, err = info.SpendInfo.CreateTimeLockPathWitnessStrict(earlyTx, 0, sign(earlyTx))
+	require.Error(t, err)
+
+	_, err = info.SpendInfo.CreateTimeLockPathWitnessStrict(spendTx, 1, sign(spendTx))
+	require.Error(t, err)
+}
+
+func TestCreateWitnessWitnessVersion(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	scenario := GenerateTestScenario(r, t, 1, 3, 2, btcutil.Amount(2*10e8), 1000)
+
+	infos, err := btcstaking.BuildAllSpendInfos(scenario.StakingParams(100))
+	require.NoError(t, err)
+
+	// taproot witness layout is unchanged
+	si := infos.Unbonding
+	require.Equal(t, btcstaking.WitnessV1Taproot, si.WitnessVersion())
+	sigs := [][]byte{datagen.GenRandomByteArray(r, 64), {}, datagen.GenRandomByteArray(r, 64), datagen.GenRandomByteArray(r, 64)}
+	witness, err := btcstaking.CreateWitness(si, sigs)
+	require.NoError(t, err)
+	cb, err := si.ControlBlock.ToBytes()
+	require.NoError(t, err)
+	require.Equal(t, wire.TxWitness(append(append([][]byte{}, sigs...), si.GetPkScriptPath(), cb)), witness)
+
+	// P2WSH witness has no control block
+	key, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	witnessScript, err := txscript.NewScriptBuilder().
+		AddData(key.PubKey().SerializeCompressed()).
+		AddOp(txscript.OP_CHECKSIG).
+		Script()
+	require.NoError(t, err)
+
+	v0Info, err := btcstaking.NewWitnessV0SpendInfo(witnessScript)
+	require.NoError(t, err)
+	require.Equal(t, btcstaking.WitnessV0, v0Info.WitnessVersion())
+
+	scriptHash := sha256.Sum256(witnessScript)
+	addr, err := btcutil.NewAddressWitnessScriptHash(scriptHash[:], &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	prevOut := outputFromAddressAndValue(t, addr, 100000)
+
+	spendTx := createSpendStakeTx(90000)
+	fetcher := txscript.NewCannedPrevOutputFetcher(prevOut.PkScript, prevOut.Value)
+	sig, err := txscript.RawTxInWitnessSignature(
+		spendTx, txscript.NewTxSigHashes(spendTx, fetcher), 0, prevOut.Value, witnessScript, txscript.SigHashAll, key,
+	)
+	require.NoError(t, err)
+
+	v0Witness, err := btcstaking.CreateWitness(v0Info, [][]byte{sig})
+	require.NoError(t, err)
+	require.Equal(t, wire.TxWitness{sig, witnessScript}, v0Witness)
+	require.NoError(t, btcstaking.ValidateWitness(prevOut, spendTx, 0, v0Witness))
+
+	// serialized P2WSH witness has no control block either
+	var buf bytes.Buffer
+	n, err := btcstaking.WriteWitness(&buf, v0Info, [][]byte{sig})
+	require.NoError(t, err)
+	require.Equal(t, v0Witness.SerializeSize(), n)
+	spendTx.TxIn[0].Witness = v0Witness
+	var txBuf bytes.Buffer
+	require.NoError(t, spendTx.Serialize(&txBuf))
+	require.True(t, bytes.Contains(txBuf.Bytes(), buf.Bytes()))
+
+	// layout of the unbonding path builder does not depend on placeholders
+	covSig, err := schnorr.Sign(key, datagen.GenRandomByteArray(r, 32))
+	require.NoError(t, err)
+	stakerSig, err := schnorr.Sign(key, datagen.GenRandomByteArray(r, 32))
+	require.NoError(t, err)
+	v0Witness, err = v0Info.CreateUnbondingPathWitness([]*schnorr.Signature{covSig}, stakerSig)
+	require.NoError(t, err)
+	require.Equal(t, wire.TxWitness{covSig.Serialize(), stakerSig.Serialize(), witnessScript}, v0Witness)
+	v0Witness, err = v0Info.CreateUnbondingPathWitness([]*schnorr.Signature{nil, covSig}, stakerSig)
+	require.NoError(t, err)
+	require.Equal(t, wire.TxWitness{{}, covSig.Serialize(), stakerSig.Serialize(), witnessScript}, v0Witness)
+
+	_, err = btcstaking.NewWitnessV0SpendInfo(nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package bitcoin
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	conformancePrevOutValue = int64(100_000_000)
+	conformanceFee          = int64(1_000)
+	// conformanceSequence satisfies every block based relative time lock which
+	// fits in uint16, so time lock paths can be spent by the conformance tx
+	conformanceSequence = uint32(0xffff)
+)
+
+// ConformancePrevOut returns the synthetic output committing to the taproot
+// tree of the given spend info, which is spent by RunWitnessConformance
+func ConformancePrevOut(t *testing.T, si *btcstaking.SpendInfo) *wire.TxOut {
+	t.Helper()
+
+	require.NotNil(t, si.ControlBlock.InternalKey, "control block internal key is nil")
+
+	rootHash := si.ControlBlock.RootHash(si.RevealedLeaf.Script)
+	outputKey := txscript.ComputeTaprootOutputKey(si.ControlBlock.InternalKey, rootHash)
+	pkScript, err := txscript.PayToTaprootScript(outputKey)
+	require.NoError(t, err)
+
+	return wire.NewTxOut(conformancePrevOutValue, pkScript)
+}
+
+// ConformanceSpendTx returns the synthetic transaction spending the output
+// returned by ConformancePrevOut. It is deterministic, so signatures over its
+// sighash can be produced before calling RunWitnessConformance.
+func ConformanceSpendTx(t *testing.T, si *btcstaking.SpendInfo) *wire.MsgTx {
+	t.Helper()
+
+	prevOut := ConformancePrevOut(t, si)
+
+	tx := wire.NewMsgTx(2)
+	txIn := wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{1}, Index: 0}, nil, nil)
+	txIn.Sequence = conformanceSequence
+	tx.AddTxIn(txIn)
+	tx.AddTxOut(wire.NewTxOut(conformancePrevOutValue-conformanceFee, prevOut.PkScript))
+
+	return tx
+}
+
+// RunWitnessConformance checks that the script path described by the given
+// spend info is spendable through the given spend path. It builds the witness
+// from the signatures returned by makeSigs, executes it with the script engine
+// against the output returned by ConformancePrevOut, and parses it back,
+// asserting that every witness element round trips.
+// makeSigs must sign the sighash of the tx returned by ConformanceSpendTx.
+func RunWitnessConformance(
+	t *testing.T,
+	si *btcstaking.SpendInfo,
+	path btcstaking.SpendPath,
+	makeSigs func() [][]byte,
+) {
+	t.Helper()
+
+	require.NotNil(t, si, "spend info must not be nil")
+
+	scriptPath, err := btcstaking.SpendPathFromScript(si.GetPkScriptPath())
+	require.NoError(t, err)
+	require.Equal(t, path, scriptPath, "revealed script does not belong to %s path", path)
+
+	prevOut := ConformancePrevOut(t, si)
+	tx := ConformanceSpendTx(t, si)
+
+	sigs := makeSigs()
+
+	witness, err := btcstaking.CreateWitness(si, sigs)
+	require.NoError(t, err)
+	tx.TxIn[0].Witness = witness
+
+	AssertSlashingTxExecution(t, prevOut, tx)
+
+	parsed, err := btcstaking.ParseWitness(witness)
+	require.NoError(t, err)
+	require.Nil(t, parsed.Annex)
+	require.Len(t, parsed.Signatures, len(sigs))
+	for i := range sigs {
+		require.True(t, bytes.Equal(sigs[i], parsed.Signatures[i]), "signature %d does not round trip", i)
+	}
+	require.Equal(t, si.GetPkScriptPath(), parsed.Script)
+
+	controlBlock, err := si.ControlBlock.ToBytes()
+	require.NoError(t, err)
+	require.Equal(t, controlBlock, parsed.ControlBlock)
+}
@@ -0,0 +1,111 @@
+package datagen
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+
+	"github.com/babylonlabs-io/babylon/btcstaking"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// syntheticSigMarker is the R value of every synthetic signature generated by
+// GenWitness. It is not a valid field element, so synthetic signatures can be
+// told apart from real ones and never pass verification.
+var syntheticSigMarker = bytes.Repeat([]byte{0xff}, 32)
+
+// IsSyntheticSig returns true if the given signature was generated by GenWitness
+func IsSyntheticSig(sig []byte) bool {
+	return len(sig) == schnorr.SignatureSize && bytes.Equal(sig[:32], syntheticSigMarker)
+}
+
+func genSyntheticSig(r *rand.Rand) []byte {
+	return append(bytes.Clone(syntheticSigMarker), GenRandomByteArray(r, 32)...)
+}
+
+// GenWitness deterministically generates a well-formed witness spending a
+// Babylon staking output through the given path. Keys of the staker, one
+// finality provider and committeeSize covenant members are derived from the
+// seed. The witness contains the signature of the staker, of the finality
+// provider for the slashing path, and of quorum covenant members for the
+// unbonding and slashing paths. Remaining signature slots are empty.
+// Signatures are synthetic: they have the valid size but never verify, see
+// IsSyntheticSig. It panics if the parameters are invalid.
+func GenWitness(seed int64, path btcstaking.SpendPath, committeeSize, quorum int) wire.TxWitness {
+	r := rand.New(rand.NewSource(seed))
+
+	_, stakerPk, err := GenRandomBTCKeyPair(r)
+	if err != nil {
+		panic(err)
+	}
+	_, fpPks, err := GenRandomBTCKeyPairs(r, 1)
+	if err != nil {
+		panic(err)
+	}
+	_, covenantPks, err := GenRandomBTCKeyPairs(r, committeeSize)
+	if err != nil {
+		panic(err)
+	}
+
+	infos, err := btcstaking.BuildAllSpendInfos(btcstaking.StakingParams{
+		StakerKey:            stakerPk,
+		FinalityProviderKeys: fpPks,
+		CovenantKeys:         covenantPks,
+		CovenantQuorum:       uint32(quorum),
+		StakingTime:          uint16(RandomInt(r, 1000) + 1),
+		UnbondingTime:        uint16(RandomInt(r, 1000) + 1),
+		StakingAmount:        btcutil.Amount(RandomInt(r, 1e8) + 1e6),
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	var (
+		si            *btcstaking.SpendInfo
+		sigs          [][]byte
+		covenantSlots int
+	)
+
+	// covenant signatures come first in the witness, followed by the finality
+	// provider signature and the staker signature
+	switch path {
+	case btcstaking.TimeLockPath:
+		si = infos.TimeLock
+	case btcstaking.UnbondingPath:
+		si = infos.Unbonding
+		covenantSlots = committeeSize
+	case btcstaking.SlashingPath:
+		si = infos.Slashing
+		covenantSlots = committeeSize
+	case btcstaking.UnbondingSlashingPath:
+		si = infos.UnbondingSlashing
+		covenantSlots = committeeSize
+	default:
+		panic(fmt.Sprintf("unsupported spend path %s", path))
+	}
+
+	covenantSigs := make([][]byte, covenantSlots)
+	for i := range covenantSigs {
+		covenantSigs[i] = []byte{}
+	}
+	if covenantSlots > 0 {
+		for _, idx := range r.Perm(covenantSlots)[:quorum] {
+			covenantSigs[idx] = genSyntheticSig(r)
+		}
+	}
+	sigs = append(sigs, covenantSigs...)
+
+	if path == btcstaking.SlashingPath || path == btcstaking.UnbondingSlashingPath {
+		sigs = append(sigs, genSyntheticSig(r))
+	}
+	sigs = append(sigs, genSyntheticSig(r))
+
+	witness, err := btcstaking.CreateWitness(si, sigs)
+	if err != nil {
+		panic(err)
+	}
+
+	return witness
+}